@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/eotel/me19/configs"
+)
+
+// configUsage is printed for "me19 config" without a valid subcommand
+const configUsage = `Usage:
+  me19 config print    [-c FILE] [--set key=value] [--format json|yaml|toml]
+  me19 config validate [-c FILE] [--set key=value]
+  me19 config init     [--format json|yaml|toml] [--force] [FILE]
+
+Without -c the configuration file is searched in the standard locations.
+`
+
+// runConfigCommand runs "me19 config <subcommand>" and returns the exit status
+func runConfigCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, configUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "print":
+		err = configPrint(args[1:])
+	case "validate":
+		err = configValidate(args[1:])
+	case "init":
+		err = configInit(args[1:])
+	case "-h", "--help", "help":
+		fmt.Fprint(os.Stdout, configUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown config command: %s\n\n%s", args[0], configUsage)
+		return 2
+	}
+
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "me19 config %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// configOptions are the flags selecting the configuration to load
+type configOptions struct {
+	configPath string
+	overrides  overrideFlag
+}
+
+func (o *configOptions) register(fs *flag.FlagSet) {
+	o.overrides = overrideFlag{}
+	fs.StringVar(&o.configPath, "config", "", "Path to configuration file")
+	fs.StringVar(&o.configPath, "c", "", "Path to configuration file (shorthand)")
+	fs.Var(o.overrides, "set", "Override a configuration value, e.g. --set camera.fps=15 (repeatable)")
+}
+
+// load loads the configuration like the scanner does, reporting errors instead of falling back
+func (o *configOptions) load() (configs.Config, string, error) {
+	return loadCommandConfiguration(o.configPath, o.overrides)
+}
+
+// configPrint prints the effective configuration with secrets redacted
+func configPrint(args []string) error {
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	var opts configOptions
+	opts.register(fs)
+	format := fs.String("format", "json", "Output format: json, yaml or toml")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, _, err := opts.load()
+	if err != nil {
+		return err
+	}
+	return configs.Write(os.Stdout, config.Redacted(), *format)
+}
+
+// configValidate reports every problem in the configuration
+func configValidate(args []string) error {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	var opts configOptions
+	opts.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, path, err := opts.load()
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		path = "default configuration"
+	}
+	if err := config.Validate(); err != nil {
+		var invalid *configs.ValidationError
+		if errors.As(err, &invalid) {
+			fmt.Printf("%s: %d problem(s)\n", path, len(invalid.Problems))
+			for _, problem := range invalid.Problems {
+				fmt.Printf("  %s\n", problem)
+			}
+			return errors.New("invalid configuration")
+		}
+		return err
+	}
+	fmt.Printf("%s: OK\n", path)
+	return nil
+}
+
+// configInit writes the default configuration to a new file
+func configInit(args []string) error {
+	fs := flag.NewFlagSet("config init", flag.ContinueOnError)
+	format := fs.String("format", "", "File format: json, yaml or toml (default from the file extension)")
+	force := fs.Bool("force", false, "Overwrite an existing file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("only one file can be written")
+	}
+
+	path := "config.json"
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+	if *format == "" {
+		*format = configs.FormatFor(path)
+	}
+
+	var buf bytes.Buffer
+	if err := configs.Write(&buf, configs.DefaultConfig(), *format); err != nil {
+		return err
+	}
+
+	// 既存の設定ファイルは --force なしでは上書きしない
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote default configuration to %s\n", path)
+	return nil
+}
+
+// loadCommandConfiguration loads the specified configuration file or the first one
+// found in the standard locations, without logging. The path of the loaded file is
+// returned, or an empty string if none was found.
+func loadCommandConfiguration(configPath string, overrides map[string]string) (configs.Config, string, error) {
+	if configPath == "" {
+		configPath = configs.FindConfigFile()
+	}
+	config, err := configs.Load(configPath, overrides)
+	if err != nil {
+		if configPath == "" {
+			return config, "", err
+		}
+		return config, "", fmt.Errorf("failed to load %s: %w", configPath, err)
+	}
+	return config, configPath, nil
+}
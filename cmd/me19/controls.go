@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/eotel/me19/configs"
+	"github.com/eotel/me19/internal/camera"
+)
+
+// cameraControls converts the configured camera controls
+func cameraControls(cfg configs.ControlsConfig) camera.Controls {
+	controls := make(camera.Controls)
+	setBool := func(ctrl camera.Control, value *bool) {
+		if value == nil {
+			return
+		}
+		controls[ctrl] = 0
+		if *value {
+			controls[ctrl] = 1
+		}
+	}
+	setFloat := func(ctrl camera.Control, value *float64) {
+		if value != nil {
+			controls[ctrl] = *value
+		}
+	}
+	setBool(camera.AutoExposure, cfg.AutoExposure)
+	setFloat(camera.Exposure, cfg.Exposure)
+	setBool(camera.AutoFocus, cfg.AutoFocus)
+	setFloat(camera.Focus, cfg.Focus)
+	setFloat(camera.Gain, cfg.Gain)
+	setBool(camera.AutoWhiteBalance, cfg.AutoWhiteBalance)
+	setFloat(camera.WhiteBalance, cfg.WhiteBalance)
+	setFloat(camera.Zoom, cfg.Zoom)
+	if cfg.BufferSize != nil {
+		controls[camera.BufferSize] = float64(*cfg.BufferSize)
+	}
+	return controls
+}
+
+// logControls logs the control values the camera reports, which may differ
+// from the requested ones when the device rounds or ignores them
+func logControls(name string, cam *camera.Camera) {
+	values, err := cam.Controls()
+	if err != nil {
+		log.Printf("Cannot read camera controls of %s: %v", name, err)
+		return
+	}
+	requested := cam.RequestedControls()
+	var parts []string
+	for _, ctrl := range camera.AllControls {
+		value, ok := values[ctrl]
+		if !ok {
+			continue
+		}
+		part := fmt.Sprintf("%s=%g", ctrl, value)
+		if want, ok := requested[ctrl]; ok && want != value {
+			part += fmt.Sprintf(" (requested %g)", want)
+		}
+		parts = append(parts, part)
+	}
+	log.Printf("Camera controls of %s: %s", name, strings.Join(parts, ", "))
+}
+
+// controlKey is a keyboard shortcut of the preview window that changes a camera control
+type controlKey struct {
+	control camera.Control
+	step    float64        // 0 toggles an automatic mode
+	manual  camera.Control // Automatic mode turned off before the value is changed (-1 for none)
+}
+
+// controlKeys are the keyboard shortcuts for the camera controls. The keys 0-9 switch
+// the camera and codes 96-105 are numpad digits on some systems, so letters such
+// as 'e' and 'f' are not used.
+var controlKeys = map[int]controlKey{
+	'j': {control: camera.AutoFocus},
+	'k': {control: camera.Focus, step: -5, manual: camera.AutoFocus},
+	'l': {control: camera.Focus, step: 5, manual: camera.AutoFocus},
+	'x': {control: camera.AutoExposure},
+	',': {control: camera.Exposure, step: -1, manual: camera.AutoExposure},
+	'.': {control: camera.Exposure, step: 1, manual: camera.AutoExposure},
+	'n': {control: camera.Gain, step: -1, manual: -1},
+	'm': {control: camera.Gain, step: 1, manual: -1},
+	'w': {control: camera.AutoWhiteBalance},
+	'-': {control: camera.Zoom, step: -1, manual: -1},
+	'=': {control: camera.Zoom, step: 1, manual: -1},
+	'+': {control: camera.Zoom, step: 1, manual: -1},
+}
+
+// controlKeysHelp describes the keyboard shortcuts of controlKeys
+const controlKeysHelp = "Camera controls: j autofocus on/off, k/l focus, x auto exposure on/off, ,/. exposure, n/m gain, w auto white balance on/off, -/= zoom, p show values, r reset"
+
+// adjustedValue returns the value after pressing a key that changes a control by
+// step. Exposure and white balance have large ranges, so the step grows with the value.
+func adjustedValue(current, step float64) float64 {
+	if size := math.Round(math.Abs(current) / 10); size > math.Abs(step) {
+		step = math.Copysign(size, step)
+	}
+	return current + step
+}
+
+// handleControlKey changes a camera control for a key pressed in the preview
+// window. 'p' logs the current values and 'r' requests the configured ones again,
+// returning the other controls to the values the device had when it was opened.
+// It reports whether the key was a camera control shortcut.
+func handleControlKey(cam *camera.Camera, key int, configured camera.Controls) bool {
+	switch key {
+	case 'p':
+		logControls(fmt.Sprintf("device ID %d", cam.GetDeviceID()), cam)
+		return true
+	case 'r':
+		if err := cam.ResetControls(configured); err != nil {
+			log.Printf("Error resetting camera controls: %v", err)
+		}
+		logControls(fmt.Sprintf("device ID %d", cam.GetDeviceID()), cam)
+		return true
+	}
+
+	k, ok := controlKeys[key]
+	if !ok {
+		return false
+	}
+	current, err := cam.Control(k.control)
+	if err != nil {
+		log.Printf("Cannot change %s: %v", k.control, err)
+		return true
+	}
+
+	value := 1.0
+	if k.step == 0 {
+		// 自動モードの切り替え
+		if current != 0 {
+			value = 0
+		}
+	} else {
+		if k.manual >= 0 {
+			if _, err := cam.SetControl(k.manual, 0); err != nil {
+				log.Printf("Cannot turn off %s: %v", k.manual, err)
+			}
+		}
+		value = adjustedValue(current, k.step)
+		// Windowsの露出は2の累乗秒で負の値になる。ほかのコントロールは0で止める
+		if value < 0 && k.control != camera.Exposure {
+			value = 0
+		}
+	}
+
+	actual, err := cam.SetControl(k.control, value)
+	if err != nil {
+		log.Printf("Cannot change %s: %v", k.control, err)
+		return true
+	}
+	log.Printf("Camera control %s: %g -> %g", k.control, current, actual)
+	return true
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/gif" // Register GIF format
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+	"github.com/eotel/me19/internal/qrcode"
+	"gocv.io/x/gocv"
+)
+
+// decodeResult is the JSON line printed for each file by "me19 decode"
+type decodeResult struct {
+	File  string                `json:"file"`
+	Codes []detection.Detection `json:"codes"`
+	Parts int                   `json:"parts,omitempty"` // Symbols of multi-symbol codes still waiting for the remaining parts
+	Error string                `json:"error,omitempty"`
+}
+
+// runDecodeCommand runs "me19 decode" and returns the exit status.
+// It exits with 1 if any file could not be read or contained no code.
+func runDecodeCommand(args []string) int {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: me19 decode [--charset NAME] FILE...")
+		fs.PrintDefaults()
+	}
+	charset := fs.String("charset", "auto", "Character set for byte-mode data without ECI")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	detector := qrcode.New()
+	if err := detector.Initialize(); err != nil {
+		fmt.Fprintf(os.Stderr, "me19 decode: %v\n", err)
+		return 1
+	}
+	defer detector.Close()
+	if err := detector.SetCharset(*charset); err != nil {
+		fmt.Fprintf(os.Stderr, "me19 decode: %v\n", err)
+		return 1
+	}
+
+	// 分割シンボルは後に続くファイルと結合する
+	assembler := qrcode.NewAssembler(0)
+	status := 0
+	for _, path := range fs.Args() {
+		result := decodeFile(detector, assembler, path)
+		if result.Error != "" || (len(result.Codes) == 0 && result.Parts == 0) {
+			status = 1
+		}
+		if err := writeJSONLine(os.Stdout, result); err != nil {
+			fmt.Fprintf(os.Stderr, "me19 decode: %v\n", err)
+			return 1
+		}
+	}
+	if pending := assembler.Pending(); pending > 0 {
+		fmt.Fprintf(os.Stderr, "me19 decode: %d multi-symbol code(s) are missing parts\n", pending)
+		status = 1
+	}
+	return status
+}
+
+// decodeFile decodes the codes in one image file
+func decodeFile(detector *qrcode.Detector, assembler *qrcode.Assembler, path string) decodeResult {
+	result := decodeResult{File: path, Codes: []detection.Detection{}}
+	img, err := readImage(path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	results, err := detector.DetectImage(img)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	now := time.Now()
+	for _, r := range results {
+		if r.Append != nil && r.Append.Total > 1 {
+			combined, ok := assembler.Add(r)
+			if !ok {
+				result.Parts++
+				continue
+			}
+			r = combined
+		}
+		result.Codes = append(result.Codes, detection.New(r.Text, r.Raw, now, r.Points))
+	}
+	return result
+}
+
+// readImage decodes an image file. PNG, JPEG and GIF are decoded directly;
+// other formats such as BMP, TIFF and WebP are left to OpenCV.
+func readImage(path string) (image.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+		return img, nil
+	}
+
+	mat, err := gocv.IMDecode(data, gocv.IMReadColor)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image format: %w", err)
+	}
+	defer mat.Close()
+	if mat.Empty() {
+		return nil, errors.New("unsupported image format")
+	}
+	return mat.ToImage()
+}
+
+// writeJSONLine writes v as a single line of JSON
+func writeJSONLine(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/eotel/me19/internal/camera"
+	"github.com/eotel/me19/internal/videodev"
+)
+
+// deviceInfo describes a camera that delivered a frame when probed
+type deviceInfo struct {
+	ID     int      `json:"id"`
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+	Name   string   `json:"name,omitempty"`    // Driver name (Linux)
+	Path   string   `json:"path,omitempty"`    // Device node (Linux)
+	Serial string   `json:"serial,omitempty"`  // USB serial number (Linux)
+	ByID   []string `json:"by_id,omitempty"`   // Stable links usable as camera.device (Linux)
+	ByPath []string `json:"by_path,omitempty"` // Links naming the USB port (Linux)
+}
+
+// runDevicesCommand runs "me19 devices" and returns the exit status.
+// It exits with 1 if no working camera was found.
+func runDevicesCommand(args []string) int {
+	fs := flag.NewFlagSet("devices", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: me19 devices [--max N] [--json]")
+		fs.PrintDefaults()
+	}
+	max := fs.Int("max", 10, "Number of device IDs to probe, starting at 0")
+	asJSON := fs.Bool("json", false, "Print the devices as JSON")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	devices := probeDevices(*max)
+	var err error
+	if *asJSON {
+		err = printDevicesJSON(os.Stdout, devices)
+	} else {
+		err = printDevices(os.Stdout, devices)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "me19 devices: %v\n", err)
+		return 1
+	}
+	if len(devices) == 0 {
+		return 1
+	}
+	return 0
+}
+
+// probeDevices opens each device ID in turn and keeps those that deliver a frame.
+// On Linux the names and stable paths of the devices are added.
+func probeDevices(max int) []deviceInfo {
+	nodes := make(map[int]videodev.Device)
+	if list, err := videodev.System.List(); err == nil {
+		for _, d := range list {
+			nodes[d.Index] = d
+		}
+	}
+
+	devices := []deviceInfo{}
+	for id := 0; id < max; id++ {
+		var cam *camera.Camera
+		if os.Getenv("ME19_TEST_MODE") == "true" {
+			cam = camera.NewWithTestBackend()
+		} else {
+			cam = camera.New()
+		}
+		cam.SetDeviceID(id)
+		if err := cam.Open(); err != nil {
+			continue
+		}
+
+		// 開けてもフレームを取得できないデバイスは一覧に含めない
+		mat, err := cam.CaptureFrameMat()
+		if err == nil {
+			node := nodes[id]
+			devices = append(devices, deviceInfo{
+				ID:     id,
+				Width:  mat.Cols(),
+				Height: mat.Rows(),
+				Name:   node.Name,
+				Path:   node.Path,
+				Serial: node.Serial,
+				ByID:   node.ByID,
+				ByPath: node.ByPath,
+			})
+		}
+		mat.Close()
+		cam.Close()
+	}
+	return devices
+}
+
+// printDevices prints the devices as a table
+func printDevices(out io.Writer, devices []deviceInfo) error {
+	if len(devices) == 0 {
+		_, err := fmt.Fprintln(out, "No working cameras found.")
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tRESOLUTION\tNAME\tSTABLE PATH")
+	for _, d := range devices {
+		// camera.device に指定できる安定したパス（USBシリアルのないカメラはポートのパス）
+		stable := "-"
+		if len(d.ByID) > 0 {
+			stable = d.ByID[0]
+		} else if len(d.ByPath) > 0 {
+			stable = d.ByPath[0]
+		}
+		name := d.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(w, "%d\t%dx%d\t%s\t%s\n", d.ID, d.Width, d.Height, name, stable)
+	}
+	return w.Flush()
+}
+
+// printDevicesJSON prints the devices as a JSON array
+func printDevicesJSON(out io.Writer, devices []deviceInfo) error {
+	data, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"gocv.io/x/gocv"
+)
+
+// Display modes selected with -mode or display.mode
+const (
+	modeAuto     = "auto"
+	modeHeadless = "headless"
+	modeDisplay  = "display"
+)
+
+// windowTitle is the title of the camera preview window
+const windowTitle = "ME19 QR Code Scanner"
+
+// useDisplay decides whether to open the preview window for mode on the platform goos.
+// In auto mode the window is used when a display is reachable: an X11 or Wayland
+// server on Unix-like systems, and the local desktop on macOS and Windows unless the
+// session came in over SSH without X forwarding. The reason is returned for logging.
+func useDisplay(mode, goos string, getenv func(string) string) (bool, string) {
+	switch mode {
+	case modeHeadless:
+		return false, "headless mode selected"
+	case modeDisplay:
+		return true, "display mode selected"
+	}
+
+	switch goos {
+	case "darwin", "windows":
+		if getenv("SSH_CONNECTION") != "" && getenv("DISPLAY") == "" {
+			return false, "no display in SSH session"
+		}
+		return true, fmt.Sprintf("%s desktop", goos)
+	}
+
+	// SSHのX転送もDISPLAYが設定される
+	if display := getenv("WAYLAND_DISPLAY"); display != "" {
+		return true, fmt.Sprintf("Wayland display %s", display)
+	}
+	if display := getenv("DISPLAY"); display != "" {
+		return true, fmt.Sprintf("X11 display %s", display)
+	}
+	return false, "neither DISPLAY nor WAYLAND_DISPLAY is set"
+}
+
+// openWindow creates the preview window. OpenCV reports a missing or unusable
+// display as an exception, which is returned as an error instead of aborting.
+func openWindow(title string) (*gocv.Window, error) {
+	gocv.ClearLastException()
+	window := gocv.NewWindow(title)
+	if err := gocv.LastExceptionError(); err != nil {
+		window.Close()
+		gocv.ClearLastException()
+		return nil, err
+	}
+	if err := window.SetWindowProperty(gocv.WindowPropertyAutosize, gocv.WindowAutosize); err != nil {
+		window.Close()
+		gocv.ClearLastException()
+		return nil, err
+	}
+	return window, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eotel/me19/configs"
+	"github.com/eotel/me19/internal/history"
+)
+
+// historyUsage is printed for "me19 history" without a valid subcommand
+const historyUsage = `Usage:
+  me19 history list   [--since T] [--until T] [--code CODE] [--limit N] [--db PATH]
+  me19 history export [--format csv|jsonl] [--output FILE] [--since T] [--until T] [--code CODE] [--db PATH]
+
+T is RFC 3339, YYYY-MM-DD, "YYYY-MM-DD hh:mm" or a duration before now such as 24h or 7d.
+`
+
+// historyOptions are the flags shared by the history subcommands
+type historyOptions struct {
+	configPath string
+	dbPath     string
+	since      string
+	until      string
+	code       string
+	limit      int
+}
+
+func (o *historyOptions) register(fs *flag.FlagSet, defaultLimit int) {
+	fs.StringVar(&o.configPath, "config", "", "Path to configuration file")
+	fs.StringVar(&o.configPath, "c", "", "Path to configuration file (shorthand)")
+	fs.StringVar(&o.dbPath, "db", "", "Path to the history database (default from configuration)")
+	fs.StringVar(&o.since, "since", "", "Only detections at or after this time")
+	fs.StringVar(&o.until, "until", "", "Only detections before this time")
+	fs.StringVar(&o.code, "code", "", "Only detections of exactly this payload")
+	fs.IntVar(&o.limit, "limit", defaultLimit, "Maximum number of detections (0 for all)")
+}
+
+// open opens the history database selected by the flags or the configuration
+func (o *historyOptions) open() (*history.Store, error) {
+	path := o.dbPath
+	if path == "" {
+		path = loadQuietConfiguration(o.configPath).HistoryPath()
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("history database not found: %s", path)
+	}
+	return history.OpenReadOnly(path)
+}
+
+// query builds the history query from the flags
+func (o *historyOptions) query(now time.Time) (history.Query, error) {
+	since, err := history.ParseTime(o.since, now)
+	if err != nil {
+		return history.Query{}, fmt.Errorf("--since: %w", err)
+	}
+	until, err := history.ParseTime(o.until, now)
+	if err != nil {
+		return history.Query{}, fmt.Errorf("--until: %w", err)
+	}
+	return history.Query{Since: since, Until: until, Code: o.code, Limit: o.limit}, nil
+}
+
+// runHistoryCommand runs "me19 history <subcommand>" and returns the exit status
+func runHistoryCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, historyUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = historyList(args[1:], os.Stdout)
+	case "export":
+		err = historyExport(args[1:], os.Stdout)
+	case "-h", "--help", "help":
+		fmt.Fprint(os.Stdout, historyUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown history command: %s\n\n%s", args[0], historyUsage)
+		return 2
+	}
+
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "me19 history %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// historyList prints matching detections, newest first
+func historyList(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("history list", flag.ContinueOnError)
+	var opts historyOptions
+	opts.register(fs, 20)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := opts.open()
+	if err != nil {
+		return err
+	}
+	q, err := opts.query(time.Now())
+	if err != nil {
+		return err
+	}
+	q.Descending = true
+
+	detections, err := store.Find(q)
+	if err != nil {
+		return err
+	}
+	if len(detections) == 0 {
+		fmt.Fprintln(out, "No detections found.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tDEVICE\tTYPE\tCODE")
+	for _, d := range detections {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n",
+			d.Time.Local().Format("2006-01-02 15:04:05"), d.DeviceID, d.Type, displayText(d.Code, 80))
+	}
+	return w.Flush()
+}
+
+// historyExport writes matching detections in time order
+func historyExport(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("history export", flag.ContinueOnError)
+	var opts historyOptions
+	opts.register(fs, 0)
+	formatName := fs.String("format", "csv", "Export format: csv or jsonl")
+	outputPath := fs.String("output", "", "Write to this file instead of standard output")
+	fs.StringVar(outputPath, "o", "", "Write to this file instead of standard output (shorthand)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	format, err := history.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	store, err := opts.open()
+	if err != nil {
+		return err
+	}
+	q, err := opts.query(time.Now())
+	if err != nil {
+		return err
+	}
+
+	if *outputPath != "" {
+		file, err := os.Create(*outputPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	return store.Export(out, format, q)
+}
+
+// loadQuietConfiguration loads the configuration like the scanner does, without logging
+func loadQuietConfiguration(configPath string) configs.Config {
+	if config, _, err := loadCommandConfiguration(configPath, nil); err == nil {
+		return config
+	}
+	config, err := configs.Load("", nil)
+	if err != nil {
+		return configs.DefaultConfig()
+	}
+	return config
+}
@@ -1,38 +1,51 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	"image/jpeg"
+	"io/fs"
 	"log"
 	"os"
 	"os/signal"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/eotel/me19/configs"
+	"github.com/eotel/me19/internal/actions"
 	"github.com/eotel/me19/internal/camera"
+	"github.com/eotel/me19/internal/consensus"
+	"github.com/eotel/me19/internal/detection"
 	"github.com/eotel/me19/internal/fileio"
+	"github.com/eotel/me19/internal/filter"
+	"github.com/eotel/me19/internal/history"
+	"github.com/eotel/me19/internal/instance"
+	"github.com/eotel/me19/internal/payload"
+	"github.com/eotel/me19/internal/pipeline"
 	"github.com/eotel/me19/internal/qrcode"
+	"github.com/eotel/me19/internal/sanitize"
+	"github.com/eotel/me19/internal/sink"
+	"github.com/eotel/me19/internal/state"
+	"github.com/eotel/me19/internal/verify"
 	"gocv.io/x/gocv"
 )
 
-// QRCodeResult は検出されたQRコードの結果を表す構造体
-type QRCodeResult struct {
-	Code string
-	Time time.Time
-}
-
 // FrameData は処理のためのフレームデータを表す構造体
 type FrameData struct {
-	Mat  gocv.Mat
-	Time time.Time
+	Mat      gocv.Mat
+	Time     time.Time
+	DeviceID int            // フレームを取得したカメラのデバイスID
+	Camera   string         // 複数のカメラを使う場合のカメラ名
+	analyzer *frameAnalyzer // フレームを取得したカメラの解析状態
 }
 
 func init() {
@@ -40,21 +53,105 @@ func init() {
 	runtime.LockOSThread()
 }
 
+// Version is the release version, set at build time with -ldflags "-X main.Version=..."
+var Version = "dev"
+
+// usage is printed for "me19 help" and unknown commands
+const usage = `Usage:
+  me19 [run] [options]          Scan codes from the camera (default)
+  me19 decode [options] FILE... Decode codes from image files
+  me19 devices [options]        List working cameras and their resolutions
+  me19 config print|validate|init
+  me19 history list|export
+  me19 version                  Print version and build information
+
+Run "me19 <command> -h" for the options of a command.
+`
+
 func main() {
+	args := os.Args[1:]
+	// コマンドを省略した場合やフラグから始まる場合は従来どおりスキャンを開始する
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		runScanner(args)
+		return
+	}
+
+	switch args[0] {
+	case "run":
+		runScanner(args[1:])
+	case "decode":
+		os.Exit(runDecodeCommand(args[1:]))
+	case "devices":
+		os.Exit(runDevicesCommand(args[1:]))
+	case "config":
+		os.Exit(runConfigCommand(args[1:]))
+	case "history":
+		os.Exit(runHistoryCommand(args[1:]))
+	case "version":
+		os.Exit(runVersionCommand(args[1:]))
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n%s", args[0], usage)
+		os.Exit(2)
+	}
+}
+
+// runScanner runs "me19 run": it scans codes from the camera until interrupted
+func runScanner(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "%s\nOptions of me19 run:\n", usage)
+		fs.PrintDefaults()
+	}
+
 	// Parse command line flags
-	configPath := flag.String("config", "", "Path to configuration file")
-	deviceID := flag.Int("device", -1, "Camera device ID")
-	outputFile := flag.String("output", "", "Path to output file")
+	configPath := fs.String("config", "", "Path to configuration file")
+	deviceID := fs.Int("device", -1, "Camera device ID")
+	outputFile := fs.String("output", "", "Path to output file")
+	mode := fs.String("mode", "", "Preview window: auto, headless or display (default from configuration)")
+	takeover := fs.Bool("takeover", false, "Ask a running instance writing the same output to exit and take its place")
+	printConfig := fs.Bool("print-config", false, "Print the effective configuration as JSON and exit")
+	strict := fs.Bool("strict", false, "Refuse to start if the configuration file cannot be loaded or is invalid")
+	overrides := overrideFlag{}
+	fs.Var(overrides, "set", "Override a configuration value, e.g. -set camera.fps=15 (repeatable)")
 
 	// 短縮形のフラグも追加
-	flag.StringVar(configPath, "c", "", "Path to configuration file (shorthand)")
-	flag.IntVar(deviceID, "d", -1, "Camera device ID (shorthand)")
-	flag.StringVar(outputFile, "o", "", "Path to output file (shorthand)")
-	flag.Parse()
+	fs.StringVar(configPath, "c", "", "Path to configuration file (shorthand)")
+	fs.IntVar(deviceID, "d", -1, "Camera device ID (shorthand)")
+	fs.StringVar(outputFile, "o", "", "Path to output file (shorthand)")
+	fs.Parse(args)
+
+	// 個別のフラグは -set と同じく環境変数と設定ファイルより優先される
+	if *deviceID >= 0 {
+		log.Printf("Overriding camera device ID from command line: %d", *deviceID)
+		overrides["camera.device_id"] = strconv.Itoa(*deviceID)
+	}
+	if *mode != "" {
+		overrides["display.mode"] = *mode
+	}
+	if *outputFile != "" {
+		log.Printf("Overriding output file path from command line: %s", *outputFile)
+		overrides["output_file.file_path"] = *outputFile
+	}
+
+	// Load configuration (defaults < file < environment variables < command line)
+	config, loadedPath := loadConfiguration(*configPath, overrides, *strict)
+
+	if *printConfig {
+		data, err := json.MarshalIndent(config.Redacted(), "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
+		fmt.Println(string(data))
+		return
+	}
 
 	fmt.Println("ME19 QR Code Scanner")
 	fmt.Println("Press Ctrl+C to exit")
-	fmt.Println("Press keys 0-9 to switch camera")
+	if len(config.Cameras) == 0 {
+		fmt.Println("Press keys 0-9 to switch camera")
+	}
 
 	// Set up context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
@@ -63,93 +160,661 @@ func main() {
 	// Handle shutdown gracefully
 	setupSignalHandler(cancel)
 
-	// Load configuration
-	var config configs.Config
-	var err error
+	// 同じ出力先に書き込む別のインスタンスが動いていないことを確認
+	if config.Lock.Enabled {
+		lock := acquireLock(config, *takeover)
+		defer lock.Release()
+	}
 
-	if *configPath != "" {
-		// User specified a config file, try to load it
-		config, err = configs.LoadConfig(*configPath)
+	// 設定ファイルの変更は読み込んだ値と比較する
+	loaded := config
+
+	// 前回終了時の状態を読み込む
+	var saved *state.State
+	if config.State.Enabled {
+		saved = loadState(config.StatePath())
+	}
+	// コマンドラインや環境変数で指定されていなければ、前回選択していたカメラを使う
+	// （複数のカメラを使う場合はキー操作でカメラを切り替えないため復元しない）
+	_, deviceOverridden := overrides["camera.device_id"]
+	if saved != nil && len(config.Cameras) == 0 && !deviceOverridden && config.Camera.Device == "" && os.Getenv(configs.EnvName("camera.device_id")) == "" && saved.DeviceID != config.Camera.DeviceID {
+		log.Printf("Restoring camera device ID from state file: %d", saved.DeviceID)
+		config.Camera.DeviceID = saved.DeviceID
+	}
+
+	// Initialize components
+	if os.Getenv("ME19_TEST_MODE") == "true" {
+		log.Println("Using mock camera backend (test mode enabled via environment variable)")
+	} else {
+		log.Println("Using real camera backend")
+	}
+	// カメラごとにQRコード検出器と解析状態を用意する
+	var sources []*cameraSource
+	for _, source := range config.Sources() {
+		src, err := newCameraSource(source.Name, source.Camera, config.QRCode)
 		if err != nil {
-			log.Printf("Warning: Failed to load specified config file '%s': %v", *configPath, err)
-			log.Println("Using default configuration.")
-			config = configs.DefaultConfig()
+			log.Fatalf("Failed to set up camera %s: %v", cameraName(source.Camera), err)
+		}
+		defer src.close()
+		sources = append(sources, src)
+	}
+	if len(config.Cameras) > 0 {
+		log.Printf("Capturing from %d cameras (dedup scope: %s)", len(sources), config.Dedup.Scope)
+	}
+
+	// 出力先ファイルのシンクを作成
+	fileSink, err := newOutputSink(config.OutputFile)
+	if err != nil {
+		log.Fatalf("Invalid output settings: %v", err)
+	}
+	sinks := []sink.Sink{fileSink}
+
+	// 設定された追加の出力先
+	extraSinks, err := newSinks(config.Sinks)
+	if err != nil {
+		log.Fatalf("Invalid sink settings: %v", err)
+	}
+	sinks = append(sinks, extraSinks...)
+
+	// 検出履歴をデータベースに保存
+	if config.History.Enabled {
+		store, err := history.Open(config.HistoryPath())
+		if err != nil {
+			log.Fatalf("Failed to open detection history: %v", err)
+		}
+		sinks = append(sinks, store)
+		log.Printf("Detection history is stored in: %s", config.HistoryPath())
+	}
+	defer closeSinks(sinks)
+
+	// 出力前に検出結果を処理するステージ
+	stages, err := newPipeline(config)
+	if err != nil {
+		log.Fatalf("Invalid output settings: %v", err)
+	}
+
+	out := &emitter{
+		pipeline:  stages,
+		sinks:     sinks,
+		deviceID:  config.Camera.DeviceID,
+		perCamera: config.Dedup.Scope == "camera",
+		window:    time.Duration(config.Dedup.WindowSeconds) * time.Second,
+	}
+	if config.State.Enabled {
+		out.statePath = config.StatePath()
+		if saved != nil {
+			out.restore(*saved, time.Duration(config.State.MaxAgeSeconds)*time.Second, time.Now())
+		}
+	}
+
+	// ペイロードのパターンに応じたアクション
+	if len(config.Actions.Rules) > 0 {
+		rules, err := actionRules(config.Actions.Rules)
+		if err != nil {
+			log.Fatalf("Invalid action rules: %v", err)
+		}
+		engine, err := actions.New(rules, logActionResult)
+		if err != nil {
+			log.Fatalf("Invalid action rules: %v", err)
+		}
+		engine.SetMaxConcurrent(config.Actions.MaxConcurrent)
+		// 終了時は実行中のアクションを待つ（各アクションはタイムアウトで打ち切られる）
+		defer engine.Wait()
+		out.actions = engine
+		log.Printf("Loaded %d action rule(s)", engine.Len())
+	}
+
+	s := &session{
+		config:     loaded,
+		overrides:  overrides,
+		sources:    sources,
+		out:        out,
+		outputSink: fileSink,
+	}
+
+	// 設定ファイルの変更を監視して実行中に反映する
+	if loadedPath != "" && config.Reload.Enabled {
+		watcher, err := configs.Watch(loadedPath, reloadDelay, func(err error) {
+			log.Printf("Error watching configuration file: %v", err)
+		})
+		if err != nil {
+			log.Printf("Warning: Configuration changes will not be applied until restart: %v", err)
 		} else {
-			log.Printf("Using configuration from specified file: %s", *configPath)
+			defer watcher.Close()
+			s.path = loadedPath
+			s.reloads = watcher.Changes()
+			log.Printf("Watching configuration file for changes: %s", loadedPath)
 		}
-	} else {
-		// Try to find a config file in standard locations
-		foundConfigPath := configs.FindConfigFile()
-		if foundConfigPath != "" {
-			config, err = configs.LoadConfig(foundConfigPath)
-			if err != nil {
-				log.Printf("Warning: Found config file at %s but failed to load it: %v", foundConfigPath, err)
-				log.Println("Using default configuration.")
-				config = configs.DefaultConfig()
+	}
+
+	// プレビューウィンドウを使うかどうかを決める
+	display, reason := useDisplay(config.Display.Mode, runtime.GOOS, os.Getenv)
+	if display {
+		// ウィンドウを作成できない場合はヘッドレスで続行する
+		window, err := openWindow(windowTitle)
+		if err != nil {
+			log.Printf("Warning: Failed to create the preview window (%s), running headless instead: %v", reason, err)
+		} else {
+			defer window.Close()
+			log.Printf("Running with display enabled on %s platform (%s)", runtime.GOOS, reason)
+			if s.multiCamera() {
+				runCameras(ctx, s, window)
 			} else {
-				log.Printf("Using configuration from: %s", foundConfigPath)
+				runWithDisplay(ctx, s, window)
 			}
-		} else {
-			// No config file found, use default without error message
-			log.Println("No configuration file found. Using default configuration.")
-			config = configs.DefaultConfig()
+			return
 		}
+	} else {
+		log.Printf("Running in headless mode (%s) - camera preview window disabled", reason)
+	}
+	if s.multiCamera() {
+		runCameras(ctx, s, nil)
+		return
 	}
+	runHeadless(ctx, s)
+}
 
-	// Override config with command line arguments if provided
-	if *deviceID >= 0 {
-		log.Printf("Overriding camera device ID from command line: %d", *deviceID)
-		config.Camera.DeviceID = *deviceID
+// loadConfiguration loads the configuration file given on the command line, or
+// the first one found in the standard locations, falling back to the defaults.
+// Environment variables and command line overrides are applied on top.
+// In strict mode a file that cannot be loaded or an invalid configuration is fatal;
+// otherwise the problems are logged as warnings. The path of the loaded file is
+// returned, or an empty string if none was loaded.
+func loadConfiguration(configPath string, overrides map[string]string, strict bool) (configs.Config, string) {
+	config, path, err := loadConfigurationFile(configPath, overrides)
+	if err != nil {
+		if strict {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		log.Printf("Warning: %v", err)
+		log.Println("Using default configuration.")
+
+		// 環境変数やコマンドラインの値が不正な場合は続行しない
+		config, err = configs.Load("", overrides)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		path = ""
 	}
 
-	if *outputFile != "" {
-		log.Printf("Overriding output file path from command line: %s", *outputFile)
-		config.OutputFile.FilePath = *outputFile
+	var invalid *configs.ValidationError
+	if err := config.Validate(); errors.As(err, &invalid) {
+		if strict {
+			log.Fatalf("%v\nRefusing to start with an invalid configuration (-strict)", err)
+		}
+		for _, problem := range invalid.Problems {
+			log.Printf("Warning: configuration problem: %s", problem)
+		}
 	}
+	return config, path
+}
 
-	// Load environment variables (which override both config file and command line)
-	configs.LoadEnvironmentVariables(&config)
+// loadConfigurationFile loads the specified configuration file or the first one
+// found in the standard locations
+func loadConfigurationFile(configPath string, overrides map[string]string) (configs.Config, string, error) {
+	if configPath != "" {
+		// User specified a config file, try to load it
+		config, err := configs.Load(configPath, overrides)
+		if err != nil {
+			return config, "", fmt.Errorf("failed to load specified config file '%s': %w", configPath, err)
+		}
+		log.Printf("Using configuration from specified file: %s", configPath)
+		return config, configPath, nil
+	}
 
-	// Initialize components
-	var cam *camera.Camera
-	if os.Getenv("ME19_TEST_MODE") == "true" {
-		cam = camera.NewWithTestBackend()
-		log.Println("Using mock camera backend (test mode enabled via environment variable)")
-	} else {
-		cam = camera.New()
-		log.Println("Using real camera backend")
+	// Try to find a config file in standard locations
+	foundConfigPath := configs.FindConfigFile()
+	if foundConfigPath == "" {
+		// No config file found, use default without error message
+		log.Println("No configuration file found. Using default configuration.")
+		config, err := configs.Load("", overrides)
+		return config, "", err
+	}
+	config, err := configs.Load(foundConfigPath, overrides)
+	if err != nil {
+		return config, "", fmt.Errorf("found config file at %s but failed to load it: %w", foundConfigPath, err)
 	}
-	defer cam.Close()
+	log.Printf("Using configuration from: %s", foundConfigPath)
+	return config, foundConfigPath, nil
+}
 
-	// カメラのデバイスIDを設定
-	cam.SetDeviceID(config.Camera.DeviceID)
+// overrideFlag collects repeated -set key=value flags
+type overrideFlag map[string]string
 
-	// QRコード検出器を作成
-	detector := qrcode.New()
-	// 検出器を初期化
-	if err := detector.Initialize(); err != nil {
-		log.Fatalf("Failed to initialize QR code detector: %v", err)
+func (o overrideFlag) String() string {
+	pairs := make([]string, 0, len(o))
+	for key, value := range o {
+		pairs = append(pairs, key+"="+value)
 	}
-	defer detector.Close()
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
 
-	// ファイル書き込みオブジェクトを作成して参照を保持
-	writer := fileio.New(config.OutputFile.FilePath)
-	log.Printf("QR code data will be written to: %s", config.OutputFile.FilePath)
+func (o overrideFlag) Set(value string) error {
+	key, v, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	o[strings.ToLower(key)] = v
+	return nil
+}
+
+// takeoverTimeout is how long -takeover waits for the running instance to exit
+const takeoverTimeout = 10 * time.Second
 
-	// ヘッドレスモードで実行するかどうかを確認
-	var headless bool
-	if runtime.GOOS == "darwin" {
-		headless = os.Getenv("CGO_ENABLED") == "0"
+// acquireLock takes the single-instance lock for the output file, exiting if
+// another instance holds it
+func acquireLock(config configs.Config, takeover bool) *instance.Lock {
+	path := config.Lock.Path
+	if path == "" {
+		path = instance.LockPath(config.OutputFile.FilePath)
+	}
+
+	var lock *instance.Lock
+	var err error
+	if takeover {
+		lock, err = instance.TakeOver(path, takeoverTimeout)
 	} else {
-		headless = os.Getenv("DISPLAY") == ""
+		lock, err = instance.Acquire(path)
+	}
+
+	var locked *instance.LockedError
+	if errors.As(err, &locked) {
+		owner := "Another instance"
+		if locked.PID != 0 {
+			owner = fmt.Sprintf("Another instance (PID %d)", locked.PID)
+		}
+		if takeover {
+			log.Fatalf("%s did not release %s within %v", owner, config.OutputFile.FilePath, takeoverTimeout)
+		}
+		log.Fatalf("%s is already writing to %s (lock file %s). Stop it or run with -takeover", owner, config.OutputFile.FilePath, path)
+	}
+	if err != nil {
+		log.Fatalf("Failed to acquire instance lock: %v", err)
+	}
+	log.Printf("Acquired instance lock: %s", path)
+	return lock
+}
+
+// loadState reads the state saved by the previous run, or returns nil if there is none
+func loadState(path string) *state.State {
+	saved, err := state.Load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Warning: Ignoring state file: %v", err)
+		return nil
+	}
+	log.Printf("Loaded state saved at %s from: %s", saved.SavedAt.Local().Format(time.DateTime), path)
+	return &saved
+}
+
+// reconnectPolicy converts the configured camera reconnection settings
+func reconnectPolicy(cfg configs.ReconnectConfig) camera.ReconnectPolicy {
+	if !cfg.Enabled {
+		return camera.ReconnectPolicy{}
+	}
+	return camera.ReconnectPolicy{
+		FailureThreshold: cfg.FailureThreshold,
+		InitialBackoff:   time.Duration(cfg.InitialBackoffMS) * time.Millisecond,
+		MaxBackoff:       time.Duration(cfg.MaxBackoffMS) * time.Millisecond,
+	}
+}
+
+// cameraEventMessage describes a change of the camera connection state for the log
+func cameraEventMessage(e camera.Event) string {
+	switch e.State {
+	case camera.StateConnected:
+		return fmt.Sprintf("Camera device ID %d connected", e.DeviceID)
+	case camera.StateLost:
+		return fmt.Sprintf("Camera device ID %d lost: %v", e.DeviceID, e.Err)
+	case camera.StateReconnecting:
+		return fmt.Sprintf("Reconnecting to camera failed (attempt %d, retrying in %v): %v", e.Attempt, e.Delay, e.Err)
+	}
+	return e.State.String()
+}
+
+// captureRetryDelay returns how long to wait before capturing again after err.
+// While the camera is disconnected the reconnection backoff decides when it is
+// reopened, so there is no need to poll it often.
+func captureRetryDelay(err error) time.Duration {
+	if errors.Is(err, camera.ErrDisconnected) {
+		return 100 * time.Millisecond
+	}
+	return 10 * time.Millisecond
+}
+
+// selectCamera selects the configured camera: by its stable selector if one is
+// set, otherwise by device ID
+func selectCamera(cam *camera.Camera, cfg configs.CameraConfig) {
+	cam.SetDeviceID(cfg.DeviceID)
+	if cfg.Device != "" {
+		cam.SetSelector(cfg.Device)
+	}
+}
+
+// cameraFormat converts the configured capture format
+func cameraFormat(cfg configs.CameraConfig) camera.Format {
+	return camera.Format{Width: cfg.Width, Height: cfg.Height, FPS: cfg.FPS}
+}
+
+// newScanner creates a scanner for the configured regions of interest and tracking
+func newScanner(detector *qrcode.Detector, cfg configs.QRCodeConfig) *qrcode.Scanner {
+	scanner := qrcode.NewScanner(detector,
+		roiRectangles(cfg.ROIs),
+		cfg.Tracking.Enabled,
+		cfg.Tracking.Padding)
+	if len(scanner.ROIs()) > 0 {
+		log.Printf("Scanning %d region(s) of interest", len(scanner.ROIs()))
+	}
+	if cfg.Tracking.Enabled {
+		log.Printf("Tracking enabled with %dpx padding", cfg.Tracking.Padding)
+	}
+	return scanner
+}
+
+// newConfirmer creates the confirmation policy, or returns nil if it is disabled
+func newConfirmer(cfg configs.ConfirmationConfig) (*consensus.Confirmer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	confirmer, err := consensus.New(consensus.Policy{
+		Mode:     consensus.Mode(cfg.Mode),
+		Required: cfg.Required,
+		Frames:   cfg.Frames,
+		Window:   time.Duration(cfg.WindowMS) * time.Millisecond,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Confirmation enabled: %s mode, %d required", cfg.Mode, cfg.Required)
+	return confirmer, nil
+}
+
+// newOutputSink creates the sink for the main output file
+func newOutputSink(cfg configs.OutputFileConfig) (*sink.FileSink, error) {
+	encoding, err := fileio.ParseEncoding(cfg.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	fileSink, err := sink.NewFileSink(sink.FileOptions{
+		Path:     cfg.FilePath,
+		Encoding: encoding,
+		Template: cfg.Template,
+		Append:   cfg.Append,
+		Rotation: rotationPolicy(cfg.Rotation),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template: %w", err)
+	}
+	log.Printf("QR code data will be written to: %s (%s)", cfg.FilePath, encoding)
+	return fileSink, nil
+}
+
+// newPipeline creates the stages that process detections before output
+func newPipeline(config configs.Config) (*pipeline.Pipeline, error) {
+	stages := pipeline.New()
+
+	// 制御文字の除去とサイズ制限は最初に適用する
+	sanitizeMode, err := sanitize.ParseMode(config.Sanitize.ControlChars)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sanitize settings: %w", err)
+	}
+	stages.Add(sanitize.Policy{
+		Mode:              sanitizeMode,
+		MaxLength:         config.Sanitize.MaxLength,
+		RejectInvalidUTF8: config.Sanitize.RejectInvalidUTF8,
+	})
+	payloadFilter, err := filter.New(filterPolicy(config.Filter))
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter rules: %w", err)
+	}
+	if !payloadFilter.Empty() {
+		stages.Add(payloadFilter)
+		log.Printf("Payload filter enabled: %d allow rule(s), %d deny rule(s)", len(config.Filter.Allow), len(config.Filter.Deny))
 	}
 
-	if headless {
-		log.Println("Running in headless mode - camera preview window disabled")
-		runHeadless(ctx, cam, detector, writer)
+	// 署名付きペイロード（チケットなど）の検証
+	if config.Verification.Enabled {
+		verifier, err := newVerifier(config.Verification)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verification settings: %w", err)
+		}
+		stages.Add(verifier)
+		log.Printf("Signature verification enabled with %d key(s)", len(config.Verification.Keys))
+	}
+	return stages, nil
+}
+
+// roiRectangles converts the configured regions of interest to image rectangles
+func roiRectangles(rois []configs.ROIConfig) []image.Rectangle {
+	rects := make([]image.Rectangle, 0, len(rois))
+	for _, roi := range rois {
+		rects = append(rects, image.Rect(roi.X, roi.Y, roi.X+roi.Width, roi.Y+roi.Height))
+	}
+	return rects
+}
+
+// filterPolicy converts the configured filter rules to a filter policy
+func filterPolicy(cfg configs.FilterConfig) filter.Policy {
+	rules := func(rules []configs.FilterRuleConfig) []filter.Rule {
+		converted := make([]filter.Rule, 0, len(rules))
+		for _, r := range rules {
+			converted = append(converted, filter.Rule{
+				Name:      r.Name,
+				Pattern:   r.Regex,
+				Prefix:    r.Prefix,
+				Type:      payload.Type(r.Type),
+				MinLength: r.MinLength,
+				MaxLength: r.MaxLength,
+			})
+		}
+		return converted
+	}
+	return filter.Policy{
+		Allow:     rules(cfg.Allow),
+		Deny:      rules(cfg.Deny),
+		MinLength: cfg.MinLength,
+		MaxLength: cfg.MaxLength,
+	}
+}
+
+// newVerifier creates the signature verification stage from the configuration
+func newVerifier(cfg configs.VerificationConfig) (*verify.Verifier, error) {
+	keys := make([]verify.Key, 0, len(cfg.Keys))
+	for _, k := range cfg.Keys {
+		algorithm, err := verify.ParseAlgorithm(k.Algorithm)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", k.ID, err)
+		}
+		material := k.PublicKey
+		if algorithm == verify.HS256 {
+			material = k.Secret
+		}
+		key, err := verify.NewKey(k.ID, algorithm, material)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	opts := verify.Options{
+		Keys:          keys,
+		Leeway:        time.Duration(cfg.LeewaySeconds) * time.Second,
+		RequireExpiry: cfg.RequireExpiry,
+	}
+	if cfg.ReplayStore != "" {
+		store, err := verify.OpenFileStore(cfg.ReplayStore)
+		if err != nil {
+			return nil, err
+		}
+		opts.Store = store
+		log.Printf("Used tokens are recorded in: %s", cfg.ReplayStore)
 	} else {
-		log.Printf("Running with display enabled on %s platform", runtime.GOOS)
-		runWithDisplay(ctx, cam, detector, writer)
+		opts.Store = verify.NewMemoryStore()
+	}
+	return verify.New(opts)
+}
+
+// actionRules converts the configured action rules
+func actionRules(cfgs []configs.ActionRuleConfig) ([]actions.Rule, error) {
+	rules := make([]actions.Rule, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		name := cfg.Name
+		if name == "" {
+			name = fmt.Sprintf("rule[%d]", i)
+		}
+		orDefault := func(value, def string) string {
+			if value == "" {
+				return def
+			}
+			return value
+		}
+
+		var action actions.Action
+		switch cfg.Action {
+		case "file":
+			if cfg.Path == "" {
+				return nil, fmt.Errorf("rule %s: file action requires path", name)
+			}
+			action = actions.FileAction{Path: cfg.Path, Content: orDefault(cfg.Content, "$0"), Append: cfg.Append}
+		case "http":
+			if cfg.URL == "" {
+				return nil, fmt.Errorf("rule %s: http action requires url", name)
+			}
+			action = actions.HTTPAction{Method: cfg.Method, URL: cfg.URL, Body: orDefault(cfg.Body, "$0"), Headers: cfg.Headers}
+		case "osc":
+			if cfg.Target == "" || cfg.Address == "" {
+				return nil, fmt.Errorf("rule %s: osc action requires target and address", name)
+			}
+			action = actions.OSCAction{Target: cfg.Target, Address: cfg.Address, Args: cfg.Args}
+		case "exec":
+			if cfg.Command == "" {
+				return nil, fmt.Errorf("rule %s: exec action requires command", name)
+			}
+			action = actions.ExecAction{Command: cfg.Command, Args: cfg.Args, InheritEnv: cfg.InheritEnv}
+		default:
+			return nil, fmt.Errorf("rule %s: unknown action %q", name, cfg.Action)
+		}
+
+		rules = append(rules, actions.Rule{
+			Name:    name,
+			Pattern: cfg.Match,
+			Action:  action,
+			Timeout: time.Duration(cfg.TimeoutMS) * time.Millisecond,
+		})
+	}
+	return rules, nil
+}
+
+// logActionResult はアクションの実行結果をログに出力する
+func logActionResult(result actions.Result) {
+	if result.Err != nil {
+		log.Printf("Action %s (%s) failed for %s: %s", result.Rule, result.Kind,
+			displayText(result.Code, maxLogRunes), displayText(result.Err.Error(), maxLogRunes))
+		return
+	}
+	log.Printf("Action %s (%s) completed in %v", result.Rule, result.Kind, result.Duration.Round(time.Millisecond))
+}
+
+// newSinks creates the additional outputs from the configuration
+func newSinks(cfgs []configs.SinkConfig) ([]sink.Sink, error) {
+	var sinks []sink.Sink
+	for i, cfg := range cfgs {
+		name := cfg.Name
+		if name == "" {
+			name = fmt.Sprintf("%s[%d]", cfg.Type, i)
+		}
+
+		switch cfg.Type {
+		case "file":
+			if cfg.Path == "" {
+				closeSinks(sinks)
+				return nil, fmt.Errorf("sink %s: file sink requires path", name)
+			}
+			encoding, err := fileio.ParseEncoding(cfg.Encoding)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, fmt.Errorf("sink %s: %w", name, err)
+			}
+			s, err := sink.NewFileSink(sink.FileOptions{
+				Name:     name,
+				Path:     cfg.Path,
+				Encoding: encoding,
+				Template: cfg.Template,
+				Append:   cfg.Append,
+				Rotation: rotationPolicy(cfg.Rotation),
+			})
+			if err != nil {
+				closeSinks(sinks)
+				return nil, fmt.Errorf("sink %s: %w", name, err)
+			}
+			sinks = append(sinks, s)
+			log.Printf("Writing detections to %s (sink %s)", cfg.Path, name)
+		case "exec":
+			stdin, err := sink.ParseStdinMode(cfg.Stdin)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, fmt.Errorf("sink %s: %w", name, err)
+			}
+			s, err := sink.NewExecSink(sink.ExecOptions{
+				Name:        name,
+				Command:     cfg.Command,
+				Args:        cfg.Args,
+				Stdin:       stdin,
+				Timeout:     time.Duration(cfg.TimeoutMS) * time.Millisecond,
+				Concurrency: cfg.Concurrency,
+				QueueSize:   cfg.QueueSize,
+				InheritEnv:  cfg.InheritEnv,
+			}, logExecResult)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, fmt.Errorf("sink %s: %w", name, err)
+			}
+			sinks = append(sinks, s)
+			log.Printf("Running %s for each detection (sink %s)", cfg.Command, name)
+		default:
+			closeSinks(sinks)
+			return nil, fmt.Errorf("sink %s: unknown type %q", name, cfg.Type)
+		}
+	}
+	return sinks, nil
+}
+
+// rotationPolicy converts the configured rotation settings
+func rotationPolicy(cfg configs.RotationConfig) fileio.RotationPolicy {
+	return fileio.RotationPolicy{
+		MaxSize:    int64(cfg.MaxSizeKB) * 1024,
+		Interval:   time.Duration(cfg.IntervalMinutes) * time.Minute,
+		Compress:   cfg.Compress,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     time.Duration(cfg.MaxAgeDays) * 24 * time.Hour,
+	}
+}
+
+// closeSinks は出力先を閉じる（実行待ちのコマンドは完了まで待つ）
+func closeSinks(sinks []sink.Sink) {
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			log.Printf("Error closing %s: %v", s.Name(), err)
+		}
+	}
+}
+
+// logExecResult はコマンドの終了ステータスと標準エラー出力をログに出力する
+func logExecResult(result sink.ExecResult) {
+	if result.Err == nil {
+		log.Printf("Sink %s: command exited with status 0 in %v", result.Sink, result.Duration.Round(time.Millisecond))
+		if result.Stderr != "" {
+			log.Printf("Sink %s stderr: %s", result.Sink, displayText(result.Stderr, maxLogRunes))
+		}
+		return
+	}
+	log.Printf("Sink %s: command failed for %s (exit status %d): %v", result.Sink,
+		displayText(result.Code, maxLogRunes), result.ExitCode, result.Err)
+	if result.Stderr != "" {
+		log.Printf("Sink %s stderr: %s", result.Sink, displayText(result.Stderr, maxLogRunes))
 	}
 }
 
@@ -166,21 +831,22 @@ func setupSignalHandler(cancel context.CancelFunc) {
 }
 
 // runHeadless runs the application without UI
-func runHeadless(ctx context.Context, cam *camera.Camera, detector *qrcode.Detector, writer *fileio.Writer) {
+func runHeadless(ctx context.Context, s *session) {
+	src, out := s.primary(), s.out
+	cam, analyzer := src.cam, src.analyzer
+
 	// Open the camera
-	if err := cam.Open(); err != nil {
-		log.Fatalf("Error opening camera: %v", err)
-	}
+	s.openCamera()
 
 	// QRコード検出結果を共有するためのチャネル
-	resultChan := make(chan QRCodeResult, 10)
+	resultChan := make(chan detection.Detection, 10)
 
 	// QRコード検出用のゴルーチンを起動
-	frameChannel := make(chan gocv.Mat, 5)
-	go detectQRCodesFromFrames(ctx, detector, frameChannel, resultChan)
+	frameChannel := make(chan FrameData, 5)
+	go detectQRCodesFromFrames(ctx, frameChannel, resultChan)
 
-	// 最後に検出したQRコード
-	var lastCode string
+	// 定期的な状態ログ用のフレームカウンタ
+	frameCount := 0
 
 	// フレーム取得と結果処理ループ
 	for {
@@ -192,14 +858,11 @@ func runHeadless(ctx context.Context, cam *camera.Camera, detector *qrcode.Detec
 
 		case result := <-resultChan:
 			// 新しいコードであれば記録
-			if result.Code != lastCode && result.Code != "" {
-				if err := writer.WriteData(result.Code); err != nil {
-					log.Printf("Error writing QR code data to file: %v", err)
-				} else {
-					log.Printf("Detected new QR code and wrote to file: %s", result.Code)
-					lastCode = result.Code
-				}
-			}
+			out.emit(result)
+
+		case <-s.reloads:
+			// 設定ファイルの変更を反映
+			s.reload()
 
 		default:
 			// メインスレッドでフレームを取得
@@ -208,85 +871,376 @@ func runHeadless(ctx context.Context, cam *camera.Camera, detector *qrcode.Detec
 				if mat.Ptr() != nil {
 					mat.Close()
 				}
-				time.Sleep(10 * time.Millisecond)
+				time.Sleep(captureRetryDelay(err))
 				continue
 			}
 
-			// フレームを検出チャネルに送信（コピーを作成）
-			clone := mat.Clone()
-			select {
-			case frameChannel <- clone:
-				// フレームが正常に送信された
-			default:
-				// チャネルがいっぱいの場合はフレームを破棄
-				clone.Close()
+			// スキャン間隔ごとにフレームを検出チャネルに送信（コピーを作成）
+			if now := time.Now(); src.throttle.due(now) {
+				clone := mat.Clone()
+				select {
+				case frameChannel <- FrameData{Mat: clone, Time: now, DeviceID: cam.GetDeviceID(), analyzer: analyzer}:
+					// フレームが正常に送信された
+				default:
+					// チャネルがいっぱいの場合はフレームを破棄
+					clone.Close()
+				}
 			}
 
 			// 元のMatを閉じる
 			mat.Close()
 
+			// 確認待ちの候補を定期的にログ出力し、失敗した書き込みを再試行
+			frameCount++
+			if frameCount%100 == 0 {
+				logPendingCandidates(analyzer.pending())
+				out.retryPending()
+			}
+
 			time.Sleep(10 * time.Millisecond)
 		}
 	}
 }
 
+const (
+	// ログに出力するコードの最大文字数
+	maxLogRunes = 200
+	// プレビューウィンドウに表示するコードの最大文字数
+	maxOverlayRunes = 60
+)
+
+// displayText はログやプレビューに表示できるよう制御文字をエスケープして切り詰める
+func displayText(text string, maxRunes int) string {
+	return sanitize.ForDisplay(text, maxRunes)
+}
+
+// emitter は検出結果を処理ステージに通してから出力先に書き込む
+type emitter struct {
+	pipeline *pipeline.Pipeline
+	sinks    []sink.Sink
+	actions  *actions.Engine // nilの場合はアクションを実行しない
+
+	last         map[string]state.Emission // カメラ名ごとの最後に書き込んだコード
+	perCamera    bool                      // trueの場合は別のカメラで検出された同じコードも書き込む
+	window       time.Duration             // perCameraがfalseの場合、別のカメラで書き込んだコードを重複とみなす時間
+	recent       map[string]state.Emission // すべてのカメラで最近書き込んだコード
+	lastRejected string                    // 最後に拒否したコード（同じ拒否を繰り返しログに出さないため）
+
+	retries   []state.Retry // 書き込みに失敗し、再試行を待っている検出結果
+	deviceID  int           // 選択中のカメラ
+	statePath string        // 空の場合は状態を保存しない
+}
+
+// maxRetries は再試行を待つ検出結果の上限（超えた場合は古いものから破棄する）
+const maxRetries = 100
+
+// emit は新しいコードを処理ステージに通して出力先に書き込む。
+// 書き込んだ場合は処理後の検出結果とtrueを、処理ステージに拒否された場合はその理由を返す。
+func (e *emitter) emit(d detection.Detection) (detection.Detection, bool, *pipeline.Rejection) {
+	// 重複判定は処理前のコードで行う（サニタイズでコードが変わっても同じ入力を書き直さない）
+	input := d.Code
+	if input == "" || e.duplicate(input, d.Camera, d.Time) {
+		return d, false, nil
+	}
+
+	d, rejection := e.pipeline.Run(d)
+	if rejection != nil {
+		if input != e.lastRejected {
+			log.Printf("Rejected QR code (%s): %s",
+				displayText(rejection.String(), maxLogRunes), displayText(input, maxLogRunes))
+			e.lastRejected = input
+		}
+		return d, false, rejection
+	}
+
+	// アクションは非同期に実行され、ファイルへの書き込みを待たせない
+	if e.actions != nil {
+		e.actions.Handle(d)
+	}
+
+	// 一部の出力先で失敗しても、成功した出力先に同じコードを繰り返し書き込まない。
+	// 失敗した出力先には後で再試行する
+	e.remember(state.Emission{Code: input, DeviceID: d.DeviceID, Camera: d.Camera, Time: d.Time})
+	written, delivered := true, false
+	for _, s := range e.sinks {
+		if err := s.Write(d); err != nil {
+			log.Printf("Error writing QR code data to %s (will retry): %v", s.Name(), err)
+			e.queueRetry(state.Retry{Sink: s.Name(), Detection: d, Attempts: 1})
+			written = false
+			continue
+		}
+		delivered = true
+	}
+	// 署名付きトークンなどは出力先に書き込めてから使用済みとして記録する
+	if delivered || len(e.sinks) == 0 {
+		e.commit(d)
+	}
+	if written {
+		if d.Camera != "" {
+			log.Printf("Detected new QR code (%s) on camera %s and wrote to outputs: %s", d.Type, displayText(d.Camera, maxLogRunes), displayText(d.Code, maxLogRunes))
+		} else {
+			log.Printf("Detected new QR code (%s) and wrote to outputs: %s", d.Type, displayText(d.Code, maxLogRunes))
+		}
+	}
+	e.saveState()
+	return d, written, nil
+}
+
+// commit は書き込んだ検出結果を処理ステージに伝える
+func (e *emitter) commit(d detection.Detection) {
+	if err := e.pipeline.Commit(d); err != nil {
+		log.Printf("Error recording written QR code: %v", err)
+	}
+}
+
+// duplicate は同じカメラで最後に書き込んだコード、またはほかのカメラで
+// 重複判定の時間内に書き込んだコードと同じかどうかを返す。
+// 1台のカメラでは別のコードを挟んだ同じコードを書き込む
+func (e *emitter) duplicate(code, camera string, now time.Time) bool {
+	if code == e.last[camera].Code {
+		return true
+	}
+	if e.perCamera {
+		return false
+	}
+	written, ok := e.recent[code]
+	return ok && written.Camera != camera && now.Sub(written.Time) < e.window
+}
+
+// remember は書き込んだコードを重複判定のために記録する
+func (e *emitter) remember(emitted state.Emission) {
+	if e.last == nil {
+		e.last = make(map[string]state.Emission)
+	}
+	e.last[emitted.Camera] = emitted
+
+	if e.recent == nil {
+		e.recent = make(map[string]state.Emission)
+	}
+	// 判定の時間を過ぎたコードは忘れる
+	for code, written := range e.recent {
+		if emitted.Time.Sub(written.Time) >= e.window {
+			delete(e.recent, code)
+		}
+	}
+	if e.window > 0 {
+		e.recent[emitted.Code] = emitted
+	}
+}
+
+// queueRetry は再試行を待つ検出結果を追加する
+func (e *emitter) queueRetry(retry state.Retry) {
+	if len(e.retries) >= maxRetries {
+		dropped := e.retries[0]
+		log.Printf("Retry queue is full, dropping write to %s: %s", dropped.Sink, displayText(dropped.Detection.Code, maxLogRunes))
+		e.retries = e.retries[1:]
+	}
+	e.retries = append(e.retries, retry)
+}
+
+// retryPending は書き込みに失敗した検出結果を再び出力先に書き込む
+func (e *emitter) retryPending() {
+	if len(e.retries) == 0 {
+		return
+	}
+
+	var remaining []state.Retry
+	for _, retry := range e.retries {
+		s := e.sink(retry.Sink)
+		if s == nil {
+			log.Printf("Dropping retry for unknown output %s: %s", retry.Sink, displayText(retry.Detection.Code, maxLogRunes))
+			continue
+		}
+		if err := s.Write(retry.Detection); err != nil {
+			retry.Attempts++
+			remaining = append(remaining, retry)
+			continue
+		}
+		log.Printf("Wrote QR code data to %s after %d attempt(s): %s", s.Name(), retry.Attempts+1, displayText(retry.Detection.Code, maxLogRunes))
+		e.commit(retry.Detection)
+	}
+	e.retries = remaining
+	e.saveState()
+}
+
+// sink は名前で出力先を探す
+func (e *emitter) sink(name string) sink.Sink {
+	for _, s := range e.sinks {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// replaceSink は出力先を置き換える
+func (e *emitter) replaceSink(old, new sink.Sink) {
+	for i, s := range e.sinks {
+		if s == old {
+			e.sinks[i] = new
+		}
+	}
+}
+
+// selectDevice は選択中のカメラを記録する
+func (e *emitter) selectDevice(deviceID int) {
+	e.deviceID = deviceID
+	e.saveState()
+}
+
+// restore は前回の状態を復元する。maxAgeより古いコードは重複判定に使わない。
+func (e *emitter) restore(saved state.State, maxAge time.Duration, now time.Time) {
+	// 保存順に復元するため、同じキーでは最後のコードが残る
+	for _, last := range saved.Fresh(maxAge, now) {
+		e.remember(last)
+	}
+	for _, last := range e.last {
+		log.Printf("Restored last QR code from %s: %s", last.Time.Local().Format(time.DateTime), displayText(last.Code, maxLogRunes))
+	}
+	for _, retry := range saved.Retries {
+		e.queueRetry(retry)
+	}
+	if len(e.retries) > 0 {
+		log.Printf("Restored %d pending write(s) to retry", len(e.retries))
+	}
+}
+
+// saveState は重複判定の状態、再試行待ちの書き込み、選択中のカメラを状態ファイルに保存する
+func (e *emitter) saveState() {
+	if e.statePath == "" {
+		return
+	}
+	s := state.State{DeviceID: e.deviceID, Retries: e.retries}
+	for _, last := range e.last {
+		s.Emitted = append(s.Emitted, last)
+	}
+	sort.Slice(s.Emitted, func(i, j int) bool { return s.Emitted[i].Time.Before(s.Emitted[j].Time) })
+	if err := state.Save(e.statePath, s, time.Now()); err != nil {
+		log.Printf("Error saving state to %s: %v", e.statePath, err)
+	}
+}
+
+// frameAnalyzer は1フレームの解析に必要な状態をまとめる。
+// 検出用のゴルーチンと設定を再読み込みするメインループから使われる。
+type frameAnalyzer struct {
+	mu        sync.Mutex
+	scanner   *qrcode.Scanner
+	assembler *qrcode.Assembler
+	confirmer *consensus.Confirmer // nilの場合は確認なしで出力する
+}
+
+// configure はスキャナーと確認ポリシーを置き換える
+func (a *frameAnalyzer) configure(scanner *qrcode.Scanner, confirmer *consensus.Confirmer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.scanner = scanner
+	a.confirmer = confirmer
+}
+
+// regions は走査対象のROIと追跡中の領域を返す
+func (a *frameAnalyzer) regions() ([]image.Rectangle, image.Rectangle) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.scanner.ROIs(), a.scanner.Tracked()
+}
+
+// analyze はフレームからQRコードを検出し、出力すべき検出結果を返す
+func (a *frameAnalyzer) analyze(img image.Image, now time.Time) ([]detection.Detection, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// ROIと追跡領域を考慮してQRコードを検出
+	results, err := a.scanner.Scan(img)
+	if err != nil {
+		return nil, err
+	}
+
+	var detections []detection.Detection
+	for _, result := range results {
+		// 分割シンボルはすべて揃うまで出力しない
+		if result.Append != nil {
+			combined, ok := a.assembler.Add(result)
+			if !ok {
+				continue
+			}
+			result = combined
+		}
+
+		if result.Text != "" {
+			detections = append(detections, detection.New(result.Text, result.Raw, now, result.Points))
+		}
+	}
+
+	// 複数フレームで同じ内容が読み取れるまで出力を保留する
+	if a.confirmer != nil {
+		detections = a.confirmer.Observe(detections, now)
+	}
+	return detections, nil
+}
+
+// pending は確認待ちの候補を返す
+func (a *frameAnalyzer) pending() []consensus.Candidate {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.confirmer == nil {
+		return nil
+	}
+	return a.confirmer.Pending()
+}
+
 // detectQRCodesFromFrames はMatチャネルからQRコードを検出する
-func detectQRCodesFromFrames(ctx context.Context, detector *qrcode.Detector, frameChan <-chan gocv.Mat, resultChan chan<- QRCodeResult) {
+// 複数のカメラのフレームを複数のゴルーチンで処理できるよう、解析状態はフレームごとに受け取る
+func detectQRCodesFromFrames(ctx context.Context, frameChan <-chan FrameData, resultChan chan<- detection.Detection) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
-		case mat, ok := <-frameChan:
+		case frame, ok := <-frameChan:
 			if !ok {
 				// チャネルが閉じられた
 				return
 			}
 
-			// MatからQRコードを検出
-			codes, err := detectQRCodesFromMat(mat, detector)
+			// MatをImageに変換
+			img, err := frame.Mat.ToImage()
 
 			// 使用済みのMatは必ず閉じる
-			mat.Close()
+			frame.Mat.Close()
 
 			if err != nil {
 				continue
 			}
 
+			detections, err := frame.analyzer.analyze(img, frame.Time)
+			if err != nil {
+				continue
+			}
+
 			// 検出されたQRコードを結果チャネルに送信
-			for _, code := range codes {
-				if code != "" {
-					resultChan <- QRCodeResult{
-						Code: code,
-						Time: time.Now(),
-					}
+			for _, d := range detections {
+				d.DeviceID = frame.DeviceID
+				d.Camera = frame.Camera
+				select {
+				case resultChan <- d:
+				case <-ctx.Done():
+					return
 				}
 			}
 		}
 	}
 }
 
-// detectQRCodesFromMat はMatからQRコードを検出する
-func detectQRCodesFromMat(mat gocv.Mat, detector *qrcode.Detector) ([]string, error) {
-	// MatをImageに変換
-	img, err := mat.ToImage()
-	if err != nil {
-		return nil, err
-	}
-
-	// ImageをJPEGにエンコード
-	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 75}); err != nil {
-		return nil, err
+// logPendingCandidates は確認待ちの候補をログに出力する
+func logPendingCandidates(pending []consensus.Candidate) {
+	for _, candidate := range pending {
+		log.Printf("Pending confirmation: %s (%d/%d)", displayText(candidate.Code, maxLogRunes), candidate.Count, candidate.Required)
 	}
-
-	// JPEGバイトデータからQRコードを検出
-	return detector.Detect(buf.Bytes())
 }
 
-// tryOpenCamera attempts to open the camera with the specified device ID
+// tryOpenCamera attempts to open the camera selected by cfg
 // Returns true if successful, false otherwise
-func tryOpenCamera(cam *camera.Camera, deviceID int) bool {
+func tryOpenCamera(cam *camera.Camera, cfg configs.CameraConfig) bool {
 	// First close the current camera if it's open
 	if cam.IsOpen() {
 		if err := cam.Close(); err != nil {
@@ -295,13 +1249,13 @@ func tryOpenCamera(cam *camera.Camera, deviceID int) bool {
 		}
 	}
 
-	// Set new device ID
-	cam.SetDeviceID(deviceID)
+	// Set new device ID or selector
+	selectCamera(cam, cfg)
 
 	// Try to open with new device ID
 	err := cam.Open()
 	if err != nil {
-		log.Printf("Failed to open camera with device ID %d: %v", deviceID, err)
+		log.Printf("Failed to open camera %s: %v", cameraName(cfg), err)
 		return false
 	}
 
@@ -309,35 +1263,34 @@ func tryOpenCamera(cam *camera.Camera, deviceID int) bool {
 }
 
 // runWithDisplay runs the application with UI
-func runWithDisplay(ctx context.Context, cam *camera.Camera, detector *qrcode.Detector, writer *fileio.Writer) {
+func runWithDisplay(ctx context.Context, s *session, window *gocv.Window) {
+	src, out := s.primary(), s.out
+	cam, analyzer := src.cam, src.analyzer
+
 	// Open the camera
-	if err := cam.Open(); err != nil {
-		log.Fatalf("Error opening camera: %v", err)
-	}
+	s.openCamera()
 
 	// 検出されたQRコードの結果を受け取るチャネル
-	resultChan := make(chan QRCodeResult, 10)
+	resultChan := make(chan detection.Detection, 10)
 
 	// フレーム処理チャネル
-	frameChannel := make(chan gocv.Mat, 5)
+	frameChannel := make(chan FrameData, 5)
 
 	// QRコード検出用のゴルーチンを起動
-	go detectQRCodesFromFrames(ctx, detector, frameChannel, resultChan)
+	go detectQRCodesFromFrames(ctx, frameChannel, resultChan)
 
 	// 現在のQRコード情報を保持する
 	type displayInfo struct {
-		code string
-		time time.Time
-		mu   sync.RWMutex
+		code       string
+		kind       payload.Type
+		time       time.Time
+		rejected   *pipeline.Rejection
+		rejectedAt time.Time
+		mu         sync.RWMutex
 	}
 
 	currentQRCode := &displayInfo{}
 
-	// Create window on the main thread
-	window := gocv.NewWindow("ME19 QR Code Scanner")
-	window.SetWindowProperty(gocv.WindowPropertyAutosize, gocv.WindowAutosize)
-	defer window.Close()
-
 	// Current device ID
 	currentDeviceID := cam.GetDeviceID()
 	log.Printf("Initial camera device ID: %d", currentDeviceID)
@@ -346,9 +1299,7 @@ func runWithDisplay(ctx context.Context, cam *camera.Camera, detector *qrcode.De
 	frameCount := 0
 
 	fmt.Println("Window is open. Click on the window and press keys 0-9 to switch cameras")
-
-	// 最後に書き込んだコード
-	var lastWrittenCode string
+	fmt.Println(controlKeysHelp)
 
 	// Main display loop
 	for {
@@ -360,27 +1311,35 @@ func runWithDisplay(ctx context.Context, cam *camera.Camera, detector *qrcode.De
 
 		case result := <-resultChan:
 			// 新しいコードであれば記録
-			if result.Code != lastWrittenCode && result.Code != "" {
-				if err := writer.WriteData(result.Code); err != nil {
-					log.Printf("Error writing QR code data to file: %v", err)
-				} else {
-					log.Printf("Detected new QR code and wrote to file: %s", result.Code)
-					lastWrittenCode = result.Code
-
-					// 表示用の情報を更新
-					currentQRCode.mu.Lock()
-					currentQRCode.code = result.Code
-					currentQRCode.time = result.Time
-					currentQRCode.mu.Unlock()
-				}
+			emitted, written, rejection := out.emit(result)
+
+			// 表示用の情報を更新
+			currentQRCode.mu.Lock()
+			if written {
+				currentQRCode.code = displayText(emitted.Code, maxOverlayRunes)
+				currentQRCode.kind = emitted.Type
+				currentQRCode.time = emitted.Time
 			}
+			if rejection != nil {
+				currentQRCode.rejected = rejection
+				currentQRCode.rejectedAt = result.Time
+			}
+			currentQRCode.mu.Unlock()
+
+		case <-s.reloads:
+			// 設定ファイルの変更を反映（カメラが開き直された場合はデバイスIDも更新）
+			s.reload()
+			currentDeviceID = cam.GetDeviceID()
 
 		default:
 			// Capture frame directly as Mat for display
 			mat, err := cam.CaptureFrameMat()
 			if err != nil {
-				log.Printf("Error capturing frame: %v", err)
-				time.Sleep(10 * time.Millisecond)
+				// 切断中の状態はカメラのイベントとして記録される
+				if !errors.Is(err, camera.ErrDisconnected) {
+					log.Printf("Error capturing frame: %v", err)
+				}
+				time.Sleep(captureRetryDelay(err))
 				continue
 			}
 
@@ -391,14 +1350,25 @@ func runWithDisplay(ctx context.Context, cam *camera.Camera, detector *qrcode.De
 				continue
 			}
 
-			// QRコード検出用にMatのコピーを作成
-			clone := mat.Clone()
-			select {
-			case frameChannel <- clone:
-				// フレームが正常に送信された
-			default:
-				// チャネルがいっぱいの場合はフレームを破棄
-				clone.Close()
+			// スキャン間隔ごとにQRコード検出用のMatのコピーを作成
+			if now := time.Now(); src.throttle.due(now) {
+				clone := mat.Clone()
+				select {
+				case frameChannel <- FrameData{Mat: clone, Time: now, DeviceID: currentDeviceID, analyzer: analyzer}:
+					// フレームが正常に送信された
+				default:
+					// チャネルがいっぱいの場合はフレームを破棄
+					clone.Close()
+				}
+			}
+
+			// 走査対象のROIと追跡中の領域を描画
+			rois, tracked := analyzer.regions()
+			for _, roi := range rois {
+				gocv.Rectangle(&mat, roi, color.RGBA{0, 128, 255, 255}, 2)
+			}
+			if !tracked.Empty() {
+				gocv.Rectangle(&mat, tracked, color.RGBA{255, 255, 0, 255}, 1)
 			}
 
 			// Draw current device ID text on the frame
@@ -414,14 +1384,33 @@ func runWithDisplay(ctx context.Context, cam *camera.Camera, detector *qrcode.De
 				// QRコードの検出から一定時間以内なら表示
 				if time.Since(currentQRCode.time) < 3*time.Second {
 					gocv.PutText(&mat,
-						fmt.Sprintf("QR: %s", currentQRCode.code),
+						fmt.Sprintf("QR [%s]: %s", currentQRCode.kind, currentQRCode.code),
 						image.Point{X: 10, Y: 60},
 						gocv.FontHersheyPlain, 1.2,
 						color.RGBA{R: 255, G: 0, B: 0, A: 255}, 2)
 				}
 			}
+			// 拒否されたコードがあれば理由を表示
+			if currentQRCode.rejected != nil && time.Since(currentQRCode.rejectedAt) < 3*time.Second {
+				gocv.PutText(&mat,
+					fmt.Sprintf("Rejected (%s): %s",
+						displayText(currentQRCode.rejected.String(), maxOverlayRunes),
+						displayText(currentQRCode.rejected.Detection.Code, maxOverlayRunes)),
+					image.Point{X: 10, Y: 120},
+					gocv.FontHersheyPlain, 1.2,
+					color.RGBA{R: 255, G: 0, B: 255, A: 255}, 2)
+			}
 			currentQRCode.mu.RUnlock()
 
+			// 確認待ちの候補があれば進捗を表示
+			if pending := analyzer.pending(); len(pending) > 0 {
+				gocv.PutText(&mat,
+					fmt.Sprintf("Confirming: %s (%d/%d)", displayText(pending[0].Code, maxOverlayRunes), pending[0].Count, pending[0].Required),
+					image.Point{X: 10, Y: 90},
+					gocv.FontHersheyPlain, 1.2,
+					color.RGBA{R: 255, G: 165, B: 0, A: 255}, 2)
+			}
+
 			// Show the image in the window
 			window.IMShow(mat)
 			mat.Close()
@@ -437,6 +1426,13 @@ func runWithDisplay(ctx context.Context, cam *camera.Camera, detector *qrcode.De
 			frameCount++
 			if frameCount%100 == 0 {
 				log.Printf("Processed %d frames, current device: %d", frameCount, currentDeviceID)
+				logPendingCandidates(analyzer.pending())
+				out.retryPending()
+			}
+
+			// カメラコントロールのショートカット
+			if handleControlKey(cam, key, cameraControls(s.config.Camera.Controls)) {
+				continue
 			}
 
 			// Handle numeric key presses (both standard and numpad)
@@ -454,13 +1450,16 @@ func runWithDisplay(ctx context.Context, cam *camera.Camera, detector *qrcode.De
 				if newDeviceID != currentDeviceID {
 					log.Printf("Switching from device ID %d to %d", currentDeviceID, newDeviceID)
 
-					if tryOpenCamera(cam, newDeviceID) {
+					previous := configs.CameraConfig{DeviceID: currentDeviceID, Device: cam.Selector()}
+					if tryOpenCamera(cam, configs.CameraConfig{DeviceID: newDeviceID}) {
 						log.Printf("Successfully switched to camera device ID: %d", newDeviceID)
 						currentDeviceID = newDeviceID
+						out.selectDevice(newDeviceID)
 					} else {
 						log.Printf("Failed to switch camera. Reopening original camera (device ID: %d)", currentDeviceID)
-						if tryOpenCamera(cam, currentDeviceID) {
+						if tryOpenCamera(cam, previous) {
 							log.Println("Successfully reopened original camera")
+							currentDeviceID = cam.GetDeviceID()
 						} else {
 							log.Println("Failed to reopen original camera. Exiting.")
 							return
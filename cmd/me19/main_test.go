@@ -1,11 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/png"
 	"os"
-	"runtime"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/eotel/me19/configs"
 	"github.com/eotel/me19/internal/camera"
+	"github.com/eotel/me19/internal/detection"
+	"github.com/eotel/me19/internal/framegen"
+	"github.com/eotel/me19/internal/payload"
+	"github.com/eotel/me19/internal/pipeline"
+	"github.com/eotel/me19/internal/qrcode"
+	"github.com/eotel/me19/internal/sink"
 )
 
 func TestCameraInitialization(t *testing.T) {
@@ -38,70 +50,34 @@ func TestCameraInitialization(t *testing.T) {
 }
 
 func TestPlatformDetection(t *testing.T) {
-	originalDisplay := os.Getenv("DISPLAY")
-	originalCGOEnabled := os.Getenv("CGO_ENABLED")
-	defer func() {
-		os.Setenv("DISPLAY", originalDisplay)
-		os.Setenv("CGO_ENABLED", originalCGOEnabled)
-	}()
-
 	testCases := []struct {
-		name           string
-		platform       string
-		display        string
-		cgoEnabled     string
-		expectHeadless bool
+		name         string
+		mode         string
+		platform     string
+		env          map[string]string
+		expectWindow bool
 	}{
-		{
-			name:           "Linux with DISPLAY",
-			platform:       "linux",
-			display:        ":0",
-			cgoEnabled:     "1",
-			expectHeadless: false,
-		},
-		{
-			name:           "Linux without DISPLAY",
-			platform:       "linux",
-			display:        "",
-			cgoEnabled:     "1",
-			expectHeadless: true,
-		},
-		{
-			name:           "macOS with CGO_ENABLED",
-			platform:       "darwin",
-			display:        "",
-			cgoEnabled:     "1",
-			expectHeadless: false,
-		},
-		{
-			name:           "macOS without CGO_ENABLED",
-			platform:       "darwin",
-			display:        "",
-			cgoEnabled:     "0",
-			expectHeadless: true,
-		},
+		{"Linux with DISPLAY", modeAuto, "linux", map[string]string{"DISPLAY": ":0"}, true},
+		{"Linux without DISPLAY", modeAuto, "linux", nil, false},
+		{"Linux on Wayland", modeAuto, "linux", map[string]string{"WAYLAND_DISPLAY": "wayland-0"}, true},
+		{"SSH with X forwarding", modeAuto, "linux", map[string]string{"DISPLAY": "localhost:10.0", "SSH_CONNECTION": "10.0.0.2 50000 10.0.0.1 22"}, true},
+		{"FreeBSD with DISPLAY", modeAuto, "freebsd", map[string]string{"DISPLAY": ":0"}, true},
+		{"macOS desktop", modeAuto, "darwin", map[string]string{"CGO_ENABLED": "0"}, true},
+		{"macOS over SSH", modeAuto, "darwin", map[string]string{"SSH_CONNECTION": "10.0.0.2 50000 10.0.0.1 22"}, false},
+		{"Windows desktop", modeAuto, "windows", nil, true},
+		{"headless forced", modeHeadless, "linux", map[string]string{"DISPLAY": ":0"}, false},
+		{"display forced", modeDisplay, "linux", nil, true},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			if runtime.GOOS != tc.platform {
-				t.Skipf("Skipping test for %s platform on %s", tc.platform, runtime.GOOS)
-				return
+			getenv := func(key string) string { return tc.env[key] }
+			display, reason := useDisplay(tc.mode, tc.platform, getenv)
+			if display != tc.expectWindow {
+				t.Errorf("useDisplay(%q, %q, %v) = %v (%s), want %v", tc.mode, tc.platform, tc.env, display, reason, tc.expectWindow)
 			}
-
-			os.Setenv("DISPLAY", tc.display)
-			os.Setenv("CGO_ENABLED", tc.cgoEnabled)
-
-			var headless bool
-			if runtime.GOOS == "darwin" {
-				headless = os.Getenv("CGO_ENABLED") == "0"
-			} else {
-				headless = os.Getenv("DISPLAY") == ""
-			}
-
-			if headless != tc.expectHeadless {
-				t.Errorf("Expected headless=%v for %s platform with DISPLAY=%q and CGO_ENABLED=%q, got %v",
-					tc.expectHeadless, tc.platform, tc.display, tc.cgoEnabled, headless)
+			if reason == "" {
+				t.Error("useDisplay() should explain its decision")
 			}
 		})
 	}
@@ -140,3 +116,331 @@ func TestErrorHandling(t *testing.T) {
 		t.Error("Expected error when opening non-existent camera device, but got nil")
 	}
 }
+
+func TestEmitter_DedupScope(t *testing.T) {
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	testCases := []struct {
+		name       string
+		perCamera  bool
+		detections []detection.Detection
+		want       []bool
+	}{
+		{
+			name: "すべてのカメラで重複を判定",
+			detections: []detection.Detection{
+				{Code: "A", Camera: "entrance", Time: at(0)},
+				{Code: "A", Camera: "exit", Time: at(1)},
+				{Code: "A", Camera: "entrance", Time: at(2)},
+				{Code: "B", Camera: "exit", Time: at(3)},
+				{Code: "A", Camera: "entrance", Time: at(4)},
+				// 判定時間を過ぎると別のカメラでも出力する
+				{Code: "A", Camera: "exit", Time: at(15)},
+			},
+			want: []bool{true, false, false, true, false, true},
+		},
+		{
+			name: "2台のカメラが別々のコードを読み続ける",
+			detections: []detection.Detection{
+				{Code: "X", Camera: "entrance", Time: at(0)},
+				{Code: "Y", Camera: "exit", Time: at(0)},
+				{Code: "X", Camera: "entrance", Time: at(1)},
+				{Code: "Y", Camera: "exit", Time: at(1)},
+				{Code: "X", Camera: "entrance", Time: at(20)},
+				{Code: "Y", Camera: "exit", Time: at(20)},
+			},
+			want: []bool{true, true, false, false, false, false},
+		},
+		{
+			name: "1台のカメラでは別のコードを挟めば出力する",
+			detections: []detection.Detection{
+				{Code: "A", Time: at(0)},
+				{Code: "B", Time: at(1)},
+				{Code: "A", Time: at(2)},
+			},
+			want: []bool{true, true, true},
+		},
+		{
+			name:      "カメラごとに重複を判定",
+			perCamera: true,
+			detections: []detection.Detection{
+				{Code: "A", Camera: "entrance", Time: at(0)},
+				{Code: "A", Camera: "exit", Time: at(1)},
+				{Code: "A", Camera: "entrance", Time: at(2)},
+				{Code: "B", Camera: "exit", Time: at(3)},
+				{Code: "A", Camera: "entrance", Time: at(4)},
+			},
+			want: []bool{true, true, false, true, false},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &emitter{pipeline: pipeline.New(), perCamera: tc.perCamera, window: 10 * time.Second}
+			for i, d := range tc.detections {
+				if _, written, _ := out.emit(d); written != tc.want[i] {
+					t.Errorf("emit(%s from %s at %v) written = %v, want %v", d.Code, d.Camera, d.Time.Sub(start), written, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+// committingStage records the codes committed after they are written
+type committingStage struct {
+	committed []string
+}
+
+func (c *committingStage) Name() string { return "committing" }
+
+func (c *committingStage) Process(d detection.Detection) (detection.Detection, error) {
+	return d, nil
+}
+
+func (c *committingStage) Commit(d detection.Detection) error {
+	c.committed = append(c.committed, d.Code)
+	return nil
+}
+
+func TestEmitter_CommitAfterWrite(t *testing.T) {
+	stage := &committingStage{}
+	recorder := &recordingSink{err: errors.New("disk full")}
+	out := &emitter{pipeline: pipeline.New(stage), sinks: []sink.Sink{recorder}}
+
+	// 書き込みに失敗したコードは確定しない（チケットを使用済みにしない）
+	if _, written, _ := out.emit(detection.Detection{Code: "TICKET-1", Time: time.Now()}); written {
+		t.Fatal("emit should report the failed write")
+	}
+	if len(stage.committed) != 0 {
+		t.Fatalf("A code that was not written should not be committed, got %v", stage.committed)
+	}
+
+	// 再試行で書き込めたときに確定する
+	recorder.err = nil
+	out.retryPending()
+	if !reflect.DeepEqual(stage.committed, []string{"TICKET-1"}) {
+		t.Errorf("committed = %v after the retry, want [TICKET-1]", stage.committed)
+	}
+}
+
+func TestEmitter_StructuredPayloads(t *testing.T) {
+	codes := []string{
+		"BEGIN:VCARD\r\nVERSION:3.0\r\nFN:山田 太郎\r\nEND:VCARD",
+		"01095011015300031725061510LOT-42\x1d21SN1",
+	}
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		// デフォルトでは追記先の行を偽造できないよう改行やGS区切りも除去する
+		{"strip", []string{"BEGIN:VCARDVERSION:3.0FN:山田 太郎END:VCARD", "01095011015300031725061510LOT-4221SN1"}},
+		// keep_separators を指定した場合はそのまま出力される
+		{"keep_separators", codes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			config := configs.DefaultConfig()
+			config.Sanitize.ControlChars = tt.mode
+			stages, err := newPipeline(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := &recordingSink{}
+			out := &emitter{pipeline: stages, sinks: []sink.Sink{recorder}}
+
+			for _, code := range codes {
+				out.emit(detection.New(code, []byte(code), time.Now(), nil))
+			}
+			if len(recorder.written) != len(codes) {
+				t.Fatalf("wrote %d codes, want %d", len(recorder.written), len(codes))
+			}
+			for i, want := range tt.want {
+				if got := recorder.written[i]; got.Code != want || string(got.Raw) != want {
+					t.Errorf("wrote %q (raw %q), want %q", got.Code, got.Raw, want)
+				}
+			}
+			// 形式は除去前の内容で判定される
+			if recorder.written[0].Type != payload.TypeVCard || recorder.written[1].Type != payload.TypeGS1 {
+				t.Errorf("types = %s, %s", recorder.written[0].Type, recorder.written[1].Type)
+			}
+		})
+	}
+}
+
+func TestCameraControls(t *testing.T) {
+	autofocus, focus, bufferSize := false, 30.0, 1
+	got := cameraControls(configs.ControlsConfig{AutoFocus: &autofocus, Focus: &focus, BufferSize: &bufferSize})
+	want := camera.Controls{camera.AutoFocus: 0, camera.Focus: 30, camera.BufferSize: 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cameraControls() = %v, want %v", got, want)
+	}
+	if got := cameraControls(configs.ControlsConfig{}); len(got) != 0 {
+		t.Errorf("cameraControls() without settings = %v, want none", got)
+	}
+}
+
+func TestHandleControlKey(t *testing.T) {
+	cam := camera.NewWithTestBackend()
+	if err := cam.Open(); err != nil {
+		t.Fatalf("Failed to open camera: %v", err)
+	}
+	defer cam.Close()
+	configured := camera.Controls{camera.AutoFocus: 1, camera.Focus: 0}
+	cam.SetControls(configured)
+
+	// フォーカスを変えると自動フォーカスが切れる
+	for range 3 {
+		if !handleControlKey(cam, 'l', configured) {
+			t.Fatal("'l' should change the focus")
+		}
+	}
+	if focus, _ := cam.Control(camera.Focus); focus != 15 {
+		t.Errorf("focus = %v after pressing 'l' 3 times, want 15", focus)
+	}
+	if autofocus, _ := cam.Control(camera.AutoFocus); autofocus != 0 {
+		t.Errorf("autofocus = %v after changing the focus, want 0", autofocus)
+	}
+
+	// 0未満にはならない
+	for range 5 {
+		handleControlKey(cam, 'k', configured)
+	}
+	if focus, _ := cam.Control(camera.Focus); focus != 0 {
+		t.Errorf("focus = %v after pressing 'k' 5 times, want 0", focus)
+	}
+
+	// 自動モードの切り替えと設定値への復元
+	handleControlKey(cam, 'j', configured)
+	if autofocus, _ := cam.Control(camera.AutoFocus); autofocus != 1 {
+		t.Errorf("autofocus = %v after pressing 'j', want 1", autofocus)
+	}
+	handleControlKey(cam, 'l', configured)
+	handleControlKey(cam, 'r', configured)
+	if focus, _ := cam.Control(camera.Focus); focus != 0 {
+		t.Errorf("focus = %v after pressing 'r', want 0", focus)
+	}
+
+	// カメラの切り替えに使うキーは扱わない
+	if handleControlKey(cam, '1', configured) {
+		t.Error("'1' should not be a camera control shortcut")
+	}
+}
+
+func TestAdjustedValue(t *testing.T) {
+	testCases := []struct {
+		current, step, want float64
+	}{
+		{0, 5, 5},
+		{100, 5, 110},
+		{5000, -1, 4500},
+		{-6, -1, -7},
+	}
+	for _, tc := range testCases {
+		if got := adjustedValue(tc.current, tc.step); got != tc.want {
+			t.Errorf("adjustedValue(%v, %v) = %v, want %v", tc.current, tc.step, got, tc.want)
+		}
+	}
+}
+
+// recordingSink records the detections written to it, or fails with err
+type recordingSink struct {
+	written []detection.Detection
+	err     error
+}
+
+func (r *recordingSink) Name() string { return "recording" }
+
+func (r *recordingSink) Write(d detection.Detection) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.written = append(r.written, d)
+	return nil
+}
+
+func (r *recordingSink) Close() error { return nil }
+
+func TestPipeline_ScriptedCamera(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := camera.NewManualClock(start)
+	code := func(payload string, rotation float64) framegen.Scene {
+		return framegen.Scene{Codes: []framegen.Code{{Payload: payload, Rotation: rotation}}}
+	}
+	noisy := code("TICKET-2", 0)
+	noisy.Noise, noisy.Seed = 30, 1
+	// 1ステップは100ms。1フレームだけの読み取り（FLASH）は確定しない
+	cam := camera.NewWithScript(camera.Script{
+		Steps: []camera.Step{
+			{},
+			{Scene: code("TICKET-1", 0), Duration: 300 * time.Millisecond},
+			{Scene: code("FLASH", 0)},
+			{},
+			{Err: errors.New("glitch")},
+			{Scene: noisy, Duration: 200 * time.Millisecond},
+			{Scene: code("TICKET-1", 90), Duration: 200 * time.Millisecond},
+		},
+		Width:  480,
+		Height: 360,
+	}, clock)
+	if err := cam.Open(); err != nil {
+		t.Fatalf("Failed to open camera: %v", err)
+	}
+	defer cam.Close()
+
+	detector := qrcode.New()
+	if err := detector.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer detector.Close()
+	confirmer, err := newConfirmer(configs.ConfirmationConfig{Enabled: true, Mode: "frames", Required: 2, Frames: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	analyzer := &frameAnalyzer{assembler: qrcode.NewAssembler(0)}
+	analyzer.configure(newScanner(detector, configs.DefaultConfig().QRCode), confirmer)
+
+	recorder := &recordingSink{}
+	out := &emitter{pipeline: pipeline.New(), sinks: []sink.Sink{recorder}}
+
+	// スクリプトが終わるまで100msごとに1フレームを処理する
+	for {
+		frame, err := cam.CaptureFrame()
+		if errors.Is(err, camera.ErrScriptEnded) {
+			break
+		}
+		if err == nil {
+			img, _, err := image.Decode(bytes.NewReader(frame))
+			if err != nil {
+				t.Fatalf("decoding frame: %v", err)
+			}
+			detections, err := analyzer.analyze(img, clock.Now())
+			if err != nil {
+				t.Fatalf("analyze() error = %v", err)
+			}
+			for _, d := range detections {
+				out.emit(d)
+			}
+		}
+		clock.Advance(100 * time.Millisecond)
+	}
+
+	want := []struct {
+		code string
+		at   time.Duration
+	}{
+		{"TICKET-1", 200 * time.Millisecond},
+		{"TICKET-2", 800 * time.Millisecond},
+		{"TICKET-1", 1000 * time.Millisecond},
+	}
+	if len(recorder.written) != len(want) {
+		t.Fatalf("emitted %d detections, want %d: %+v", len(recorder.written), len(want), recorder.written)
+	}
+	for i, w := range want {
+		got := recorder.written[i]
+		if got.Code != w.code || !got.Time.Equal(start.Add(w.at)) {
+			t.Errorf("detection %d = %s at %v, want %s at %v", i, got.Code, got.Time.Sub(start), w.code, w.at)
+		}
+	}
+}
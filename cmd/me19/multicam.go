@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/eotel/me19/configs"
+	"github.com/eotel/me19/internal/camera"
+	"github.com/eotel/me19/internal/detection"
+	"github.com/eotel/me19/internal/qrcode"
+	"gocv.io/x/gocv"
+)
+
+const (
+	// プレビューでカメラ1台に割り当てる領域の大きさ
+	tileWidth  = 640
+	tileHeight = 480
+	// プレビューを更新する間隔
+	previewInterval = 33 * time.Millisecond
+	// 確認待ちの候補のログ出力と失敗した書き込みの再試行の間隔
+	statusInterval = time.Second
+)
+
+// cameraSource is a camera with its own detector and analysis state. With
+// several cameras each source is captured by its own goroutine.
+type cameraSource struct {
+	name     string // 空の場合はcameraセクションのカメラ1台だけを使う
+	cam      *camera.Camera
+	detector *qrcode.Detector
+	analyzer *frameAnalyzer
+	throttle *scanThrottle
+
+	// プレビュー用の情報（キャプチャ用のゴルーチンとメインループから使われる）
+	mu       sync.Mutex
+	preview  gocv.Mat     // 最後に取得したフレーム
+	state    camera.State // 接続状態
+	deviceID int          // 最後に開いたデバイスID
+	lastCode string       // 最後に書き込んだコード
+	lastTime time.Time
+}
+
+// newCamera creates a camera with the real backend, or the mock backend in test mode
+func newCamera() *camera.Camera {
+	if os.Getenv("ME19_TEST_MODE") == "true" {
+		return camera.NewWithTestBackend()
+	}
+	return camera.New()
+}
+
+// newCameraSource sets up a camera and the detector that scans its frames
+func newCameraSource(name string, cfg configs.CameraConfig, qr configs.QRCodeConfig) (*cameraSource, error) {
+	src := &cameraSource{
+		name:     name,
+		cam:      newCamera(),
+		detector: qrcode.New(),
+		throttle: &scanThrottle{interval: time.Duration(qr.ScanInterval) * time.Millisecond},
+	}
+
+	// カメラのデバイスIDと解像度・フレームレートを設定
+	selectCamera(src.cam, cfg)
+	src.cam.SetFormat(cameraFormat(cfg))
+	// 露出やフォーカスなどのコントロールはカメラを開くたびに要求する
+	src.cam.SetControls(cameraControls(cfg.Controls))
+	// 読み取りが続けて失敗した場合はカメラを開き直す
+	src.cam.SetReconnect(reconnectPolicy(cfg.Reconnect), src.logCameraEvent)
+
+	// 検出器を初期化
+	if err := src.detector.Initialize(); err != nil {
+		return nil, fmt.Errorf("QR code detector: %w", err)
+	}
+	// ECIのないバイトモードデータの文字コードを設定
+	if err := src.detector.SetCharset(qr.Charset); err != nil {
+		src.detector.Close()
+		return nil, fmt.Errorf("QR code charset: %w", err)
+	}
+
+	// 複数フレームでの一致を確認してから出力するポリシー
+	confirmer, err := newConfirmer(qr.Confirmation)
+	if err != nil {
+		src.detector.Close()
+		return nil, fmt.Errorf("confirmation policy: %w", err)
+	}
+
+	src.analyzer = &frameAnalyzer{
+		// 複数シンボルに分割されたQRコード（Structured Append）を結合するアセンブラ
+		assembler: qrcode.NewAssembler(time.Duration(qr.StructuredAppendTimeout) * time.Millisecond),
+	}
+	// ROIと追跡設定に従って走査するスキャナー
+	src.analyzer.configure(newScanner(src.detector, qr), confirmer)
+	return src, nil
+}
+
+// close releases the camera, the detector and the preview frame
+func (src *cameraSource) close() {
+	src.cam.Close()
+	src.detector.Close()
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if src.preview.Ptr() != nil {
+		src.preview.Close()
+	}
+}
+
+// setCharset changes the charset of the detector while no frame is being analyzed
+func (src *cameraSource) setCharset(charset string) error {
+	src.analyzer.mu.Lock()
+	defer src.analyzer.mu.Unlock()
+	return src.detector.SetCharset(charset)
+}
+
+// label names the camera in logs and the preview
+func (src *cameraSource) label() string {
+	if src.name != "" {
+		return displayText(src.name, maxOverlayRunes)
+	}
+	return fmt.Sprintf("device %d", src.cam.GetDeviceID())
+}
+
+// logCameraEvent records and logs changes of the connection state
+func (src *cameraSource) logCameraEvent(e camera.Event) {
+	src.mu.Lock()
+	src.state = e.State
+	src.deviceID = e.DeviceID
+	src.mu.Unlock()
+	if src.name == "" {
+		log.Print(cameraEventMessage(e))
+		return
+	}
+	log.Printf("[%s] %s", src.label(), cameraEventMessage(e))
+}
+
+// setPreview replaces the frame shown in the preview, taking ownership of mat
+func (src *cameraSource) setPreview(mat gocv.Mat) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if src.preview.Ptr() != nil {
+		src.preview.Close()
+	}
+	src.preview = mat
+}
+
+// written records a code written to the outputs for the preview
+func (src *cameraSource) written(d detection.Detection) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	src.lastCode = displayText(d.Code, maxOverlayRunes)
+	src.lastTime = d.Time
+}
+
+// capture reads frames until ctx is cancelled, sending them to frameChan at the
+// scan interval. With preview set the latest frame is also kept for the window.
+func (src *cameraSource) capture(ctx context.Context, frameChan chan<- FrameData, preview bool) {
+	for ctx.Err() == nil {
+		mat, err := src.cam.CaptureFrameMat()
+		if err != nil || mat.Empty() {
+			if mat.Ptr() != nil {
+				mat.Close()
+			}
+			// 切断中の状態はカメラのイベントとして記録される
+			if err != nil && !errors.Is(err, camera.ErrDisconnected) {
+				log.Printf("[%s] Error capturing frame: %v", src.label(), err)
+			}
+			time.Sleep(captureRetryDelay(err))
+			continue
+		}
+
+		// スキャン間隔ごとにフレームを検出チャネルに送信（コピーを作成）
+		if now := time.Now(); src.throttle.due(now) {
+			clone := mat.Clone()
+			select {
+			case frameChan <- FrameData{Mat: clone, Time: now, DeviceID: src.cam.GetDeviceID(), Camera: src.name, analyzer: src.analyzer}:
+				// フレームが正常に送信された
+			default:
+				// チャネルがいっぱいの場合はフレームを破棄
+				clone.Close()
+			}
+		}
+
+		if preview {
+			src.setPreview(mat)
+		} else {
+			mat.Close()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// runCameras captures from several cameras at the same time. Each camera is read
+// by its own goroutine and its frames are scanned in order by another one.
+// With a window the cameras are shown side by side; a nil window runs headless.
+func runCameras(ctx context.Context, s *session, window *gocv.Window) {
+	for _, src := range s.sources {
+		if err := src.cam.Open(); err != nil {
+			log.Fatalf("Error opening camera %s: %v", src.label(), err)
+		}
+		log.Printf("Camera %s is device ID %d", src.label(), src.cam.GetDeviceID())
+		logControls(src.label(), src.cam)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	// QRコード検出結果を共有するためのチャネル
+	resultChan := make(chan detection.Detection, 10*len(s.sources))
+
+	// カメラごとにキャプチャ用と検出用のゴルーチンを1つずつ起動する。
+	// フレームのチャネルもカメラごとに分け、各カメラのフレームを取得順に処理する
+	var capturing, detecting sync.WaitGroup
+	frameChannels := make([]chan FrameData, len(s.sources))
+	for i, src := range s.sources {
+		frameChannel := make(chan FrameData, 5)
+		frameChannels[i] = frameChannel
+		capturing.Add(1)
+		go func() {
+			defer capturing.Done()
+			src.capture(ctx, frameChannel, window != nil)
+		}()
+		detecting.Add(1)
+		go func() {
+			defer detecting.Done()
+			detectQRCodesFromFrames(ctx, frameChannel, resultChan)
+		}()
+	}
+	// カメラを閉じる前にゴルーチンの終了を待ち、処理されずに残ったフレームを解放する
+	defer func() {
+		cancel()
+		capturing.Wait()
+		detecting.Wait()
+		for _, frameChannel := range frameChannels {
+			close(frameChannel)
+			for frame := range frameChannel {
+				frame.Mat.Close()
+			}
+		}
+	}()
+
+	status := time.NewTicker(statusInterval)
+	defer status.Stop()
+	var previewTick <-chan time.Time
+	if window != nil {
+		ticker := time.NewTicker(previewInterval)
+		defer ticker.Stop()
+		previewTick = ticker.C
+		fmt.Println("Window is open. Each tile shows one camera")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case result := <-resultChan:
+			// 新しいコードであれば記録
+			if emitted, written, _ := s.out.emit(result); written {
+				if src := s.source(result.Camera); src != nil {
+					src.written(emitted)
+				}
+			}
+
+		case <-s.reloads:
+			// 設定ファイルの変更を反映
+			s.reload()
+
+		case <-status.C:
+			// 確認待ちの候補を定期的にログ出力し、失敗した書き込みを再試行
+			for _, src := range s.sources {
+				logPendingCandidates(src.analyzer.pending())
+			}
+			s.out.retryPending()
+
+		case <-previewTick:
+			canvas := previewCanvas(s.sources)
+			window.IMShow(canvas)
+			canvas.Close()
+			window.WaitKey(1)
+		}
+	}
+}
+
+// source finds a camera by name
+func (s *session) source(name string) *cameraSource {
+	for _, src := range s.sources {
+		if src.name == name {
+			return src
+		}
+	}
+	return nil
+}
+
+// previewCanvas tiles the latest frames of the cameras in a grid, each scaled to
+// fit its tile and labelled with the camera, its state and the last code written
+func previewCanvas(sources []*cameraSource) gocv.Mat {
+	cols := int(math.Ceil(math.Sqrt(float64(len(sources)))))
+	rows := (len(sources) + cols - 1) / cols
+	canvas := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), rows*tileHeight, cols*tileWidth, gocv.MatTypeCV8UC3)
+
+	for i, src := range sources {
+		tile := image.Rect(0, 0, tileWidth, tileHeight).Add(image.Pt(i%cols*tileWidth, i/cols*tileHeight))
+
+		src.mu.Lock()
+		if src.preview.Ptr() != nil && !src.preview.Empty() && src.preview.Type() == gocv.MatTypeCV8UC3 {
+			// 縦横比を保ったまま領域に収める
+			scale := math.Min(float64(tileWidth)/float64(src.preview.Cols()), float64(tileHeight)/float64(src.preview.Rows()))
+			size := image.Pt(int(float64(src.preview.Cols())*scale), int(float64(src.preview.Rows())*scale))
+			offset := tile.Min.Add(image.Pt((tileWidth-size.X)/2, (tileHeight-size.Y)/2))
+
+			resized := gocv.NewMat()
+			gocv.Resize(src.preview, &resized, size, 0, 0, gocv.InterpolationLinear)
+			region := canvas.Region(image.Rectangle{Min: offset, Max: offset.Add(size)})
+			resized.CopyTo(&region)
+			region.Close()
+			resized.Close()
+		}
+		state, deviceID, lastCode, lastTime := src.state, src.deviceID, src.lastCode, src.lastTime
+		src.mu.Unlock()
+
+		gocv.PutText(&canvas,
+			fmt.Sprintf("%s (device %d, %s)", src.label(), deviceID, state),
+			tile.Min.Add(image.Pt(10, 30)),
+			gocv.FontHersheyPlain, 1.2,
+			color.RGBA{0, 255, 0, 255}, 2)
+		// QRコードの検出から一定時間以内なら表示
+		if lastCode != "" && time.Since(lastTime) < 3*time.Second {
+			gocv.PutText(&canvas,
+				fmt.Sprintf("QR: %s", lastCode),
+				tile.Min.Add(image.Pt(10, 60)),
+				gocv.FontHersheyPlain, 1.2,
+				color.RGBA{R: 255, G: 0, B: 0, A: 255}, 2)
+		}
+		// 確認待ちの候補があれば進捗を表示
+		if pending := src.analyzer.pending(); len(pending) > 0 {
+			gocv.PutText(&canvas,
+				fmt.Sprintf("Confirming: %s (%d/%d)", displayText(pending[0].Code, maxOverlayRunes), pending[0].Count, pending[0].Required),
+				tile.Min.Add(image.Pt(10, 90)),
+				gocv.FontHersheyPlain, 1.2,
+				color.RGBA{R: 255, G: 165, B: 0, A: 255}, 2)
+		}
+	}
+	return canvas
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eotel/me19/configs"
+	"github.com/eotel/me19/internal/consensus"
+	"github.com/eotel/me19/internal/pipeline"
+	"github.com/eotel/me19/internal/qrcode"
+	"github.com/eotel/me19/internal/sink"
+)
+
+// reloadDelay is how long the configuration file must be unchanged before it is reloaded
+const reloadDelay = 250 * time.Millisecond
+
+// restartSections are the configuration sections that are only applied at startup
+var restartSections = []string{"sinks", "actions", "history", "state", "lock", "reload", "display", "cameras"}
+
+// session holds the running components that a configuration reload updates.
+// It is only used from the capture loop.
+type session struct {
+	config    configs.Config    // 最後に読み込んだ設定（実行中に選択したカメラは含まない）
+	path      string            // 監視中の設定ファイル（空の場合は再読み込みしない）
+	overrides map[string]string // コマンドラインで指定された値（再読み込み後も優先する）
+	reloads   <-chan struct{}   // 設定ファイルの変更通知（監視していない場合はnil）
+
+	sources    []*cameraSource // camerasを指定しない場合はcameraセクションのカメラ1台
+	out        *emitter
+	outputSink *sink.FileSink
+}
+
+// primary returns the camera of the single-camera capture loops
+func (s *session) primary() *cameraSource {
+	return s.sources[0]
+}
+
+// multiCamera reports whether several cameras are captured at the same time
+func (s *session) multiCamera() bool {
+	return len(s.config.Cameras) > 0
+}
+
+// reload loads the configuration file again and applies what changed. An
+// invalid configuration is rejected and a change that fails is rolled back,
+// so the scanner always keeps running with a consistent configuration.
+func (s *session) reload() {
+	next, err := configs.Load(s.path, s.overrides)
+	if err != nil {
+		log.Printf("Configuration reload failed, keeping the current configuration: %v", err)
+		return
+	}
+	if err := next.Validate(); err != nil {
+		log.Printf("Configuration reload rejected, keeping the current configuration: %v", err)
+		return
+	}
+
+	changes := configs.Diff(s.config, next)
+	if len(changes) == 0 {
+		return
+	}
+	for _, change := range changes {
+		log.Printf("Configuration changed: %s", change)
+	}
+
+	// 起動時にのみ適用される項目は現在の値のままにする。
+	// 複数のカメラを使う場合はカメラの設定も再起動後に反映する
+	restart := restartSections
+	if s.multiCamera() {
+		restart = append(restart[:len(restart):len(restart)], "camera")
+	}
+	if configs.Changed(changes, restart...) {
+		var keys []string
+		for _, change := range changes {
+			if configs.Changed([]configs.Change{change}, restart...) {
+				keys = append(keys, change.Key)
+			}
+		}
+		log.Printf("Changes to %s take effect after a restart", strings.Join(keys, ", "))
+		next.Sinks = s.config.Sinks
+		next.Actions = s.config.Actions
+		next.History = s.config.History
+		next.State = s.config.State
+		next.Lock = s.config.Lock
+		next.Reload = s.config.Reload
+		next.Display = s.config.Display
+		next.Cameras = s.config.Cameras
+		if s.multiCamera() {
+			next.Camera = s.config.Camera
+		}
+	}
+
+	if err := s.apply(next, changes); err != nil {
+		log.Printf("Configuration reload failed, rolled back to the previous configuration: %v", err)
+		return
+	}
+	s.config = next
+	log.Printf("Applied configuration from %s", s.path)
+}
+
+// apply switches the running components to next. All new components are
+// created first; if any of them or reopening the camera fails, nothing is changed.
+func (s *session) apply(next configs.Config, changes []configs.Change) (err error) {
+	var (
+		outputSink *sink.FileSink
+		stages     *pipeline.Pipeline
+		scanners   []*qrcode.Scanner
+		confirmers []*consensus.Confirmer
+	)
+
+	// 失敗した場合は作成済みのものを破棄し、変更した設定を元に戻す
+	charsetChanged := false
+	defer func() {
+		if err == nil {
+			return
+		}
+		if outputSink != nil {
+			outputSink.Close()
+		}
+		if charsetChanged {
+			for _, src := range s.sources {
+				src.setCharset(s.config.QRCode.Charset)
+			}
+		}
+	}()
+
+	if configs.Changed(changes, "output_file") {
+		if outputSink, err = newOutputSink(next.OutputFile); err != nil {
+			return fmt.Errorf("output file: %w", err)
+		}
+	}
+	if configs.Changed(changes, "sanitize", "filter", "verification") {
+		if stages, err = newPipeline(next); err != nil {
+			return err
+		}
+	}
+	scannerChanged := configs.Changed(changes, "qrcode.rois", "qrcode.tracking", "qrcode.confirmation", "qrcode.charset")
+	if scannerChanged {
+		// 追跡や確認の状態はカメラごとに持つ
+		for _, src := range s.sources {
+			confirmer, err := newConfirmer(next.QRCode.Confirmation)
+			if err != nil {
+				return fmt.Errorf("confirmation policy: %w", err)
+			}
+			confirmers = append(confirmers, confirmer)
+			scanners = append(scanners, newScanner(src.detector, next.QRCode))
+		}
+	}
+	if configs.Changed(changes, "qrcode.charset") {
+		charsetChanged = true
+		for _, src := range s.sources {
+			if err = src.setCharset(next.QRCode.Charset); err != nil {
+				return fmt.Errorf("QR code charset: %w", err)
+			}
+		}
+	}
+	// 再接続やコントロールの設定だけが変わった場合はカメラを開き直さない
+	cameraChanged := !s.multiCamera() && configs.Changed(changes, "camera.device_id", "camera.device", "camera.width", "camera.height", "camera.fps")
+	if cameraChanged {
+		// カメラの指定が変わっていなければ、キー操作や前回の状態で選択したカメラを使い続ける
+		cam := s.primary().cam
+		cameraConfig := next.Camera
+		if !configs.Changed(changes, "camera.device_id", "camera.device") {
+			cameraConfig.DeviceID = cam.GetDeviceID()
+			cameraConfig.Device = cam.Selector()
+		}
+		if err = s.reopenCamera(cameraConfig); err != nil {
+			return err
+		}
+	}
+
+	// ここから先は失敗しない
+	if outputSink != nil {
+		s.out.replaceSink(s.outputSink, outputSink)
+		if err := s.outputSink.Close(); err != nil {
+			log.Printf("Error closing previous output file: %v", err)
+		}
+		s.outputSink = outputSink
+	}
+	if stages != nil {
+		s.out.pipeline = stages
+	}
+	for i, src := range s.sources {
+		if scannerChanged {
+			src.analyzer.configure(scanners[i], confirmers[i])
+		}
+		src.throttle.setInterval(time.Duration(next.QRCode.ScanInterval) * time.Millisecond)
+	}
+	s.out.perCamera = next.Dedup.Scope == "camera"
+	s.out.window = time.Duration(next.Dedup.WindowSeconds) * time.Second
+	if cameraChanged && configs.Changed(changes, "camera.device_id", "camera.device") {
+		s.out.selectDevice(s.primary().cam.GetDeviceID())
+	}
+	if !s.multiCamera() && configs.Changed(changes, "camera.reconnect") {
+		src := s.primary()
+		src.cam.SetReconnect(reconnectPolicy(next.Camera.Reconnect), src.logCameraEvent)
+	}
+	// コントロールはカメラを開き直さずに変更する（デバイスが対応しない値はログで確認できる）
+	if !s.multiCamera() && configs.Changed(changes, "camera.controls") {
+		cam := s.primary().cam
+		if err := cam.SetControls(cameraControls(next.Camera.Controls)); err != nil {
+			log.Printf("Error changing camera controls: %v", err)
+		}
+		if cam.IsOpen() {
+			logControls(fmt.Sprintf("device ID %d", cam.GetDeviceID()), cam)
+		}
+	}
+	return nil
+}
+
+// reopenCamera closes the camera and opens it with the new settings, reopening
+// it with the previous settings if that fails
+func (s *session) reopenCamera(cfg configs.CameraConfig) error {
+	cam := s.primary().cam
+	prev := configs.CameraConfig{DeviceID: cam.GetDeviceID(), Device: cam.Selector()}
+	prevFormat := cam.Format()
+
+	log.Printf("Reopening camera %s with %dx%d at %d fps", cameraName(cfg), cfg.Width, cfg.Height, cfg.FPS)
+	cam.SetFormat(cameraFormat(cfg))
+	if tryOpenCamera(cam, cfg) {
+		return nil
+	}
+
+	cam.SetFormat(prevFormat)
+	if !tryOpenCamera(cam, prev) {
+		log.Printf("Failed to reopen previous camera %s", cameraName(prev))
+	}
+	return fmt.Errorf("failed to open camera %s", cameraName(cfg))
+}
+
+// openCamera opens the camera when the single-camera capture loop starts
+func (s *session) openCamera() {
+	cam := s.primary().cam
+	if err := cam.Open(); err != nil {
+		log.Fatalf("Error opening camera: %v", err)
+	}
+	if selector := cam.Selector(); selector != "" {
+		log.Printf("Camera %q is device ID %d", selector, cam.GetDeviceID())
+		s.out.selectDevice(cam.GetDeviceID())
+	}
+	logControls(fmt.Sprintf("device ID %d", cam.GetDeviceID()), cam)
+}
+
+// cameraName describes the configured camera for logging
+func cameraName(cfg configs.CameraConfig) string {
+	if cfg.Device != "" {
+		return fmt.Sprintf("%q", cfg.Device)
+	}
+	return fmt.Sprintf("device ID %d", cfg.DeviceID)
+}
+
+// scanThrottle limits how often frames are passed to the detector.
+// With several cameras it is used by a capture goroutine while reloads change the interval.
+type scanThrottle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+// setInterval changes the minimum time between scanned frames
+func (t *scanThrottle) setInterval(interval time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interval = interval
+}
+
+// due reports whether a frame captured at now should be scanned
+func (t *scanThrottle) due(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.last) < t.interval {
+		return false
+	}
+	t.last = now
+	return true
+}
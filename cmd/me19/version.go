@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"gocv.io/x/gocv"
+)
+
+// runVersionCommand runs "me19 version" and returns the exit status
+func runVersionCommand(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	printVersion(os.Stdout)
+	return 0
+}
+
+// printVersion prints the release version together with build information
+func printVersion(out io.Writer) {
+	fmt.Fprintf(out, "me19 %s\n", Version)
+	fmt.Fprintf(out, "  go:       %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(out, "  gocv:     %s\n", gocv.Version())
+	fmt.Fprintf(out, "  opencv:   %s\n", gocv.OpenCVVersion())
+
+	// go build がVCS情報を埋め込んでいればコミットを表示する
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	settings := make(map[string]string)
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	if revision := settings["vcs.revision"]; revision != "" {
+		if settings["vcs.modified"] == "true" {
+			revision += " (modified)"
+		}
+		fmt.Fprintf(out, "  commit:   %s\n", revision)
+	}
+	if t := settings["vcs.time"]; t != "" {
+		fmt.Fprintf(out, "  built at: %s\n", t)
+	}
+}
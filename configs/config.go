@@ -2,27 +2,321 @@ package configs
 
 // Config holds the application configuration
 type Config struct {
-	Camera     CameraConfig     `json:"camera"`
-	QRCode     QRCodeConfig     `json:"qrcode"`
-	OutputFile OutputFileConfig `json:"output_file"`
+	Camera       CameraConfig         `json:"camera"`
+	Cameras      []CameraSourceConfig `json:"cameras"` // Several cameras used at the same time (empty uses camera only)
+	Dedup        DedupConfig          `json:"dedup"`
+	QRCode       QRCodeConfig         `json:"qrcode"`
+	OutputFile   OutputFileConfig     `json:"output_file"`
+	Filter       FilterConfig         `json:"filter"`
+	Verification VerificationConfig   `json:"verification"`
+	Sanitize     SanitizeConfig       `json:"sanitize"`
+	Actions      ActionsConfig        `json:"actions"`
+	Sinks        []SinkConfig         `json:"sinks"`
+	History      HistoryConfig        `json:"history"`
+	State        StateConfig          `json:"state"`
+	Lock         LockConfig           `json:"lock"`
+	Reload       ReloadConfig         `json:"reload"`
+	Display      DisplayConfig        `json:"display"`
+
+	unknownKeys []string // Keys in the configuration file that no field uses, reported by Validate
 }
 
 // CameraConfig holds camera-related configuration
 type CameraConfig struct {
-	DeviceID int `json:"device_id"`
-	Width    int `json:"width"`
-	Height   int `json:"height"`
-	FPS      int `json:"fps"`
+	DeviceID  int             `json:"device_id"`
+	Device    string          `json:"device"` // Stable device path, "serial:..." or name substring (Linux; overrides device_id)
+	Width     int             `json:"width"`
+	Height    int             `json:"height"`
+	FPS       int             `json:"fps"`
+	Reconnect ReconnectConfig `json:"reconnect"`
+	Controls  ControlsConfig  `json:"controls"`
+}
+
+// ControlsConfig holds optional camera controls requested through capture
+// properties when the camera is opened. Unset (null) values keep the device defaults.
+type ControlsConfig struct {
+	AutoExposure     *bool    `json:"auto_exposure,omitempty"`
+	Exposure         *float64 `json:"exposure,omitempty"` // Device-specific units (100µs on Linux, log2 seconds on Windows); set auto_exposure to false
+	AutoFocus        *bool    `json:"autofocus,omitempty"`
+	Focus            *float64 `json:"focus,omitempty"` // Set autofocus to false
+	Gain             *float64 `json:"gain,omitempty"`
+	AutoWhiteBalance *bool    `json:"auto_white_balance,omitempty"`
+	WhiteBalance     *float64 `json:"white_balance,omitempty"` // Color temperature in kelvin; set auto_white_balance to false
+	Zoom             *float64 `json:"zoom,omitempty"`
+	BufferSize       *int     `json:"buffer_size,omitempty"` // Frames buffered by the driver; 1 keeps the latest frame
+}
+
+// merge returns c with the values set in o replacing its own
+func (c ControlsConfig) merge(o ControlsConfig) ControlsConfig {
+	if o.AutoExposure != nil {
+		c.AutoExposure = o.AutoExposure
+	}
+	if o.Exposure != nil {
+		c.Exposure = o.Exposure
+	}
+	if o.AutoFocus != nil {
+		c.AutoFocus = o.AutoFocus
+	}
+	if o.Focus != nil {
+		c.Focus = o.Focus
+	}
+	if o.Gain != nil {
+		c.Gain = o.Gain
+	}
+	if o.AutoWhiteBalance != nil {
+		c.AutoWhiteBalance = o.AutoWhiteBalance
+	}
+	if o.WhiteBalance != nil {
+		c.WhiteBalance = o.WhiteBalance
+	}
+	if o.Zoom != nil {
+		c.Zoom = o.Zoom
+	}
+	if o.BufferSize != nil {
+		c.BufferSize = o.BufferSize
+	}
+	return c
+}
+
+// ReconnectConfig controls reopening the camera when reads keep failing, for example after it is unplugged
+type ReconnectConfig struct {
+	Enabled          bool `json:"enabled"`
+	FailureThreshold int  `json:"failure_threshold"`  // Consecutive read failures before the camera is considered lost
+	InitialBackoffMS int  `json:"initial_backoff_ms"` // Delay after the first failed attempt, doubled after each further one
+	MaxBackoffMS     int  `json:"max_backoff_ms"`     // Upper limit of the delay between attempts
+}
+
+// CameraSourceConfig describes one of several cameras used at the same time.
+// Zero width, height and fps take the values of the camera section, and so do
+// the reconnection settings and the controls that are not set.
+type CameraSourceConfig struct {
+	Name     string         `json:"name"` // Tagged on every detection of the camera
+	DeviceID int            `json:"device_id"`
+	Device   string         `json:"device"` // Stable device path, "serial:..." or name substring (Linux; overrides device_id)
+	Width    int            `json:"width"`
+	Height   int            `json:"height"`
+	FPS      int            `json:"fps"`
+	Controls ControlsConfig `json:"controls"`
+}
+
+// CameraSource is a named camera with its effective settings
+type CameraSource struct {
+	Name   string // Empty when only the camera section is used
+	Camera CameraConfig
+}
+
+// Sources returns the cameras to capture from: the cameras list with the
+// defaults of the camera section applied, or the camera section alone
+func (c Config) Sources() []CameraSource {
+	if len(c.Cameras) == 0 {
+		return []CameraSource{{Camera: c.Camera}}
+	}
+	sources := make([]CameraSource, len(c.Cameras))
+	for i, cam := range c.Cameras {
+		cfg := c.Camera
+		cfg.DeviceID = cam.DeviceID
+		cfg.Device = cam.Device
+		if cam.Width > 0 {
+			cfg.Width = cam.Width
+		}
+		if cam.Height > 0 {
+			cfg.Height = cam.Height
+		}
+		if cam.FPS > 0 {
+			cfg.FPS = cam.FPS
+		}
+		cfg.Controls = cfg.Controls.merge(cam.Controls)
+		sources[i] = CameraSource{Name: cam.Name, Camera: cfg}
+	}
+	return sources
+}
+
+// DedupConfig controls which detections count as repeats of an already written code
+type DedupConfig struct {
+	Scope         string `json:"scope"`          // "global" (a code is written once whichever camera sees it) or "camera" (once per camera)
+	WindowSeconds int    `json:"window_seconds"` // With global scope, how long a code written by one camera is a repeat on the others
 }
 
 // QRCodeConfig holds QR code detection configuration
 type QRCodeConfig struct {
-	ScanInterval int `json:"scan_interval_ms"` // Interval between scans in milliseconds
+	ScanInterval            int                `json:"scan_interval_ms"` // Interval between scans in milliseconds
+	ROIs                    []ROIConfig        `json:"rois"`             // Regions of the frame to scan (empty means the full frame)
+	Tracking                TrackingConfig     `json:"tracking"`
+	StructuredAppendTimeout int                `json:"structured_append_timeout_ms"` // How long incomplete multi-symbol payloads are kept
+	Charset                 string             `json:"charset"`                      // Character set for byte-mode data without ECI ("auto" to guess)
+	Confirmation            ConfirmationConfig `json:"confirmation"`
+}
+
+// ConfirmationConfig controls how often a payload must be decoded before it is accepted
+type ConfirmationConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Mode     string `json:"mode"`      // "frames" (K of the last N frames) or "time" (M times within a window)
+	Required int    `json:"required"`  // K or M
+	Frames   int    `json:"frames"`    // N, used in "frames" mode
+	WindowMS int    `json:"window_ms"` // Time window, used in "time" mode
+}
+
+// ROIConfig describes a rectangular region of interest in frame pixel coordinates
+type ROIConfig struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// TrackingConfig controls searching around the previous hit before scanning the whole frame
+type TrackingConfig struct {
+	Enabled bool `json:"enabled"`
+	Padding int  `json:"padding_px"` // Margin added around the previous code location
+}
+
+// FilterConfig holds the allow and deny rules applied before output.
+// Deny rules take precedence; when allow rules are present a payload must match one of them.
+type FilterConfig struct {
+	Allow     []FilterRuleConfig `json:"allow"`
+	Deny      []FilterRuleConfig `json:"deny"`
+	MinLength int                `json:"min_length"` // Minimum payload length in characters (0 means no limit)
+	MaxLength int                `json:"max_length"` // Maximum payload length in characters (0 means no limit)
+}
+
+// FilterRuleConfig describes a single filter rule. All conditions that are set must hold.
+type FilterRuleConfig struct {
+	Name      string `json:"name"`
+	Regex     string `json:"regex"`
+	Prefix    string `json:"prefix"`
+	Type      string `json:"type"` // Payload type such as "url" or "wifi" (case-insensitive)
+	MinLength int    `json:"min_length"`
+	MaxLength int    `json:"max_length"`
+}
+
+// VerificationConfig controls checking signed payloads such as tickets
+type VerificationConfig struct {
+	Enabled       bool                    `json:"enabled"`
+	Keys          []VerificationKeyConfig `json:"keys"`
+	LeewaySeconds int                     `json:"leeway_s"`     // Allowed clock skew for exp and nbf
+	RequireExpiry bool                    `json:"require_exp"`  // Reject tokens without an exp claim
+	ReplayStore   string                  `json:"replay_store"` // File remembering used token IDs (empty keeps them in memory only)
+}
+
+// VerificationKeyConfig describes a verification key
+type VerificationKeyConfig struct {
+	ID        string `json:"id"`
+	Algorithm string `json:"algorithm"`  // "ed25519" or "hs256"
+	PublicKey string `json:"public_key"` // Ed25519: base64 raw key or PEM
+	Secret    string `json:"secret"`     // HS256 shared secret
+}
+
+// SanitizeConfig controls how payloads are cleaned before they are written, logged or displayed
+type SanitizeConfig struct {
+	ControlChars      string `json:"control_chars"`       // "strip", "keep_separators", "escape" or "keep"
+	MaxLength         int    `json:"max_length"`          // Maximum payload size in bytes (0 means no limit)
+	RejectInvalidUTF8 bool   `json:"reject_invalid_utf8"` // Reject instead of replacing invalid UTF-8
+}
+
+// ActionsConfig holds the rules that trigger actions for matching payloads
+type ActionsConfig struct {
+	Rules         []ActionRuleConfig `json:"rules"`
+	MaxConcurrent int                `json:"max_concurrent"` // Actions running at the same time; more are skipped (default 8)
+}
+
+// ActionRuleConfig maps a payload pattern to an action. Capture groups of the
+// pattern can be used as $1, ${1} or ${name} in the templated fields.
+type ActionRuleConfig struct {
+	Name      string `json:"name"`
+	Match     string `json:"match"`      // Regular expression matched against the payload
+	Action    string `json:"action"`     // "file", "http", "osc" or "exec"
+	TimeoutMS int    `json:"timeout_ms"` // Maximum run time of the action (default 5000)
+
+	// file
+	Path    string `json:"path"`    // Templated file path
+	Content string `json:"content"` // Templated file content (default "$0")
+	Append  bool   `json:"append"`
+
+	// http
+	URL     string            `json:"url"`    // Templated URL; substituted values are escaped
+	Method  string            `json:"method"` // Default POST
+	Body    string            `json:"body"`   // Templated request body (default "$0")
+	Headers map[string]string `json:"headers"`
+
+	// osc
+	Target  string `json:"target"`  // host:port of the OSC receiver
+	Address string `json:"address"` // Templated OSC address such as "/room/$1"
+
+	// exec
+	Command    string `json:"command"`
+	InheritEnv bool   `json:"inherit_env"` // Pass the whole environment instead of only PATH and HOME
+
+	// osc and exec
+	Args []string `json:"args"` // Templated arguments
+}
+
+// SinkConfig describes an additional output that receives every accepted detection
+type SinkConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // "file" or "exec"
+
+	// file
+	Path     string         `json:"path"`
+	Encoding string         `json:"encoding"` // Used when no template is set
+	Template string         `json:"template"` // Go text/template for each detection
+	Append   bool           `json:"append"`   // Append instead of replacing the file
+	Rotation RotationConfig `json:"rotation"` // Applied when appending
+
+	// exec
+	Command     string   `json:"command"`
+	Args        []string `json:"args"`        // Passed as is; the payload is never interpolated
+	Stdin       string   `json:"stdin"`       // "none", "text" or "json"
+	TimeoutMS   int      `json:"timeout_ms"`  // Maximum run time of one command (default 5000)
+	Concurrency int      `json:"concurrency"` // Commands running at the same time (default 1)
+	QueueSize   int      `json:"queue_size"`  // Detections waiting for a free slot (default 16)
+	InheritEnv  bool     `json:"inherit_env"` // Pass the whole environment instead of only PATH and HOME
+}
+
+// HistoryConfig controls the persistent detection history
+type HistoryConfig struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"` // Database file (default: output file path + ".history.db")
+}
+
+// StateConfig controls the scanner state kept across restarts
+type StateConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Path          string `json:"path"`      // State file, written atomically (default: output file path + ".state.json")
+	MaxAgeSeconds int    `json:"max_age_s"` // Ignore remembered codes older than this on startup (0 means no limit)
+}
+
+// LockConfig controls the single-instance lock
+type LockConfig struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"` // Lock file (default: output file path + ".lock")
+}
+
+// ReloadConfig controls applying changes to the configuration file while running
+type ReloadConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// DisplayConfig controls the camera preview window
+type DisplayConfig struct {
+	Mode string `json:"mode"` // "auto" (detect a display), "headless" or "display"
 }
 
 // OutputFileConfig holds file output configuration
 type OutputFileConfig struct {
-	FilePath string `json:"file_path"`
+	FilePath string         `json:"file_path"`
+	Encoding string         `json:"encoding"` // "text" (normalized UTF-8), "raw" or "base64"
+	Template string         `json:"template"` // Go text/template for each detection (overrides encoding)
+	Append   bool           `json:"append"`   // Append instead of replacing the file
+	Rotation RotationConfig `json:"rotation"` // Applied when appending
+}
+
+// RotationConfig controls rotation and retention of appended files
+type RotationConfig struct {
+	MaxSizeKB       int  `json:"max_size_kb"`      // Rotate when the file would exceed this size (0 disables)
+	IntervalMinutes int  `json:"interval_minutes"` // Rotate at interval boundaries, aligned to UTC (0 disables)
+	Compress        bool `json:"compress"`         // Gzip rotated files
+	MaxBackups      int  `json:"max_backups"`      // Number of rotated files to keep (0 keeps all)
+	MaxAgeDays      int  `json:"max_age_days"`     // Delete rotated files older than this (0 keeps all)
 }
 
 // DefaultConfig returns the default configuration
@@ -33,12 +327,102 @@ func DefaultConfig() Config {
 			Width:    1280,
 			Height:   720,
 			FPS:      30,
+			Reconnect: ReconnectConfig{
+				Enabled:          true,
+				FailureThreshold: 10,
+				InitialBackoffMS: 500,
+				MaxBackoffMS:     10000,
+			},
 		},
 		QRCode: QRCodeConfig{
 			ScanInterval: 500,
+			Tracking: TrackingConfig{
+				Enabled: false,
+				Padding: 80,
+			},
+			StructuredAppendTimeout: 10000,
+			Charset:                 "auto",
+			Confirmation: ConfirmationConfig{
+				Enabled:  false,
+				Mode:     "frames",
+				Required: 3,
+				Frames:   5,
+				WindowMS: 1000,
+			},
 		},
 		OutputFile: OutputFileConfig{
 			FilePath: "code.txt",
+			Encoding: "text",
+		},
+		History: HistoryConfig{
+			Enabled: true,
+		},
+		Reload: ReloadConfig{
+			Enabled: true,
+		},
+		Display: DisplayConfig{
+			Mode: "auto",
+		},
+		Actions: ActionsConfig{
+			MaxConcurrent: 8,
+		},
+		Dedup: DedupConfig{
+			Scope:         "global",
+			WindowSeconds: 10,
 		},
+		Lock: LockConfig{
+			Enabled: true,
+		},
+		State: StateConfig{
+			Enabled:       true,
+			MaxAgeSeconds: 600,
+		},
+		Sanitize: SanitizeConfig{
+			ControlChars:      "strip",
+			MaxLength:         4096,
+			RejectInvalidUTF8: false,
+		},
+		Verification: VerificationConfig{
+			Enabled:       false,
+			LeewaySeconds: 30,
+			ReplayStore:   "used_tokens.txt",
+		},
+	}
+}
+
+// HistoryPath returns the history database. By default it is next to the
+// output file, like the lock file, so instances writing different outputs in
+// one directory keep separate histories.
+func (c Config) HistoryPath() string {
+	if c.History.Path != "" {
+		return c.History.Path
+	}
+	return c.OutputFile.FilePath + ".history.db"
+}
+
+// StatePath returns the state file, by default next to the output file
+func (c Config) StatePath() string {
+	if c.State.Path != "" {
+		return c.State.Path
+	}
+	return c.OutputFile.FilePath + ".state.json"
+}
+
+// redactedSecret replaces secrets when the configuration is printed
+const redactedSecret = "********"
+
+// Redacted returns a copy of the configuration with secrets replaced, for printing
+func (c Config) Redacted() Config {
+	if c.Verification.Keys == nil {
+		return c
+	}
+	keys := make([]VerificationKeyConfig, len(c.Verification.Keys))
+	for i, key := range c.Verification.Keys {
+		if key.Secret != "" {
+			key.Secret = redactedSecret
+		}
+		keys[i] = key
 	}
+	c.Verification.Keys = keys
+	return c
 }
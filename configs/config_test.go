@@ -1,9 +1,13 @@
 package configs
 
 import (
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestViperConfig(t *testing.T) {
@@ -144,3 +148,539 @@ func TestFindConfigFile(t *testing.T) {
 		t.Errorf("FindConfigFile() returned %s, want empty string when no config exists", foundPath)
 	}
 }
+
+func TestROIConfig(t *testing.T) {
+	testConfig := `{
+		"qrcode": {
+			"rois": [
+				{"x": 100, "y": 50, "width": 400, "height": 300},
+				{"x": 700, "y": 50, "width": 400, "height": 300}
+			],
+			"tracking": {"enabled": true, "padding_px": 60}
+		}
+	}`
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(testConfig), 0644); err != nil {
+		t.Fatalf("設定ファイルを書き込めませんでした: %v", err)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("設定を読み込めませんでした: %v", err)
+	}
+
+	if len(config.QRCode.ROIs) != 2 {
+		t.Fatalf("QRCode.ROIs: expected 2 regions, got %d", len(config.QRCode.ROIs))
+	}
+	if roi := config.QRCode.ROIs[1]; roi.X != 700 || roi.Width != 400 {
+		t.Errorf("QRCode.ROIs[1]: unexpected value %+v", roi)
+	}
+	if !config.QRCode.Tracking.Enabled || config.QRCode.Tracking.Padding != 60 {
+		t.Errorf("QRCode.Tracking: unexpected value %+v", config.QRCode.Tracking)
+	}
+	// 指定されていない項目はデフォルト値のまま
+	if config.QRCode.ScanInterval != 500 {
+		t.Errorf("QRCode.ScanInterval: expected default 500, got %d", config.QRCode.ScanInterval)
+	}
+}
+
+func TestLoad_Formats(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"JSON", "config.json", `{"camera": {"fps": 15}, "output_file": {"file_path": "out.txt"}, "actions": {"rules": [{"name": "door", "match": "^DOOR:", "action": "http", "url": "http://door/open", "headers": {"X-Token": "abc"}}]}}`},
+		{"YAML", "config.yaml", "camera:\n  fps: 15\noutput_file:\n  file_path: out.txt\nactions:\n  rules:\n    - name: door\n      match: \"^DOOR:\"\n      action: http\n      url: http://door/open\n      headers:\n        X-Token: abc\n"},
+		{"TOML", "config.toml", "[camera]\nfps = 15\n\n[output_file]\nfile_path = \"out.txt\"\n\n[[actions.rules]]\nname = \"door\"\nmatch = \"^DOOR:\"\naction = \"http\"\nurl = \"http://door/open\"\n\n[actions.rules.headers]\nX-Token = \"abc\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := Load(path, nil)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if config.Camera.FPS != 15 || config.OutputFile.FilePath != "out.txt" {
+				t.Errorf("Load() camera = %+v, output = %+v", config.Camera, config.OutputFile)
+			}
+			// 指定されていない項目はデフォルト値のまま
+			if config.Camera.Width != 1280 || config.HistoryPath() != "out.txt.history.db" {
+				t.Errorf("Load() did not keep defaults: camera = %+v, history = %+v", config.Camera, config.History)
+			}
+			if len(config.Actions.Rules) != 1 {
+				t.Fatalf("Load() rules = %+v", config.Actions.Rules)
+			}
+			rule := config.Actions.Rules[0]
+			if rule.Match != "^DOOR:" || rule.URL != "http://door/open" {
+				t.Errorf("Load() rule = %+v", rule)
+			}
+			// ヘッダー名は大文字小文字を区別しないため、小文字になっていてもよい
+			if rule.Headers["X-Token"] != "abc" && rule.Headers["x-token"] != "abc" {
+				t.Errorf("Load() headers = %v", rule.Headers)
+			}
+		})
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{"camera": {"device_id": 1, "width": 640, "fps": 10}, "qrcode": {"confirmation": {"required": 4}}}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 入れ子の項目やリストも環境変数で上書きできる
+	t.Setenv("ME19_CAMERA_WIDTH", "800")
+	t.Setenv("ME19_CAMERA_FPS", "20")
+	t.Setenv("ME19_QRCODE_CONFIRMATION_ENABLED", "true")
+	t.Setenv("ME19_QRCODE_ROIS", `[{"x": 10, "y": 20, "width": 300, "height": 200}]`)
+	t.Setenv("ME19_SINKS", `[{"name": "log", "type": "exec", "command": "logger", "args": ["-t", "me19"]}]`)
+	t.Setenv("ME19_OUTPUT_FILE_PATH", "legacy.txt")
+
+	config, err := Load(path, map[string]string{"camera.fps": "30"})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"設定ファイル", config.Camera.DeviceID, 1},
+		{"環境変数が設定ファイルより優先", config.Camera.Width, 800},
+		{"コマンドラインが環境変数より優先", config.Camera.FPS, 30},
+		{"入れ子の設定ファイル", config.QRCode.Confirmation.Required, 4},
+		{"入れ子の環境変数", config.QRCode.Confirmation.Enabled, true},
+		{"デフォルト", config.Camera.Height, 720},
+		{"互換性のための環境変数", config.OutputFile.FilePath, "legacy.txt"},
+		{"リストの環境変数", len(config.QRCode.ROIs), 1},
+		{"シンクの環境変数", len(config.Sinks), 1},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if len(config.QRCode.ROIs) == 1 && config.QRCode.ROIs[0].Width != 300 {
+		t.Errorf("QRCode.ROIs[0] = %+v", config.QRCode.ROIs[0])
+	}
+	if len(config.Sinks) == 1 && (config.Sinks[0].Command != "logger" || len(config.Sinks[0].Args) != 2) {
+		t.Errorf("Sinks[0] = %+v", config.Sinks[0])
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	if _, err := Load("", map[string]string{"camera.zoom": "2"}); err == nil {
+		t.Error("Load() with an unknown key should fail")
+	}
+	if _, err := Load("", map[string]string{"camera.fps": "fast"}); err == nil {
+		t.Error("Load() with an invalid number should fail")
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("camera: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, nil); err == nil {
+		t.Error("Load() with a broken file should fail")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	keys := Keys()
+	for _, want := range []string{"camera.device_id", "qrcode.confirmation.window_ms", "output_file.rotation.max_size_kb", "sinks", "history.path"} {
+		found := false
+		for _, key := range keys {
+			if key == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Keys() does not contain %s", want)
+		}
+	}
+	if got := EnvName("qrcode.confirmation.window_ms"); got != "ME19_QRCODE_CONFIRMATION_WINDOW_MS" {
+		t.Errorf("EnvName() = %s", got)
+	}
+}
+
+func TestFindConfigFile_YAML(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("camera:\n  fps: 15\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	originalWd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(originalWd)
+
+	if got := FindConfigFile(); got != "config.yaml" {
+		t.Errorf("FindConfigFile() = %q, want config.yaml", got)
+	}
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	config := DefaultConfig()
+	config.Verification.Keys = []VerificationKeyConfig{
+		{ID: "hmac", Algorithm: "hs256", Secret: "s3cret"},
+		{ID: "ed", Algorithm: "ed25519", PublicKey: "AAAA"},
+	}
+
+	redacted := config.Redacted()
+	if redacted.Verification.Keys[0].Secret == "s3cret" {
+		t.Error("Redacted() kept the secret")
+	}
+	if redacted.Verification.Keys[1].PublicKey != "AAAA" {
+		t.Error("Redacted() changed the public key")
+	}
+	// 元の設定は変更しない
+	if config.Verification.Keys[0].Secret != "s3cret" {
+		t.Error("Redacted() modified the original configuration")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   []string // 問題として報告されるべきパス
+	}{
+		{"デフォルト", func(c *Config) {}, nil},
+		{"大文字小文字を区別しない名前", func(c *Config) {
+			// 起動時の変換と同じく大文字小文字を区別しない
+			c.Sanitize.ControlChars = "Escape"
+			c.OutputFile.Encoding = "BASE64"
+			c.Filter.Allow = []FilterRuleConfig{{Type: "URL"}}
+			c.Sinks = []SinkConfig{{Name: "a", Type: "exec", Command: "logger", Stdin: "JSON"}}
+		}, nil},
+		{"カメラ", func(c *Config) {
+			c.Camera.FPS = -5
+			c.Camera.Width = 0
+		}, []string{"camera.width", "camera.fps"}},
+		{"スキャン間隔", func(c *Config) { c.QRCode.ScanInterval = 0 }, []string{"qrcode.scan_interval_ms"}},
+		{"存在しないディレクトリ", func(c *Config) {
+			c.OutputFile.FilePath = filepath.Join(dir, "missing", "code.txt")
+		}, []string{"output_file.file_path"}},
+		{"確認ポリシー", func(c *Config) {
+			c.QRCode.Confirmation = ConfirmationConfig{Enabled: true, Mode: "frames", Required: 5, Frames: 3}
+		}, []string{"qrcode.confirmation.frames"}},
+		{"ROI", func(c *Config) {
+			c.QRCode.ROIs = []ROIConfig{{X: 0, Y: 0, Width: 100, Height: 100}, {X: -1, Y: 0, Width: 0, Height: 10}}
+		}, []string{"qrcode.rois[1].x", "qrcode.rois[1].width"}},
+		{"フィルター", func(c *Config) {
+			c.Filter.Deny = []FilterRuleConfig{{Regex: "("}, {Type: "URL"}}
+			c.Filter.Allow = []FilterRuleConfig{{Type: "link"}}
+			c.Filter.MinLength = 10
+			c.Filter.MaxLength = 5
+		}, []string{"filter.max_length", "filter.allow[0].type", "filter.deny[0].regex"}},
+		{"署名検証", func(c *Config) {
+			c.Verification.Enabled = true
+			c.Verification.Keys = []VerificationKeyConfig{{ID: "k1", Algorithm: "rsa"}, {Algorithm: "hs256"}}
+		}, []string{"verification.keys[0].algorithm", "verification.keys[1].id", "verification.keys[1].secret"}},
+		{"アクション", func(c *Config) {
+			c.Actions.Rules = []ActionRuleConfig{{Match: "^x", Action: "http"}, {Match: "[", Action: "mail"}}
+		}, []string{"actions.rules[0].url", "actions.rules[1].match", "actions.rules[1].action"}},
+		{"シンク", func(c *Config) {
+			c.Sinks = []SinkConfig{
+				{Name: "a", Type: "exec", Stdin: "xml"},
+				{Name: "a", Type: "file", Path: "out.txt", Encoding: "utf-16"},
+			}
+		}, []string{"sinks[0].command", "sinks[0].stdin", "sinks[1].encoding", "sinks[1].name"}},
+		{"サニタイズ", func(c *Config) { c.Sanitize.ControlChars = "drop" }, []string{"sanitize.control_chars"}},
+		{"表示モード", func(c *Config) { c.Display.Mode = "window" }, []string{"display.mode"}},
+		{"複数カメラ", func(c *Config) {
+			c.Cameras = []CameraSourceConfig{{Name: "left"}, {Name: "left", DeviceID: -1}, {}}
+			c.Dedup.Scope = "device"
+			c.Dedup.WindowSeconds = -1
+		}, []string{"cameras[1].name", "cameras[1].device_id", "cameras[2].name", "dedup.scope", "dedup.window_seconds"}},
+		{"カメラコントロール", func(c *Config) {
+			focus, bufferSize := -1.0, 0
+			c.Camera.Controls.Focus = &focus
+			c.Camera.Controls.BufferSize = &bufferSize
+		}, []string{"camera.controls.focus", "camera.controls.buffer_size"}},
+		{"再接続", func(c *Config) { c.Camera.Reconnect.FailureThreshold = 0; c.Camera.Reconnect.MaxBackoffMS = 100 }, []string{"camera.reconnect.failure_threshold", "camera.reconnect.max_backoff_ms"}},
+		{"未知のキー", func(c *Config) { c.unknownKeys = []string{"camera.zoom"} }, []string{"camera.zoom"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tt.modify(&config)
+
+			err := config.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Validate() error = %v, want *ValidationError", err)
+			}
+			var got []string
+			for _, p := range verr.Problems {
+				got = append(got, p.Path)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Validate() problems = %v, want %v\n%v", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoad_UnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{"camera": {"fps": 15, "zoom": 2}, "sinks": [{"type": "exec", "comand": "logger"}], "bogus": true}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未知のキーがあっても読み込みは成功し、Validateで報告される
+	config, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if config.Camera.FPS != 15 {
+		t.Errorf("Camera.FPS = %d, want 15", config.Camera.FPS)
+	}
+
+	err = config.Validate()
+	for _, want := range []string{"bogus: unknown key", "camera.zoom: unknown key", "sinks[0].comand: unknown key", "sinks[0].command: is required"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error = %v, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := DefaultConfig()
+	changed := DefaultConfig()
+	changed.Camera.FPS = 15
+	changed.OutputFile.FilePath = "other.txt"
+	changed.Filter.Deny = []FilterRuleConfig{{Prefix: "spam"}}
+	changed.Verification.Keys = []VerificationKeyConfig{{ID: "k", Algorithm: "hs256", Secret: "s3cret"}}
+
+	changes := Diff(old, changed)
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+		if strings.Contains(c.New, "s3cret") {
+			t.Errorf("Diff() leaked a secret: %s", c)
+		}
+	}
+	want := []string{
+		`camera.fps: 30 -> 15`,
+		`filter.deny: null -> [{"max_length":0,"min_length":0,"name":"","prefix":"spam","regex":"","type":""}]`,
+		`output_file.file_path: "code.txt" -> "other.txt"`,
+	}
+	if len(got) != 4 || strings.Join(got[:3], "\n") != strings.Join(want, "\n") || !strings.HasPrefix(got[3], "verification.keys: ") {
+		t.Errorf("Diff() = \n%s\nwant\n%s\n(and verification.keys)", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if !Changed(changes, "camera") || !Changed(changes, "qrcode", "filter") || Changed(changes, "qrcode", "history") {
+		t.Error("Changed() reported wrong sections")
+	}
+	if len(Diff(old, DefaultConfig())) != 0 {
+		t.Error("Diff() of equal configurations should be empty")
+	}
+
+	// 秘密の値だけを変えた場合も変更として報告する（漏らさない）
+	rotated := changed
+	rotated.Verification.Keys = []VerificationKeyConfig{{ID: "k", Algorithm: "hs256", Secret: "n3w-secret"}}
+	changes = Diff(changed, rotated)
+	if len(changes) != 1 || changes[0].Key != "verification.keys" || !Changed(changes, "verification") {
+		t.Fatalf("Diff() after rotating a secret = %v, want a change to verification.keys", changes)
+	}
+	if s := changes[0].String(); s != "verification.keys: secret changed" || strings.Contains(changes[0].New, "n3w-secret") {
+		t.Errorf("Change.String() = %q", s)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"camera": {"fps": 30}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := Watch(path, 50*time.Millisecond, func(err error) { t.Errorf("watch error: %v", err) })
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+
+	expectChange := func(name string) {
+		t.Helper()
+		select {
+		case <-w.Changes():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no change reported", name)
+		}
+	}
+
+	// 同じディレクトリの別のファイルは無視される
+	if err := os.WriteFile(filepath.Join(dir, "other.json"), []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-w.Changes():
+		t.Fatal("change reported for another file")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// 直接の書き込み
+	if err := os.WriteFile(path, []byte(`{"camera": {"fps": 15}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("write")
+
+	// エディタのように別のファイルに書いてから置き換える
+	tmp := filepath.Join(dir, "config.json.tmp")
+	if err := os.WriteFile(tmp, []byte(`{"camera": {"fps": 10}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("rename")
+}
+
+func TestWrite(t *testing.T) {
+	config := DefaultConfig()
+	config.Camera.FPS = 15
+	config.QRCode.ROIs = []ROIConfig{{X: 10, Y: 20, Width: 300, Height: 200}}
+	config.Sinks = []SinkConfig{{Name: "log", Type: "exec", Command: "logger", Args: []string{"-t", "me19"}}}
+
+	for _, format := range Formats {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config."+format)
+			file, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Write(file, config, format); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			file.Close()
+			if FormatFor(path) != format {
+				t.Errorf("FormatFor(%s) = %s", path, FormatFor(path))
+			}
+
+			// 書き出した設定を読み込むと同じ設定になる
+			loaded, err := LoadConfig(path)
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if changes := Diff(config, loaded); len(changes) != 0 {
+				t.Errorf("round trip changed %v", changes)
+			}
+			if err := loaded.Validate(); err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+
+	if err := Write(io.Discard, config, "xml"); err == nil {
+		t.Error("Write() with an unknown format should fail")
+	}
+}
+
+func TestConfig_Sources(t *testing.T) {
+	config := DefaultConfig()
+	config.Camera.DeviceID = 3
+
+	// camerasがなければcameraセクションのカメラ1台
+	sources := config.Sources()
+	if len(sources) != 1 || sources[0].Name != "" || sources[0].Camera.DeviceID != 3 {
+		t.Fatalf("Sources() = %+v, want the camera section", sources)
+	}
+
+	// 指定されていない値はcameraセクションの値になる
+	config.Cameras = []CameraSourceConfig{
+		{Name: "left", DeviceID: 0, Width: 640, Height: 480},
+		{Name: "right", Device: "serial:ABC123", FPS: 15},
+	}
+	sources = config.Sources()
+	if len(sources) != 2 {
+		t.Fatalf("Sources() returned %d cameras, want 2", len(sources))
+	}
+	left, right := sources[0], sources[1]
+	if left.Name != "left" || left.Camera.DeviceID != 0 || left.Camera.Width != 640 || left.Camera.Height != 480 || left.Camera.FPS != 30 {
+		t.Errorf("left = %+v", left)
+	}
+	if right.Name != "right" || right.Camera.Device != "serial:ABC123" || right.Camera.Width != 1280 || right.Camera.FPS != 15 {
+		t.Errorf("right = %+v", right)
+	}
+	if !right.Camera.Reconnect.Enabled || right.Camera.Reconnect != config.Camera.Reconnect {
+		t.Errorf("right.Reconnect = %+v, want the camera section", right.Camera.Reconnect)
+	}
+
+	// カメラごとのコントロールはcameraセクションの値を上書きする
+	autofocus, focus, zoom := false, 30.0, 2.0
+	config.Camera.Controls = ControlsConfig{AutoFocus: &autofocus, Focus: &focus}
+	config.Cameras[1].Controls = ControlsConfig{Zoom: &zoom}
+	config.Cameras[0].Controls = ControlsConfig{Focus: &zoom}
+	sources = config.Sources()
+	if c := sources[1].Camera.Controls; c.AutoFocus != &autofocus || c.Focus != &focus || c.Zoom != &zoom {
+		t.Errorf("right.Controls = %+v", c)
+	}
+	if c := sources[0].Camera.Controls; c.Focus != &zoom || c.AutoFocus != &autofocus || c.Zoom != nil {
+		t.Errorf("left.Controls = %+v", c)
+	}
+}
+
+func TestLoad_Controls(t *testing.T) {
+	config, err := Load("", map[string]string{
+		"camera.controls.autofocus":   "false",
+		"camera.controls.focus":       "35",
+		"camera.controls.buffer_size": "1",
+	})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	c := config.Camera.Controls
+	if c.AutoFocus == nil || *c.AutoFocus || c.Focus == nil || *c.Focus != 35 || c.BufferSize == nil || *c.BufferSize != 1 {
+		t.Errorf("Controls = %+v", c)
+	}
+	// 指定されていないコントロールはデバイスの既定値のまま
+	if c.Exposure != nil || c.Zoom != nil {
+		t.Errorf("unset controls = %v, %v, want nil", c.Exposure, c.Zoom)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestConfig_DerivedPaths(t *testing.T) {
+	// 出力先が異なるインスタンスは状態と履歴を共有しない
+	c := DefaultConfig()
+	c.OutputFile.FilePath = "/var/lib/me19/gate-a.txt"
+	if got := c.HistoryPath(); got != "/var/lib/me19/gate-a.txt.history.db" {
+		t.Errorf("HistoryPath() = %q", got)
+	}
+	if got := c.StatePath(); got != "/var/lib/me19/gate-a.txt.state.json" {
+		t.Errorf("StatePath() = %q", got)
+	}
+
+	c.History.Path = "/data/history.db"
+	c.State.Path = "/data/state.json"
+	if c.HistoryPath() != "/data/history.db" || c.StatePath() != "/data/state.json" {
+		t.Errorf("Configured paths should be used, got %q, %q", c.HistoryPath(), c.StatePath())
+	}
+}
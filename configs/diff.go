@@ -0,0 +1,78 @@
+package configs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Change is a configuration value that differs between two configurations
+type Change struct {
+	Key string // Such as "camera.fps"
+	Old string // JSON encoded value
+	New string // JSON encoded value
+}
+
+func (c Change) String() string {
+	// 秘密の値だけが変わった場合は伏せた値が同じになる
+	if c.Old == c.New {
+		return fmt.Sprintf("%s: secret changed", c.Key)
+	}
+	return fmt.Sprintf("%s: %s -> %s", c.Key, c.Old, c.New)
+}
+
+// Diff returns the values that differ between old and new, ordered by key.
+// Values are compared including secrets, but the secrets are redacted in the changes.
+func Diff(old, new Config) []Change {
+	oldValues, newValues := flatten(old), flatten(new)
+	oldRedacted, newRedacted := flatten(old.Redacted()), flatten(new.Redacted())
+
+	var changes []Change
+	for _, key := range Keys() {
+		if oldValues[key] != newValues[key] {
+			changes = append(changes, Change{Key: key, Old: oldRedacted[key], New: newRedacted[key]})
+		}
+	}
+	return changes
+}
+
+// Changed reports whether any of the changes is to a key in one of the sections,
+// given as prefixes such as "camera" or "output_file"
+func Changed(changes []Change, sections ...string) bool {
+	for _, c := range changes {
+		for _, section := range sections {
+			if c.Key == section || strings.HasPrefix(c.Key, section+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flatten returns the JSON encoded value of every configuration key
+func flatten(c Config) map[string]string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil
+	}
+	var tree map[string]any
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil
+	}
+
+	values := make(map[string]string)
+	for _, key := range Keys() {
+		var node any = tree
+		for _, part := range strings.Split(key, ".") {
+			m, ok := node.(map[string]any)
+			if !ok {
+				node = nil
+				break
+			}
+			node = m[part]
+		}
+		encoded, _ := json.Marshal(node)
+		values[key] = string(encoded)
+	}
+	return values
+}
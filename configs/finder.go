@@ -3,8 +3,12 @@ package configs
 import (
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// configExtensions are the configuration file formats, in the order they are searched
+var configExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
 // FindConfigFile searches for a config file in standard locations
 // Returns the path to the first config file found, or an empty string if none found
 func FindConfigFile() string {
@@ -32,8 +36,12 @@ func FindConfigFile() string {
 	}
 
 	for _, path := range standardPaths {
-		if _, err := os.Stat(path); err == nil {
-			return path
+		// 同じ場所ではJSON、YAML、TOMLの順に探す
+		base := strings.TrimSuffix(path, ".json")
+		for _, ext := range configExtensions {
+			if _, err := os.Stat(base + ext); err == nil {
+				return base + ext
+			}
 		}
 	}
 
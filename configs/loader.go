@@ -4,56 +4,194 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
 	"strings"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
+// EnvPrefix is the prefix of environment variables that override configuration values.
+// A key such as "camera.device_id" is overridden by ME19_CAMERA_DEVICE_ID.
+const EnvPrefix = "ME19"
+
+// envAliases are environment variable names kept for compatibility with older releases
+var envAliases = map[string][]string{
+	"output_file.file_path": {"ME19_OUTPUT_FILE_PATH"},
+}
+
+// Load builds the configuration from, in increasing order of precedence, the
+// defaults, the configuration file at path (if not empty), ME19_* environment
+// variables and overrides given on the command line (keyed like "camera.fps").
+// The file format is chosen by its extension: .json, .yaml, .yml or .toml.
+// Unknown keys in the file are ignored here and reported by Config.Validate.
+func Load(path string, overrides map[string]string) (Config, error) {
+	config := DefaultConfig()
+
+	v := newViper()
+	if path != "" {
+		if err := readConfigFile(v, path); err != nil {
+			return config, err
+		}
+	}
+	bindEnvironment(v)
+	for key, value := range overrides {
+		if !isKey(key) {
+			return config, fmt.Errorf("unknown configuration key: %s", key)
+		}
+		v.Set(key, value)
+	}
+
+	if err := decode(v, &config); err != nil {
+		return DefaultConfig(), err
+	}
+	return config, nil
+}
+
+// LoadConfig loads the configuration file at filePath on top of the defaults,
+// without applying environment variables
 func LoadConfig(filePath string) (Config, error) {
 	config := DefaultConfig()
 
-	// First check if the file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return config, fmt.Errorf("config file not found: %s", filePath)
+	v := newViper()
+	if err := readConfigFile(v, filePath); err != nil {
+		return config, err
+	}
+	if err := decode(v, &config); err != nil {
+		return DefaultConfig(), err
 	}
+	return config, nil
+}
 
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return config, fmt.Errorf("reading config file: %w", err)
+// LoadEnvironmentVariables overrides config with the values of ME19_* environment variables
+func LoadEnvironmentVariables(config *Config) error {
+	v := newViper()
+	bindEnvironment(v)
+	return decode(v, config)
+}
+
+// Keys returns every configuration key, such as "camera.device_id"
+func Keys() []string {
+	var keys []string
+	collectKeys(reflect.TypeOf(Config{}), "", &keys)
+	sort.Strings(keys)
+	return keys
+}
+
+// EnvName returns the environment variable that overrides key
+func EnvName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+func newViper() *viper.Viper {
+	return viper.NewWithOptions(viper.KeyDelimiter("."))
+}
+
+// readConfigFile reads a configuration file into v
+func readConfigFile(v *viper.Viper, path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("config file not found: %s", path)
 	}
 
-	err = json.Unmarshal(data, &config)
-	if err != nil {
-		return config, fmt.Errorf("unmarshaling config: %w", err)
+	v.SetConfigFile(path)
+	// 拡張子のないファイルはJSONとして読む
+	if !hasSupportedExtension(path) {
+		v.SetConfigType("json")
+	}
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("reading config file: %w", err)
 	}
+	return nil
+}
 
-	return config, nil
+// hasSupportedExtension reports whether viper can tell the format of path from its extension
+func hasSupportedExtension(path string) bool {
+	for _, ext := range configExtensions {
+		if strings.HasSuffix(strings.ToLower(path), ext) {
+			return true
+		}
+	}
+	return false
 }
 
-func LoadEnvironmentVariables(config *Config) {
-	v := viper.New()
-	v.SetEnvPrefix("ME19")
-	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	v.AutomaticEnv()
+// bindEnvironment binds every configuration key to its ME19_* environment variable
+func bindEnvironment(v *viper.Viper) {
+	for _, key := range Keys() {
+		names := append([]string{EnvName(key)}, envAliases[key]...)
+		v.BindEnv(append([]string{key}, names...)...)
+	}
+}
 
-	if v.IsSet("CAMERA_DEVICE_ID") {
-		config.Camera.DeviceID = v.GetInt("CAMERA_DEVICE_ID")
+// decode stores the settings of v in config, leaving fields that are not set
+// unchanged, and records the keys that no field uses
+func decode(v *viper.Viper, config *Config) error {
+	var metadata mapstructure.Metadata
+	err := v.Unmarshal(config,
+		viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+			jsonStringHook,
+			mapstructure.StringToSliceHookFunc(","),
+		)),
+		func(dc *mapstructure.DecoderConfig) {
+			dc.TagName = "json"
+			dc.Metadata = &metadata
+		})
+	if err != nil {
+		return fmt.Errorf("unmarshaling config: %w", err)
 	}
-	if v.IsSet("CAMERA_WIDTH") {
-		config.Camera.Width = v.GetInt("CAMERA_WIDTH")
+	config.unknownKeys = append(config.unknownKeys, metadata.Unused...)
+	sort.Strings(config.unknownKeys)
+	return nil
+}
+
+// jsonStringHook lets environment variables and overrides set lists and
+// sections as JSON, e.g. ME19_QRCODE_ROIS='[{"x":0,"y":0,"width":640,"height":480}]'
+func jsonStringHook(from reflect.Type, to reflect.Type, data any) (any, error) {
+	s, ok := data.(string)
+	if !ok {
+		return data, nil
 	}
-	if v.IsSet("CAMERA_HEIGHT") {
-		config.Camera.Height = v.GetInt("CAMERA_HEIGHT")
+	switch to.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct:
+	default:
+		return data, nil
 	}
-	if v.IsSet("CAMERA_FPS") {
-		config.Camera.FPS = v.GetInt("CAMERA_FPS")
+
+	trimmed := strings.TrimSpace(s)
+	if !strings.HasPrefix(trimmed, "[") && !strings.HasPrefix(trimmed, "{") {
+		return data, nil
+	}
+	var value any
+	if err := json.Unmarshal([]byte(trimmed), &value); err != nil {
+		return nil, fmt.Errorf("invalid JSON value %q: %w", s, err)
 	}
+	return value, nil
+}
 
-	if v.IsSet("QRCODE_SCAN_INTERVAL_MS") {
-		config.QRCode.ScanInterval = v.GetInt("QRCODE_SCAN_INTERVAL_MS")
+// collectKeys appends the keys of the fields of t to keys. Lists and maps are single keys.
+func collectKeys(t reflect.Type, prefix string, keys *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		if field.Type.Kind() == reflect.Struct {
+			collectKeys(field.Type, key+".", keys)
+			continue
+		}
+		*keys = append(*keys, key)
 	}
+}
 
-	if v.IsSet("OUTPUT_FILE_PATH") {
-		config.OutputFile.FilePath = v.GetString("OUTPUT_FILE_PATH")
+// isKey reports whether key is a configuration key
+func isKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range Keys() {
+		if k == key {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,354 @@
+package configs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/eotel/me19/internal/fileio"
+	"github.com/eotel/me19/internal/payload"
+	"github.com/eotel/me19/internal/sanitize"
+	"github.com/eotel/me19/internal/sink"
+	"github.com/eotel/me19/internal/verify"
+)
+
+// Problem is an invalid configuration value
+type Problem struct {
+	Path    string // Key of the value, such as "camera.fps" or "sinks[1].command"
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("invalid configuration (%d problem(s)):", len(e.Problems)))
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validator collects problems
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) add(path, format string, args ...any) {
+	v.problems = append(v.problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) positive(path string, value int) {
+	if value <= 0 {
+		v.add(path, "must be greater than 0 (got %d)", value)
+	}
+}
+
+func (v *validator) nonNegative(path string, value int) {
+	if value < 0 {
+		v.add(path, "must not be negative (got %d)", value)
+	}
+}
+
+func (v *validator) oneOf(path, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add(path, "must be one of %s (got %q)", strings.Join(allowed, ", "), value)
+}
+
+// parsed reports the error of the function that converts the value when the
+// application starts, so that validation accepts exactly the same values
+func (v *validator) parsed(path string, err error) {
+	if err != nil {
+		v.add(path, "%v", err)
+	}
+}
+
+func (v *validator) required(path, value string) bool {
+	if value == "" {
+		v.add(path, "is required")
+		return false
+	}
+	return true
+}
+
+func (v *validator) regex(path, pattern string) {
+	if _, err := regexp.Compile(pattern); err != nil {
+		v.add(path, "invalid regular expression: %v", err)
+	}
+}
+
+// writableDir checks that the directory a file is created in exists
+func (v *validator) writableDir(path, file string) {
+	dir := filepath.Dir(file)
+	info, err := os.Stat(dir)
+	if err != nil {
+		v.add(path, "directory %s does not exist", dir)
+		return
+	}
+	if !info.IsDir() {
+		v.add(path, "%s is not a directory", dir)
+	}
+}
+
+// Validate checks the configuration and reports every problem it finds,
+// including keys in the configuration file that are not known. The returned
+// error is a *ValidationError, or nil if the configuration is valid.
+func (c Config) Validate() error {
+	v := &validator{}
+
+	for _, key := range c.unknownKeys {
+		v.add(key, "unknown key")
+	}
+
+	v.nonNegative("camera.device_id", c.Camera.DeviceID)
+	if serial, ok := strings.CutPrefix(c.Camera.Device, "serial:"); ok && serial == "" {
+		v.add("camera.device", "serial number is empty")
+	}
+	v.positive("camera.width", c.Camera.Width)
+	v.positive("camera.height", c.Camera.Height)
+	v.positive("camera.fps", c.Camera.FPS)
+	if r := c.Camera.Reconnect; r.Enabled {
+		v.positive("camera.reconnect.failure_threshold", r.FailureThreshold)
+		v.positive("camera.reconnect.initial_backoff_ms", r.InitialBackoffMS)
+		if r.MaxBackoffMS < r.InitialBackoffMS {
+			v.add("camera.reconnect.max_backoff_ms", "must be at least initial_backoff_ms (%d)", r.InitialBackoffMS)
+		}
+	}
+
+	c.Camera.Controls.validate(v, "camera.controls")
+
+	names := make(map[string]bool)
+	for i, cam := range c.Cameras {
+		path := fmt.Sprintf("cameras[%d]", i)
+		if v.required(path+".name", cam.Name) {
+			if names[cam.Name] {
+				v.add(path+".name", "duplicate camera name %q", cam.Name)
+			}
+			names[cam.Name] = true
+		}
+		v.nonNegative(path+".device_id", cam.DeviceID)
+		v.nonNegative(path+".width", cam.Width)
+		v.nonNegative(path+".height", cam.Height)
+		v.nonNegative(path+".fps", cam.FPS)
+		if serial, ok := strings.CutPrefix(cam.Device, "serial:"); ok && serial == "" {
+			v.add(path+".device", "serial number is empty")
+		}
+		cam.Controls.validate(v, path+".controls")
+	}
+	v.oneOf("dedup.scope", c.Dedup.Scope, "global", "camera")
+	v.nonNegative("dedup.window_seconds", c.Dedup.WindowSeconds)
+
+	c.QRCode.validate(v)
+
+	v.required("output_file.file_path", c.OutputFile.FilePath)
+	if c.OutputFile.FilePath != "" {
+		v.writableDir("output_file.file_path", c.OutputFile.FilePath)
+	}
+	validateEncoding(v, "output_file.encoding", c.OutputFile.Encoding)
+	c.OutputFile.Rotation.validate(v, "output_file.rotation")
+
+	c.Filter.validate(v)
+	c.Verification.validate(v)
+
+	_, err := sanitize.ParseMode(c.Sanitize.ControlChars)
+	v.parsed("sanitize.control_chars", err)
+	v.nonNegative("sanitize.max_length", c.Sanitize.MaxLength)
+
+	v.nonNegative("actions.max_concurrent", c.Actions.MaxConcurrent)
+	for i, rule := range c.Actions.Rules {
+		rule.validate(v, fmt.Sprintf("actions.rules[%d]", i))
+	}
+
+	names = make(map[string]bool)
+	for i, s := range c.Sinks {
+		path := fmt.Sprintf("sinks[%d]", i)
+		s.validate(v, path)
+		if s.Name != "" {
+			if names[s.Name] {
+				v.add(path+".name", "duplicate sink name %q", s.Name)
+			}
+			names[s.Name] = true
+		}
+	}
+
+	if c.History.Enabled && c.History.Path != "" {
+		v.writableDir("history.path", c.History.Path)
+	}
+	if c.State.Enabled && c.State.Path != "" {
+		v.writableDir("state.path", c.State.Path)
+	}
+	v.nonNegative("state.max_age_s", c.State.MaxAgeSeconds)
+	if c.Lock.Enabled && c.Lock.Path != "" {
+		v.writableDir("lock.path", c.Lock.Path)
+	}
+
+	v.oneOf("display.mode", c.Display.Mode, "auto", "headless", "display")
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.problems}
+}
+
+func (c ControlsConfig) validate(v *validator, path string) {
+	// 露出はWindowsでは負の値（2の累乗秒）になるため範囲を確認しない
+	values := []struct {
+		name  string
+		value *float64
+	}{{"focus", c.Focus}, {"gain", c.Gain}, {"white_balance", c.WhiteBalance}, {"zoom", c.Zoom}}
+	for _, f := range values {
+		if f.value != nil && *f.value < 0 {
+			v.add(path+"."+f.name, "must not be negative (got %g)", *f.value)
+		}
+	}
+	if c.BufferSize != nil {
+		v.positive(path+".buffer_size", *c.BufferSize)
+	}
+}
+
+func (q QRCodeConfig) validate(v *validator) {
+	v.positive("qrcode.scan_interval_ms", q.ScanInterval)
+	for i, roi := range q.ROIs {
+		path := fmt.Sprintf("qrcode.rois[%d]", i)
+		v.nonNegative(path+".x", roi.X)
+		v.nonNegative(path+".y", roi.Y)
+		v.positive(path+".width", roi.Width)
+		v.positive(path+".height", roi.Height)
+	}
+	v.nonNegative("qrcode.tracking.padding_px", q.Tracking.Padding)
+	v.nonNegative("qrcode.structured_append_timeout_ms", q.StructuredAppendTimeout)
+
+	if !q.Confirmation.Enabled {
+		return
+	}
+	v.oneOf("qrcode.confirmation.mode", q.Confirmation.Mode, "frames", "time")
+	v.positive("qrcode.confirmation.required", q.Confirmation.Required)
+	switch q.Confirmation.Mode {
+	case "frames":
+		if q.Confirmation.Frames < q.Confirmation.Required {
+			v.add("qrcode.confirmation.frames", "must be at least required (%d) (got %d)", q.Confirmation.Required, q.Confirmation.Frames)
+		}
+	case "time":
+		v.positive("qrcode.confirmation.window_ms", q.Confirmation.WindowMS)
+	}
+}
+
+func (r RotationConfig) validate(v *validator, path string) {
+	v.nonNegative(path+".max_size_kb", r.MaxSizeKB)
+	v.nonNegative(path+".interval_minutes", r.IntervalMinutes)
+	v.nonNegative(path+".max_backups", r.MaxBackups)
+	v.nonNegative(path+".max_age_days", r.MaxAgeDays)
+}
+
+func (f FilterConfig) validate(v *validator) {
+	validateLengths(v, "filter", f.MinLength, f.MaxLength)
+	lists := []struct {
+		name  string
+		rules []FilterRuleConfig
+	}{{"filter.allow", f.Allow}, {"filter.deny", f.Deny}}
+	for _, list := range lists {
+		for i, rule := range list.rules {
+			path := fmt.Sprintf("%s[%d]", list.name, i)
+			if rule.Regex != "" {
+				v.regex(path+".regex", rule.Regex)
+			}
+			if rule.Type != "" {
+				_, err := payload.ParseType(rule.Type)
+				v.parsed(path+".type", err)
+			}
+			validateLengths(v, path, rule.MinLength, rule.MaxLength)
+		}
+	}
+}
+
+func (c VerificationConfig) validate(v *validator) {
+	v.nonNegative("verification.leeway_s", c.LeewaySeconds)
+	if !c.Enabled {
+		return
+	}
+	if len(c.Keys) == 0 {
+		v.add("verification.keys", "at least one key is required when verification is enabled")
+	}
+	if c.ReplayStore != "" {
+		v.writableDir("verification.replay_store", c.ReplayStore)
+	}
+	for i, key := range c.Keys {
+		path := fmt.Sprintf("verification.keys[%d]", i)
+		v.required(path+".id", key.ID)
+		algorithm, err := verify.ParseAlgorithm(key.Algorithm)
+		switch algorithm {
+		case verify.Ed25519:
+			v.required(path+".public_key", key.PublicKey)
+		case verify.HS256:
+			v.required(path+".secret", key.Secret)
+		default:
+			v.parsed(path+".algorithm", err)
+		}
+	}
+}
+
+func (r ActionRuleConfig) validate(v *validator, path string) {
+	if v.required(path+".match", r.Match) {
+		v.regex(path+".match", r.Match)
+	}
+	v.nonNegative(path+".timeout_ms", r.TimeoutMS)
+	switch r.Action {
+	case "file":
+		v.required(path+".path", r.Path)
+	case "http":
+		v.required(path+".url", r.URL)
+	case "osc":
+		v.required(path+".target", r.Target)
+		v.required(path+".address", r.Address)
+	case "exec":
+		v.required(path+".command", r.Command)
+	default:
+		v.add(path+".action", "must be one of file, http, osc, exec (got %q)", r.Action)
+	}
+}
+
+func (s SinkConfig) validate(v *validator, path string) {
+	switch s.Type {
+	case "file":
+		if v.required(path+".path", s.Path) {
+			v.writableDir(path+".path", s.Path)
+		}
+		validateEncoding(v, path+".encoding", s.Encoding)
+		s.Rotation.validate(v, path+".rotation")
+	case "exec":
+		v.required(path+".command", s.Command)
+		_, err := sink.ParseStdinMode(s.Stdin)
+		v.parsed(path+".stdin", err)
+		v.nonNegative(path+".timeout_ms", s.TimeoutMS)
+		v.nonNegative(path+".concurrency", s.Concurrency)
+		v.nonNegative(path+".queue_size", s.QueueSize)
+	default:
+		v.add(path+".type", "must be one of file, exec (got %q)", s.Type)
+	}
+}
+
+func validateEncoding(v *validator, path, encoding string) {
+	_, err := fileio.ParseEncoding(encoding)
+	v.parsed(path, err)
+}
+
+func validateLengths(v *validator, path string, minLength, maxLength int) {
+	v.nonNegative(path+".min_length", minLength)
+	v.nonNegative(path+".max_length", maxLength)
+	if minLength > 0 && maxLength > 0 && minLength > maxLength {
+		v.add(path+".max_length", "must not be less than min_length (%d) (got %d)", minLength, maxLength)
+	}
+}
@@ -0,0 +1,109 @@
+package configs
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher reports changes to a configuration file. It watches the directory
+// containing the file so that editors which replace the file on save are
+// handled, and coalesces bursts of events into a single change.
+type Watcher struct {
+	watcher *fsnotify.Watcher
+	path    string
+	delay   time.Duration
+	report  func(error)
+	changes chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Watch starts watching the configuration file at path. A change is reported
+// once no further events arrived for delay. Watch errors are passed to report,
+// which may be nil.
+func Watch(path string, delay time.Duration, report func(error)) (*Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		fw.Close()
+		return nil, err
+	}
+	if err := fw.Add(filepath.Dir(absPath)); err != nil {
+		fw.Close()
+		return nil, err
+	}
+
+	w := &Watcher{
+		watcher: fw,
+		path:    absPath,
+		delay:   delay,
+		report:  report,
+		changes: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w, nil
+}
+
+// Changes returns a channel that receives a value when the file has changed
+func (w *Watcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	close(w.done)
+	err := w.watcher.Close()
+	w.wg.Wait()
+	return err
+}
+
+func (w *Watcher) loop() {
+	defer w.wg.Done()
+
+	// 保存時の連続したイベントをまとめるためのタイマー
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			name, err := filepath.Abs(event.Name)
+			if err != nil || name != w.path || event.Op == fsnotify.Chmod {
+				continue
+			}
+			timer.Reset(w.delay)
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			if w.report != nil {
+				w.report(err)
+			}
+
+		case <-timer.C:
+			select {
+			case w.changes <- struct{}{}:
+			default:
+				// 未処理の通知があればそれで十分
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package configs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Formats are the supported configuration file formats
+var Formats = []string{"json", "yaml", "toml"}
+
+// FormatFor returns the configuration format for a file name, defaulting to JSON
+func FormatFor(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// Write encodes the configuration in format ("json", "yaml" or "toml")
+func Write(w io.Writer, c Config, format string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		_, err := w.Write(append(data, '\n'))
+		return err
+	case "yaml", "toml":
+	default:
+		return fmt.Errorf("unknown configuration format: %s (expected %s)", format, strings.Join(Formats, ", "))
+	}
+
+	// YAMLとTOMLはJSONと同じキーで出力する（TOMLはnullを表せないため空の値は省く）
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var tree map[string]any
+	if err := decoder.Decode(&tree); err != nil {
+		return err
+	}
+	normalize(tree)
+	v := viper.New()
+	if err := v.MergeConfigMap(tree); err != nil {
+		return err
+	}
+	v.SetConfigType(format)
+	return v.WriteConfigTo(w)
+}
+
+// normalize deletes null values from a decoded JSON object and turns numbers into
+// integers, so that they are not written as floating point values
+func normalize(m map[string]any) {
+	for key, value := range m {
+		if value == nil {
+			delete(m, key)
+			continue
+		}
+		m[key] = normalizeValue(value)
+	}
+}
+
+func normalizeValue(value any) any {
+	switch value := value.(type) {
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]any:
+		normalize(value)
+	case []any:
+		for i, item := range value {
+			value[i] = normalizeValue(item)
+		}
+	}
+	return value
+}
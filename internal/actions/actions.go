@@ -0,0 +1,164 @@
+// Package actions runs configured actions when a payload matches a rule pattern,
+// for example writing a file, posting to a URL, sending an OSC message or running
+// a command. Capture groups of the pattern can be used in the action arguments.
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+// DefaultTimeout is used for rules without a timeout
+const DefaultTimeout = 5 * time.Second
+
+// DefaultMaxConcurrent is the number of actions that may run at the same time
+// unless SetMaxConcurrent changes it
+const DefaultMaxConcurrent = 8
+
+// ErrBusy is reported for an action that was not started because the maximum
+// number of actions were already running
+var ErrBusy = errors.New("too many actions running")
+
+// Action is something executed for a matching payload
+type Action interface {
+	Kind() string
+	Execute(ctx context.Context, m Match) error
+}
+
+// Rule maps a payload pattern to an action
+type Rule struct {
+	Name    string // Used in reports; defaults to the rule position
+	Pattern string // Regular expression matched against the payload
+	Action  Action
+	Timeout time.Duration // Maximum run time of the action (DefaultTimeout if zero)
+}
+
+// Result reports the outcome of one action execution
+type Result struct {
+	Rule     string
+	Kind     string
+	Code     string
+	Err      error
+	Duration time.Duration
+}
+
+// Engine matches detections against rules and runs the actions asynchronously
+type Engine struct {
+	rules   []compiledRule
+	report  func(Result)
+	running chan struct{} // 実行中のアクションの数を制限するセマフォ
+	wg      sync.WaitGroup
+}
+
+type compiledRule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// New compiles the rules. report is called from the action goroutines with the
+// result of every execution, and from Handle for actions that were not started;
+// it may be nil.
+func New(rules []Rule, report func(Result)) (*Engine, error) {
+	e := &Engine{report: report, running: make(chan struct{}, DefaultMaxConcurrent)}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule[%d]", i)
+		}
+		if rule.Action == nil {
+			return nil, fmt.Errorf("rule %s: no action", rule.Name)
+		}
+		if rule.Timeout <= 0 {
+			rule.Timeout = DefaultTimeout
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid pattern: %w", rule.Name, err)
+		}
+		e.rules = append(e.rules, compiledRule{Rule: rule, re: re})
+	}
+	return e, nil
+}
+
+// Len returns the number of rules
+func (e *Engine) Len() int {
+	return len(e.rules)
+}
+
+// SetMaxConcurrent sets how many actions may run at the same time. It must be
+// called before Handle.
+func (e *Engine) SetMaxConcurrent(n int) {
+	if n <= 0 {
+		n = DefaultMaxConcurrent
+	}
+	e.running = make(chan struct{}, n)
+}
+
+// Handle starts the actions of every rule matching d and returns how many were started.
+// It does not wait for the actions to finish. When the maximum number of actions
+// are already running, the action is not started and ErrBusy is reported.
+func (e *Engine) Handle(d detection.Detection) int {
+	started := 0
+	for _, rule := range e.rules {
+		groups := rule.re.FindStringSubmatch(d.Code)
+		if groups == nil {
+			continue
+		}
+		m := Match{Groups: groups, Names: make(map[string]int), Detection: d}
+		for i, name := range rule.re.SubexpNames() {
+			if name != "" {
+				m.Names[name] = i
+			}
+		}
+
+		// 検出ループを止めないため、空きがなければ実行しない
+		select {
+		case e.running <- struct{}{}:
+		default:
+			if e.report != nil {
+				e.report(Result{Rule: rule.Name, Kind: rule.Action.Kind(), Code: d.Code, Err: ErrBusy})
+			}
+			continue
+		}
+		e.wg.Add(1)
+		go e.run(rule, m, d.Code)
+		started++
+	}
+	return started
+}
+
+// run executes a single action with the rule timeout and reports the result
+func (e *Engine) run(rule compiledRule, m Match, code string) {
+	defer e.wg.Done()
+	defer func() { <-e.running }()
+
+	// 検出ループの終了に巻き込まれないよう、各アクションは独立したタイムアウトで実行する
+	ctx, cancel := context.WithTimeout(context.Background(), rule.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := rule.Action.Execute(ctx, m)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %v: %w", rule.Timeout, err)
+	}
+
+	if e.report != nil {
+		e.report(Result{
+			Rule:     rule.Name,
+			Kind:     rule.Action.Kind(),
+			Code:     code,
+			Err:      err,
+			Duration: time.Since(start),
+		})
+	}
+}
+
+// Wait blocks until every started action has finished
+func (e *Engine) Wait() {
+	e.wg.Wait()
+}
@@ -0,0 +1,314 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+func TestMatch_Expand(t *testing.T) {
+	m := Match{
+		Groups: []string{"ROOM-12-A", "12", "A"},
+		Names:  map[string]int{"wing": 2},
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"room $1", "room 12"},
+		{"${1}0", "120"},
+		{"$10", ""},
+		{"${wing}-$1", "A-12"},
+		{"$0", "ROOM-12-A"},
+		{"cost $$5", "cost $5"},
+		{"$x and ${", "$x and ${"},
+		{"${missing}", ""},
+	}
+	for _, tt := range tests {
+		if got := m.Expand(tt.template, nil); got != tt.want {
+			t.Errorf("Expand(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+
+	// URLに埋め込む値はエスケープされる
+	evil := Match{Groups: []string{"", "a/b?x=1&y=2 z"}}
+	if got := evil.Expand("http://host/rooms/$1?v=$1", urlEscape); got != "http://host/rooms/a%2Fb%3Fx%3D1%26y%3D2%20z?v=a%2Fb%3Fx%3D1%26y%3D2%20z" {
+		t.Errorf("URL expansion = %q", got)
+	}
+}
+
+// collector は実行結果を集める
+type collector struct {
+	mu      sync.Mutex
+	results []Result
+}
+
+func (c *collector) report(r Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = append(c.results, r)
+}
+
+func (c *collector) all() []Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Result(nil), c.results...)
+}
+
+func TestEngine_FileAction(t *testing.T) {
+	dir := t.TempDir()
+	c := &collector{}
+	e, err := New([]Rule{
+		{Name: "room", Pattern: `^ROOM-(\d+)$`, Action: FileAction{Path: filepath.Join(dir, "room_$1.txt"), Content: "entered $1\n"}},
+		{Name: "log", Pattern: `.`, Action: FileAction{Path: filepath.Join(dir, "all.log"), Content: "$0\n", Append: true}},
+	}, c.report)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if n := e.Handle(detection.Detection{Code: "ROOM-42"}); n != 2 {
+		t.Errorf("Expected 2 matching rules, got %d", n)
+	}
+	if n := e.Handle(detection.Detection{Code: "HALL"}); n != 1 {
+		t.Errorf("Expected 1 matching rule, got %d", n)
+	}
+	e.Wait()
+
+	data, err := os.ReadFile(filepath.Join(dir, "room_42.txt"))
+	if err != nil || string(data) != "entered 42\n" {
+		t.Errorf("room_42.txt = %q, %v", data, err)
+	}
+	data, _ = os.ReadFile(filepath.Join(dir, "all.log"))
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 {
+		t.Errorf("all.log should have 2 lines, got %q", data)
+	}
+	for _, r := range c.all() {
+		if r.Err != nil {
+			t.Errorf("Rule %s failed: %v", r.Rule, r.Err)
+		}
+	}
+}
+
+func TestFileAction_PathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	action := FileAction{Path: filepath.Join(dir, "rooms", "$1.txt"), Content: "$0"}
+	if err := os.Mkdir(filepath.Join(dir, "rooms"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		group   string
+		wantErr bool
+	}{
+		{name: "ファイル名", group: "12"},
+		{name: "ドットを含む名前", group: "a..b"},
+		{name: "親ディレクトリ", group: "..", wantErr: true},
+		{name: "相対パス", group: "../../escape", wantErr: true},
+		{name: "Windowsの区切り文字", group: `..\escape`, wantErr: true},
+		{name: "絶対パス", group: "/tmp/escape", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := action.Execute(context.Background(), Match{Groups: []string{"ROOM-" + tt.group, tt.group}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Execute(%q) error = %v, wantErr %v", tt.group, err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape.txt")); err == nil {
+		t.Error("A payload should not write outside the directory")
+	}
+}
+
+// blockingAction は解放されるまで終わらないアクション
+type blockingAction struct {
+	release chan struct{}
+}
+
+func (a blockingAction) Kind() string { return "blocking" }
+
+func (a blockingAction) Execute(ctx context.Context, m Match) error {
+	<-a.release
+	return nil
+}
+
+func TestEngine_MaxConcurrent(t *testing.T) {
+	c := &collector{}
+	action := blockingAction{release: make(chan struct{})}
+	e, err := New([]Rule{{Name: "slow", Pattern: `.`, Action: action}}, c.report)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	e.SetMaxConcurrent(2)
+
+	started := 0
+	for range 3 {
+		started += e.Handle(detection.Detection{Code: "A"})
+	}
+	if started != 2 {
+		t.Errorf("Expected 2 started actions, got %d", started)
+	}
+	results := c.all()
+	if len(results) != 1 || !errors.Is(results[0].Err, ErrBusy) {
+		t.Errorf("The third action should be reported as busy, got %+v", results)
+	}
+
+	// 終了すると再び実行できる
+	close(action.release)
+	e.Wait()
+	if e.Handle(detection.Detection{Code: "A"}) != 1 {
+		t.Error("An action should start once the others have finished")
+	}
+	e.Wait()
+}
+
+func TestEngine_HTTPAction(t *testing.T) {
+	var mu sync.Mutex
+	var gotPath, gotBody, gotHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		gotPath, gotBody, gotHeader = r.URL.EscapedPath(), string(body), r.Header.Get("X-Room")
+		mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/13") {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	c := &collector{}
+	e, _ := New([]Rule{{
+		Pattern: `^ROOM-(?P<room>\d+)$`,
+		Action: HTTPAction{
+			URL:     server.URL + "/rooms/${room}",
+			Body:    `{"room": "$1"}`,
+			Headers: map[string]string{"X-Room": "$1"},
+		},
+	}}, c.report)
+
+	e.Handle(detection.Detection{Code: "ROOM-42"})
+	e.Wait()
+	mu.Lock()
+	if gotPath != "/rooms/42" || gotBody != `{"room": "42"}` || gotHeader != "42" {
+		t.Errorf("Unexpected request: path=%q body=%q header=%q", gotPath, gotBody, gotHeader)
+	}
+	mu.Unlock()
+
+	// エラーステータスは失敗として報告される
+	e.Handle(detection.Detection{Code: "ROOM-13"})
+	e.Wait()
+	results := c.all()
+	if len(results) != 2 || results[0].Err != nil || results[1].Err == nil {
+		t.Fatalf("Unexpected results: %+v", results)
+	}
+	if results[1].Rule != "rule[0]" || results[1].Kind != "http" {
+		t.Errorf("Unexpected result: %+v", results[1])
+	}
+}
+
+func TestEngine_OSCAction(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("UDP not available: %v", err)
+	}
+	defer conn.Close()
+
+	e, _ := New([]Rule{{
+		Pattern: `^ROOM-(\d+)$`,
+		Action:  OSCAction{Target: conn.LocalAddr().String(), Address: "/room/$1", Args: []string{"$1", "enter"}},
+	}}, nil)
+	e.Handle(detection.Detection{Code: "ROOM-7"})
+	e.Wait()
+
+	buf := make([]byte, 512)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("No OSC message received: %v", err)
+	}
+
+	want := []byte("/room/7\x00,ss\x007\x00\x00\x00enter\x00\x00\x00")
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("OSC message = %q, want %q", buf[:n], want)
+	}
+}
+
+func TestEncodeOSCMessage(t *testing.T) {
+	got, err := encodeOSCMessage("/abc", nil)
+	if err != nil {
+		t.Fatalf("encodeOSCMessage failed: %v", err)
+	}
+	if want := []byte("/abc\x00\x00\x00\x00,\x00\x00\x00"); !bytes.Equal(got, want) {
+		t.Errorf("encodeOSCMessage = %q, want %q", got, want)
+	}
+	if _, err := encodeOSCMessage("room", nil); err == nil {
+		t.Error("encodeOSCMessage should reject addresses without a leading slash")
+	}
+}
+
+func TestEngine_ExecAction(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	out := filepath.Join(t.TempDir(), "out.txt")
+
+	c := &collector{}
+	e, _ := New([]Rule{
+		// 引数はシェルを通さず1つの引数として渡される
+		{Name: "echo", Pattern: `^ECHO (.*)$`, Action: ExecAction{Command: sh, Args: []string{"-c", `printf '%s' "$$1" > "$$2"`, "sh", "$1", out}}},
+		{Name: "fail", Pattern: `^FAIL$`, Action: ExecAction{Command: sh, Args: []string{"-c", "echo broken >&2; exit 3"}}},
+		{Name: "slow", Pattern: `^SLOW$`, Action: ExecAction{Command: sh, Args: []string{"-c", "sleep 5"}}, Timeout: 100 * time.Millisecond},
+	}, c.report)
+
+	e.Handle(detection.Detection{Code: "ECHO a; rm -rf / $(id)"})
+	e.Handle(detection.Detection{Code: "FAIL"})
+	e.Handle(detection.Detection{Code: "SLOW"})
+	e.Wait()
+
+	data, _ := os.ReadFile(out)
+	if string(data) != "a; rm -rf / $(id)" {
+		t.Errorf("Argument should be passed verbatim, got %q", data)
+	}
+
+	byRule := make(map[string]Result)
+	for _, r := range c.all() {
+		byRule[r.Rule] = r
+	}
+	if byRule["echo"].Err != nil {
+		t.Errorf("echo failed: %v", byRule["echo"].Err)
+	}
+	if err := byRule["fail"].Err; err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("fail should report the command output, got %v", err)
+	}
+	if err := byRule["slow"].Err; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("slow should time out, got %v", err)
+	}
+	if byRule["slow"].Duration > 3*time.Second {
+		t.Errorf("slow should be stopped by the timeout, took %v", byRule["slow"].Duration)
+	}
+}
+
+func TestNew_InvalidRules(t *testing.T) {
+	if _, err := New([]Rule{{Pattern: "(", Action: FileAction{}}}, nil); err == nil {
+		t.Error("New should reject invalid patterns")
+	}
+	if _, err := New([]Rule{{Pattern: "x"}}, nil); err == nil {
+		t.Error("New should reject rules without an action")
+	}
+}
@@ -0,0 +1,172 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/eotel/me19/internal/command"
+)
+
+// FileAction writes to a file. Path and Content are templates; values
+// substituted into the path must be single file name elements, so a payload
+// cannot make the action write outside the directory of the template.
+type FileAction struct {
+	Path    string
+	Content string
+	Append  bool
+}
+
+// Kind implements Action
+func (a FileAction) Kind() string { return "file" }
+
+// Execute implements Action
+func (a FileAction) Execute(ctx context.Context, m Match) error {
+	var unsafe []string
+	path := m.Expand(a.Path, func(value string) string {
+		if !isPathElement(value) {
+			unsafe = append(unsafe, value)
+		}
+		return value
+	})
+	if len(unsafe) > 0 {
+		return fmt.Errorf("captured value %q cannot be used in a file path", unsafe[0])
+	}
+	if path == "" {
+		return fmt.Errorf("empty file path")
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if a.Append {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(m.Expand(a.Content, nil))
+	return err
+}
+
+// isPathElement reports whether a substituted value stays within one element
+// of a path: it has no separators and is not "." or ".."
+func isPathElement(value string) bool {
+	if value == "." || value == ".." {
+		return false
+	}
+	// Windowsの区切り文字も拒否する
+	return !strings.ContainsAny(value, "/\\\x00")
+}
+
+// HTTPAction sends an HTTP request. URL, Body and header values are templates;
+// values substituted into the URL are escaped.
+type HTTPAction struct {
+	Method  string
+	URL     string
+	Body    string
+	Headers map[string]string
+	Client  *http.Client // nil uses http.DefaultClient
+}
+
+// Kind implements Action
+func (a HTTPAction) Kind() string { return "http" }
+
+// Execute implements Action
+func (a HTTPAction) Execute(ctx context.Context, m Match) error {
+	method := a.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, m.Expand(a.URL, urlEscape), strings.NewReader(m.Expand(a.Body, nil)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	for name, value := range a.Headers {
+		req.Header.Set(name, m.Expand(value, nil))
+	}
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s %s: %s", method, req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// OSCAction sends an OSC message over UDP. Address and Args are templates;
+// every argument is sent as an OSC string.
+type OSCAction struct {
+	Target  string // host:port
+	Address string // OSC address pattern such as /room
+	Args    []string
+}
+
+// Kind implements Action
+func (a OSCAction) Kind() string { return "osc" }
+
+// Execute implements Action
+func (a OSCAction) Execute(ctx context.Context, m Match) error {
+	args := make([]string, 0, len(a.Args))
+	for _, arg := range a.Args {
+		args = append(args, m.Expand(arg, nil))
+	}
+	message, err := encodeOSCMessage(m.Expand(a.Address, nil), args)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", a.Target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+	_, err = conn.Write(message)
+	return err
+}
+
+// ExecAction runs a command directly, without a shell. Args are templates,
+// and each one is passed as a single argument so payloads cannot inject further
+// commands through shell syntax or word splitting. The command runs like an exec
+// output, with the ME19_* variables of the detection in its environment.
+type ExecAction struct {
+	Command    string
+	Args       []string
+	InheritEnv bool // Pass the environment of the scanner (see command.Command)
+}
+
+// Kind implements Action
+func (a ExecAction) Kind() string { return "exec" }
+
+// Execute implements Action
+func (a ExecAction) Execute(ctx context.Context, m Match) error {
+	args := make([]string, 0, len(a.Args))
+	for _, arg := range a.Args {
+		args = append(args, m.Expand(arg, nil))
+	}
+	return command.Run(ctx, command.Command{
+		Path:       a.Command,
+		Args:       args,
+		Env:        command.Environment(m.Detection),
+		InheritEnv: a.InheritEnv,
+	}).Failure()
+}
@@ -0,0 +1,31 @@
+package actions
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// encodeOSCMessage encodes an OSC 1.0 message whose arguments are all strings
+func encodeOSCMessage(address string, args []string) ([]byte, error) {
+	if !strings.HasPrefix(address, "/") {
+		return nil, fmt.Errorf("osc address must start with '/': %q", address)
+	}
+
+	var buf bytes.Buffer
+	writeOSCString(&buf, address)
+	writeOSCString(&buf, ","+strings.Repeat("s", len(args)))
+	for _, arg := range args {
+		writeOSCString(&buf, arg)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeOSCString writes a null-terminated string padded to a multiple of four bytes
+func writeOSCString(buf *bytes.Buffer, s string) {
+	// 文字列中のNULは終端と区別できないため取り除く
+	s = strings.ReplaceAll(s, "\x00", "")
+	buf.WriteString(s)
+	padding := 4 - len(s)%4
+	buf.Write(make([]byte, padding))
+}
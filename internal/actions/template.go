@@ -0,0 +1,84 @@
+package actions
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+// Match holds the capture groups of a rule pattern matched against a payload
+type Match struct {
+	Groups    []string       // Groups[0] is the whole match
+	Names     map[string]int // Named groups and their index
+	Detection detection.Detection
+}
+
+// Expand replaces $N, ${N} and ${name} in template with the capture groups.
+// "$$" produces a literal dollar sign; unknown groups expand to an empty string.
+// Each substituted value is passed through escape when it is not nil.
+func (m Match) Expand(template string, escape func(string) string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(template, '$')
+		if i < 0 {
+			b.WriteString(template)
+			return b.String()
+		}
+		b.WriteString(template[:i])
+		template = template[i+1:]
+
+		var name string
+		switch {
+		case strings.HasPrefix(template, "$"):
+			b.WriteByte('$')
+			template = template[1:]
+			continue
+		case strings.HasPrefix(template, "{"):
+			end := strings.IndexByte(template, '}')
+			if end < 0 {
+				// 閉じ括弧がない場合はそのまま出力する
+				b.WriteByte('$')
+				continue
+			}
+			name, template = template[1:end], template[end+1:]
+		default:
+			n := 0
+			for n < len(template) && template[n] >= '0' && template[n] <= '9' {
+				n++
+			}
+			if n == 0 {
+				b.WriteByte('$')
+				continue
+			}
+			name, template = template[:n], template[n:]
+		}
+
+		value := m.group(name)
+		if escape != nil {
+			value = escape(value)
+		}
+		b.WriteString(value)
+	}
+}
+
+// group returns the capture group with the given index or name
+func (m Match) group(name string) string {
+	index, err := strconv.Atoi(name)
+	if err != nil {
+		var ok bool
+		if index, ok = m.Names[name]; !ok {
+			return ""
+		}
+	}
+	if index < 0 || index >= len(m.Groups) {
+		return ""
+	}
+	return m.Groups[index]
+}
+
+// urlEscape escapes a value so that it is safe in both the path and the query of a URL
+func urlEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
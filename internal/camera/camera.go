@@ -2,6 +2,10 @@ package camera
 
 import (
 	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eotel/me19/internal/videodev"
 	"gocv.io/x/gocv"
 )
 
@@ -18,11 +22,39 @@ type Device interface {
 }
 
 type Camera struct {
-	deviceID int           // ID of the camera device to use (typically 0 for the first camera)
-	isOpen   bool          // Flag indicating if the camera is currently open
-	backend  CameraBackend // The implementation that handles actual camera operations
+	deviceID int              // ID of the camera device to use (typically 0 for the first camera)
+	selector string           // Stable camera selector resolved to deviceID when the camera is opened
+	format   Format           // Requested capture format, applied when the camera is opened
+	controls Controls         // Requested controls, applied when the camera is opened (controls.go)
+	initial  map[int]Controls // Control values of each device when it was first opened, before any was applied
+	isOpen   bool             // Flag indicating if the camera is currently open
+	backend  CameraBackend    // The implementation that handles actual camera operations
+
+	// 切断時の再接続（reconnect.go）
+	reconnect ReconnectPolicy
+	report    func(Event)
+	now       func() time.Time // Clock used for backoff; tests replace it
+	failures  int              // Consecutive read failures
+	lost      bool             // The device was closed after reads kept failing
+	attempt   int              // Failed reconnection attempts since the camera was lost
+	retryAt   time.Time        // When the next reconnection attempt is due
+}
+
+// Format is a requested capture format. Zero values keep the device defaults.
+type Format struct {
+	Width  int
+	Height int
+	FPS    int
+}
+
+// formatSetter is implemented by backends that can request a capture format
+type formatSetter interface {
+	setFormat(f Format)
 }
 
+// resolveSelector resolves a camera selector to a device ID; tests replace it
+var resolveSelector = videodev.System.Resolve
+
 // CameraBackend defines the interface for actual camera operations
 // This allows us to swap implementations for testing
 type CameraBackend interface {
@@ -30,6 +62,11 @@ type CameraBackend interface {
 	Close() error
 	Read() ([]byte, error)
 	IsOpened() bool
+	// SetControl requests a control value from the open device
+	SetControl(ctrl Control, value float64) error
+	// Control reads back the value the open device uses, or an error matching
+	// ErrUnsupportedControl if the device does not report it
+	Control(ctrl Control) (float64, error)
 }
 
 // DefaultBackend returns the appropriate camera backend based on environment
@@ -66,22 +103,27 @@ func (c *Camera) CaptureFrameMat() (gocv.Mat, error) {
 	if !c.isOpen {
 		return gocv.NewMat(), errors.New("camera is not open")
 	}
+	if err := c.ensureConnected(); err != nil {
+		return gocv.NewMat(), err
+	}
 
 	// OpenCVバックエンドへの直接アクセス
 	if backend, ok := c.backend.(*opencvBackend); ok {
 		mat := gocv.NewMat()
 		if !backend.camera.Read(&mat) || mat.Empty() {
 			mat.Close()
-			return gocv.NewMat(), errors.New("failed to read frame")
+			return gocv.NewMat(), c.readFailed(errors.New("failed to read frame"))
 		}
+		c.failures = 0
 		return mat, nil
 	}
 
 	// 他のバックエンド（モックなど）の場合は従来の方法
 	frameBytes, err := c.backend.Read()
 	if err != nil {
-		return gocv.NewMat(), err
+		return gocv.NewMat(), c.readFailed(err)
 	}
+	c.failures = 0
 
 	mat, err := gocv.IMDecode(frameBytes, gocv.IMReadColor)
 	if err != nil {
@@ -91,9 +133,32 @@ func (c *Camera) CaptureFrameMat() (gocv.Mat, error) {
 	return mat, nil
 }
 
-// SetDeviceID sets the camera device ID to use
+// SetDeviceID sets the camera device ID to use, replacing any selector
 func (c *Camera) SetDeviceID(id int) {
 	c.deviceID = id
+	c.selector = ""
+}
+
+// SetSelector selects the camera by a stable device path, name or serial number
+// (see videodev.Tree.Resolve). The selector is resolved to a device ID every time
+// the camera is opened, so it follows the camera when its index changes.
+func (c *Camera) SetSelector(selector string) {
+	c.selector = selector
+}
+
+// Selector returns the camera selector, or an empty string if the camera is selected by device ID
+func (c *Camera) Selector() string {
+	return c.selector
+}
+
+// SetFormat sets the capture format requested the next time the camera is opened
+func (c *Camera) SetFormat(f Format) {
+	c.format = f
+}
+
+// Format returns the requested capture format
+func (c *Camera) Format() Format {
+	return c.format
 }
 
 // Open initializes the camera
@@ -102,13 +167,52 @@ func (c *Camera) Open() error {
 		return errors.New("camera is already open")
 	}
 
-	// Initialize the camera using the backend
-	err := c.backend.Open(c.deviceID)
-	if err != nil {
+	if err := c.openBackend(); err != nil {
 		return err
 	}
 
 	c.isOpen = true
+	c.lost = false
+	c.failures = 0
+	c.attempt = 0
+	c.emit(Event{State: StateConnected, DeviceID: c.deviceID})
+	return nil
+}
+
+// openBackend resolves the selector and opens the device with the requested format
+func (c *Camera) openBackend() error {
+	// セレクターで指定された場合は開くたびにデバイスIDを解決する
+	if c.selector != "" {
+		id, err := resolveSelector(c.selector)
+		if err != nil {
+			return fmt.Errorf("camera %q: %w", c.selector, err)
+		}
+		c.deviceID = id
+	}
+
+	// 対応するバックエンドには要求するキャプチャ形式を渡す
+	if setter, ok := c.backend.(formatSetter); ok {
+		setter.setFormat(c.format)
+	}
+
+	// Initialize the camera using the backend
+	if err := c.backend.Open(c.deviceID); err != nil {
+		return err
+	}
+
+	// 要求する値を適用する前のデバイスの値をResetControls用に記録する。
+	// 再接続で開き直したデバイスは変更した値を保持していることがあるため、最初に開いたときだけ読む
+	if _, ok := c.initial[c.deviceID]; !ok {
+		if values, err := c.readControls(); err == nil {
+			if c.initial == nil {
+				c.initial = make(map[int]Controls)
+			}
+			c.initial[c.deviceID] = values
+		}
+	}
+
+	// デバイスが対応しない値は無視されるため、実際の値はControlsで読み返す
+	c.applyControls()
 	return nil
 }
 
@@ -118,6 +222,13 @@ func (c *Camera) Close() error {
 		return errors.New("camera is not open")
 	}
 
+	// 切断を検出した時点でデバイスは閉じている
+	if c.lost {
+		c.isOpen = false
+		c.lost = false
+		return nil
+	}
+
 	err := c.backend.Close()
 	if err != nil {
 		return err
@@ -132,8 +243,16 @@ func (c *Camera) CaptureFrame() ([]byte, error) {
 	if !c.isOpen {
 		return nil, errors.New("camera is not open")
 	}
+	if err := c.ensureConnected(); err != nil {
+		return nil, err
+	}
 
-	return c.backend.Read()
+	frame, err := c.backend.Read()
+	if err != nil {
+		return nil, c.readFailed(err)
+	}
+	c.failures = 0
+	return frame, nil
 }
 
 func (c *Camera) IsOpen() bool {
@@ -1,7 +1,18 @@
 package camera
 
 import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/eotel/me19/internal/framegen"
+	"github.com/eotel/me19/internal/qrcode"
 )
 
 func TestNew(t *testing.T) {
@@ -118,3 +129,369 @@ func TestSetDeviceID(t *testing.T) {
 	}
 	// エラーが発生すれば期待通り、deviceIDが設定されたと判断できる
 }
+
+func TestSetFormat(t *testing.T) {
+	cam := NewWithTestBackend()
+	cam.SetFormat(Format{Width: 320, Height: 240, FPS: 15})
+	if got := cam.Format(); got.Width != 320 || got.Height != 240 || got.FPS != 15 {
+		t.Errorf("Format() = %+v", got)
+	}
+
+	if err := cam.Open(); err != nil {
+		t.Fatalf("Failed to open camera: %v", err)
+	}
+	defer cam.Close()
+
+	// モックバックエンドは要求された解像度のフレームを返す
+	frame, err := cam.CaptureFrame()
+	if err != nil {
+		t.Fatalf("Failed to capture frame: %v", err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatalf("Failed to decode frame: %v", err)
+	}
+	if cfg.Width != 320 || cfg.Height != 240 {
+		t.Errorf("frame size = %dx%d, want 320x240", cfg.Width, cfg.Height)
+	}
+}
+
+func TestSetSelector(t *testing.T) {
+	// 開くたびにセレクターを解決する（再接続でインデックスが変わる場合を再現）
+	indices := []int{2, 4}
+	calls := 0
+	original := resolveSelector
+	resolveSelector = func(selector string) (int, error) {
+		if selector != "serial:ABC123" {
+			return 0, errors.New("no matching camera")
+		}
+		index := indices[calls]
+		calls++
+		return index, nil
+	}
+	defer func() { resolveSelector = original }()
+
+	cam := NewWithTestBackend()
+	cam.SetSelector("serial:ABC123")
+	for _, want := range indices {
+		if err := cam.Open(); err != nil {
+			t.Fatalf("Failed to open camera: %v", err)
+		}
+		if got := cam.GetDeviceID(); got != want {
+			t.Errorf("GetDeviceID() = %d, want %d", got, want)
+		}
+		cam.Close()
+	}
+
+	// 一致するカメラがなければ開けない
+	cam.SetSelector("serial:NOPE")
+	if err := cam.Open(); err == nil {
+		cam.Close()
+		t.Error("Expected error when no camera matches the selector")
+	}
+
+	// デバイスIDを指定するとセレクターは解除される
+	cam.SetDeviceID(1)
+	if cam.Selector() != "" {
+		t.Errorf("Selector() = %q after SetDeviceID, want empty", cam.Selector())
+	}
+	if err := cam.Open(); err != nil {
+		t.Fatalf("Failed to open camera: %v", err)
+	}
+	cam.Close()
+}
+
+func TestReconnect(t *testing.T) {
+	backend := newMockBackend().(*mockBackend)
+	cam := NewWithBackend(backend)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	cam.now = func() time.Time { return now }
+	var events []Event
+	cam.SetReconnect(ReconnectPolicy{FailureThreshold: 3, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 400 * time.Millisecond},
+		func(e Event) { events = append(events, e) })
+
+	if err := cam.Open(); err != nil {
+		t.Fatalf("Failed to open camera: %v", err)
+	}
+	if len(events) != 1 || events[0].State != StateConnected {
+		t.Fatalf("events after Open = %+v, want connected", events)
+	}
+
+	// カメラを抜く：しきい値に達するまでは通常の読み取りエラー
+	backend.readErr = errors.New("unplugged")
+	backend.openErr = errors.New("no such device")
+	for i := 0; i < 2; i++ {
+		if _, err := cam.CaptureFrame(); err == nil || errors.Is(err, ErrDisconnected) {
+			t.Fatalf("read %d error = %v, want a plain read error", i+1, err)
+		}
+	}
+	if _, err := cam.CaptureFrame(); !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("read 3 error = %v, want ErrDisconnected", err)
+	}
+	if cam.State() != StateLost || events[1].State != StateLost || backend.isOpen {
+		t.Fatalf("after 3 failures: state = %v, events = %+v, backend open = %v", cam.State(), events, backend.isOpen)
+	}
+
+	// 再接続の間隔は指数的に伸び、上限で頭打ちになる
+	opens := backend.openCalls
+	for i, wantDelay := range []time.Duration{100, 200, 400, 400} {
+		if _, err := cam.CaptureFrame(); !errors.Is(err, ErrDisconnected) {
+			t.Fatalf("attempt %d error = %v, want ErrDisconnected", i+1, err)
+		}
+		last := events[len(events)-1]
+		if last.State != StateReconnecting || last.Attempt != i+1 || last.Delay != wantDelay*time.Millisecond {
+			t.Errorf("attempt %d event = %+v, want reconnecting after %v", i+1, last, wantDelay*time.Millisecond)
+		}
+
+		// 次の試行までは開き直さない
+		before := len(events)
+		cam.CaptureFrame()
+		if backend.openCalls != opens+i+1 || len(events) != before {
+			t.Errorf("attempt %d: reopened before the backoff elapsed", i+1)
+		}
+		now = now.Add(last.Delay)
+	}
+	if cam.State() != StateReconnecting {
+		t.Errorf("State() = %v, want reconnecting", cam.State())
+	}
+
+	// カメラを挿し直すと次の試行で接続される
+	backend.readErr = nil
+	backend.openErr = nil
+	if _, err := cam.CaptureFrame(); err != nil {
+		t.Fatalf("read after reconnecting error = %v", err)
+	}
+	if last := events[len(events)-1]; last.State != StateConnected || cam.State() != StateConnected {
+		t.Errorf("after reconnecting: state = %v, last event = %+v", cam.State(), last)
+	}
+
+	// 再接続後は失敗の回数と間隔がリセットされる
+	backend.readErr = errors.New("unplugged")
+	backend.openErr = errors.New("no such device")
+	for i := 0; i < 3; i++ {
+		cam.CaptureFrame()
+	}
+	cam.CaptureFrame()
+	if last := events[len(events)-1]; last.State != StateReconnecting || last.Attempt != 1 || last.Delay != 100*time.Millisecond {
+		t.Errorf("second disconnection event = %+v, want the first attempt", last)
+	}
+
+	// 切断中でも閉じられる
+	if err := cam.Close(); err != nil {
+		t.Errorf("Close() while disconnected error = %v", err)
+	}
+}
+
+func TestReconnect_Disabled(t *testing.T) {
+	backend := newMockBackend().(*mockBackend)
+	cam := NewWithBackend(backend)
+	if err := cam.Open(); err != nil {
+		t.Fatalf("Failed to open camera: %v", err)
+	}
+	defer cam.Close()
+
+	// 再接続を設定しなければ読み取りエラーをそのまま返す
+	backend.readErr = errors.New("unplugged")
+	for i := 0; i < 20; i++ {
+		if _, err := cam.CaptureFrame(); err == nil || errors.Is(err, ErrDisconnected) {
+			t.Fatalf("read %d error = %v, want the read error", i+1, err)
+		}
+	}
+	if backend.openCalls != 1 || cam.State() != StateConnected {
+		t.Errorf("openCalls = %d, State() = %v", backend.openCalls, cam.State())
+	}
+}
+
+func TestControls(t *testing.T) {
+	backend := newMockBackend().(*mockBackend)
+	backend.unsupported = map[Control]bool{Zoom: true}
+	backend.round = 5
+	cam := NewWithBackend(backend)
+
+	// 開く前に設定した値は開いたときに適用される
+	if err := cam.SetControls(Controls{AutoFocus: 0, Focus: 42}); err != nil {
+		t.Fatalf("SetControls() before Open error = %v", err)
+	}
+	if _, err := cam.Control(Focus); err == nil {
+		t.Error("Control() before Open should fail")
+	}
+	if err := cam.Open(); err != nil {
+		t.Fatalf("Failed to open camera: %v", err)
+	}
+	defer cam.Close()
+
+	// デバイスが丸めた実際の値を読み返す
+	if got, err := cam.Control(Focus); err != nil || got != 40 {
+		t.Errorf("Control(Focus) = %v, %v, want 40", got, err)
+	}
+	if got, err := cam.SetControl(Exposure, 123); err != nil || got != 125 {
+		t.Errorf("SetControl(Exposure, 123) = %v, %v, want 125", got, err)
+	}
+
+	// 対応しないコントロールはエラーになり、一覧には含まれない
+	if _, err := cam.SetControl(Zoom, 2); !errors.Is(err, ErrUnsupportedControl) {
+		t.Errorf("SetControl(Zoom) error = %v, want ErrUnsupportedControl", err)
+	}
+	values, err := cam.Controls()
+	if err != nil {
+		t.Fatalf("Controls() error = %v", err)
+	}
+	if _, ok := values[Zoom]; ok || values[Focus] != 40 || values[Exposure] != 125 {
+		t.Errorf("Controls() = %v", values)
+	}
+
+	// 開き直しても要求した値が適用される
+	cam.Close()
+	if err := cam.Open(); err != nil {
+		t.Fatalf("Failed to reopen camera: %v", err)
+	}
+	want := Controls{AutoFocus: 0, Focus: 42, Exposure: 123}
+	if got := cam.RequestedControls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RequestedControls() = %v, want %v", got, want)
+	}
+	if got, _ := cam.Control(Exposure); got != 125 {
+		t.Errorf("Control(Exposure) after reopening = %v, want 125", got)
+	}
+}
+
+func TestResetControls(t *testing.T) {
+	backend := newMockBackend().(*mockBackend)
+	backend.defaults = Controls{AutoExposure: 1, Gain: 8}
+	backend.unsupported = map[Control]bool{Zoom: true}
+	cam := NewWithBackend(backend)
+
+	configured := Controls{Focus: 42}
+	cam.SetControls(configured)
+	if err := cam.Open(); err != nil {
+		t.Fatalf("Failed to open camera: %v", err)
+	}
+	defer cam.Close()
+
+	cam.SetControl(AutoExposure, 0)
+	cam.SetControl(Gain, 20)
+	cam.SetControl(Focus, 10)
+
+	// 設定した値と、設定していないコントロールの開いたときの値に戻る
+	if err := cam.ResetControls(configured); err != nil {
+		t.Fatalf("ResetControls() error = %v", err)
+	}
+	values, err := cam.Controls()
+	if err != nil {
+		t.Fatalf("Controls() error = %v", err)
+	}
+	if values[AutoExposure] != 1 || values[Gain] != 8 || values[Focus] != 42 {
+		t.Errorf("Controls() after ResetControls() = %v", values)
+	}
+	if got := cam.RequestedControls(); !reflect.DeepEqual(got, configured) {
+		t.Errorf("RequestedControls() = %v, want %v", got, configured)
+	}
+}
+
+func TestControl_String(t *testing.T) {
+	for _, ctrl := range AllControls {
+		if strings.HasPrefix(ctrl.String(), "Control(") {
+			t.Errorf("control %d has no name", int(ctrl))
+		}
+	}
+}
+
+func TestScriptedCamera(t *testing.T) {
+	clock := NewManualClock(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	glitch := errors.New("glitch")
+	cam := NewWithScript(Script{
+		Steps: []Step{
+			{}, // 空のフレーム
+			{Scene: framegen.Scene{Codes: []framegen.Code{{Payload: "A", Rotation: 15}}, Noise: 20}, Duration: 200 * time.Millisecond},
+			{Err: glitch},
+			{Unplugged: true, Duration: 500 * time.Millisecond},
+			{Scene: framegen.Scene{Codes: []framegen.Code{{Payload: "B", X: 200, Y: 150}}}, Duration: 500 * time.Millisecond},
+		},
+		Width:  400,
+		Height: 300,
+	}, clock)
+	var events []State
+	cam.SetReconnect(ReconnectPolicy{FailureThreshold: 2, InitialBackoff: 200 * time.Millisecond, MaxBackoff: time.Second},
+		func(e Event) { events = append(events, e.State) })
+
+	detector := qrcode.New()
+	if err := detector.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer detector.Close()
+
+	if err := cam.Open(); err != nil {
+		t.Fatalf("Failed to open camera: %v", err)
+	}
+	defer cam.Close()
+
+	// 100msごとに1フレームを読み取り、読み取れたコードまたはエラーを記録する
+	var got []string
+	for range 15 {
+		frame, err := cam.CaptureFrame()
+		switch {
+		case errors.Is(err, ErrDisconnected):
+			got = append(got, "disconnected")
+		case errors.Is(err, ErrScriptEnded):
+			got = append(got, "ended")
+		case err != nil:
+			got = append(got, "error: "+err.Error())
+		default:
+			codes, err := detector.Detect(frame)
+			if err != nil {
+				t.Fatalf("Detect() error = %v", err)
+			}
+			got = append(got, strings.Join(codes, ","))
+		}
+		clock.Advance(100 * time.Millisecond)
+	}
+
+	// 400msに2回続けて失敗したため切断とみなし、500msと700msの再接続に失敗し、1100msに再接続する
+	want := []string{
+		"", "A", "A", "error: glitch",
+		"disconnected", "disconnected", "disconnected", "disconnected",
+		"disconnected", "disconnected", "disconnected",
+		"B", "B", "B", "ended",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("frames =\n%q\nwant\n%q", got, want)
+	}
+
+	wantEvents := []State{StateConnected, StateLost, StateReconnecting, StateReconnecting, StateConnected}
+	if !reflect.DeepEqual(events, wantEvents) {
+		t.Errorf("events = %v, want %v", events, wantEvents)
+	}
+}
+
+func TestScriptedBackend_Loop(t *testing.T) {
+	clock := NewManualClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	backend := NewScriptedBackend(Script{Steps: []Step{{}, {Duration: time.Second}}, Loop: true}, clock)
+
+	for _, tt := range []struct {
+		advance time.Duration
+		want    int
+	}{
+		{0, 0},
+		{100 * time.Millisecond, 1},
+		{time.Second, 0}, // 1.1秒で最初に戻る
+		{200 * time.Millisecond, 1},
+	} {
+		clock.Advance(tt.advance)
+		if got := backend.Step(); got != tt.want {
+			t.Errorf("Step() at %v = %d, want %d", clock.Now().Sub(backend.start), got, tt.want)
+		}
+	}
+
+	// 要求された解像度でフレームを描画する
+	backend.setFormat(Format{Width: 320, Height: 240})
+	if err := backend.Open(0); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := backend.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(frame))
+	if err != nil || img.Bounds().Dx() != 320 || img.Bounds().Dy() != 240 {
+		t.Errorf("frame = %v, %v, want 320x240", img.Bounds(), err)
+	}
+}
@@ -0,0 +1,175 @@
+package camera
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Control is a camera setting applied through a capture property
+type Control int
+
+const (
+	// AutoExposure turns automatic exposure on (1) or off (0)
+	AutoExposure Control = iota
+	// Exposure is the manual exposure, in device-specific units
+	Exposure
+	// AutoFocus turns autofocus on (1) or off (0)
+	AutoFocus
+	// Focus is the manual focus position
+	Focus
+	// Gain is the sensor gain
+	Gain
+	// AutoWhiteBalance turns automatic white balance on (1) or off (0)
+	AutoWhiteBalance
+	// WhiteBalance is the white balance color temperature in kelvin
+	WhiteBalance
+	// Zoom is the zoom level
+	Zoom
+	// BufferSize is the number of frames the driver buffers
+	BufferSize
+)
+
+// AllControls lists the controls in the order they are applied: automatic modes
+// are switched before the manual values that depend on them.
+var AllControls = []Control{AutoExposure, Exposure, AutoFocus, Focus, Gain, AutoWhiteBalance, WhiteBalance, Zoom, BufferSize}
+
+var controlNames = map[Control]string{
+	AutoExposure:     "auto_exposure",
+	Exposure:         "exposure",
+	AutoFocus:        "autofocus",
+	Focus:            "focus",
+	Gain:             "gain",
+	AutoWhiteBalance: "auto_white_balance",
+	WhiteBalance:     "white_balance",
+	Zoom:             "zoom",
+	BufferSize:       "buffer_size",
+}
+
+// String returns the name of the control as used in the configuration
+func (c Control) String() string {
+	if name, ok := controlNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Control(%d)", int(c))
+}
+
+// Controls are requested control values. Controls that are not set keep the device defaults.
+type Controls map[Control]float64
+
+// ErrUnsupportedControl is matched by the error returned for a control the backend cannot read or change
+var ErrUnsupportedControl = errors.New("unsupported camera control")
+
+// SetControls sets the controls requested every time the camera is opened,
+// including after a reconnection. If the camera is open they are applied now.
+func (c *Camera) SetControls(controls Controls) error {
+	c.controls = make(Controls, len(controls))
+	for ctrl, value := range controls {
+		c.controls[ctrl] = value
+	}
+	if !c.isOpen || c.lost {
+		return nil
+	}
+	return c.applyControls()
+}
+
+// SetControl changes one control of the open camera and returns the value the
+// device reports afterwards, which may differ if the device rounds or ignores it.
+// The value is also requested when the camera is opened again.
+func (c *Camera) SetControl(ctrl Control, value float64) (float64, error) {
+	if !c.isOpen || c.lost {
+		return 0, errors.New("camera is not open")
+	}
+	if err := c.backend.SetControl(ctrl, value); err != nil {
+		return 0, fmt.Errorf("%s: %w", ctrl, err)
+	}
+	if c.controls == nil {
+		c.controls = make(Controls)
+	}
+	c.controls[ctrl] = value
+	return c.backend.Control(ctrl)
+}
+
+// Control reads back the value of a control from the open camera
+func (c *Camera) Control(ctrl Control) (float64, error) {
+	if !c.isOpen || c.lost {
+		return 0, errors.New("camera is not open")
+	}
+	return c.backend.Control(ctrl)
+}
+
+// ResetControls replaces the requested controls like SetControls. Controls of
+// the open camera that are not in controls go back to the values the device
+// reported when it was first opened, so that changes made with SetControl are undone.
+func (c *Camera) ResetControls(controls Controls) error {
+	c.controls = make(Controls, len(controls))
+	for ctrl, value := range controls {
+		c.controls[ctrl] = value
+	}
+	if !c.isOpen || c.lost {
+		return nil
+	}
+
+	var errs []error
+	initial := c.initial[c.deviceID]
+	for _, ctrl := range AllControls {
+		value, ok := c.controls[ctrl]
+		if !ok {
+			value, ok = initial[ctrl]
+		}
+		if !ok {
+			continue
+		}
+		if err := c.backend.SetControl(ctrl, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ctrl, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Controls reads back every control the open camera reports
+func (c *Camera) Controls() (Controls, error) {
+	if !c.isOpen || c.lost {
+		return nil, errors.New("camera is not open")
+	}
+	return c.readControls()
+}
+
+// readControls reads every control the backend supports
+func (c *Camera) readControls() (Controls, error) {
+	values := make(Controls)
+	for _, ctrl := range AllControls {
+		value, err := c.backend.Control(ctrl)
+		if errors.Is(err, ErrUnsupportedControl) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ctrl, err)
+		}
+		values[ctrl] = value
+	}
+	return values, nil
+}
+
+// RequestedControls returns the controls requested with SetControls and SetControl
+func (c *Camera) RequestedControls() Controls {
+	requested := make(Controls, len(c.controls))
+	for ctrl, value := range c.controls {
+		requested[ctrl] = value
+	}
+	return requested
+}
+
+// applyControls requests the controls from the open backend in the order of AllControls
+func (c *Camera) applyControls() error {
+	var errs []error
+	for _, ctrl := range AllControls {
+		value, ok := c.controls[ctrl]
+		if !ok {
+			continue
+		}
+		if err := c.backend.SetControl(ctrl, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ctrl, err))
+		}
+	}
+	return errors.Join(errs...)
+}
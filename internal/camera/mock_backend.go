@@ -6,11 +6,23 @@ import (
 	"image"
 	"image/color"
 	"image/jpeg"
+	"math"
 )
 
 // mockBackend implements the CameraBackend interface for testing
 type mockBackend struct {
 	isOpen bool
+	format Format
+
+	// 障害を再現するためにテストから設定する
+	openErr   error // Returned by Open while set, as if the device were missing
+	readErr   error // Returned by Read while set, as if the camera were unplugged
+	openCalls int   // Number of calls to Open
+
+	controls    Controls         // Values of the controls, reset to the defaults when the device is opened
+	defaults    Controls         // Values the simulated device starts with (0 if not set)
+	unsupported map[Control]bool // Controls the simulated device does not have
+	round       float64          // If set, control values are rounded to multiples of it like a device with steps
 }
 
 // newMockBackend creates a new mock camera backend for testing
@@ -20,11 +32,19 @@ func newMockBackend() CameraBackend {
 
 // Open simulates opening a camera device
 func (m *mockBackend) Open(deviceID int) error {
+	m.openCalls++
+	if m.openErr != nil {
+		return m.openErr
+	}
 	// If device ID is 99, simulate a non-existent camera
 	if deviceID == 99 {
 		return errors.New("device not found")
 	}
 	m.isOpen = true
+	m.controls = make(Controls, len(m.defaults))
+	for ctrl, value := range m.defaults {
+		m.controls[ctrl] = value
+	}
 	return nil
 }
 
@@ -42,9 +62,47 @@ func (m *mockBackend) Read() ([]byte, error) {
 	if !m.isOpen {
 		return nil, errors.New("camera not open")
 	}
+	if m.readErr != nil {
+		return nil, m.readErr
+	}
 
 	// Generate a test image (a simple gray rectangle)
-	return createTestImage(640, 480)
+	width, height := 640, 480
+	if m.format.Width > 0 && m.format.Height > 0 {
+		width, height = m.format.Width, m.format.Height
+	}
+	return createTestImage(width, height)
+}
+
+// SetControl implements CameraBackend
+func (m *mockBackend) SetControl(ctrl Control, value float64) error {
+	if !m.isOpen {
+		return errors.New("camera not open")
+	}
+	if m.unsupported[ctrl] {
+		return ErrUnsupportedControl
+	}
+	if m.round > 0 {
+		value = math.Round(value/m.round) * m.round
+	}
+	m.controls[ctrl] = value
+	return nil
+}
+
+// Control implements CameraBackend
+func (m *mockBackend) Control(ctrl Control) (float64, error) {
+	if !m.isOpen {
+		return 0, errors.New("camera not open")
+	}
+	if m.unsupported[ctrl] {
+		return 0, ErrUnsupportedControl
+	}
+	return m.controls[ctrl], nil
+}
+
+// setFormat implements formatSetter
+func (m *mockBackend) setFormat(f Format) {
+	m.format = f
 }
 
 // IsOpened returns whether the mock camera is open
@@ -3,7 +3,9 @@ package camera
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"image/jpeg"
+	"runtime"
 
 	"gocv.io/x/gocv"
 )
@@ -12,6 +14,7 @@ import (
 type opencvBackend struct {
 	camera *gocv.VideoCapture
 	isOpen bool
+	format Format
 }
 
 // newOpenCVBackend creates a new OpenCV-based camera backend
@@ -30,11 +33,94 @@ func (o *opencvBackend) Open(deviceID int) error {
 		return err
 	}
 
+	// 要求された解像度とフレームレートを設定（デバイスが対応しない値は無視される）
+	if o.format.Width > 0 {
+		camera.Set(gocv.VideoCaptureFrameWidth, float64(o.format.Width))
+	}
+	if o.format.Height > 0 {
+		camera.Set(gocv.VideoCaptureFrameHeight, float64(o.format.Height))
+	}
+	if o.format.FPS > 0 {
+		camera.Set(gocv.VideoCaptureFPS, float64(o.format.FPS))
+	}
+
 	o.camera = camera
 	o.isOpen = true
 	return nil
 }
 
+// setFormat implements formatSetter
+func (o *opencvBackend) setFormat(f Format) {
+	o.format = f
+}
+
+// controlProperties maps the controls to OpenCV capture properties
+var controlProperties = map[Control]gocv.VideoCaptureProperties{
+	AutoExposure:     gocv.VideoCaptureAutoExposure,
+	Exposure:         gocv.VideoCaptureExposure,
+	AutoFocus:        gocv.VideoCaptureAutoFocus,
+	Focus:            gocv.VideoCaptureFocus,
+	Gain:             gocv.VideoCaptureGain,
+	AutoWhiteBalance: gocv.VideoCaptureAutoWB,
+	WhiteBalance:     gocv.VideoCaptureWBTemperature,
+	Zoom:             gocv.VideoCaptureZoom,
+	BufferSize:       gocv.VideoCaptureBufferSize,
+}
+
+// 自動露出の値はバックエンドによって異なる。V4L2はV4L2_EXPOSURE_MANUAL(1)と
+// V4L2_EXPOSURE_APERTURE_PRIORITY(3)、DirectShowなどは0.25（手動）と0.75（自動）
+func autoExposureValues() (manual, auto float64) {
+	if runtime.GOOS == "linux" {
+		return 1, 3
+	}
+	return 0.25, 0.75
+}
+
+// SetControl implements CameraBackend
+func (o *opencvBackend) SetControl(ctrl Control, value float64) error {
+	if !o.isOpen || o.camera == nil {
+		return errors.New("camera not open")
+	}
+	prop, ok := controlProperties[ctrl]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedControl, ctrl)
+	}
+	if ctrl == AutoExposure {
+		manual, auto := autoExposureValues()
+		if value != 0 {
+			value = auto
+		} else {
+			value = manual
+		}
+	}
+	o.camera.Set(prop, value)
+	return nil
+}
+
+// Control implements CameraBackend
+func (o *opencvBackend) Control(ctrl Control) (float64, error) {
+	if !o.isOpen || o.camera == nil {
+		return 0, errors.New("camera not open")
+	}
+	prop, ok := controlProperties[ctrl]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedControl, ctrl)
+	}
+	value := o.camera.Get(prop)
+	// V4L2バックエンドはデバイスが対応しないプロパティに-1を返す
+	if runtime.GOOS == "linux" && value == -1 {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedControl, ctrl)
+	}
+	if ctrl == AutoExposure {
+		manual, _ := autoExposureValues()
+		if value == manual {
+			return 0, nil
+		}
+		return 1, nil
+	}
+	return value, nil
+}
+
 // Close releases OpenCV camera resources
 func (o *opencvBackend) Close() error {
 	if !o.isOpen || o.camera == nil {
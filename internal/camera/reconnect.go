@@ -0,0 +1,140 @@
+package camera
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDisconnected is matched by capture errors while the camera is lost and being reconnected
+var ErrDisconnected = errors.New("camera disconnected")
+
+// State is the connection state of a camera
+type State int
+
+const (
+	// StateConnected means the camera was opened and delivers frames
+	StateConnected State = iota
+	// StateLost means reads kept failing and the device was closed
+	StateLost
+	// StateReconnecting means an attempt to reopen the device failed and another one is scheduled
+	StateReconnecting
+)
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateLost:
+		return "lost"
+	case StateReconnecting:
+		return "reconnecting"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Event reports a change of the camera connection state
+type Event struct {
+	State    State
+	DeviceID int
+	Attempt  int           // Failed reconnection attempts so far (StateReconnecting)
+	Delay    time.Duration // Time until the next attempt (StateReconnecting)
+	Err      error         // Read error (StateLost) or error of the last attempt (StateReconnecting)
+}
+
+// ReconnectPolicy controls reopening a camera whose reads keep failing, for
+// example after it was unplugged. Attempts are spaced with exponential backoff
+// from InitialBackoff, doubling up to MaxBackoff.
+type ReconnectPolicy struct {
+	FailureThreshold int // Consecutive read failures before the camera is considered lost (0 disables reconnection)
+	InitialBackoff   time.Duration
+	MaxBackoff       time.Duration
+}
+
+// SetReconnect enables reconnection with policy. report, if not nil, receives
+// every state change; it is called from the goroutine that opens or reads the camera.
+func (c *Camera) SetReconnect(policy ReconnectPolicy, report func(Event)) {
+	c.reconnect = policy
+	c.report = report
+}
+
+// State returns the current connection state
+func (c *Camera) State() State {
+	switch {
+	case !c.lost:
+		return StateConnected
+	case c.attempt == 0:
+		return StateLost
+	}
+	return StateReconnecting
+}
+
+// ensureConnected reopens a lost camera once its next attempt is due. It returns
+// an error matching ErrDisconnected while the camera is not connected.
+func (c *Camera) ensureConnected() error {
+	if !c.lost {
+		return nil
+	}
+	now := c.clock()
+	if now.Before(c.retryAt) {
+		return fmt.Errorf("%w (next attempt in %v)", ErrDisconnected, c.retryAt.Sub(now).Round(time.Millisecond))
+	}
+
+	// 抜き差しでデバイス番号が変わる場合に備えて、セレクターも解決し直す
+	if err := c.openBackend(); err != nil {
+		c.attempt++
+		delay := c.backoff(c.attempt)
+		c.retryAt = now.Add(delay)
+		c.emit(Event{State: StateReconnecting, DeviceID: c.deviceID, Attempt: c.attempt, Delay: delay, Err: err})
+		return fmt.Errorf("%w: %v", ErrDisconnected, err)
+	}
+
+	c.lost = false
+	c.failures = 0
+	c.attempt = 0
+	c.emit(Event{State: StateConnected, DeviceID: c.deviceID})
+	return nil
+}
+
+// readFailed counts a failed read. After FailureThreshold consecutive failures
+// the device is closed and the first reconnection attempt is made on the next read.
+func (c *Camera) readFailed(err error) error {
+	c.failures++
+	if c.reconnect.FailureThreshold <= 0 || c.failures < c.reconnect.FailureThreshold {
+		return err
+	}
+
+	// 切断されたデバイスはエラーになることがあるため、閉じる際のエラーは無視する
+	c.backend.Close()
+	c.lost = true
+	c.attempt = 0
+	c.retryAt = c.clock()
+	c.emit(Event{State: StateLost, DeviceID: c.deviceID, Err: err})
+	return fmt.Errorf("%w: %v", ErrDisconnected, err)
+}
+
+// backoff returns the delay after the given number of failed attempts
+func (c *Camera) backoff(attempt int) time.Duration {
+	delay := c.reconnect.InitialBackoff
+	for i := 1; i < attempt && delay < c.reconnect.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if c.reconnect.MaxBackoff > 0 && delay > c.reconnect.MaxBackoff {
+		delay = c.reconnect.MaxBackoff
+	}
+	return delay
+}
+
+func (c *Camera) emit(e Event) {
+	if c.report != nil {
+		c.report(e)
+	}
+}
+
+func (c *Camera) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,230 @@
+package camera
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eotel/me19/internal/framegen"
+)
+
+// ErrScriptEnded is returned by reads of a scripted camera after its last step
+var ErrScriptEnded = errors.New("camera script ended")
+
+// ErrUnplugged is returned by a scripted camera while a step unplugs it
+var ErrUnplugged = errors.New("camera unplugged")
+
+// ManualClock is a clock that only moves when a test advances it. A scripted
+// camera and the reconnection backoff of its Camera read the same clock.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock returns a clock stopped at start
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now returns the current time of the clock
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Step is one entry of a camera script. Every read during the step returns its
+// frame, its error, or fails as if the camera were unplugged.
+type Step struct {
+	Scene     framegen.Scene // Frame shown during the step; a scene without codes is a blank frame
+	Err       error          // Reads fail with Err instead of returning a frame
+	Unplugged bool           // Reads fail and the device cannot be opened until the step ends
+	Duration  time.Duration  // How long the step lasts on the clock (Script.Interval if zero)
+}
+
+// Script is a sequence of steps played by a scripted camera, starting at the
+// time the backend is created
+type Script struct {
+	Steps    []Step
+	Interval time.Duration // Duration of steps without one (100ms if zero)
+	Width    int           // Frame size when no capture format is requested (640x480 if zero)
+	Height   int
+	Loop     bool // Start again after the last step instead of failing with ErrScriptEnded
+}
+
+// ScriptedBackend is a CameraBackend that plays a Script at the time of a
+// ManualClock, so tests get the same frames, errors and disconnections on every run
+type ScriptedBackend struct {
+	script   Script
+	clock    *ManualClock
+	start    time.Time
+	isOpen   bool
+	format   Format
+	controls Controls
+	frames   map[int][]byte // 描画したフレーム（ステップごと）
+
+	// Opens and Reads count the calls to Open and Read
+	Opens int
+	Reads int
+}
+
+// NewScriptedBackend returns a backend that plays script from the current time of clock
+func NewScriptedBackend(script Script, clock *ManualClock) *ScriptedBackend {
+	if script.Interval <= 0 {
+		script.Interval = 100 * time.Millisecond
+	}
+	return &ScriptedBackend{script: script, clock: clock, start: clock.Now()}
+}
+
+// NewWithScript creates a Camera that plays script. The camera uses clock for
+// reconnection backoff, so a test controls both with Advance.
+func NewWithScript(script Script, clock *ManualClock) *Camera {
+	c := NewWithBackend(NewScriptedBackend(script, clock))
+	c.now = clock.Now
+	return c
+}
+
+// Step returns the index of the step playing at the current time of the clock,
+// or -1 after the last step of a script that does not loop
+func (b *ScriptedBackend) Step() int {
+	var total time.Duration
+	for _, step := range b.script.Steps {
+		total += b.duration(step)
+	}
+	if total <= 0 {
+		return -1
+	}
+
+	elapsed := b.clock.Now().Sub(b.start)
+	if elapsed >= total {
+		if !b.script.Loop {
+			return -1
+		}
+		elapsed %= total
+	}
+	for i, step := range b.script.Steps {
+		if elapsed < b.duration(step) {
+			return i
+		}
+		elapsed -= b.duration(step)
+	}
+	return -1
+}
+
+func (b *ScriptedBackend) duration(step Step) time.Duration {
+	if step.Duration > 0 {
+		return step.Duration
+	}
+	return b.script.Interval
+}
+
+// unplugged reports whether the current step unplugs the camera
+func (b *ScriptedBackend) unplugged() bool {
+	i := b.Step()
+	return i >= 0 && b.script.Steps[i].Unplugged
+}
+
+// Open implements CameraBackend
+func (b *ScriptedBackend) Open(deviceID int) error {
+	b.Opens++
+	if b.isOpen {
+		return errors.New("camera is already open")
+	}
+	if b.unplugged() {
+		return fmt.Errorf("device %d: %w", deviceID, ErrUnplugged)
+	}
+	b.isOpen = true
+	b.controls = nil
+	return nil
+}
+
+// Close implements CameraBackend
+func (b *ScriptedBackend) Close() error {
+	if !b.isOpen {
+		return errors.New("camera not open")
+	}
+	b.isOpen = false
+	return nil
+}
+
+// Read implements CameraBackend. Frames are PNG encoded.
+func (b *ScriptedBackend) Read() ([]byte, error) {
+	b.Reads++
+	if !b.isOpen {
+		return nil, errors.New("camera not open")
+	}
+
+	i := b.Step()
+	if i < 0 {
+		return nil, ErrScriptEnded
+	}
+	step := b.script.Steps[i]
+	switch {
+	case step.Unplugged:
+		return nil, ErrUnplugged
+	case step.Err != nil:
+		return nil, step.Err
+	}
+
+	// 同じステップのフレームは一度だけ描画する
+	if frame, ok := b.frames[i]; ok {
+		return frame, nil
+	}
+	width, height := b.script.Width, b.script.Height
+	if b.format.Width > 0 && b.format.Height > 0 {
+		width, height = b.format.Width, b.format.Height
+	}
+	if width <= 0 || height <= 0 {
+		width, height = 640, 480
+	}
+	frame, err := framegen.Encode(step.Scene, width, height)
+	if err != nil {
+		return nil, fmt.Errorf("step %d: %w", i, err)
+	}
+	if b.frames == nil {
+		b.frames = make(map[int][]byte)
+	}
+	b.frames[i] = frame
+	return frame, nil
+}
+
+// IsOpened implements CameraBackend
+func (b *ScriptedBackend) IsOpened() bool {
+	return b.isOpen
+}
+
+// SetControl implements CameraBackend; the values are kept but do not change the frames
+func (b *ScriptedBackend) SetControl(ctrl Control, value float64) error {
+	if !b.isOpen {
+		return errors.New("camera not open")
+	}
+	if b.controls == nil {
+		b.controls = make(Controls)
+	}
+	b.controls[ctrl] = value
+	return nil
+}
+
+// Control implements CameraBackend
+func (b *ScriptedBackend) Control(ctrl Control) (float64, error) {
+	if !b.isOpen {
+		return 0, errors.New("camera not open")
+	}
+	return b.controls[ctrl], nil
+}
+
+// setFormat implements formatSetter
+func (b *ScriptedBackend) setFormat(f Format) {
+	if f != b.format {
+		b.frames = nil
+	}
+	b.format = f
+}
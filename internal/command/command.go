@@ -0,0 +1,190 @@
+// Package command runs the external commands of exec outputs and exec actions,
+// so both behave the same: the command runs directly without a shell, gets a
+// minimal environment with the ME19_* variables of the detection added, and is
+// stopped at its timeout. Standard output is discarded and the start of
+// standard error is kept for the report.
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+// passedVariables are the variables of this process every command gets
+var passedVariables = []string{"PATH", "HOME"}
+
+// MaxStderr is the number of bytes of standard error kept in a Result
+const MaxStderr = 4096
+
+// Command describes one run of an external command
+type Command struct {
+	Path    string
+	Args    []string      // Passed as is, each as a single argument
+	Env     []string      // Added to the environment
+	Stdin   []byte        // Written to standard input (empty if nil)
+	Timeout time.Duration // Maximum run time (0 means only the context limits it)
+	// InheritEnv passes the whole environment of this process instead of only
+	// PATH and HOME. Its ME19_* variables are left out even then, since they
+	// configure the scanner and may hold secrets such as verification keys.
+	InheritEnv bool
+}
+
+// Result reports the outcome of one run
+type Result struct {
+	ExitCode int    // -1 if the command could not be started or was killed
+	Stderr   string // Start of standard error, trimmed
+	Err      error
+	Duration time.Duration
+}
+
+// Run runs c and waits for it to finish or time out
+func Run(ctx context.Context, c Command) Result {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var stderr limitedBuffer
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Env = append(baseEnvironment(c.InheritEnv), c.Env...)
+	cmd.Stdin = bytes.NewReader(c.Stdin)
+	cmd.Stderr = &stderr
+	// 子プロセスが標準エラー出力を開いたままでもタイムアウト後に待ち続けない
+	cmd.WaitDelay = time.Second
+
+	result := Result{ExitCode: -1}
+	start := time.Now()
+	err := cmd.Run()
+	result.Duration = time.Since(start)
+	result.Stderr = strings.TrimSpace(stderr.String())
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		switch {
+		case ctx.Err() != nil && c.Timeout > 0:
+			err = fmt.Errorf("timed out after %v: %w", c.Timeout, ctx.Err())
+		case ctx.Err() != nil:
+			err = ctx.Err()
+		}
+		result.Err = err
+	}
+	return result
+}
+
+// Failure returns Err followed by the start of standard error, or nil if the
+// command succeeded
+func (r Result) Failure() error {
+	if r.Err == nil {
+		return nil
+	}
+	if r.Stderr == "" {
+		return r.Err
+	}
+	const maxOutput = 200
+	out := r.Stderr
+	if len(out) > maxOutput {
+		out = out[:maxOutput] + "..."
+	}
+	return fmt.Errorf("%w: %s", r.Err, out)
+}
+
+// Environment returns the ME19_* variables describing d
+func Environment(d detection.Detection) []string {
+	env := []string{
+		"ME19_CODE=" + envValue(d.Code),
+		"ME19_TYPE=" + string(d.Type),
+		"ME19_TIME=" + d.Time.Format(time.RFC3339Nano),
+		"ME19_DEVICE_ID=" + strconv.Itoa(d.DeviceID),
+	}
+	if len(d.Raw) > 0 {
+		env = append(env, "ME19_RAW_BASE64="+base64.StdEncoding.EncodeToString(d.Raw))
+	}
+
+	names := make([]string, 0, len(d.Fields))
+	for name := range d.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, "ME19_FIELD_"+envName(name)+"="+envValue(d.Fields[name]))
+	}
+	return env
+}
+
+// baseEnvironment returns the variables of this process passed to a command
+func baseEnvironment(inherit bool) []string {
+	if !inherit {
+		env := make([]string, 0, len(passedVariables))
+		for _, name := range passedVariables {
+			if value, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+value)
+			}
+		}
+		return env
+	}
+
+	env := make([]string, 0, len(os.Environ()))
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, "ME19_") {
+			env = append(env, variable)
+		}
+	}
+	return env
+}
+
+// envName converts a field name to an environment variable name
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// envValue removes NUL bytes, which cannot appear in environment variables
+func envValue(value string) string {
+	return strings.ReplaceAll(value, "\x00", "")
+}
+
+// limitedBuffer keeps the first MaxStderr bytes written to it
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := MaxStderr - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+			b.truncated = true
+		} else {
+			b.buf.Write(p)
+		}
+	} else if len(p) > 0 {
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "..."
+	}
+	return b.buf.String()
+}
@@ -0,0 +1,169 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+func lookPathSh(t *testing.T) string {
+	t.Helper()
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	return sh
+}
+
+func TestRun(t *testing.T) {
+	sh := lookPathSh(t)
+
+	tests := []struct {
+		name     string
+		command  Command
+		ctx      func() (context.Context, context.CancelFunc)
+		exitCode int
+		stderr   string
+		wantErr  string
+	}{
+		{
+			name:    "引数・環境変数・標準入力",
+			command: Command{Path: sh, Args: []string{"-c", `read line; [ "$line" = "$1" ] && [ "$ME19_CODE" = "$1" ]`, "sh", "$(id)"}, Env: []string{"ME19_CODE=$(id)"}, Stdin: []byte("$(id)\n")},
+		},
+		{
+			name:     "失敗",
+			command:  Command{Path: sh, Args: []string{"-c", "echo door offline >&2; exit 4"}},
+			exitCode: 4,
+			stderr:   "door offline",
+			wantErr:  "exit status 4: door offline",
+		},
+		{
+			name:     "タイムアウト",
+			command:  Command{Path: sh, Args: []string{"-c", "sleep 5"}, Timeout: 100 * time.Millisecond},
+			exitCode: -1,
+			wantErr:  "timed out after 100ms",
+		},
+		{
+			name:    "呼び出し元の期限",
+			command: Command{Path: sh, Args: []string{"-c", "sleep 5"}},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 100*time.Millisecond)
+			},
+			exitCode: -1,
+			wantErr:  context.DeadlineExceeded.Error(),
+		},
+		{
+			name:     "存在しないコマンド",
+			command:  Command{Path: "/nonexistent/me19-command"},
+			exitCode: -1,
+			wantErr:  "no such file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel()
+
+			r := Run(ctx, tt.command)
+			if r.ExitCode != tt.exitCode || r.Stderr != tt.stderr {
+				t.Errorf("Run() = exit code %d, stderr %q, want %d, %q", r.ExitCode, r.Stderr, tt.exitCode, tt.stderr)
+			}
+			if err := r.Failure(); tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Failure() = %v, want %q", err, tt.wantErr)
+			}
+			if r.Duration > 3*time.Second {
+				t.Errorf("Command should be stopped by the timeout, took %v", r.Duration)
+			}
+		})
+	}
+}
+
+func TestRun_Environment(t *testing.T) {
+	sh := lookPathSh(t)
+	t.Setenv("ME19_VERIFY_SECRET", "s3cret")
+	t.Setenv("ME19_TEST_OTHER", "")
+	t.Setenv("OTHER_SETTING", "1")
+	out := filepath.Join(t.TempDir(), "env")
+
+	tests := []struct {
+		inherit bool
+		want    []string
+		notWant []string
+	}{
+		{inherit: false, want: []string{"PATH=", "ME19_CODE=TICKET"}, notWant: []string{"OTHER_SETTING=", "ME19_VERIFY_SECRET="}},
+		// 明示的に引き継ぐ場合もスキャナーの ME19_* 変数は渡さない
+		{inherit: true, want: []string{"PATH=", "ME19_CODE=TICKET", "OTHER_SETTING=1"}, notWant: []string{"ME19_VERIFY_SECRET=", "ME19_TEST_OTHER="}},
+	}
+	for _, tt := range tests {
+		r := Run(context.Background(), Command{
+			Path:       sh,
+			Args:       []string{"-c", `env > "$0"`, out},
+			Env:        []string{"ME19_CODE=TICKET"},
+			InheritEnv: tt.inherit,
+		})
+		if r.Err != nil {
+			t.Fatalf("Run failed: %v", r.Failure())
+		}
+		data, _ := os.ReadFile(out)
+		lines := strings.Split(string(data), "\n")
+		has := func(prefix string) bool {
+			return slices.ContainsFunc(lines, func(line string) bool { return strings.HasPrefix(line, prefix) })
+		}
+		for _, prefix := range tt.want {
+			if !has(prefix) {
+				t.Errorf("inherit=%v: %s missing from %q", tt.inherit, prefix, lines)
+			}
+		}
+		for _, prefix := range tt.notWant {
+			if has(prefix) {
+				t.Errorf("inherit=%v: %s should not be passed", tt.inherit, prefix)
+			}
+		}
+	}
+}
+
+func TestResult_FailureKeepsError(t *testing.T) {
+	r := Result{Err: context.DeadlineExceeded, Stderr: strings.Repeat("x", 300)}
+	err := r.Failure()
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.HasSuffix(err.Error(), "...") {
+		t.Errorf("Failure() = %v", err)
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	d := detection.New("WIFI:T:WPA;S:guest net;P:secret;;", []byte{0x01}, time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), nil)
+	env := Environment(d)
+
+	want := []string{
+		"ME19_CODE=WIFI:T:WPA;S:guest net;P:secret;;",
+		"ME19_TYPE=wifi",
+		"ME19_TIME=2025-01-02T03:04:05Z",
+		"ME19_DEVICE_ID=0",
+		"ME19_RAW_BASE64=AQ==",
+		"ME19_FIELD_PASSWORD=secret",
+		"ME19_FIELD_SECURITY=WPA",
+		"ME19_FIELD_SSID=guest net",
+	}
+	if strings.Join(env, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Environment() =\n%s\nwant\n%s", strings.Join(env, "\n"), strings.Join(want, "\n"))
+	}
+
+	if got := envName("x-ray.id"); got != "X_RAY_ID" {
+		t.Errorf("envName = %q", got)
+	}
+	if got := Environment(detection.Detection{Code: "a\x00b"})[0]; got != "ME19_CODE=ab" {
+		t.Errorf("NUL should be removed, got %q", got)
+	}
+}
@@ -0,0 +1,173 @@
+// Package consensus requires a payload to be decoded repeatedly before it is accepted,
+// so that a single misread from a blurry frame never reaches the outputs.
+package consensus
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+// Mode selects how repeated decodes are counted
+type Mode string
+
+const (
+	// ModeFrames requires the payload in Required of the last Frames analyzed frames
+	ModeFrames Mode = "frames"
+	// ModeTime requires the payload to be decoded Required times within Window
+	ModeTime Mode = "time"
+)
+
+// Policy describes when a payload is considered confirmed
+type Policy struct {
+	Mode     Mode
+	Required int           // K (frames mode) or M (time mode)
+	Frames   int           // N: number of analyzed frames considered in frames mode
+	Window   time.Duration // Time window considered in time mode
+}
+
+// Validate checks that the policy can be satisfied
+func (p Policy) Validate() error {
+	if p.Required < 1 {
+		return fmt.Errorf("required must be at least 1, got %d", p.Required)
+	}
+	switch p.Mode {
+	case ModeFrames:
+		if p.Frames < p.Required {
+			return fmt.Errorf("frames (%d) must be at least required (%d)", p.Frames, p.Required)
+		}
+	case ModeTime:
+		if p.Window <= 0 {
+			return fmt.Errorf("window must be positive, got %v", p.Window)
+		}
+	default:
+		return fmt.Errorf("unknown confirmation mode: %s", p.Mode)
+	}
+	return nil
+}
+
+// Candidate is a payload that has been decoded but not yet confirmed
+type Candidate struct {
+	Code     string
+	Count    int
+	Required int
+}
+
+// Confirmer tracks recent decodes and reports which payloads satisfy the policy
+type Confirmer struct {
+	policy Policy
+
+	mu     sync.Mutex
+	frames []map[string]bool      // frames mode: payloads seen in each of the last N frames
+	seen   map[string][]time.Time // time mode: decode times within the window
+	counts map[string]int         // current count per payload
+}
+
+// New creates a confirmer for the given policy
+func New(policy Policy) (*Confirmer, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &Confirmer{
+		policy: policy,
+		seen:   make(map[string][]time.Time),
+		counts: make(map[string]int),
+	}, nil
+}
+
+// Observe records the detections of one analyzed frame, which may be empty,
+// and returns the detections whose payload currently satisfies the policy.
+func (c *Confirmer) Observe(detections []detection.Detection, now time.Time) []detection.Detection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 同じフレーム内の重複は1回として数える
+	codes := make(map[string]bool, len(detections))
+	for _, d := range detections {
+		codes[d.Code] = true
+	}
+
+	switch c.policy.Mode {
+	case ModeFrames:
+		c.observeFrame(codes)
+	case ModeTime:
+		c.observeTime(codes, now)
+	}
+
+	var confirmed []detection.Detection
+	for _, d := range detections {
+		if codes[d.Code] && c.counts[d.Code] >= c.policy.Required {
+			confirmed = append(confirmed, d)
+			delete(codes, d.Code)
+		}
+	}
+	return confirmed
+}
+
+// observeFrame slides the frame window by one frame
+func (c *Confirmer) observeFrame(codes map[string]bool) {
+	c.frames = append(c.frames, codes)
+	for code := range codes {
+		c.counts[code]++
+	}
+
+	if len(c.frames) > c.policy.Frames {
+		for code := range c.frames[0] {
+			c.decrement(code)
+		}
+		c.frames = c.frames[1:]
+	}
+}
+
+// observeTime adds the decode times and drops those outside the window
+func (c *Confirmer) observeTime(codes map[string]bool, now time.Time) {
+	for code := range codes {
+		c.seen[code] = append(c.seen[code], now)
+	}
+
+	cutoff := now.Add(-c.policy.Window)
+	for code, times := range c.seen {
+		i := 0
+		for i < len(times) && !times[i].After(cutoff) {
+			i++
+		}
+		if i == len(times) {
+			delete(c.seen, code)
+			delete(c.counts, code)
+			continue
+		}
+		c.seen[code] = times[i:]
+		c.counts[code] = len(times) - i
+	}
+}
+
+func (c *Confirmer) decrement(code string) {
+	c.counts[code]--
+	if c.counts[code] <= 0 {
+		delete(c.counts, code)
+	}
+}
+
+// Pending returns the payloads seen recently that have not reached the required count,
+// ordered by count (highest first) and then by payload
+func (c *Confirmer) Pending() []Candidate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var pending []Candidate
+	for code, count := range c.counts {
+		if count < c.policy.Required {
+			pending = append(pending, Candidate{Code: code, Count: count, Required: c.policy.Required})
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].Count != pending[j].Count {
+			return pending[i].Count > pending[j].Count
+		}
+		return pending[i].Code < pending[j].Code
+	})
+	return pending
+}
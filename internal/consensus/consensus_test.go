@@ -0,0 +1,150 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+func frame(codes ...string) []detection.Detection {
+	detections := make([]detection.Detection, 0, len(codes))
+	for _, code := range codes {
+		detections = append(detections, detection.Detection{Code: code})
+	}
+	return detections
+}
+
+func codesOf(detections []detection.Detection) []string {
+	codes := make([]string, 0, len(detections))
+	for _, d := range detections {
+		codes = append(codes, d.Code)
+	}
+	return codes
+}
+
+func TestConfirmer_Frames(t *testing.T) {
+	c, err := New(Policy{Mode: ModeFrames, Required: 3, Frames: 5})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	start := time.Now()
+
+	// 1フレームだけの誤読は確定しない
+	steps := []struct {
+		frame []detection.Detection
+		want  []string
+	}{
+		{frame("MISREAD"), nil},
+		{frame("TICKET"), nil},
+		{frame(), nil},
+		{frame("TICKET"), nil},
+		{frame("TICKET", "TICKET"), []string{"TICKET"}},
+		{frame("TICKET"), []string{"TICKET"}},
+	}
+
+	for i, step := range steps {
+		got := codesOf(c.Observe(step.frame, start.Add(time.Duration(i)*100*time.Millisecond)))
+		if len(got) != len(step.want) || (len(got) > 0 && got[0] != step.want[0]) {
+			t.Fatalf("step %d: Observe() = %v, want %v", i, got, step.want)
+		}
+	}
+
+	// MISREADは5フレームの窓から外れている
+	for _, candidate := range c.Pending() {
+		if candidate.Code == "MISREAD" {
+			t.Errorf("MISREAD should have left the window, got %+v", candidate)
+		}
+	}
+}
+
+func TestConfirmer_FramesWindowSlides(t *testing.T) {
+	c, _ := New(Policy{Mode: ModeFrames, Required: 2, Frames: 3})
+	now := time.Now()
+
+	c.Observe(frame("A"), now)
+	c.Observe(frame(), now)
+	c.Observe(frame(), now)
+	// 最初の "A" は窓から外れるため、ここでの "A" は1回目として数えられる
+	if got := c.Observe(frame("A"), now); len(got) != 0 {
+		t.Fatalf("A should not be confirmed after leaving the window, got %v", codesOf(got))
+	}
+	if got := c.Observe(frame("A"), now); len(got) != 1 {
+		t.Fatalf("A should be confirmed on the second frame in the window, got %v", codesOf(got))
+	}
+}
+
+func TestConfirmer_Time(t *testing.T) {
+	c, err := New(Policy{Mode: ModeTime, Required: 3, Window: time.Second})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	start := time.Now()
+
+	c.Observe(frame("A"), start)
+	c.Observe(frame("A"), start.Add(400*time.Millisecond))
+	// 最初の検出は窓から外れる
+	if got := c.Observe(frame("A"), start.Add(1200*time.Millisecond)); len(got) != 0 {
+		t.Fatalf("A should not be confirmed with an expired decode, got %v", codesOf(got))
+	}
+	if got := c.Observe(frame("A"), start.Add(1300*time.Millisecond)); len(got) != 1 {
+		t.Fatalf("A should be confirmed with 3 decodes within the window, got %v", codesOf(got))
+	}
+
+	// 窓の外に出ると候補から消える
+	c.Observe(frame(), start.Add(5*time.Second))
+	if pending := c.Pending(); len(pending) != 0 {
+		t.Errorf("Expected no pending candidates, got %+v", pending)
+	}
+}
+
+func TestConfirmer_Pending(t *testing.T) {
+	c, _ := New(Policy{Mode: ModeFrames, Required: 3, Frames: 10})
+	now := time.Now()
+
+	c.Observe(frame("B", "A"), now)
+	c.Observe(frame("A"), now)
+
+	pending := c.Pending()
+	if len(pending) != 2 {
+		t.Fatalf("Expected 2 pending candidates, got %+v", pending)
+	}
+	if pending[0] != (Candidate{Code: "A", Count: 2, Required: 3}) {
+		t.Errorf("Unexpected first candidate %+v", pending[0])
+	}
+	if pending[1] != (Candidate{Code: "B", Count: 1, Required: 3}) {
+		t.Errorf("Unexpected second candidate %+v", pending[1])
+	}
+
+	// 確定した候補はPendingに含まれない
+	c.Observe(frame("A"), now)
+	for _, candidate := range c.Pending() {
+		if candidate.Code == "A" {
+			t.Errorf("Confirmed payload should not be pending: %+v", candidate)
+		}
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{name: "frames", policy: Policy{Mode: ModeFrames, Required: 3, Frames: 5}},
+		{name: "time", policy: Policy{Mode: ModeTime, Required: 2, Window: time.Second}},
+		{name: "single frame", policy: Policy{Mode: ModeFrames, Required: 1, Frames: 1}},
+		{name: "required exceeds frames", policy: Policy{Mode: ModeFrames, Required: 5, Frames: 3}, wantErr: true},
+		{name: "zero required", policy: Policy{Mode: ModeFrames, Required: 0, Frames: 3}, wantErr: true},
+		{name: "zero window", policy: Policy{Mode: ModeTime, Required: 2}, wantErr: true},
+		{name: "unknown mode", policy: Policy{Mode: "votes", Required: 2, Frames: 3}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
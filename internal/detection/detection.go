@@ -0,0 +1,34 @@
+// Package detection defines the event emitted for every decoded QR code.
+package detection
+
+import (
+	"image"
+	"time"
+
+	"github.com/eotel/me19/internal/payload"
+)
+
+// Detection is a decoded QR code as it flows from the capture loop to the outputs
+type Detection struct {
+	Code     string            `json:"code"`             // Decoded text
+	Raw      []byte            `json:"raw,omitempty"`    // Byte-mode segments as stored in the symbol
+	Time     time.Time         `json:"time"`             // When the code was decoded
+	Points   []image.Point     `json:"points,omitempty"` // Location of the code in the frame
+	DeviceID int               `json:"device_id"`        // Camera the frame was captured from
+	Camera   string            `json:"camera,omitempty"` // Name of the camera when several cameras are used
+	Type     payload.Type      `json:"type"`             // Parsed content format
+	Fields   map[string]string `json:"fields,omitempty"` // Structured fields of the parsed format
+}
+
+// New creates a detection for code and classifies its payload
+func New(code string, raw []byte, t time.Time, points []image.Point) Detection {
+	p := payload.Parse(code)
+	return Detection{
+		Code:   code,
+		Raw:    raw,
+		Time:   t,
+		Points: points,
+		Type:   p.Type,
+		Fields: p.Fields,
+	}
+}
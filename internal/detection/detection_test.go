@@ -0,0 +1,29 @@
+package detection
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/eotel/me19/internal/payload"
+)
+
+func TestNew(t *testing.T) {
+	now := time.Now()
+	points := []image.Point{{10, 10}, {40, 10}, {10, 40}}
+
+	d := New("https://example.com/ticket/1", nil, now, points)
+
+	if d.Code != "https://example.com/ticket/1" {
+		t.Errorf("Code = %q, want the raw text", d.Code)
+	}
+	if !d.Time.Equal(now) || len(d.Points) != 3 {
+		t.Errorf("Time and Points should be kept as given, got %v %v", d.Time, d.Points)
+	}
+	if d.Type != payload.TypeURL {
+		t.Errorf("Type = %q, want %q", d.Type, payload.TypeURL)
+	}
+	if d.Fields["host"] != "example.com" {
+		t.Errorf("Fields[host] = %q, want example.com", d.Fields["host"])
+	}
+}
@@ -0,0 +1,52 @@
+package fileio
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Encoding selects how a decoded QR code is converted to the bytes written to a file
+type Encoding string
+
+const (
+	// EncodingText writes the decoded text as NFC-normalized UTF-8
+	EncodingText Encoding = "text"
+	// EncodingRaw writes the byte-mode segments exactly as stored in the symbol
+	EncodingRaw Encoding = "raw"
+	// EncodingBase64 writes the raw bytes as standard base64
+	EncodingBase64 Encoding = "base64"
+)
+
+// ParseEncoding validates an encoding name from the configuration.
+// An empty name selects EncodingText.
+func ParseEncoding(name string) (Encoding, error) {
+	switch Encoding(strings.ToLower(name)) {
+	case "", EncodingText:
+		return EncodingText, nil
+	case EncodingRaw:
+		return EncodingRaw, nil
+	case EncodingBase64:
+		return EncodingBase64, nil
+	}
+	return "", fmt.Errorf("unknown output encoding: %s", name)
+}
+
+// Encode converts a decoded payload to bytes for the given encoding.
+// raw holds the byte-mode segments of the symbol; when it is empty the UTF-8 text is used instead.
+func Encode(enc Encoding, text string, raw []byte) []byte {
+	if len(raw) == 0 {
+		raw = []byte(text)
+	}
+
+	switch enc {
+	case EncodingRaw:
+		return raw
+	case EncodingBase64:
+		return []byte(base64.StdEncoding.EncodeToString(raw))
+	default:
+		return []byte(norm.NFC.String(strings.ToValidUTF8(text, "�")))
+	}
+}
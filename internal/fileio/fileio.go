@@ -20,6 +20,11 @@ func New(filePath string) *Writer {
 
 // WriteData writes the given QR code data to the file, replacing any existing content
 func (w *Writer) WriteData(data string) error {
+	return w.WriteBytes([]byte(data))
+}
+
+// WriteBytes writes the given bytes to the file, replacing any existing content
+func (w *Writer) WriteBytes(data []byte) error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
@@ -29,12 +34,17 @@ func (w *Writer) WriteData(data string) error {
 	}
 	defer file.Close()
 
-	_, err = file.WriteString(data)
+	_, err = file.Write(data)
 	return err
 }
 
 // AppendData appends the given QR code data to the file without replacing existing content
 func (w *Writer) AppendData(data string) error {
+	return w.AppendBytes([]byte(data))
+}
+
+// AppendBytes appends the given bytes to the file without replacing existing content
+func (w *Writer) AppendBytes(data []byte) error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
@@ -44,6 +54,6 @@ func (w *Writer) AppendData(data string) error {
 	}
 	defer file.Close()
 
-	_, err = file.WriteString(data)
+	_, err = file.Write(data)
 	return err
 }
@@ -1,9 +1,16 @@
 package fileio
 
 import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestWriter_WriteData(t *testing.T) {
@@ -143,3 +150,312 @@ func TestWriter_AppendData(t *testing.T) {
 		})
 	}
 }
+
+func TestEncode(t *testing.T) {
+	// "が" を結合文字で表現したNFD形式
+	decomposed := "\u304b\u3099"
+	sjis := []byte{0x93, 0xfc, 0x8f, 0xea} // "入場" のShift_JIS表現
+
+	tests := []struct {
+		name     string
+		encoding Encoding
+		text     string
+		raw      []byte
+		expected []byte
+	}{
+		{name: "text is normalized", encoding: EncodingText, text: decomposed, raw: []byte(decomposed), expected: []byte("\u304c")},
+		{name: "text ignores raw bytes", encoding: EncodingText, text: "入場", raw: sjis, expected: []byte("入場")},
+		{name: "invalid utf-8 is replaced", encoding: EncodingText, text: "a\xffb", expected: []byte("a�b")},
+		{name: "raw keeps original bytes", encoding: EncodingRaw, text: "入場", raw: sjis, expected: sjis},
+		{name: "raw falls back to text", encoding: EncodingRaw, text: "TICKET", expected: []byte("TICKET")},
+		{name: "base64 of raw bytes", encoding: EncodingBase64, text: "入場", raw: sjis, expected: []byte("k/yP6g==")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Encode(tt.encoding, tt.text, tt.raw)
+			if !bytes.Equal(got, tt.expected) {
+				t.Errorf("Encode() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	for name, want := range map[string]Encoding{"": EncodingText, "text": EncodingText, "RAW": EncodingRaw, "base64": EncodingBase64} {
+		got, err := ParseEncoding(name)
+		if err != nil || got != want {
+			t.Errorf("ParseEncoding(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseEncoding("hex"); err == nil {
+		t.Error("ParseEncoding should reject unknown encodings")
+	}
+}
+
+func TestWriter_WriteBytes(t *testing.T) {
+	testFilePath := filepath.Join(t.TempDir(), "qrcode.bin")
+	data := []byte{0x00, 0xff, 0x0a, 0x93}
+
+	if err := New(testFilePath).WriteBytes(data); err != nil {
+		t.Fatalf("WriteBytes() error = %v", err)
+	}
+	content, err := os.ReadFile(testFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+	if !bytes.Equal(content, data) {
+		t.Errorf("WriteBytes() wrote %x, want %x", content, data)
+	}
+}
+
+// fakeClock はローテーションのテスト用の時計
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestRotatingWriter(t *testing.T, policy RotationPolicy) (*RotatingWriter, *fakeClock, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "codes.log")
+	clock := &fakeClock{now: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)}
+	w := NewRotatingWriter(path, policy)
+	w.now = clock.Now
+	return w, clock, path
+}
+
+func TestRotatingWriter_Size(t *testing.T) {
+	w, clock, path := newTestRotatingWriter(t, RotationPolicy{MaxSize: 10})
+
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n"} {
+		if err := w.AppendBytes([]byte(line)); err != nil {
+			t.Fatalf("AppendBytes failed: %v", err)
+		}
+		clock.Advance(time.Second)
+	}
+
+	segments, err := w.Segments()
+	if err != nil {
+		t.Fatalf("Segments failed: %v", err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("Expected 2 rotated segments, got %v", segments)
+	}
+	if filepath.Base(segments[0]) != "codes-20250102T030407.000.log" {
+		t.Errorf("Unexpected segment name %s", filepath.Base(segments[0]))
+	}
+	first, _ := os.ReadFile(segments[0])
+	if string(first) != "aaaa\nbbbb\n" {
+		t.Errorf("First segment = %q", first)
+	}
+	current, _ := os.ReadFile(path)
+	if string(current) != "eeee\n" {
+		t.Errorf("Current file = %q", current)
+	}
+}
+
+func TestRotatingWriter_Interval(t *testing.T) {
+	w, clock, path := newTestRotatingWriter(t, RotationPolicy{Interval: time.Hour})
+
+	w.AppendBytes([]byte("03:04\n"))
+	clock.Advance(50 * time.Minute)
+	w.AppendBytes([]byte("03:54\n"))
+	// 04:00を過ぎたので新しいファイルになる
+	clock.Advance(10 * time.Minute)
+	w.AppendBytes([]byte("04:04\n"))
+
+	segments, _ := w.Segments()
+	if len(segments) != 1 {
+		t.Fatalf("Expected 1 rotated segment, got %v", segments)
+	}
+	rotated, _ := os.ReadFile(segments[0])
+	if string(rotated) != "03:04\n03:54\n" {
+		t.Errorf("Rotated segment = %q", rotated)
+	}
+	current, _ := os.ReadFile(path)
+	if string(current) != "04:04\n" {
+		t.Errorf("Current file = %q", current)
+	}
+}
+
+func TestRotatingWriter_CompressAndRetention(t *testing.T) {
+	w, clock, _ := newTestRotatingWriter(t, RotationPolicy{MaxSize: 1, Compress: true, MaxBackups: 3})
+
+	for i := 0; i < 6; i++ {
+		w.AppendBytes([]byte(fmt.Sprintf("line %d\n", i)))
+		clock.Advance(time.Minute)
+	}
+
+	segments, _ := w.Segments()
+	if len(segments) != 3 {
+		t.Fatalf("Expected 3 segments to be kept, got %v", segments)
+	}
+	for _, s := range segments {
+		if !strings.HasSuffix(s, ".log.gz") {
+			t.Errorf("Segment should be compressed: %s", s)
+		}
+	}
+
+	// 最も古い残りのセグメントは line 2
+	f, err := os.Open(segments[0])
+	if err != nil {
+		t.Fatalf("Failed to open segment: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Segment is not gzip: %v", err)
+	}
+	data, _ := io.ReadAll(zr)
+	if string(data) != "line 2\n" {
+		t.Errorf("Oldest kept segment = %q", data)
+	}
+}
+
+func TestRotatingWriter_MaxAge(t *testing.T) {
+	w, clock, _ := newTestRotatingWriter(t, RotationPolicy{MaxSize: 1, MaxAge: 48 * time.Hour})
+
+	w.AppendBytes([]byte("day 0\n"))
+	clock.Advance(24 * time.Hour)
+	w.AppendBytes([]byte("day 1\n")) // day 0 をローテーション
+	clock.Advance(24 * time.Hour)
+	w.AppendBytes([]byte("day 2\n")) // day 1 をローテーション
+	if segments, _ := w.Segments(); len(segments) != 2 {
+		t.Fatalf("Expected 2 segments, got %v", segments)
+	}
+
+	clock.Advance(25 * time.Hour)
+	w.AppendBytes([]byte("day 3\n")) // day 2 をローテーションし、1日目の時点で作られたセグメントを削除
+	segments, _ := w.Segments()
+	if len(segments) != 2 {
+		t.Fatalf("Expected the oldest segment to expire, got %v", segments)
+	}
+	data, _ := os.ReadFile(segments[0])
+	if string(data) != "day 1\n" {
+		t.Errorf("Oldest kept segment = %q", data)
+	}
+}
+
+func TestRotatingWriter_SharedStem(t *testing.T) {
+	// 同じディレクトリで拡張子だけが異なる出力同士のセグメントを消さない
+	w, clock, path := newTestRotatingWriter(t, RotationPolicy{MaxSize: 1, MaxBackups: 1})
+	other := NewRotatingWriter(strings.TrimSuffix(path, ".log")+".jsonl", RotationPolicy{MaxSize: 1, MaxBackups: 1})
+	other.now = clock.Now
+	dir := filepath.Dir(path)
+	unrelated := []string{"codes-20250102T030405.000.log.bak", "codes-20250102T030405.000-x.log", "codes-notatime.log"}
+	for _, name := range unrelated {
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+
+	for i := 0; i < 3; i++ {
+		other.AppendBytes([]byte(fmt.Sprintf("other %d\n", i)))
+		w.AppendBytes([]byte(fmt.Sprintf("line %d\n", i)))
+		clock.Advance(time.Minute)
+	}
+
+	for _, writer := range []*RotatingWriter{w, other} {
+		segments, _ := writer.Segments()
+		if len(segments) != 1 || filepath.Ext(segments[0]) != filepath.Ext(writer.path) {
+			t.Errorf("Segments of %s = %v, want one of its own", writer.path, segments)
+		}
+	}
+	for _, name := range unrelated {
+		if !exists(filepath.Join(dir, name)) {
+			t.Errorf("%s should not be removed", name)
+		}
+	}
+}
+
+func TestRotatingWriter_Concurrent(t *testing.T) {
+	w, _, path := newTestRotatingWriter(t, RotationPolicy{MaxSize: 64})
+
+	const writers, lines = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < lines; j++ {
+				if err := w.AppendBytes([]byte(fmt.Sprintf("writer %d line %02d\n", i, j))); err != nil {
+					t.Errorf("AppendBytes failed: %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// すべての行が欠けることなくいずれかのファイルに書き込まれている
+	segments, _ := w.Segments()
+	seen := make(map[string]bool)
+	for _, file := range append(segments, path) {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", file, err)
+		}
+		if len(data) > 64 {
+			t.Errorf("%s exceeds the size limit: %d bytes", file, len(data))
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if seen[line] {
+				t.Errorf("Duplicate line %q", line)
+			}
+			seen[line] = true
+		}
+	}
+	if len(seen) != writers*lines {
+		t.Errorf("Expected %d lines, found %d", writers*lines, len(seen))
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	if err := WriteFileAtomic(path, []byte("first"), 0600); err != nil {
+		t.Fatalf("WriteFileAtomic() error = %v", err)
+	}
+	if err := WriteFileAtomic(path, []byte("second"), 0600); err != nil {
+		t.Fatalf("WriteFileAtomic() error = %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(content) != "second" {
+		t.Errorf("content = %q, want %q", content, "second")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// 一時ファイルが残っていないこと
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want 1", len(entries))
+	}
+
+	// 書き込めない場所では既存のファイルを壊さずにエラーを返す
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "state.json"), []byte("x"), 0600); err == nil {
+		t.Error("WriteFileAtomic() into a missing directory should fail")
+	}
+}
@@ -0,0 +1,281 @@
+package fileio
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotationTimeLayout is the timestamp inserted into rotated file names (UTC)
+const rotationTimeLayout = "20060102T150405.000"
+
+// RotationPolicy controls when an appended file is rotated and how many old
+// segments are kept
+type RotationPolicy struct {
+	MaxSize    int64         // Rotate before a write would exceed this many bytes (0 disables)
+	Interval   time.Duration // Rotate when the current time enters a new interval, aligned to UTC (0 disables)
+	Compress   bool          // Gzip rotated segments
+	MaxBackups int           // Keep at most this many rotated segments (0 keeps all)
+	MaxAge     time.Duration // Delete rotated segments older than this (0 keeps all)
+}
+
+// Enabled reports whether the policy rotates at all
+func (p RotationPolicy) Enabled() bool {
+	return p.MaxSize > 0 || p.Interval > 0
+}
+
+// RotatingWriter appends to a file and rotates it according to a policy.
+// Rotated segments are renamed to "<name>-<timestamp><ext>", e.g. codes-20250102T030405.000.log.
+type RotatingWriter struct {
+	path   string
+	policy RotationPolicy
+	now    func() time.Time
+	mutex  sync.Mutex
+
+	lastWrite time.Time // 最後に書き込んだ時刻（起動直後はファイルの更新時刻を使う）
+}
+
+// NewRotatingWriter creates a writer that appends to path with rotation
+func NewRotatingWriter(path string, policy RotationPolicy) *RotatingWriter {
+	return &RotatingWriter{path: path, policy: policy, now: time.Now}
+}
+
+// AppendBytes appends data to the current file, rotating first if the policy requires it
+func (w *RotatingWriter) AppendBytes(data []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if info, err := os.Stat(w.path); err == nil && info.Size() > 0 && w.shouldRotate(info, int64(len(data))) {
+		if err := w.rotateLocked(); err != nil {
+			return fmt.Errorf("rotating %s: %w", w.path, err)
+		}
+	}
+
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	w.lastWrite = w.now()
+	return nil
+}
+
+// Rotate rotates the current file immediately
+func (w *RotatingWriter) Rotate() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if info, err := os.Stat(w.path); err != nil || info.Size() == 0 {
+		return nil
+	}
+	return w.rotateLocked()
+}
+
+// shouldRotate reports whether the current file must be rotated before writing n bytes
+func (w *RotatingWriter) shouldRotate(info os.FileInfo, n int64) bool {
+	if w.policy.MaxSize > 0 && info.Size()+n > w.policy.MaxSize {
+		return true
+	}
+	if w.policy.Interval > 0 {
+		// 最後に書き込んだ時刻と現在時刻が別の区間にあればローテーションする
+		last := w.lastWrite
+		if last.IsZero() {
+			last = info.ModTime()
+		}
+		last = last.Truncate(w.policy.Interval)
+		current := w.now().Truncate(w.policy.Interval)
+		return current.After(last)
+	}
+	return false
+}
+
+// rotateLocked renames the current file, compresses it if configured and applies retention
+func (w *RotatingWriter) rotateLocked() error {
+	rotated := w.rotatedName(w.now())
+	if err := os.Rename(w.path, rotated); err != nil {
+		return err
+	}
+	if w.policy.Compress {
+		if err := compressFile(rotated); err != nil {
+			return err
+		}
+	}
+	return w.removeOldSegments()
+}
+
+// rotatedName returns an unused name for a segment rotated at t
+func (w *RotatingWriter) rotatedName(t time.Time) string {
+	dir, base, ext := w.nameParts()
+	stamp := t.UTC().Format(rotationTimeLayout)
+	name := filepath.Join(dir, base+"-"+stamp+ext)
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		// 同じ時刻に複数回ローテーションした場合は連番を付ける
+		name = filepath.Join(dir, fmt.Sprintf("%s-%s-%d%s", base, stamp, i, ext))
+	}
+	return name
+}
+
+// nameParts splits the path into directory, base name and extension
+func (w *RotatingWriter) nameParts() (dir, base, ext string) {
+	dir = filepath.Dir(w.path)
+	name := filepath.Base(w.path)
+	ext = filepath.Ext(name)
+	return dir, strings.TrimSuffix(name, ext), ext
+}
+
+// segment is a rotated file on disk
+type segment struct {
+	path    string
+	rotated time.Time
+}
+
+// Segments returns the rotated segments of the file, oldest first
+func (w *RotatingWriter) Segments() ([]string, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	segments, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(segments))
+	for _, s := range segments {
+		paths = append(paths, s.path)
+	}
+	return paths, nil
+}
+
+func (w *RotatingWriter) segments() ([]segment, error) {
+	dir, base, ext := w.nameParts()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			continue
+		}
+		rotated, ok := parseSegmentName(name, base, ext)
+		if !ok {
+			continue
+		}
+		segments = append(segments, segment{path: filepath.Join(dir, name), rotated: rotated})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		if !segments[i].rotated.Equal(segments[j].rotated) {
+			return segments[i].rotated.Before(segments[j].rotated)
+		}
+		return segments[i].path < segments[j].path
+	})
+	return segments, nil
+}
+
+// parseSegmentName returns the rotation time of name if it is exactly a name
+// given by rotatedName, "<base>-<timestamp>[-<n>]<ext>" optionally followed by
+// ".gz". Files of another name sharing the base, such as codes-<timestamp>.jsonl
+// when rotating codes.log, are not segments.
+func parseSegmentName(name, base, ext string) (time.Time, bool) {
+	name = strings.TrimSuffix(name, ".gz")
+	if !strings.HasPrefix(name, base+"-") || !strings.HasSuffix(name, ext) || len(name) < len(base)+1+len(ext) {
+		return time.Time{}, false
+	}
+	stem := name[len(base)+1 : len(name)-len(ext)]
+	if len(stem) < len(rotationTimeLayout) {
+		return time.Time{}, false
+	}
+	// 同じ時刻にローテーションした場合の連番
+	if suffix := stem[len(rotationTimeLayout):]; suffix != "" && (suffix[0] != '-' || !isDigits(suffix[1:])) {
+		return time.Time{}, false
+	}
+	rotated, err := time.Parse(rotationTimeLayout, stem[:len(rotationTimeLayout)])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return rotated, true
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// removeOldSegments applies the MaxBackups and MaxAge retention rules
+func (w *RotatingWriter) removeOldSegments() error {
+	if w.policy.MaxBackups <= 0 && w.policy.MaxAge <= 0 {
+		return nil
+	}
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+
+	now := w.now()
+	for i, s := range segments {
+		tooMany := w.policy.MaxBackups > 0 && len(segments)-i > w.policy.MaxBackups
+		tooOld := w.policy.MaxAge > 0 && now.Sub(s.rotated) > w.policy.MaxAge
+		if tooMany || tooOld {
+			if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compressFile gzips path to path.gz and removes the original
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
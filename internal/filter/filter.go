@@ -0,0 +1,166 @@
+// Package filter decides which decoded payloads are allowed to reach the outputs
+// using allow and deny rules.
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/eotel/me19/internal/detection"
+	"github.com/eotel/me19/internal/payload"
+)
+
+// Rule matches a payload when every condition that is set holds.
+// A rule without any condition matches every payload.
+type Rule struct {
+	Name      string       // Used in rejection reasons; defaults to the rule position
+	Pattern   string       // Regular expression the text must match
+	Prefix    string       // Prefix the text must start with
+	Type      payload.Type // Required payload type (case-insensitive)
+	MinLength int          // Minimum length in characters (0 means no limit)
+	MaxLength int          // Maximum length in characters (0 means no limit)
+}
+
+// Policy is the set of rules applied to every detection.
+// Length limits are checked first, then deny rules, then allow rules.
+// Deny rules win over allow rules, and when allow rules are present
+// a payload must match at least one of them.
+type Policy struct {
+	Allow     []Rule
+	Deny      []Rule
+	MinLength int // Global minimum length in characters (0 means no limit)
+	MaxLength int // Global maximum length in characters (0 means no limit)
+}
+
+// Filter applies a compiled policy
+type Filter struct {
+	allow     []compiledRule
+	deny      []compiledRule
+	minLength int
+	maxLength int
+}
+
+type compiledRule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// New compiles the rules of policy
+func New(policy Policy) (*Filter, error) {
+	if policy.MinLength < 0 || policy.MaxLength < 0 {
+		return nil, fmt.Errorf("length limits must not be negative")
+	}
+	if policy.MaxLength > 0 && policy.MinLength > policy.MaxLength {
+		return nil, fmt.Errorf("min length (%d) exceeds max length (%d)", policy.MinLength, policy.MaxLength)
+	}
+
+	allow, err := compileRules("allow", policy.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := compileRules("deny", policy.Deny)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{
+		allow:     allow,
+		deny:      deny,
+		minLength: policy.MinLength,
+		maxLength: policy.MaxLength,
+	}, nil
+}
+
+// compileRules compiles the regular expressions and fills in default names
+func compileRules(list string, rules []Rule) ([]compiledRule, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("%s[%d]", list, i)
+		}
+		if rule.MinLength < 0 || rule.MaxLength < 0 {
+			return nil, fmt.Errorf("rule %s: length limits must not be negative", rule.Name)
+		}
+		if rule.Type != "" {
+			t, err := payload.ParseType(string(rule.Type))
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+			}
+			rule.Type = t
+		}
+		c := compiledRule{Rule: rule}
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: invalid pattern: %w", rule.Name, err)
+			}
+			c.re = re
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// Empty reports whether the filter accepts every payload
+func (f *Filter) Empty() bool {
+	return len(f.allow) == 0 && len(f.deny) == 0 && f.minLength == 0 && f.maxLength == 0
+}
+
+// Check returns nil if d may be output, or an error describing why it was rejected
+func (f *Filter) Check(d detection.Detection) error {
+	length := utf8.RuneCountInString(d.Code)
+	if f.minLength > 0 && length < f.minLength {
+		return fmt.Errorf("length %d is below the minimum of %d", length, f.minLength)
+	}
+	if f.maxLength > 0 && length > f.maxLength {
+		return fmt.Errorf("length %d exceeds the maximum of %d", length, f.maxLength)
+	}
+
+	// 拒否ルールは許可ルールより優先される
+	for _, rule := range f.deny {
+		if rule.matches(d, length) {
+			return fmt.Errorf("denied by rule %s", rule.Name)
+		}
+	}
+
+	if len(f.allow) == 0 {
+		return nil
+	}
+	for _, rule := range f.allow {
+		if rule.matches(d, length) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no allow rule matched")
+}
+
+// Name implements pipeline.Stage
+func (f *Filter) Name() string {
+	return "filter"
+}
+
+// Process implements pipeline.Stage
+func (f *Filter) Process(d detection.Detection) (detection.Detection, error) {
+	return d, f.Check(d)
+}
+
+// matches reports whether every condition of the rule holds for d
+func (r compiledRule) matches(d detection.Detection, length int) bool {
+	if r.Prefix != "" && !strings.HasPrefix(d.Code, r.Prefix) {
+		return false
+	}
+	if r.Type != "" && d.Type != r.Type {
+		return false
+	}
+	if r.MinLength > 0 && length < r.MinLength {
+		return false
+	}
+	if r.MaxLength > 0 && length > r.MaxLength {
+		return false
+	}
+	if r.re != nil && !r.re.MatchString(d.Code) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+	"github.com/eotel/me19/internal/payload"
+)
+
+func TestFilter_Check(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     Policy
+		code       string
+		wantReason string // 空文字列は許可を意味する
+	}{
+		{
+			name: "empty policy allows everything",
+			code: "anything",
+		},
+		{
+			name:   "allow by prefix",
+			policy: Policy{Allow: []Rule{{Prefix: "TICKET-"}}},
+			code:   "TICKET-42",
+		},
+		{
+			name:       "not in allowlist",
+			policy:     Policy{Allow: []Rule{{Prefix: "TICKET-"}}},
+			code:       "COUPON-1",
+			wantReason: "no allow rule matched",
+		},
+		{
+			name:   "any allow rule is enough",
+			policy: Policy{Allow: []Rule{{Prefix: "TICKET-"}, {Pattern: `^COUPON-\d+$`}}},
+			code:   "COUPON-1",
+		},
+		{
+			name:       "deny wins over allow",
+			policy:     Policy{Allow: []Rule{{Prefix: "TICKET-"}}, Deny: []Rule{{Name: "revoked", Pattern: `-13$`}}},
+			code:       "TICKET-13",
+			wantReason: "denied by rule revoked",
+		},
+		{
+			name:       "deny without allowlist",
+			policy:     Policy{Deny: []Rule{{Prefix: "javascript:"}}},
+			code:       "javascript:alert(1)",
+			wantReason: "denied by rule deny[0]",
+		},
+		{
+			name:   "all conditions of a rule must hold",
+			policy: Policy{Deny: []Rule{{Prefix: "TICKET-", MinLength: 20}}},
+			code:   "TICKET-42",
+		},
+		{
+			name:       "global length checked before rules",
+			policy:     Policy{Allow: []Rule{{Prefix: "TICKET-"}}, MaxLength: 8},
+			code:       "TICKET-42",
+			wantReason: "length 9 exceeds the maximum of 8",
+		},
+		{
+			name:       "global minimum length",
+			policy:     Policy{MinLength: 4},
+			code:       "abc",
+			wantReason: "length 3 is below the minimum of 4",
+		},
+		{
+			name:   "length counts characters",
+			policy: Policy{MaxLength: 3},
+			code:   "入場券",
+		},
+		{
+			name:   "allow by payload type",
+			policy: Policy{Allow: []Rule{{Type: payload.TypeURL}}},
+			code:   "https://example.com/",
+		},
+		{
+			name:       "payload type mismatch",
+			policy:     Policy{Allow: []Rule{{Type: payload.TypeURL}}},
+			code:       "WIFI:T:WPA;S:net;P:pass;;",
+			wantReason: "no allow rule matched",
+		},
+		{
+			name:   "payload type ignores case",
+			policy: Policy{Allow: []Rule{{Type: "URL"}}},
+			code:   "https://example.com/",
+		},
+		{
+			name:       "deny by rule length",
+			policy:     Policy{Deny: []Rule{{Name: "too long", MinLength: 10}}},
+			code:       strings.Repeat("x", 10),
+			wantReason: "denied by rule too long",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := New(tt.policy)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+
+			err = f.Check(detection.New(tt.code, nil, time.Time{}, nil))
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Errorf("Check(%q) rejected: %v", tt.code, err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Check(%q) should reject with %q", tt.code, tt.wantReason)
+			}
+			if err.Error() != tt.wantReason {
+				t.Errorf("Check(%q) reason = %q, want %q", tt.code, err.Error(), tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestNew_InvalidPolicy(t *testing.T) {
+	policies := map[string]Policy{
+		"invalid pattern":  {Allow: []Rule{{Pattern: "("}}},
+		"negative length":  {MaxLength: -1},
+		"min exceeds max":  {MinLength: 10, MaxLength: 5},
+		"negative in rule": {Deny: []Rule{{MinLength: -1}}},
+		"unknown type":     {Allow: []Rule{{Type: "link"}}},
+	}
+
+	for name, policy := range policies {
+		if _, err := New(policy); err == nil {
+			t.Errorf("%s: New should fail", name)
+		}
+	}
+}
+
+func TestFilter_Empty(t *testing.T) {
+	f, _ := New(Policy{})
+	if !f.Empty() {
+		t.Error("Filter without rules should be empty")
+	}
+	f, _ = New(Policy{Deny: []Rule{{Prefix: "x"}}})
+	if f.Empty() {
+		t.Error("Filter with rules should not be empty")
+	}
+}
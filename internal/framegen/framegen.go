@@ -0,0 +1,149 @@
+// Package framegen renders synthetic camera frames that show QR codes, so tests
+// can feed a detector frames it actually decodes: codes at given positions,
+// sizes and rotations, blurred and noisy frames, and blank frames.
+package framegen
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand/v2"
+
+	"github.com/eotel/me19/internal/qrtest"
+)
+
+const (
+	// DefaultSize is the side of a code, including its quiet zone, when Code.Size is zero
+	DefaultSize = 200
+	// background is the gray level of the frame behind the codes
+	background = 160
+)
+
+// Code is a QR code drawn on a frame
+type Code struct {
+	Payload  string  `json:"payload"`
+	X        int     `json:"x"` // Center of the code; the frame center if X and Y are both zero
+	Y        int     `json:"y"`
+	Size     int     `json:"size"`     // Side of the code including its quiet zone in pixels (DefaultSize if zero)
+	Rotation float64 `json:"rotation"` // Clockwise rotation in degrees
+}
+
+// Scene describes the content of a frame. A scene without codes is a blank frame.
+type Scene struct {
+	Codes []Code  `json:"codes"`
+	Blur  int     `json:"blur"`  // Radius of a box blur in pixels
+	Noise float64 `json:"noise"` // Amplitude of the uniform noise added to every pixel (0-255)
+	Seed  uint64  `json:"seed"`  // Seed of the noise, so the same scene always renders the same frame
+}
+
+// Render draws scene on a width by height grayscale frame
+func Render(scene Scene, width, height int) (*image.Gray, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid frame size %dx%d", width, height)
+	}
+	frame := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range frame.Pix {
+		frame.Pix[i] = background
+	}
+
+	for _, code := range scene.Codes {
+		if err := drawCode(frame, code); err != nil {
+			return nil, err
+		}
+	}
+	if scene.Blur > 0 {
+		frame = blur(frame, scene.Blur)
+	}
+	if scene.Noise > 0 {
+		addNoise(frame, scene.Noise, scene.Seed)
+	}
+	return frame, nil
+}
+
+// Encode renders scene and encodes it as PNG, the form camera backends return frames in
+func Encode(scene Scene, width, height int) ([]byte, error) {
+	frame, err := Render(scene, width, height)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, frame); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawCode draws a code rotated around its center, sampling the symbol at the
+// nearest pixel so the modules keep sharp edges
+func drawCode(frame *image.Gray, code Code) error {
+	size := code.Size
+	if size <= 0 {
+		size = DefaultSize
+	}
+	symbol, err := qrtest.Symbol(code.Payload, size, "")
+	if err != nil {
+		return fmt.Errorf("generating QR code %q: %w", code.Payload, err)
+	}
+
+	bounds := frame.Bounds()
+	cx, cy := float64(code.X), float64(code.Y)
+	if code.X == 0 && code.Y == 0 {
+		cx, cy = float64(bounds.Dx())/2, float64(bounds.Dy())/2
+	}
+	sb := symbol.Bounds()
+	half := math.Hypot(float64(sb.Dx()), float64(sb.Dy())) / 2
+	sin, cos := math.Sincos(code.Rotation * math.Pi / 180)
+
+	// 回転後の外接矩形内の各画素について、回転前のシンボル上の位置を求める
+	area := image.Rect(int(cx-half), int(cy-half), int(cx+half)+1, int(cy+half)+1).Intersect(bounds)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			sx := int(math.Floor(cos*dx+sin*dy+float64(sb.Dx())/2)) + sb.Min.X
+			sy := int(math.Floor(-sin*dx+cos*dy+float64(sb.Dy())/2)) + sb.Min.Y
+			if !(image.Point{sx, sy}).In(sb) {
+				continue
+			}
+			frame.SetGray(x, y, color.GrayModel.Convert(symbol.At(sx, sy)).(color.Gray))
+		}
+	}
+	return nil
+}
+
+// blur applies a box blur of the given radius, horizontally and then vertically
+func blur(frame *image.Gray, radius int) *image.Gray {
+	b := frame.Bounds()
+	pass := func(src *image.Gray, horizontal bool) *image.Gray {
+		dst := image.NewGray(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				sum, n := 0, 0
+				for d := -radius; d <= radius; d++ {
+					p := image.Pt(x, y+d)
+					if horizontal {
+						p = image.Pt(x+d, y)
+					}
+					if p.In(b) {
+						sum += int(src.GrayAt(p.X, p.Y).Y)
+						n++
+					}
+				}
+				dst.SetGray(x, y, color.Gray{Y: uint8(sum / n)})
+			}
+		}
+		return dst
+	}
+	return pass(pass(frame, true), false)
+}
+
+// addNoise adds uniform noise in [-amplitude, amplitude] to every pixel
+func addNoise(frame *image.Gray, amplitude float64, seed uint64) {
+	r := rand.New(rand.NewPCG(seed, 0))
+	for i, v := range frame.Pix {
+		n := float64(v) + (r.Float64()*2-1)*amplitude
+		frame.Pix[i] = uint8(math.Max(0, math.Min(255, math.Round(n))))
+	}
+}
@@ -0,0 +1,108 @@
+package framegen
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/eotel/me19/internal/qrcode"
+)
+
+// decode returns the payload the detector reads from frame, or an empty string
+func decode(t *testing.T, frame image.Image) (string, []image.Point) {
+	t.Helper()
+	detector := qrcode.New()
+	if err := detector.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer detector.Close()
+	results, err := detector.DetectImage(frame)
+	if err != nil {
+		t.Fatalf("DetectImage() error = %v", err)
+	}
+	if len(results) == 0 {
+		return "", nil
+	}
+	return results[0].Text, results[0].Points
+}
+
+func TestRender_Decodable(t *testing.T) {
+	tests := []struct {
+		name  string
+		scene Scene
+	}{
+		{"中央", Scene{Codes: []Code{{Payload: "https://example.com/ticket/1"}}}},
+		{"位置と大きさ", Scene{Codes: []Code{{Payload: "LEFT", X: 140, Y: 300, Size: 160}}}},
+		{"回転", Scene{Codes: []Code{{Payload: "ROTATED", Rotation: 30}}}},
+		{"上下逆", Scene{Codes: []Code{{Payload: "UPSIDE DOWN", Rotation: 180}}}},
+		{"ぼかし", Scene{Codes: []Code{{Payload: "BLURRED", Size: 240}}, Blur: 1}},
+		{"ノイズ", Scene{Codes: []Code{{Payload: "NOISY"}}, Noise: 40, Seed: 7}},
+		{"日本語", Scene{Codes: []Code{{Payload: "こんにちは"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := Render(tt.scene, 640, 480)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got, _ := decode(t, frame); got != tt.scene.Codes[0].Payload {
+				t.Errorf("decoded %q, want %q", got, tt.scene.Codes[0].Payload)
+			}
+		})
+	}
+}
+
+func TestRender_Position(t *testing.T) {
+	frame, err := Render(Scene{Codes: []Code{{Payload: "POSITION", X: 450, Y: 150, Size: 180}}}, 640, 480)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	_, points := decode(t, frame)
+	area := image.Rect(450-90, 150-90, 450+90, 150+90)
+	if len(points) == 0 {
+		t.Fatal("code not found")
+	}
+	for _, p := range points {
+		if !p.In(area) {
+			t.Errorf("point %v is outside the code at %v", p, area)
+		}
+	}
+}
+
+func TestRender_Blank(t *testing.T) {
+	frame, err := Render(Scene{Noise: 20, Seed: 1}, 320, 240)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if got, _ := decode(t, frame); got != "" {
+		t.Errorf("decoded %q from a blank frame", got)
+	}
+}
+
+func TestRender_Deterministic(t *testing.T) {
+	scene := Scene{Codes: []Code{{Payload: "SAME", Rotation: 10}}, Blur: 1, Noise: 30, Seed: 42}
+	first, err := Encode(scene, 320, 240)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	second, _ := Encode(scene, 320, 240)
+	if !bytes.Equal(first, second) {
+		t.Error("the same scene rendered different frames")
+	}
+	if _, err := png.Decode(bytes.NewReader(first)); err != nil {
+		t.Errorf("Encode() did not return a PNG: %v", err)
+	}
+
+	scene.Seed = 43
+	if third, _ := Encode(scene, 320, 240); bytes.Equal(first, third) {
+		t.Error("a different seed rendered the same frame")
+	}
+}
+
+func TestRender_InvalidSize(t *testing.T) {
+	if _, err := Render(Scene{}, 0, 480); err == nil {
+		t.Error("Render() with zero width should fail")
+	}
+}
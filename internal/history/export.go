@@ -0,0 +1,112 @@
+package history
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+// Format is an export format
+type Format string
+
+const (
+	// FormatCSV writes a header and one row per detection
+	FormatCSV Format = "csv"
+	// FormatJSONL writes one JSON object per line
+	FormatJSONL Format = "jsonl"
+)
+
+// ParseFormat converts a format name
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case FormatCSV:
+		return FormatCSV, nil
+	case FormatJSONL, "json":
+		return FormatJSONL, nil
+	}
+	return "", fmt.Errorf("unknown export format: %s", name)
+}
+
+// csvHeader lists the exported CSV columns
+var csvHeader = []string{"time", "device_id", "type", "code", "raw_base64", "fields"}
+
+// Export writes the detections matching q to w in the given format
+func (s *Store) Export(w io.Writer, format Format, q Query) error {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		err := s.Each(q, func(d detection.Detection) error {
+			return cw.Write(csvRecord(d))
+		})
+		if err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case FormatJSONL:
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		return s.Each(q, func(d detection.Detection) error {
+			return encoder.Encode(d)
+		})
+	}
+	return fmt.Errorf("unknown export format: %s", format)
+}
+
+func csvRecord(d detection.Detection) []string {
+	var raw, fields string
+	if len(d.Raw) > 0 {
+		raw = base64.StdEncoding.EncodeToString(d.Raw)
+	}
+	if len(d.Fields) > 0 {
+		data, _ := json.Marshal(d.Fields)
+		fields = string(data)
+	}
+	return []string{
+		d.Time.Format(time.RFC3339Nano),
+		strconv.Itoa(d.DeviceID),
+		string(d.Type),
+		d.Code,
+		raw,
+		fields,
+	}
+}
+
+// ParseTime parses a time given on the command line. Accepted forms are
+// RFC 3339, "2006-01-02 15:04:05", "2006-01-02" (local time) and a duration
+// before now such as "90m", "24h" or "7d".
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use RFC 3339, YYYY-MM-DD or a duration such as 24h or 7d)", value)
+}
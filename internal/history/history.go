@@ -0,0 +1,277 @@
+// Package history persists accepted detections in an embedded bbolt database
+// indexed by time and payload, so past scans can be queried on the device.
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+var (
+	// detectionsBucket maps time+sequence keys to JSON encoded detections
+	detectionsBucket = []byte("detections")
+	// codeIndexBucket maps length-prefixed code + time key to nothing
+	codeIndexBucket = []byte("by_code")
+)
+
+// ErrReadOnly is returned when adding to a store opened with OpenReadOnly
+var ErrReadOnly = errors.New("history is opened read-only")
+
+// lockTimeout is how long an operation waits for another process using the database
+const lockTimeout = 5 * time.Second
+
+// Store is a detection history database. The database file is opened for each
+// operation, so the scanner and the history command can use it at the same time.
+type Store struct {
+	path     string
+	readOnly bool
+}
+
+// Open creates the database at path if needed and returns a store for it
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	err := s.update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(detectionsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(codeIndexBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenReadOnly returns a store for the existing database at path. The file is
+// never created or changed and only the shared lock is taken, so queries do not
+// wait for or block other readers.
+func OpenReadOnly(path string) (*Store, error) {
+	s := &Store{path: path, readOnly: true}
+	if err := s.view(func(*bolt.Tx) error { return nil }); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path returns the database file path
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Add records a detection. A detection without a time is recorded at the current time.
+func (s *Store) Add(d detection.Detection) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if d.Time.IsZero() {
+		d.Time = time.Now()
+	}
+	value, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		detections := tx.Bucket(detectionsBucket)
+		seq, err := detections.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := timeKey(d.Time, seq)
+		if err := detections.Put(key, value); err != nil {
+			return err
+		}
+		return tx.Bucket(codeIndexBucket).Put(append(codePrefix(d.Code), key...), nil)
+	})
+}
+
+// Query selects detections from the history
+type Query struct {
+	Since      time.Time // Inclusive lower bound (zero means no bound)
+	Until      time.Time // Exclusive upper bound (zero means no bound)
+	Code       string    // Exact payload match (empty means any)
+	Limit      int       // Maximum number of results (0 means no limit)
+	Descending bool      // Newest first
+}
+
+// Find returns the detections matching q in time order
+func (s *Store) Find(q Query) ([]detection.Detection, error) {
+	var results []detection.Detection
+	err := s.Each(q, func(d detection.Detection) error {
+		results = append(results, d)
+		return nil
+	})
+	return results, err
+}
+
+// Each calls fn for every detection matching q in time order
+func (s *Store) Each(q Query, fn func(detection.Detection) error) error {
+	return s.view(func(tx *bolt.Tx) error {
+		detections := tx.Bucket(detectionsBucket)
+		if detections == nil {
+			// 読み取り専用で開いた、まだ何も記録されていないデータベース
+			return nil
+		}
+
+		// コードが指定された場合は索引を、そうでなければ時刻順のキーを走査する
+		var cursor *bolt.Cursor
+		var prefix []byte
+		if q.Code != "" {
+			cursor = tx.Bucket(codeIndexBucket).Cursor()
+			prefix = codePrefix(q.Code)
+		} else {
+			cursor = detections.Cursor()
+		}
+
+		lower := prefix
+		if !q.Since.IsZero() {
+			lower = append(append([]byte(nil), prefix...), timeKey(q.Since, 0)...)
+		}
+		var upper []byte
+		if !q.Until.IsZero() {
+			upper = append(append([]byte(nil), prefix...), timeKey(q.Until, 0)...)
+		}
+
+		inRange := func(k []byte) bool {
+			return k != nil && bytes.HasPrefix(k, prefix) &&
+				bytes.Compare(k, lower) >= 0 && (upper == nil || bytes.Compare(k, upper) < 0)
+		}
+
+		var k []byte
+		if q.Descending {
+			k = seekLast(cursor, prefix, upper)
+		} else {
+			k, _ = cursor.Seek(lower)
+		}
+
+		count := 0
+		for ; inRange(k); k = step(cursor, q.Descending) {
+			value := detections.Get(k[len(prefix):])
+			if value == nil {
+				continue
+			}
+			var d detection.Detection
+			if err := json.Unmarshal(value, &d); err != nil {
+				return fmt.Errorf("decoding history entry: %w", err)
+			}
+			if err := fn(d); err != nil {
+				return err
+			}
+			count++
+			if q.Limit > 0 && count >= q.Limit {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// seekLast positions the cursor on the last key below upper within prefix
+func seekLast(cursor *bolt.Cursor, prefix, upper []byte) []byte {
+	if upper == nil {
+		// プレフィックスの直後のキーに移動して1つ戻る
+		upper = prefixEnd(prefix)
+	}
+	if upper == nil {
+		k, _ := cursor.Last()
+		return k
+	}
+	k, _ := cursor.Seek(upper)
+	if k == nil {
+		k, _ = cursor.Last()
+		return k
+	}
+	k, _ = cursor.Prev()
+	return k
+}
+
+// prefixEnd returns the smallest key greater than every key starting with prefix
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func step(cursor *bolt.Cursor, descending bool) []byte {
+	var k []byte
+	if descending {
+		k, _ = cursor.Prev()
+	} else {
+		k, _ = cursor.Next()
+	}
+	return k
+}
+
+// Count returns the number of stored detections
+func (s *Store) Count() (int, error) {
+	count := 0
+	err := s.view(func(tx *bolt.Tx) error {
+		if detections := tx.Bucket(detectionsBucket); detections != nil {
+			count = detections.Stats().KeyN
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Name implements sink.Sink
+func (s *Store) Name() string {
+	return "history"
+}
+
+// Write implements sink.Sink
+func (s *Store) Write(d detection.Detection) error {
+	return s.Add(d)
+}
+
+// Close implements sink.Sink. The database is not kept open between operations.
+func (s *Store) Close() error {
+	return nil
+}
+
+func (s *Store) update(fn func(*bolt.Tx) error) error {
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		return fmt.Errorf("opening history %s: %w", s.path, err)
+	}
+	defer db.Close()
+	return db.Update(fn)
+}
+
+func (s *Store) view(fn func(*bolt.Tx) error) error {
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: lockTimeout, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("opening history %s: %w", s.path, err)
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+// timeKey orders entries by time; the sequence keeps keys for the same instant unique
+func timeKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// codePrefix is the index prefix for a payload. The length prefix keeps one
+// payload from matching another that merely starts with it.
+func codePrefix(code string) []byte {
+	prefix := make([]byte, 4, 4+len(code))
+	binary.BigEndian.PutUint32(prefix, uint32(len(code)))
+	return append(prefix, code...)
+}
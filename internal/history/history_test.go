@@ -0,0 +1,234 @@
+package history
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+var base = time.Date(2025, 5, 1, 9, 0, 0, 0, time.UTC)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// 9:00から1分ごとに記録する。TICKET-1 は3回読み取られている
+	codes := []string{"TICKET-1", "TICKET-2", "TICKET-1", "TICKET-10", "https://example.com/", "TICKET-1"}
+	for i, code := range codes {
+		d := detection.New(code, []byte(code), base.Add(time.Duration(i)*time.Minute), nil)
+		d.DeviceID = i % 2
+		if err := s.Add(d); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	return s
+}
+
+func codesAndMinutes(ds []detection.Detection) string {
+	var parts []string
+	for _, d := range ds {
+		parts = append(parts, d.Code+"@"+d.Time.UTC().Format("04"))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestStore_Find(t *testing.T) {
+	s := newTestStore(t)
+
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{name: "all", query: Query{}, want: "TICKET-1@00 TICKET-2@01 TICKET-1@02 TICKET-10@03 https://example.com/@04 TICKET-1@05"},
+		{name: "by code", query: Query{Code: "TICKET-1"}, want: "TICKET-1@00 TICKET-1@02 TICKET-1@05"},
+		{name: "by code newest first", query: Query{Code: "TICKET-1", Descending: true}, want: "TICKET-1@05 TICKET-1@02 TICKET-1@00"},
+		{name: "code prefix does not match", query: Query{Code: "TICKET"}, want: ""},
+		{name: "since", query: Query{Since: base.Add(3 * time.Minute)}, want: "TICKET-10@03 https://example.com/@04 TICKET-1@05"},
+		{name: "since and until", query: Query{Since: base.Add(time.Minute), Until: base.Add(3 * time.Minute)}, want: "TICKET-2@01 TICKET-1@02"},
+		{name: "code and since", query: Query{Code: "TICKET-1", Since: base.Add(time.Minute)}, want: "TICKET-1@02 TICKET-1@05"},
+		{name: "code until descending", query: Query{Code: "TICKET-1", Until: base.Add(5 * time.Minute), Descending: true}, want: "TICKET-1@02 TICKET-1@00"},
+		{name: "limit newest", query: Query{Limit: 2, Descending: true}, want: "TICKET-1@05 https://example.com/@04"},
+		{name: "limit oldest", query: Query{Limit: 2}, want: "TICKET-1@00 TICKET-2@01"},
+		{name: "unknown code", query: Query{Code: "NOPE"}, want: ""},
+		{name: "last code in index descending", query: Query{Code: "https://example.com/", Descending: true}, want: "https://example.com/@04"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Find(tt.query)
+			if err != nil {
+				t.Fatalf("Find failed: %v", err)
+			}
+			if codesAndMinutes(got) != tt.want {
+				t.Errorf("Find() = %q, want %q", codesAndMinutes(got), tt.want)
+			}
+		})
+	}
+
+	got, _ := s.Find(Query{Code: "https://example.com/"})
+	if len(got) != 1 || got[0].Type != "url" || got[0].DeviceID != 0 || string(got[0].Raw) != "https://example.com/" {
+		t.Errorf("Stored detection should keep its metadata, got %+v", got)
+	}
+	if n, _ := s.Count(); n != 6 {
+		t.Errorf("Count() = %d, want 6", n)
+	}
+}
+
+func TestStore_Reopen(t *testing.T) {
+	s := newTestStore(t)
+
+	// 別のプロセスからの利用を想定して同じファイルを開き直す
+	reopened, err := Open(s.Path())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := reopened.Write(detection.New("LATE", nil, base.Add(time.Hour), nil)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	got, _ := s.Find(Query{Descending: true, Limit: 1})
+	if len(got) != 1 || got[0].Code != "LATE" {
+		t.Errorf("Expected the latest entry from the other store, got %v", got)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	s := newTestStore(t)
+	before, _ := os.ReadFile(s.Path())
+
+	// 別のプロセスが読み取り専用で開いている間も開ける（共有ロックのみを取る）
+	other, err := bolt.Open(s.Path(), 0644, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("bolt.Open failed: %v", err)
+	}
+	defer other.Close()
+
+	readOnly, err := OpenReadOnly(s.Path())
+	if err != nil {
+		t.Fatalf("OpenReadOnly failed: %v", err)
+	}
+	if got, err := readOnly.Find(Query{Code: "TICKET-1"}); err != nil || len(got) != 3 {
+		t.Errorf("Find() = %d detections, %v, want 3", len(got), err)
+	}
+	if err := readOnly.Add(detection.New("LATE", nil, base, nil)); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Add() = %v, want ErrReadOnly", err)
+	}
+	if after, _ := os.ReadFile(s.Path()); !bytes.Equal(before, after) {
+		t.Error("The database file should not change")
+	}
+
+	if _, err := OpenReadOnly(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Error("OpenReadOnly should fail for a missing database")
+	}
+	if _, err := os.Stat(filepath.Join(t.TempDir(), "missing.db")); !os.IsNotExist(err) {
+		t.Error("OpenReadOnly should not create the database")
+	}
+}
+
+func TestOpenReadOnly_EmptyDatabase(t *testing.T) {
+	// me19以外が作成したバケットのないデータベースでも空の結果を返す
+	path := filepath.Join(t.TempDir(), "empty.db")
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open failed: %v", err)
+	}
+	db.Close()
+
+	s, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("OpenReadOnly failed: %v", err)
+	}
+	if got, err := s.Find(Query{}); err != nil || len(got) != 0 {
+		t.Errorf("Find() = %v, %v", got, err)
+	}
+	if count, err := s.Count(); err != nil || count != 0 {
+		t.Errorf("Count() = %d, %v", count, err)
+	}
+}
+
+func TestStore_Export(t *testing.T) {
+	s := newTestStore(t)
+
+	var buf bytes.Buffer
+	if err := s.Export(&buf, FormatCSV, Query{Code: "TICKET-1"}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+	if len(rows) != 4 || strings.Join(rows[0], ",") != "time,device_id,type,code,raw_base64,fields" {
+		t.Fatalf("Unexpected CSV: %v", rows)
+	}
+	if rows[1][0] != "2025-05-01T09:00:00Z" || rows[1][1] != "0" || rows[1][3] != "TICKET-1" || rows[1][4] != "VElDS0VULTE=" {
+		t.Errorf("Unexpected row: %v", rows[1])
+	}
+
+	buf.Reset()
+	if err := s.Export(&buf, FormatJSONL, Query{Since: base.Add(4 * time.Minute)}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", buf.String())
+	}
+	var d detection.Detection
+	if err := json.Unmarshal([]byte(lines[0]), &d); err != nil || d.Code != "https://example.com/" || d.Fields["host"] != "example.com" {
+		t.Errorf("Unexpected JSON line %q (%v)", lines[0], err)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	jst := time.FixedZone("JST", 9*3600)
+	now := time.Date(2025, 5, 10, 12, 0, 0, 0, jst)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"", time.Time{}},
+		{"24h", now.Add(-24 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"7d", now.AddDate(0, 0, -7)},
+		{"2025-05-01", time.Date(2025, 5, 1, 0, 0, 0, 0, jst)},
+		{"2025-05-01 08:30", time.Date(2025, 5, 1, 8, 30, 0, 0, jst)},
+		{"2025-05-01T08:30:00Z", time.Date(2025, 5, 1, 8, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.value, now)
+		if err != nil {
+			t.Errorf("ParseTime(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	if _, err := ParseTime("yesterday", now); err == nil {
+		t.Error("ParseTime should reject unknown formats")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, want := range map[string]Format{"csv": FormatCSV, "JSONL": FormatJSONL, "json": FormatJSONL} {
+		if got, err := ParseFormat(name); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v", name, got, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("ParseFormat should reject unknown formats")
+	}
+}
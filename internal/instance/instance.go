@@ -0,0 +1,118 @@
+// Package instance makes sure that only one scanner writes to an output at a time.
+// It holds an advisory lock (flock on Unix, LockFileEx on Windows) on a lock file
+// next to the output and records the owner's PID in a PID file.
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// ErrLocked is matched by the error returned when another instance holds the lock
+var ErrLocked = errors.New("another instance holds the lock")
+
+// LockedError reports the instance that holds a lock
+type LockedError struct {
+	Path string // Lock file
+	PID  int    // Process ID of the owner (0 if unknown)
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("%s is locked by another instance", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by another instance (PID %d)", e.Path, e.PID)
+}
+
+// Unwrap lets errors.Is match ErrLocked
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// Lock is a held single-instance lock
+type Lock struct {
+	flock   *flock.Flock
+	pidPath string
+}
+
+// LockPath returns the lock file used for an output file
+func LockPath(target string) string {
+	return target + ".lock"
+}
+
+// pidPath returns the PID file that belongs to a lock file
+func pidPath(lockPath string) string {
+	return strings.TrimSuffix(lockPath, ".lock") + ".pid"
+}
+
+// terminate asks a process to exit; replaced in tests
+var terminate = terminateProcess
+
+// Acquire takes the lock at path without waiting. If another instance holds it
+// the error is a *LockedError naming the owner's PID.
+func Acquire(path string) (*Lock, error) {
+	f := flock.New(path)
+	ok, err := f.TryLock()
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+	if !ok {
+		pid, _ := ReadPID(path)
+		return nil, &LockedError{Path: path, PID: pid}
+	}
+
+	l := &Lock{flock: f, pidPath: pidPath(path)}
+	if err := os.WriteFile(l.pidPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		f.Unlock()
+		return nil, fmt.Errorf("writing PID file: %w", err)
+	}
+	return l, nil
+}
+
+// TakeOver takes the lock at path, asking the instance holding it to exit and
+// waiting up to timeout for it to release the lock.
+func TakeOver(path string, timeout time.Duration) (*Lock, error) {
+	l, err := Acquire(path)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		return l, err
+	}
+	if locked.PID == 0 || locked.PID == os.Getpid() {
+		return nil, err
+	}
+	if err := terminate(locked.PID); err != nil {
+		return nil, fmt.Errorf("signaling PID %d: %w", locked.PID, err)
+	}
+
+	// 前のインスタンスがロックを解放するまで待つ
+	deadline := time.Now().Add(timeout)
+	for {
+		l, err := Acquire(path)
+		if !errors.As(err, &locked) || time.Now().After(deadline) {
+			return l, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// ReadPID returns the PID recorded for the lock at path
+func ReadPID(path string) (int, error) {
+	data, err := os.ReadFile(pidPath(path))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// Release removes the PID file and releases the lock
+func (l *Lock) Release() error {
+	// ロックを保持している間にPIDファイルを削除する
+	os.Remove(l.pidPath)
+	return l.flock.Unlock()
+}
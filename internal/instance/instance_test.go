@@ -0,0 +1,100 @@
+package instance
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	path := LockPath(filepath.Join(t.TempDir(), "code.txt"))
+
+	first, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if pid, err := ReadPID(path); err != nil || pid != os.Getpid() {
+		t.Errorf("ReadPID() = %d, %v; want %d", pid, err, os.Getpid())
+	}
+
+	// 2つ目のインスタンスは所有者のPIDを含むエラーになる
+	_, err = Acquire(path)
+	var locked *LockedError
+	if !errors.As(err, &locked) || !errors.Is(err, ErrLocked) {
+		t.Fatalf("Acquire() on a held lock error = %v, want *LockedError", err)
+	}
+	if locked.PID != os.Getpid() {
+		t.Errorf("LockedError.PID = %d, want %d", locked.PID, os.Getpid())
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, err := os.Stat(pidPath(path)); !os.IsNotExist(err) {
+		t.Errorf("PID file should be removed on release, stat error = %v", err)
+	}
+
+	second, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire() after release error = %v", err)
+	}
+	second.Release()
+}
+
+func TestTakeOver(t *testing.T) {
+	path := LockPath(filepath.Join(t.TempDir(), "code.txt"))
+	old, err := Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 別プロセスが保持しているように見せる
+	if err := os.WriteFile(pidPath(path), []byte("4242\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var signaled int
+	defer func(orig func(int) error) { terminate = orig }(terminate)
+	terminate = func(pid int) error {
+		signaled = pid
+		// 終了処理の後にロックを解放する
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			old.Release()
+		}()
+		return nil
+	}
+
+	l, err := TakeOver(path, 5*time.Second)
+	if err != nil {
+		t.Fatalf("TakeOver() error = %v", err)
+	}
+	defer l.Release()
+	if signaled != 4242 {
+		t.Errorf("signaled PID %d, want 4242", signaled)
+	}
+	if pid, _ := ReadPID(path); pid != os.Getpid() {
+		t.Errorf("ReadPID() after takeover = %d, want %d", pid, os.Getpid())
+	}
+}
+
+func TestTakeOver_Timeout(t *testing.T) {
+	path := LockPath(filepath.Join(t.TempDir(), "code.txt"))
+	old, err := Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Release()
+	if err := os.WriteFile(pidPath(path), []byte("4242\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(orig func(int) error) { terminate = orig }(terminate)
+	terminate = func(int) error { return nil }
+
+	// 所有者が終了しなければタイムアウトでエラーになる
+	if _, err := TakeOver(path, 300*time.Millisecond); !errors.Is(err, ErrLocked) {
+		t.Errorf("TakeOver() error = %v, want ErrLocked", err)
+	}
+}
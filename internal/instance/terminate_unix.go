@@ -0,0 +1,17 @@
+//go:build !windows
+
+package instance
+
+import (
+	"os"
+	"syscall"
+)
+
+// terminateProcess sends SIGTERM so the process can shut down gracefully
+func terminateProcess(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package instance
+
+import "os"
+
+// terminateProcess stops the process. Windows cannot deliver SIGTERM to another
+// process, so it is terminated immediately.
+func terminateProcess(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
@@ -0,0 +1,223 @@
+package payload
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// groupSeparator is the FNC1 separator between variable-length GS1 element strings
+const groupSeparator = "\x1d"
+
+// gs1SymbologyPrefixes are the symbology identifiers that mark FNC1 (GS1) mode
+var gs1SymbologyPrefixes = []string{"]Q3", "]C1", "]d2", "]e0"}
+
+var gs1HumanReadable = regexp.MustCompile(`^(\(\d{2,4}\)[^()]+)+$`)
+var gs1Element = regexp.MustCompile(`\((\d{2,4})\)([^()]+)`)
+
+// parseGS1 parses GS1 element strings in either the human-readable "(01)...(17)..."
+// form or the raw FNC1 form with group separators. Fields are keyed by application identifier.
+// Every element must use a known application identifier with data of its format.
+func parseGS1(text string) (map[string]string, bool) {
+	if gs1HumanReadable.MatchString(text) {
+		fields := make(map[string]string)
+		for _, m := range gs1Element.FindAllStringSubmatch(text, -1) {
+			if !validGS1Element(m[1], m[2]) {
+				return nil, false
+			}
+			fields[m[1]] = m[2]
+		}
+		return fields, true
+	}
+
+	raw := text
+	prefixed := false
+	for _, prefix := range gs1SymbologyPrefixes {
+		if strings.HasPrefix(raw, prefix) {
+			raw = raw[len(prefix):]
+			prefixed = true
+			break
+		}
+	}
+	// 識別子もグループセパレータもない数字列は通常のテキストとして扱う
+	if !prefixed && !strings.Contains(raw, groupSeparator) {
+		return nil, false
+	}
+	return parseGS1Raw(strings.TrimPrefix(raw, groupSeparator))
+}
+
+// parseGS1Raw parses concatenated element strings using the predefined AI lengths
+func parseGS1Raw(raw string) (map[string]string, bool) {
+	fields := make(map[string]string)
+	for raw != "" {
+		if len(raw) < 2 || !isDigits(raw[:2]) {
+			return nil, false
+		}
+		aiLen := gs1AILength(raw[:2])
+		if len(raw) < aiLen || !isDigits(raw[:aiLen]) {
+			return nil, false
+		}
+		ai := raw[:aiLen]
+		raw = raw[aiLen:]
+
+		var value string
+		if n, fixed := gs1FixedLength(ai); fixed {
+			if len(raw) < n {
+				return nil, false
+			}
+			value, raw = raw[:n], raw[n:]
+			raw = strings.TrimPrefix(raw, groupSeparator)
+		} else {
+			var rest string
+			value, rest, _ = strings.Cut(raw, groupSeparator)
+			raw = rest
+		}
+		if !validGS1Element(ai, value) {
+			return nil, false
+		}
+		fields[ai] = value
+	}
+	return fields, len(fields) > 0
+}
+
+// gs1AILength returns the length of the application identifier starting with prefix
+func gs1AILength(prefix string) int {
+	switch {
+	case prefix >= "00" && prefix <= "22":
+		return 2
+	case prefix >= "23" && prefix <= "29":
+		return 3
+	case prefix == "30" || prefix == "37":
+		return 2
+	case prefix >= "31" && prefix <= "36", prefix == "39":
+		return 4
+	case prefix >= "40" && prefix <= "49":
+		return 3
+	case prefix == "71":
+		return 3
+	case prefix >= "70" && prefix <= "89":
+		return 4
+	case prefix >= "90":
+		return 2
+	}
+	return 2
+}
+
+// gs1FixedLength returns the data length of application identifiers with predefined length
+func gs1FixedLength(ai string) (int, bool) {
+	switch ai[:2] {
+	case "00":
+		return 18, true
+	case "01", "02", "03":
+		return 14, true
+	case "04":
+		return 16, true
+	case "11", "12", "13", "14", "15", "16", "17", "18", "19":
+		return 6, true
+	case "20":
+		return 2, true
+	case "31", "32", "33", "34", "35", "36":
+		return 6, true
+	case "41":
+		return 13, true
+	}
+	return 0, false
+}
+
+// gs1Format describes the data of an application identifier
+type gs1Format struct {
+	numeric  bool // Digits only; otherwise characters of GS1 character set 82
+	min, max int  // Length of the data
+}
+
+func gs1N(min, max int) gs1Format { return gs1Format{numeric: true, min: min, max: max} }
+func gs1X(min, max int) gs1Format { return gs1Format{min: min, max: max} }
+
+// gs1Formats are the application identifiers of the GS1 General Specifications
+// and their data formats. Identifiers ending in a digit that gives the decimal
+// point or a sequence number are in gs1Families.
+var gs1Formats = map[string]gs1Format{
+	"00": gs1N(18, 18), "01": gs1N(14, 14), "02": gs1N(14, 14), "03": gs1N(14, 14),
+	"10": gs1X(1, 20), "11": gs1N(6, 6), "12": gs1N(6, 6), "13": gs1N(6, 6), "15": gs1N(6, 6), "16": gs1N(6, 6), "17": gs1N(6, 6),
+	"20": gs1N(2, 2), "21": gs1X(1, 20), "22": gs1X(1, 20),
+	"235": gs1X(1, 28), "240": gs1X(1, 30), "241": gs1X(1, 30), "242": gs1N(1, 6), "243": gs1X(1, 20),
+	"250": gs1X(1, 30), "251": gs1X(1, 30), "253": gs1X(13, 30), "254": gs1X(1, 20), "255": gs1N(13, 25),
+	"30": gs1N(1, 8), "37": gs1N(1, 8),
+	"400": gs1X(1, 30), "401": gs1X(1, 30), "402": gs1N(17, 17), "403": gs1X(1, 30),
+	"410": gs1N(13, 13), "411": gs1N(13, 13), "412": gs1N(13, 13), "413": gs1N(13, 13),
+	"414": gs1N(13, 13), "415": gs1N(13, 13), "416": gs1N(13, 13), "417": gs1N(13, 13),
+	"420": gs1X(1, 20), "421": gs1X(4, 12), "422": gs1N(3, 3), "423": gs1N(3, 15),
+	"424": gs1N(3, 3), "425": gs1N(3, 15), "426": gs1N(3, 3), "427": gs1X(1, 3),
+	"7001": gs1N(13, 13), "7002": gs1X(1, 30), "7003": gs1N(10, 10), "7004": gs1N(1, 4), "7005": gs1X(1, 12),
+	"7006": gs1N(6, 6), "7007": gs1N(6, 12), "7008": gs1X(1, 3), "7009": gs1X(1, 10), "7010": gs1X(1, 2),
+	"7011": gs1N(6, 10), "7020": gs1X(1, 20), "7021": gs1X(1, 20), "7022": gs1X(1, 20), "7023": gs1X(1, 30),
+	"7040": gs1X(4, 4), "7240": gs1X(1, 20),
+	"710": gs1X(1, 20), "711": gs1X(1, 20), "712": gs1X(1, 20), "713": gs1X(1, 20), "714": gs1X(1, 20), "715": gs1X(1, 20), "716": gs1X(1, 20),
+	"8001": gs1N(14, 14), "8002": gs1X(1, 20), "8003": gs1X(15, 30), "8004": gs1X(1, 30), "8005": gs1N(6, 6),
+	"8006": gs1N(18, 18), "8007": gs1X(1, 34), "8008": gs1N(8, 12), "8009": gs1X(1, 50), "8010": gs1X(1, 30),
+	"8011": gs1N(1, 12), "8012": gs1X(1, 20), "8013": gs1X(1, 25), "8017": gs1N(18, 18), "8018": gs1N(18, 18),
+	"8019": gs1N(1, 10), "8020": gs1X(1, 25), "8026": gs1N(18, 18),
+	"8110": gs1X(1, 70), "8111": gs1N(4, 4), "8112": gs1X(1, 70), "8200": gs1X(1, 70),
+	"90": gs1X(1, 30), "91": gs1X(1, 90), "92": gs1X(1, 90), "93": gs1X(1, 90), "94": gs1X(1, 90),
+	"95": gs1X(1, 90), "96": gs1X(1, 90), "97": gs1X(1, 90), "98": gs1X(1, 90), "99": gs1X(1, 90),
+}
+
+// gs1Family is a group of four-digit application identifiers sharing the first
+// three digits, whose last digit ranges from 0 to last
+type gs1Family struct {
+	gs1Format
+	last byte
+}
+
+// gs1Families are the application identifiers such as 3102 (net weight in kg
+// with two decimals), keyed by their first three digits
+var gs1Families = func() map[string]gs1Family {
+	families := map[string]gs1Family{
+		"390": {gs1N(1, 15), '9'}, "391": {gs1N(4, 18), '9'}, "392": {gs1N(1, 15), '9'},
+		"393": {gs1N(4, 18), '9'}, "394": {gs1N(4, 4), '9'}, "395": {gs1N(6, 6), '5'},
+		"703": {gs1X(4, 30), '9'},
+	}
+	// 計量値（3桁目までが単位、4桁目が小数点の位置）
+	for _, r := range [][2]int{{310, 316}, {320, 329}, {330, 337}, {340, 349}, {350, 357}, {360, 369}} {
+		for prefix := r[0]; prefix <= r[1]; prefix++ {
+			families[strconv.Itoa(prefix)] = gs1Family{gs1N(6, 6), '5'}
+		}
+	}
+	return families
+}()
+
+// gs1CharSet82 are the characters allowed in alphanumeric GS1 data
+const gs1CharSet82 = `!"%&'()*+,-./0123456789:;<=>?ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz`
+
+// validGS1Element reports whether ai is a known application identifier and
+// value is data of its format
+func validGS1Element(ai, value string) bool {
+	format, ok := gs1Formats[ai]
+	if !ok && len(ai) == 4 {
+		var family gs1Family
+		family, ok = gs1Families[ai[:3]]
+		ok = ok && ai[3] >= '0' && ai[3] <= family.last
+		format = family.gs1Format
+	}
+	if !ok || len(value) < format.min || len(value) > format.max {
+		return false
+	}
+	if format.numeric {
+		return isDigits(value)
+	}
+	for i := 0; i < len(value); i++ {
+		if strings.IndexByte(gs1CharSet82, value[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
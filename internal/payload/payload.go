@@ -0,0 +1,400 @@
+// Package payload classifies decoded QR code text into well-known content formats.
+package payload
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Type identifies the content format of a payload
+type Type string
+
+const (
+	TypeText    Type = "text"    // Plain text that matched no other format
+	TypeURL     Type = "url"     // http:// or https:// URL
+	TypeWiFi    Type = "wifi"    // WIFI: network configuration
+	TypeVCard   Type = "vcard"   // BEGIN:VCARD contact
+	TypeMeCard  Type = "mecard"  // MECARD: contact
+	TypeOTPAuth Type = "otpauth" // otpauth:// one-time password provisioning URI
+	TypeGeo     Type = "geo"     // geo: location
+	TypeSMS     Type = "sms"     // sms: / SMSTO: message
+	TypeTel     Type = "tel"     // tel: phone number
+	TypeEmail   Type = "email"   // mailto:, MATMSG: or a bare e-mail address
+	TypeGS1     Type = "gs1"     // GS1 element string
+)
+
+// Types returns every payload type
+func Types() []Type {
+	return []Type{TypeText, TypeURL, TypeWiFi, TypeVCard, TypeMeCard, TypeOTPAuth, TypeGeo, TypeSMS, TypeTel, TypeEmail, TypeGS1}
+}
+
+// ParseType returns the payload type named name, ignoring case
+func ParseType(name string) (Type, error) {
+	for _, t := range Types() {
+		if strings.EqualFold(name, string(t)) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown payload type %q", name)
+}
+
+// Payload is the structured interpretation of a decoded QR code
+type Payload struct {
+	Type   Type              `json:"type"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Parse classifies text and extracts the fields of its format.
+// Text that does not match any known format is returned as TypeText without fields.
+func Parse(text string) Payload {
+	switch {
+	case hasPrefixFold(text, "HTTP://"), hasPrefixFold(text, "HTTPS://"):
+		if fields, ok := parseURL(text); ok {
+			return Payload{Type: TypeURL, Fields: fields}
+		}
+	case hasPrefixFold(text, "WIFI:"):
+		return Payload{Type: TypeWiFi, Fields: parseWiFi(text[len("WIFI:"):])}
+	case hasPrefixFold(text, "BEGIN:VCARD"):
+		return Payload{Type: TypeVCard, Fields: parseVCard(text)}
+	case hasPrefixFold(text, "MECARD:"):
+		return Payload{Type: TypeMeCard, Fields: parseMeCard(text[len("MECARD:"):])}
+	case hasPrefixFold(text, "OTPAUTH://"):
+		if fields, ok := parseOTPAuth(text); ok {
+			return Payload{Type: TypeOTPAuth, Fields: fields}
+		}
+	case hasPrefixFold(text, "GEO:"):
+		if fields, ok := parseGeo(text[len("GEO:"):]); ok {
+			return Payload{Type: TypeGeo, Fields: fields}
+		}
+	case hasPrefixFold(text, "SMSTO:"):
+		return Payload{Type: TypeSMS, Fields: parseSMSTo(text[len("SMSTO:"):])}
+	case hasPrefixFold(text, "SMS:"):
+		return Payload{Type: TypeSMS, Fields: parseSMS(text[len("SMS:"):])}
+	case hasPrefixFold(text, "TEL:"):
+		return Payload{Type: TypeTel, Fields: map[string]string{"number": text[len("TEL:"):]}}
+	case hasPrefixFold(text, "MAILTO:"):
+		return Payload{Type: TypeEmail, Fields: parseMailto(text)}
+	case hasPrefixFold(text, "MATMSG:"):
+		return Payload{Type: TypeEmail, Fields: parseMatMsg(text[len("MATMSG:"):])}
+	}
+
+	if isEmailAddress(text) {
+		return Payload{Type: TypeEmail, Fields: map[string]string{"to": text}}
+	}
+	if fields, ok := parseGS1(text); ok {
+		return Payload{Type: TypeGS1, Fields: fields}
+	}
+
+	return Payload{Type: TypeText}
+}
+
+// hasPrefixFold reports whether text begins with prefix, ignoring case. Only the
+// bytes of text that the prefix covers are compared, so that the rest of text
+// can be sliced at len(prefix) even when case folding changes its length.
+func hasPrefixFold(text, prefix string) bool {
+	return len(text) >= len(prefix) && strings.EqualFold(text[:len(prefix)], prefix)
+}
+
+// parseURL extracts the components of an http(s) URL
+func parseURL(text string) (map[string]string, bool) {
+	u, err := url.Parse(text)
+	if err != nil || u.Host == "" {
+		return nil, false
+	}
+
+	fields := map[string]string{
+		"scheme": strings.ToLower(u.Scheme),
+		"host":   strings.ToLower(u.Hostname()),
+		"path":   u.Path,
+	}
+	if port := u.Port(); port != "" {
+		fields["port"] = port
+	}
+	if u.RawQuery != "" {
+		fields["query"] = u.RawQuery
+	}
+	if u.Fragment != "" {
+		fields["fragment"] = u.Fragment
+	}
+	return fields, true
+}
+
+// parseWiFi parses the body of a WIFI:T:WPA;S:ssid;P:password;H:true;; payload
+func parseWiFi(body string) map[string]string {
+	fields := make(map[string]string)
+	for key, value := range keyValueFields(body) {
+		switch key {
+		case "T":
+			fields["security"] = value
+		case "S":
+			fields["ssid"] = value
+		case "P":
+			fields["password"] = value
+		case "H":
+			fields["hidden"] = strings.ToLower(value)
+		}
+	}
+	return fields
+}
+
+// parseMeCard parses the body of a MECARD:N:name;TEL:number;; payload
+func parseMeCard(body string) map[string]string {
+	names := map[string]string{
+		"N":        "name",
+		"SOUND":    "sound",
+		"TEL":      "tel",
+		"EMAIL":    "email",
+		"ADR":      "address",
+		"URL":      "url",
+		"NOTE":     "note",
+		"BDAY":     "birthday",
+		"NICKNAME": "nickname",
+		"ORG":      "org",
+	}
+
+	fields := make(map[string]string)
+	for key, value := range keyValueFields(body) {
+		if name, ok := names[key]; ok {
+			// "N:姓,名" は表示用にカンマを空白に置き換える
+			if key == "N" {
+				value = strings.Join(strings.Split(value, ","), " ")
+			}
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// parseVCard extracts the commonly used properties of a vCard.
+// When a property appears more than once the first occurrence wins.
+func parseVCard(text string) map[string]string {
+	names := map[string]string{
+		"FN":    "name",
+		"TEL":   "tel",
+		"EMAIL": "email",
+		"ORG":   "org",
+		"TITLE": "title",
+		"URL":   "url",
+		"ADR":   "address",
+		"NOTE":  "note",
+		"BDAY":  "birthday",
+	}
+
+	fields := make(map[string]string)
+	var structuredName string
+	for _, line := range unfoldLines(text) {
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		// "TEL;TYPE=CELL:..." のようなパラメータ付きプロパティ名を処理
+		property := strings.ToUpper(line[:colon])
+		if semi := strings.IndexByte(property, ';'); semi >= 0 {
+			property = property[:semi]
+		}
+		value := line[colon+1:]
+
+		switch property {
+		case "N":
+			// 姓;名;ミドルネーム;敬称;接尾辞
+			parts := strings.Split(value, ";")
+			if len(parts) > 1 {
+				parts[0], parts[1] = parts[1], parts[0]
+			}
+			structuredName = strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+		case "ADR":
+			value = strings.Join(strings.Fields(strings.ReplaceAll(value, ";", " ")), " ")
+		}
+
+		if name, ok := names[property]; ok {
+			if _, exists := fields[name]; !exists {
+				fields[name] = value
+			}
+		}
+	}
+
+	if _, ok := fields["name"]; !ok && structuredName != "" {
+		fields["name"] = structuredName
+	}
+	return fields
+}
+
+// parseOTPAuth parses otpauth://totp/Issuer:account?secret=...&issuer=...
+func parseOTPAuth(text string) (map[string]string, bool) {
+	u, err := url.Parse(text)
+	if err != nil || u.Host == "" {
+		return nil, false
+	}
+
+	fields := map[string]string{
+		"kind": strings.ToLower(u.Host),
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	fields["label"] = label
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		fields["issuer"] = issuer
+		fields["account"] = strings.TrimSpace(account)
+	} else {
+		fields["account"] = label
+	}
+
+	query := u.Query()
+	for _, key := range []string{"secret", "issuer", "algorithm", "digits", "period", "counter"} {
+		if value := query.Get(key); value != "" {
+			fields[key] = value
+		}
+	}
+	return fields, true
+}
+
+// parseGeo parses the body of geo:lat,lon[,alt][;params][?q=query]
+func parseGeo(body string) (map[string]string, bool) {
+	coords, rawQuery, _ := strings.Cut(body, "?")
+	coords, _, _ = strings.Cut(coords, ";")
+
+	parts := strings.Split(coords, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, false
+	}
+	keys := []string{"latitude", "longitude", "altitude"}
+	fields := make(map[string]string)
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if _, err := strconv.ParseFloat(part, 64); err != nil {
+			return nil, false
+		}
+		fields[keys[i]] = part
+	}
+
+	if query, err := url.ParseQuery(rawQuery); err == nil {
+		if q := query.Get("q"); q != "" {
+			fields["query"] = q
+		}
+	}
+	return fields, true
+}
+
+// parseSMS parses the body of sms:number?body=message
+func parseSMS(body string) map[string]string {
+	number, rawQuery, _ := strings.Cut(body, "?")
+	fields := map[string]string{"number": number}
+	if query, err := url.ParseQuery(rawQuery); err == nil {
+		if message := query.Get("body"); message != "" {
+			fields["body"] = message
+		}
+	}
+	return fields
+}
+
+// parseSMSTo parses the body of SMSTO:number:message
+func parseSMSTo(body string) map[string]string {
+	number, message, _ := strings.Cut(body, ":")
+	fields := map[string]string{"number": number}
+	if message != "" {
+		fields["body"] = message
+	}
+	return fields
+}
+
+// parseMailto parses mailto:address?subject=...&body=...
+func parseMailto(text string) map[string]string {
+	fields := make(map[string]string)
+	u, err := url.Parse(text)
+	if err != nil {
+		fields["to"] = text[len("mailto:"):]
+		return fields
+	}
+
+	fields["to"] = u.Opaque
+	query := u.Query()
+	for _, key := range []string{"subject", "body", "cc", "bcc"} {
+		if value := query.Get(key); value != "" {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// parseMatMsg parses the body of MATMSG:TO:address;SUB:subject;BODY:message;;
+func parseMatMsg(body string) map[string]string {
+	fields := make(map[string]string)
+	for key, value := range keyValueFields(body) {
+		switch key {
+		case "TO":
+			fields["to"] = value
+		case "SUB":
+			fields["subject"] = value
+		case "BODY":
+			fields["body"] = value
+		}
+	}
+	return fields
+}
+
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// isEmailAddress reports whether text is a single bare e-mail address
+func isEmailAddress(text string) bool {
+	if !emailPattern.MatchString(text) {
+		return false
+	}
+	addr, err := mail.ParseAddress(text)
+	return err == nil && addr.Address == text
+}
+
+// keyValueFields splits "K:v;K2:v2;;" style bodies used by WIFI, MECARD and MATMSG.
+// Backslash escapes the separators, and keys are upper-cased. The first occurrence of a key wins.
+func keyValueFields(body string) map[string]string {
+	fields := make(map[string]string)
+	for _, field := range splitEscaped(body, ';') {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok || key == "" {
+			continue
+		}
+		key = strings.ToUpper(key)
+		if _, exists := fields[key]; !exists {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// splitEscaped splits s on sep, honouring backslash escapes and removing them from the result
+func splitEscaped(s string, sep byte) []string {
+	var parts []string
+	var current strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			current.WriteByte(s[i])
+		case s[i] == sep:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(s[i])
+		}
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+// unfoldLines splits a vCard into logical lines, joining folded continuation lines
+func unfoldLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,231 @@
+package payload
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		wantType Type
+		want     map[string]string
+	}{
+		{
+			name:     "plain text",
+			text:     "TEST QR CODE",
+			wantType: TypeText,
+		},
+		{
+			name:     "url",
+			text:     "https://Example.com:8443/tickets/42?seat=A1#top",
+			wantType: TypeURL,
+			want: map[string]string{
+				"scheme": "https", "host": "example.com", "port": "8443",
+				"path": "/tickets/42", "query": "seat=A1", "fragment": "top",
+			},
+		},
+		{
+			name:     "url without host is text",
+			text:     "http://",
+			wantType: TypeText,
+		},
+		{
+			name:     "wifi with escapes",
+			text:     `WIFI:T:WPA;S:my\;net;P:pa\:ss\\word;H:TRUE;;`,
+			wantType: TypeWiFi,
+			want:     map[string]string{"security": "WPA", "ssid": "my;net", "password": `pa:ss\word`, "hidden": "true"},
+		},
+		{
+			name:     "mecard",
+			text:     "MECARD:N:Yamada,Taro;TEL:+81312345678;EMAIL:taro@example.jp;;",
+			wantType: TypeMeCard,
+			want:     map[string]string{"name": "Yamada Taro", "tel": "+81312345678", "email": "taro@example.jp"},
+		},
+		{
+			name: "vcard",
+			text: "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Yamada;Taro;;;\r\nTEL;TYPE=CELL:+8190\r\nTEL;TYPE=WORK:+8130\r\n" +
+				"EMAIL:taro@example.jp\r\nORG:Example Inc.\r\nNOTE:folded\r\n  line\r\nEND:VCARD",
+			wantType: TypeVCard,
+			want: map[string]string{
+				"name": "Taro Yamada", "tel": "+8190", "email": "taro@example.jp",
+				"org": "Example Inc.", "note": "folded line",
+			},
+		},
+		{
+			name:     "otpauth",
+			text:     "otpauth://totp/ACME:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=ACME&digits=6",
+			wantType: TypeOTPAuth,
+			want: map[string]string{
+				"kind": "totp", "label": "ACME:alice@example.com", "issuer": "ACME",
+				"account": "alice@example.com", "secret": "JBSWY3DPEHPK3PXP", "digits": "6",
+			},
+		},
+		{
+			name:     "geo with query",
+			text:     "geo:35.681236,139.767125,10?q=Tokyo%20Station",
+			wantType: TypeGeo,
+			want:     map[string]string{"latitude": "35.681236", "longitude": "139.767125", "altitude": "10", "query": "Tokyo Station"},
+		},
+		{
+			name:     "invalid geo is text",
+			text:     "geo:north,south",
+			wantType: TypeText,
+		},
+		{
+			name:     "sms uri",
+			text:     "sms:+15555550100?body=hello%20there",
+			wantType: TypeSMS,
+			want:     map[string]string{"number": "+15555550100", "body": "hello there"},
+		},
+		{
+			name:     "smsto",
+			text:     "SMSTO:+15555550100:hello",
+			wantType: TypeSMS,
+			want:     map[string]string{"number": "+15555550100", "body": "hello"},
+		},
+		{
+			name:     "tel",
+			text:     "tel:+81312345678",
+			wantType: TypeTel,
+			want:     map[string]string{"number": "+81312345678"},
+		},
+		{
+			name:     "mailto",
+			text:     "mailto:support@example.com?subject=Help&body=Door%203",
+			wantType: TypeEmail,
+			want:     map[string]string{"to": "support@example.com", "subject": "Help", "body": "Door 3"},
+		},
+		{
+			name:     "matmsg",
+			text:     "MATMSG:TO:support@example.com;SUB:Help;BODY:Door 3;;",
+			wantType: TypeEmail,
+			want:     map[string]string{"to": "support@example.com", "subject": "Help", "body": "Door 3"},
+		},
+		{
+			name:     "bare email",
+			text:     "support@example.com",
+			wantType: TypeEmail,
+			want:     map[string]string{"to": "support@example.com"},
+		},
+		{
+			name:     "gs1 human readable",
+			text:     "(01)09501101530003(17)250101(10)ABC123",
+			wantType: TypeGS1,
+			want:     map[string]string{"01": "09501101530003", "17": "250101", "10": "ABC123"},
+		},
+		{
+			name:     "gs1 raw with symbology identifier",
+			text:     "]Q3010950110153000310ABC123\x1d17250101",
+			wantType: TypeGS1,
+			want:     map[string]string{"01": "09501101530003", "10": "ABC123", "17": "250101"},
+		},
+		{
+			name:     "gs1 raw with four digit ai",
+			text:     "\x1d0109501101530003310200150021SERIAL",
+			wantType: TypeGS1,
+			want:     map[string]string{"01": "09501101530003", "3102": "001500", "21": "SERIAL"},
+		},
+		{
+			name:     "truncated gs1 is text",
+			text:     "]Q30109501",
+			wantType: TypeText,
+		},
+		{
+			name:     "gs1 human readable with measure and internal ai",
+			text:     "(01)09501101530003(3103)001250(91)LINE-A",
+			wantType: TypeGS1,
+			want:     map[string]string{"01": "09501101530003", "3103": "001250", "91": "LINE-A"},
+		},
+		{
+			name:     "parenthesized text with invalid ai data is text",
+			text:     "(12)abc",
+			wantType: TypeText,
+		},
+		{
+			name:     "unknown ai is text",
+			text:     "(05)12345(10)ABC",
+			wantType: TypeText,
+		},
+		{
+			name:     "wrong gtin length is text",
+			text:     "(01)123(10)ABC",
+			wantType: TypeText,
+		},
+		{
+			name:     "measure ai with invalid decimal position is text",
+			text:     "(3109)001250",
+			wantType: TypeText,
+		},
+		{
+			name:     "value outside the gs1 character set is text",
+			text:     "(10)LOT 42",
+			wantType: TypeText,
+		},
+		{
+			name:     "raw gs1 with unknown ai is text",
+			text:     "]Q3050123",
+			wantType: TypeText,
+		},
+		{
+			name:     "raw gs1 with three digit 71x ai",
+			text:     "]Q3010950110153000371012345",
+			wantType: TypeGS1,
+			want:     map[string]string{"01": "09501101530003", "710": "12345"},
+		},
+		{
+			name:     "digits only is text",
+			text:     "0109501101530003",
+			wantType: TypeText,
+		},
+		{
+			name:     "lower case prefix",
+			text:     "tel:+81312345678",
+			wantType: TypeTel,
+			want:     map[string]string{"number": "+81312345678"},
+		},
+		{
+			// 大文字にすると長さが変わる文字は接頭辞として扱わない
+			name:     "long s is not sms",
+			text:     "ſms:+8190;hi",
+			wantType: TypeText,
+		},
+		{
+			name:     "dotless i is not wifi",
+			text:     "wıfı:S:net;;",
+			wantType: TypeText,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.text)
+			if got.Type != tt.wantType {
+				t.Fatalf("Parse(%q).Type = %q, want %q", tt.text, got.Type, tt.wantType)
+			}
+			if tt.want == nil {
+				if len(got.Fields) != 0 {
+					t.Errorf("Parse(%q).Fields = %v, want none", tt.text, got.Fields)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got.Fields, tt.want) {
+				t.Errorf("Parse(%q).Fields = %v, want %v", tt.text, got.Fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseType(t *testing.T) {
+	for _, name := range []string{"url", "URL", "Url"} {
+		if got, err := ParseType(name); err != nil || got != TypeURL {
+			t.Errorf("ParseType(%q) = %q, %v", name, got, err)
+		}
+	}
+	for _, name := range []string{"", "link", "url "} {
+		if _, err := ParseType(name); err == nil {
+			t.Errorf("ParseType(%q) should fail", name)
+		}
+	}
+}
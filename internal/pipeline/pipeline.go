@@ -0,0 +1,77 @@
+// Package pipeline runs detections through the processing stages that sit
+// between the capture loop and the outputs.
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+// Stage inspects a detection and either passes it on, possibly modified,
+// or rejects it by returning an error describing the reason
+type Stage interface {
+	Name() string
+	Process(d detection.Detection) (detection.Detection, error)
+}
+
+// Committer is implemented by stages that record state, such as used tokens,
+// only once a detection they passed has been written to an output
+type Committer interface {
+	Commit(d detection.Detection) error
+}
+
+// Rejection describes a detection dropped by a stage
+type Rejection struct {
+	Detection detection.Detection
+	Stage     string
+	Reason    string
+}
+
+// String formats the rejection for logs
+func (r Rejection) String() string {
+	return fmt.Sprintf("%s: %s", r.Stage, r.Reason)
+}
+
+// Pipeline applies stages in order
+type Pipeline struct {
+	stages []Stage
+}
+
+// New creates a pipeline with the given stages
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Add appends a stage to the end of the pipeline
+func (p *Pipeline) Add(stage Stage) {
+	p.stages = append(p.stages, stage)
+}
+
+// Run passes d through every stage. It returns the processed detection, or the
+// rejection from the first stage that refused it.
+func (p *Pipeline) Run(d detection.Detection) (detection.Detection, *Rejection) {
+	for _, stage := range p.stages {
+		processed, err := stage.Process(d)
+		if err != nil {
+			return d, &Rejection{Detection: d, Stage: stage.Name(), Reason: err.Error()}
+		}
+		d = processed
+	}
+	return d, nil
+}
+
+// Commit tells every stage implementing Committer that d, as returned by Run,
+// has been written
+func (p *Pipeline) Commit(d detection.Detection) error {
+	var errs []error
+	for _, stage := range p.stages {
+		if c, ok := stage.(Committer); ok {
+			if err := c.Commit(d); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", stage.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+// stageFunc はテスト用の関数ベースのステージ
+type stageFunc struct {
+	name string
+	fn   func(d detection.Detection) (detection.Detection, error)
+}
+
+func (s stageFunc) Name() string { return s.name }
+
+func (s stageFunc) Process(d detection.Detection) (detection.Detection, error) { return s.fn(d) }
+
+func TestPipeline_Run(t *testing.T) {
+	var calls []string
+	upper := stageFunc{name: "upper", fn: func(d detection.Detection) (detection.Detection, error) {
+		calls = append(calls, "upper")
+		d.Code = strings.ToUpper(d.Code)
+		return d, nil
+	}}
+	reject := stageFunc{name: "reject", fn: func(d detection.Detection) (detection.Detection, error) {
+		calls = append(calls, "reject")
+		if d.Code == "BAD" {
+			return d, errors.New("bad payload")
+		}
+		return d, nil
+	}}
+	never := stageFunc{name: "never", fn: func(d detection.Detection) (detection.Detection, error) {
+		calls = append(calls, "never")
+		return d, nil
+	}}
+
+	p := New(upper, reject)
+	p.Add(never)
+
+	d, rejection := p.Run(detection.Detection{Code: "good"})
+	if rejection != nil {
+		t.Fatalf("Unexpected rejection: %v", rejection)
+	}
+	if d.Code != "GOOD" {
+		t.Errorf("Stages should modify the detection in order, got %q", d.Code)
+	}
+
+	calls = nil
+	_, rejection = p.Run(detection.Detection{Code: "bad"})
+	if rejection == nil {
+		t.Fatal("Expected a rejection")
+	}
+	if rejection.Stage != "reject" || rejection.Reason != "bad payload" || rejection.Detection.Code != "BAD" {
+		t.Errorf("Unexpected rejection: %+v", rejection)
+	}
+	if rejection.String() != "reject: bad payload" {
+		t.Errorf("String() = %q", rejection.String())
+	}
+	if len(calls) != 2 {
+		t.Errorf("Stages after a rejection should not run, calls = %v", calls)
+	}
+}
+
+// committingStage は書き込み後に呼ばれたコードを記録するテスト用のステージ
+type committingStage struct {
+	stageFunc
+	committed []string
+	err       error
+}
+
+func (s *committingStage) Commit(d detection.Detection) error {
+	s.committed = append(s.committed, d.Code)
+	return s.err
+}
+
+func TestPipeline_Commit(t *testing.T) {
+	pass := stageFunc{name: "pass", fn: func(d detection.Detection) (detection.Detection, error) { return d, nil }}
+	first := &committingStage{stageFunc: stageFunc{name: "first", fn: pass.fn}}
+	second := &committingStage{stageFunc: stageFunc{name: "second", fn: pass.fn}, err: errors.New("disk full")}
+
+	p := New(first, pass, second)
+	err := p.Commit(detection.Detection{Code: "A"})
+	if len(first.committed) != 1 || len(second.committed) != 1 {
+		t.Errorf("Every committer should be called, got %v and %v", first.committed, second.committed)
+	}
+	if err == nil || err.Error() != "second: disk full" {
+		t.Errorf("Commit error = %v", err)
+	}
+}
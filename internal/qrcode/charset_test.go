@@ -0,0 +1,185 @@
+package qrcode
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/eotel/me19/internal/qrtest"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// detectGenerated reads a symbol holding content. A charset is declared with
+// an ECI designator if withECI is true, otherwise content is stored in that
+// charset as bytes the reader has to guess or be told about.
+func detectGenerated(t *testing.T, detector *Detector, content string, charset string, withECI bool) Result {
+	t.Helper()
+
+	var img image.Image
+	var err error
+	switch {
+	case charset == "" || withECI:
+		img, err = qrtest.Symbol(content, 300, charset)
+	default:
+		var data []byte
+		if data, err = encodeAs(content, charset); err == nil {
+			img, err = qrtest.BinarySymbol(data, 300)
+		}
+	}
+	if err != nil {
+		t.Fatalf("Failed to generate QR code: %v", err)
+	}
+	results, err := detector.DetectImage(img)
+	if err != nil {
+		t.Fatalf("DetectImage failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Expected one result, got %d", len(results))
+	}
+	return results[0]
+}
+
+// encodeAs encodes content in the named charset
+func encodeAs(content, charset string) ([]byte, error) {
+	enc, err := lookupCharset(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewEncoder().Bytes([]byte(content))
+}
+
+func TestDetector_Charsets(t *testing.T) {
+	const japaneseText = "入場券 No.123 東京会場"
+	sjisBytes, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte(japaneseText))
+	if err != nil {
+		t.Fatalf("Failed to encode Shift_JIS: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		encodeAs string
+		withECI  bool
+		override string
+		wantText string
+		wantRaw  []byte
+	}{
+		{
+			name:     "utf-8 without eci",
+			content:  japaneseText,
+			wantText: japaneseText,
+			wantRaw:  []byte(japaneseText),
+		},
+		{
+			name:     "utf-8 with eci",
+			content:  japaneseText,
+			encodeAs: "UTF-8",
+			withECI:  true,
+			wantText: japaneseText,
+			wantRaw:  []byte(japaneseText),
+		},
+		{
+			name:     "shift_jis with eci",
+			content:  japaneseText,
+			encodeAs: "Shift_JIS",
+			withECI:  true,
+			wantText: japaneseText,
+			wantRaw:  sjisBytes,
+		},
+		{
+			name:     "shift_jis without eci guessed",
+			content:  japaneseText,
+			encodeAs: "Shift_JIS",
+			wantText: japaneseText,
+			wantRaw:  sjisBytes,
+		},
+		{
+			name:     "shift_jis without eci with override",
+			content:  japaneseText,
+			encodeAs: "Shift_JIS",
+			override: "Shift_JIS",
+			wantText: japaneseText,
+			wantRaw:  sjisBytes,
+		},
+		{
+			name:     "eci takes precedence over override",
+			content:  japaneseText,
+			encodeAs: "UTF-8",
+			withECI:  true,
+			override: "Shift_JIS",
+			wantText: japaneseText,
+			wantRaw:  []byte(japaneseText),
+		},
+		{
+			name:     "alphanumeric has no byte segments",
+			content:  "TICKET 42",
+			wantText: "TICKET 42",
+			wantRaw:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector := newInitializedDetector(t)
+			if err := detector.SetCharset(tt.override); err != nil {
+				t.Fatalf("SetCharset failed: %v", err)
+			}
+
+			result := detectGenerated(t, detector, tt.content, tt.encodeAs, tt.withECI)
+			if result.Text != tt.wantText {
+				t.Errorf("Text = %q, want %q", result.Text, tt.wantText)
+			}
+			if !bytes.Equal(result.Raw, tt.wantRaw) {
+				t.Errorf("Raw = %x, want %x", result.Raw, tt.wantRaw)
+			}
+		})
+	}
+}
+
+func TestDetector_ShortShiftJISNeedsOverride(t *testing.T) {
+	// 短いShift_JIS文字列はISO-8859-1と区別できないことがあるため設定で指定できる
+	content := "ｱｲｳ"
+	sjisBytes, _ := japanese.ShiftJIS.NewEncoder().Bytes([]byte(content))
+
+	detector := newInitializedDetector(t)
+	if err := detector.SetCharset("SJIS"); err != nil {
+		t.Fatalf("SetCharset failed: %v", err)
+	}
+	result := detectGenerated(t, detector, content, "Shift_JIS", false)
+	if result.Text != content {
+		t.Errorf("Text = %q, want %q", result.Text, content)
+	}
+	if !bytes.Equal(result.Raw, sjisBytes) {
+		t.Errorf("Raw = %x, want %x", result.Raw, sjisBytes)
+	}
+}
+
+func TestDetector_BinaryPayload(t *testing.T) {
+	data := []byte{0x00, 0x01, 0xfe, 0xff, 0x80, 0x1b, '[', '3', '1', 'm', 0x0a, 0xc3}
+	img, err := qrtest.BinarySymbol(data, 300)
+	if err != nil {
+		t.Fatalf("Failed to generate QR code: %v", err)
+	}
+
+	detector := newInitializedDetector(t)
+	results, err := detector.DetectImage(img)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Expected one result, got %v (err=%v)", results, err)
+	}
+	if !bytes.Equal(results[0].Raw, data) {
+		t.Errorf("Raw = %x, want %x", results[0].Raw, data)
+	}
+}
+
+func TestDetector_SetCharset(t *testing.T) {
+	detector := New()
+
+	for _, name := range []string{"", "auto", "UTF-8", "Shift_JIS", "SJIS", "ISO-8859-1", "EUC-JP"} {
+		if err := detector.SetCharset(name); err != nil {
+			t.Errorf("SetCharset(%q) returned error: %v", name, err)
+		}
+	}
+	if err := detector.SetCharset("no-such-charset"); err == nil {
+		t.Error("SetCharset should reject unknown character sets")
+	}
+}
@@ -3,6 +3,7 @@ package qrcode
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"image"
 	"image/jpeg"
 	_ "image/jpeg" // Register JPEG format
@@ -10,14 +11,48 @@ import (
 	_ "image/png" // Register PNG format
 
 	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/common"
 	"github.com/makiuchi-d/gozxing/qrcode"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
 )
 
+// Result holds a decoded QR code together with its location in the source image
+type Result struct {
+	Text   string
+	Raw    []byte        // Concatenated byte-mode segments as stored in the symbol (nil when the code has none)
+	Points []image.Point // Finder pattern centers in image coordinates
+	Append *StructuredAppend
+
+	segments []segment                              // Segments of the symbol (nil if they could not be parsed)
+	hints    map[gozxing.DecodeHintType]interface{} // Hints the symbol was decoded with
+}
+
+// StructuredAppend describes the position of a symbol within a structured append sequence
+type StructuredAppend struct {
+	Index  int // 0-based position of this symbol in the sequence
+	Total  int // Number of symbols in the sequence
+	Parity int // Parity byte shared by every symbol of the same sequence
+}
+
+// Bounds returns the smallest rectangle containing all result points
+func (r Result) Bounds() image.Rectangle {
+	if len(r.Points) == 0 {
+		return image.Rectangle{}
+	}
+	var rect image.Rectangle
+	for _, p := range r.Points {
+		rect = rect.Union(image.Rectangle{Min: p, Max: p.Add(image.Pt(1, 1))})
+	}
+	return rect
+}
+
 // Detector is responsible for detecting and decoding QR codes from images
 type Detector struct {
 	// IsInitialized indicates whether the detector has been properly initialized
 	IsInitialized bool
 	qrReader      gozxing.Reader
+	hints         map[gozxing.DecodeHintType]interface{}
 }
 
 // New creates a new QR code detector
@@ -36,6 +71,36 @@ func (d *Detector) Initialize() error {
 	return nil
 }
 
+// SetCharset sets the character set used to decode byte-mode segments that carry no ECI designator.
+// An empty name or "auto" lets the decoder guess between UTF-8, Shift_JIS and ISO-8859-1.
+func (d *Detector) SetCharset(name string) error {
+	if name == "" || name == "auto" {
+		d.hints = nil
+		return nil
+	}
+
+	charset, err := lookupCharset(name)
+	if err != nil {
+		return err
+	}
+	d.hints = map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_CHARACTER_SET: charset,
+	}
+	return nil
+}
+
+// lookupCharset resolves a character set name such as "Shift_JIS" or "UTF-8"
+func lookupCharset(name string) (encoding.Encoding, error) {
+	if eci, ok := common.GetCharacterSetECIByName(name); ok {
+		return eci.GetCharset(), nil
+	}
+	charset, err := ianaindex.IANA.Encoding(name)
+	if err != nil || charset == nil {
+		return nil, fmt.Errorf("unsupported character set: %s", name)
+	}
+	return charset, nil
+}
+
 // Detect finds and decodes a single QR code in the provided image data
 func (d *Detector) Detect(imageData []byte) ([]string, error) {
 	if !d.IsInitialized {
@@ -48,6 +113,27 @@ func (d *Detector) Detect(imageData []byte) ([]string, error) {
 		return nil, err
 	}
 
+	results, err := d.DetectImage(img)
+	if err != nil {
+		return nil, err
+	}
+
+	// 検出結果をリストに追加
+	codes := make([]string, 0, len(results))
+	for _, result := range results {
+		codes = append(codes, result.Text)
+	}
+	return codes, nil
+}
+
+// DetectImage finds and decodes a single QR code in an already decoded image.
+// Result points are reported in the coordinate space of img, so sub-images keep
+// their offset within the original frame.
+func (d *Detector) DetectImage(img image.Image) ([]Result, error) {
+	if !d.IsInitialized {
+		return nil, errors.New("QR code detector is not initialized")
+	}
+
 	// gozxingのBinaryBitmapに変換
 	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
 	if err != nil {
@@ -55,14 +141,60 @@ func (d *Detector) Detect(imageData []byte) ([]string, error) {
 	}
 
 	// QRコードの検出と読み取り
-	result, err := d.qrReader.Decode(bmp, nil)
+	result, err := d.qrReader.Decode(bmp, d.hints)
 	if err != nil {
 		// QRコードが検出されなかった場合は空のリストを返す（エラーではない）
-		return []string{}, nil
+		return []Result{}, nil
 	}
 
-	// 検出結果をリストに追加
-	return []string{result.GetText()}, nil
+	// gozxingの座標は画像の左上を原点とするため、元画像の座標系に戻す
+	origin := img.Bounds().Min
+	points := make([]image.Point, 0, len(result.GetResultPoints()))
+	for _, p := range result.GetResultPoints() {
+		points = append(points, image.Pt(int(p.GetX()+0.5), int(p.GetY()+0.5)).Add(origin))
+	}
+
+	decoded := Result{
+		Text:   result.GetText(),
+		Raw:    byteSegmentsFromMetadata(result.GetResultMetadata()),
+		Points: points,
+		Append: structuredAppendFromMetadata(result.GetResultMetadata()),
+		hints:  d.hints,
+	}
+	// 構造的連接のシンボルは結合後にまとめてデコードするため、セグメントを保持する
+	if decoded.Append != nil {
+		ecLevel, _ := result.GetResultMetadata()[gozxing.ResultMetadataType_ERROR_CORRECTION_LEVEL].(string)
+		decoded.segments, _ = parseSegments(result.GetRawBytes(), ecLevel)
+	}
+	return []Result{decoded}, nil
+}
+
+// byteSegmentsFromMetadata joins the raw byte-mode segments, or returns nil when there are none
+func byteSegmentsFromMetadata(metadata map[gozxing.ResultMetadataType]interface{}) []byte {
+	segments, ok := metadata[gozxing.ResultMetadataType_BYTE_SEGMENTS].([][]byte)
+	if !ok || len(segments) == 0 {
+		return nil
+	}
+	return bytes.Join(segments, nil)
+}
+
+// structuredAppendFromMetadata extracts structured append information, or nil for a standalone symbol
+func structuredAppendFromMetadata(metadata map[gozxing.ResultMetadataType]interface{}) *StructuredAppend {
+	sequence, ok := metadata[gozxing.ResultMetadataType_STRUCTURED_APPEND_SEQUENCE].(int)
+	if !ok {
+		return nil
+	}
+	parity, ok := metadata[gozxing.ResultMetadataType_STRUCTURED_APPEND_PARITY].(int)
+	if !ok {
+		return nil
+	}
+
+	// 上位4ビットがシンボル位置、下位4ビットが総数-1
+	return &StructuredAppend{
+		Index:  sequence >> 4,
+		Total:  sequence&0x0f + 1,
+		Parity: parity,
+	}
 }
 
 // DetectMultiple finds and decodes multiple QR codes in the provided image data
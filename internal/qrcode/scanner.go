@@ -0,0 +1,120 @@
+package qrcode
+
+import (
+	"image"
+	"image/draw"
+	"sync"
+)
+
+// Scanner decides which parts of a frame are handed to the detector.
+// Static regions of interest limit scanning to known parts of the frame, and
+// tracking searches a padded box around the previous hit first so that a code
+// held still under the camera does not require decoding the whole frame.
+type Scanner struct {
+	detector *Detector
+	rois     []image.Rectangle
+	tracking bool
+	padding  int
+
+	mu      sync.Mutex
+	tracked image.Rectangle // 直前に検出した位置の周辺領域（未検出時は空）
+}
+
+// NewScanner creates a scanner using the given detector.
+// An empty rois slice scans the full frame.
+func NewScanner(detector *Detector, rois []image.Rectangle, tracking bool, padding int) *Scanner {
+	return &Scanner{
+		detector: detector,
+		rois:     rois,
+		tracking: tracking,
+		padding:  padding,
+	}
+}
+
+// Scan detects QR codes in img, trying the tracked region before the configured regions
+func (s *Scanner) Scan(img image.Image) ([]Result, error) {
+	bounds := img.Bounds()
+
+	// 追跡中の領域があれば先に探索する
+	if tracked := s.Tracked(); !tracked.Empty() {
+		results, err := s.scanRegion(img, tracked)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) > 0 {
+			s.track(results, bounds)
+			return results, nil
+		}
+	}
+
+	// 追跡領域で見つからなければROIまたはフレーム全体にフォールバック
+	var results []Result
+	if len(s.rois) == 0 {
+		found, err := s.detector.DetectImage(img)
+		if err != nil {
+			return nil, err
+		}
+		results = found
+	} else {
+		for _, roi := range s.rois {
+			found, err := s.scanRegion(img, roi)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, found...)
+		}
+	}
+
+	s.track(results, bounds)
+	return results, nil
+}
+
+// ROIs returns the configured static regions of interest
+func (s *Scanner) ROIs() []image.Rectangle {
+	return s.rois
+}
+
+// Tracked returns the region searched first on the next frame, or an empty rectangle
+func (s *Scanner) Tracked() image.Rectangle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tracked
+}
+
+// track updates the tracked region from the latest results
+func (s *Scanner) track(results []Result, bounds image.Rectangle) {
+	if !s.tracking {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(results) == 0 {
+		s.tracked = image.Rectangle{}
+		return
+	}
+	s.tracked = results[0].Bounds().Inset(-s.padding).Intersect(bounds)
+}
+
+// scanRegion runs the detector on the part of img inside region
+func (s *Scanner) scanRegion(img image.Image, region image.Rectangle) ([]Result, error) {
+	region = region.Intersect(img.Bounds())
+	if region.Empty() {
+		return []Result{}, nil
+	}
+	return s.detector.DetectImage(subImage(img, region))
+}
+
+// subImage returns the part of img inside r without copying when possible
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if si, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return si.SubImage(r)
+	}
+
+	dst := image.NewRGBA(r)
+	draw.Draw(dst, r, img, r.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,158 @@
+package qrcode
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// テスト用のQRコード画像を大きなキャンバスの指定位置に配置する
+func placeTestImage(t *testing.T, path string, canvas image.Rectangle, at image.Point) *image.RGBA {
+	t.Helper()
+
+	data, err := loadTestImage(path)
+	if err != nil {
+		t.Fatalf("Failed to load test image: %v", err)
+	}
+	code, err := BytesToImage(data)
+	if err != nil {
+		t.Fatalf("Failed to decode test image: %v", err)
+	}
+
+	img := image.NewRGBA(canvas)
+	draw.Draw(img, canvas, &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, code.Bounds().Add(at), code, code.Bounds().Min, draw.Src)
+	return img
+}
+
+func newInitializedDetector(t *testing.T) *Detector {
+	t.Helper()
+	detector := New()
+	if err := detector.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize detector: %v", err)
+	}
+	return detector
+}
+
+// TestDetector_DetectImage はサブ画像の座標が元画像の座標系で返されることを確認する
+func TestDetector_DetectImage(t *testing.T) {
+	testImagePath, _ := getTestImagesPaths()
+	at := image.Pt(300, 200)
+	img := placeTestImage(t, testImagePath, image.Rect(0, 0, 640, 480), at)
+	detector := newInitializedDetector(t)
+
+	region := image.Rect(250, 150, 450, 350)
+	results, err := detector.DetectImage(img.SubImage(region))
+	if err != nil {
+		t.Fatalf("DetectImage failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Text != "TEST QR CODE" {
+		t.Fatalf("Expected 'TEST QR CODE', got %+v", results)
+	}
+
+	codeRect := image.Rect(0, 0, 87, 87).Add(at)
+	for _, p := range results[0].Points {
+		if !p.In(codeRect) {
+			t.Errorf("Point %v is outside of the placed code %v", p, codeRect)
+		}
+	}
+}
+
+func TestScanner_ROIs(t *testing.T) {
+	testImagePath, _ := getTestImagesPaths()
+	img := placeTestImage(t, testImagePath, image.Rect(0, 0, 640, 480), image.Pt(400, 300))
+	detector := newInitializedDetector(t)
+
+	tests := []struct {
+		name     string
+		rois     []image.Rectangle
+		expected int
+	}{
+		{name: "full frame", rois: nil, expected: 1},
+		{name: "roi containing code", rois: []image.Rectangle{image.Rect(350, 250, 550, 450)}, expected: 1},
+		{name: "roi without code", rois: []image.Rectangle{image.Rect(0, 0, 300, 200)}, expected: 0},
+		{name: "roi outside frame", rois: []image.Rectangle{image.Rect(1000, 1000, 1200, 1200)}, expected: 0},
+		{
+			name:     "second roi containing code",
+			rois:     []image.Rectangle{image.Rect(0, 0, 300, 200), image.Rect(350, 250, 640, 480)},
+			expected: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := NewScanner(detector, tt.rois, false, 0)
+			results, err := scanner.Scan(img)
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			if len(results) != tt.expected {
+				t.Fatalf("Expected %d results, got %d", tt.expected, len(results))
+			}
+		})
+	}
+}
+
+func TestScanner_Tracking(t *testing.T) {
+	testImagePath, helloImagePath := getTestImagesPaths()
+	frame := image.Rect(0, 0, 640, 480)
+	detector := newInitializedDetector(t)
+	scanner := NewScanner(detector, nil, true, 40)
+
+	if !scanner.Tracked().Empty() {
+		t.Fatal("Scanner should not track anything before the first hit")
+	}
+
+	// 最初の検出で追跡領域が設定される
+	first := placeTestImage(t, testImagePath, frame, image.Pt(100, 100))
+	if results, err := scanner.Scan(first); err != nil || len(results) != 1 {
+		t.Fatalf("Expected one result, got %v (err=%v)", results, err)
+	}
+	tracked := scanner.Tracked()
+	if !image.Rect(100, 100, 187, 187).Inset(20).In(tracked) {
+		t.Fatalf("Tracked region %v does not cover the detected code", tracked)
+	}
+
+	// 追跡領域の外に移動したコードはフレーム全体へのフォールバックで見つかる
+	moved := placeTestImage(t, helloImagePath, frame, image.Pt(450, 300))
+	results, err := scanner.Scan(moved)
+	if err != nil || len(results) != 1 || results[0].Text != "HELLO WORLD" {
+		t.Fatalf("Expected fallback to find 'HELLO WORLD', got %v (err=%v)", results, err)
+	}
+	if !scanner.Tracked().Overlaps(image.Rect(450, 300, 537, 387)) {
+		t.Errorf("Tracked region %v should follow the moved code", scanner.Tracked())
+	}
+
+	// コードが消えると追跡は解除される
+	blank := placeTestImage(t, testImagePath, frame, image.Pt(-200, -200))
+	if results, err := scanner.Scan(blank); err != nil || len(results) != 0 {
+		t.Fatalf("Expected no results, got %v (err=%v)", results, err)
+	}
+	if !scanner.Tracked().Empty() {
+		t.Errorf("Tracked region should be cleared after a miss, got %v", scanner.Tracked())
+	}
+}
+
+func TestScanner_TrackingDisabled(t *testing.T) {
+	testImagePath, _ := getTestImagesPaths()
+	img := placeTestImage(t, testImagePath, image.Rect(0, 0, 320, 240), image.Pt(50, 50))
+	scanner := NewScanner(newInitializedDetector(t), nil, false, 40)
+
+	if _, err := scanner.Scan(img); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if !scanner.Tracked().Empty() {
+		t.Error("Tracked region should stay empty when tracking is disabled")
+	}
+}
+
+func TestResult_Bounds(t *testing.T) {
+	r := Result{Points: []image.Point{{10, 40}, {10, 10}, {40, 10}}}
+	if got, want := r.Bounds(), image.Rect(10, 10, 41, 41); got != want {
+		t.Errorf("Bounds() = %v, want %v", got, want)
+	}
+	if !(Result{}).Bounds().Empty() {
+		t.Error("Bounds() of a result without points should be empty")
+	}
+}
@@ -0,0 +1,224 @@
+package qrcode
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/common"
+	"github.com/makiuchi-d/gozxing/qrcode/decoder"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// segment is a run of data stored in one mode within a symbol. Byte segments
+// are kept undecoded, so that a character split between the symbols of a
+// structured append sequence can be decoded after the parts are joined.
+type segment struct {
+	byteMode bool
+	data     []byte                  // Raw bytes of a byte segment
+	eci      *common.CharacterSetECI // ECI designator in effect for a byte segment (nil if none)
+	text     string                  // Decoded text of the other modes
+	input    []byte                  // Input data of the other modes as it was encoded (for the parity)
+}
+
+// inputData returns the data the encoder was given for the segment. The parity
+// of a structured append sequence is computed over these bytes.
+func (s segment) inputData() []byte {
+	if s.byteMode {
+		return s.data
+	}
+	return s.input
+}
+
+// parseSegments splits the data codewords of a symbol into segments.
+// ecLevel is the error correction level reported by the decoder ("L", "M", "Q" or "H").
+func parseSegments(codewords []byte, ecLevel string) ([]segment, error) {
+	level, err := decoder.ErrorCorrectionLevel_ValueOf(ecLevel)
+	if err != nil {
+		return nil, err
+	}
+	version, err := versionForDataCodewords(len(codewords), level)
+	if err != nil {
+		return nil, err
+	}
+
+	bits := common.NewBitSource(codewords)
+	segments := make([]segment, 0, 1)
+	var eci *common.CharacterSetECI
+	fnc1 := false
+	for bits.Available() >= 4 {
+		modeBits, _ := bits.ReadBits(4)
+		mode, err := decoder.ModeForBits(modeBits)
+		if err != nil {
+			return nil, err
+		}
+
+		switch mode {
+		case decoder.Mode_TERMINATOR:
+			return segments, nil
+		case decoder.Mode_FNC1_FIRST_POSITION, decoder.Mode_FNC1_SECOND_POSITION:
+			fnc1 = true
+		case decoder.Mode_STRUCTURED_APPEND:
+			// シンボル位置とパリティはメタデータから取得済み
+			if _, err := bits.ReadBits(16); err != nil {
+				return nil, err
+			}
+		case decoder.Mode_ECI:
+			value, err := decoder.DecodedBitStreamParser_parseECIValue(bits)
+			if err != nil {
+				return nil, err
+			}
+			if eci, err = common.GetCharacterSetECIByValue(value); err != nil || eci == nil {
+				return nil, fmt.Errorf("unsupported ECI %d", value)
+			}
+		case decoder.Mode_HANZI:
+			subset, err := bits.ReadBits(4)
+			if err != nil {
+				return nil, err
+			}
+			count, err := bits.ReadBits(mode.GetCharacterCountBits(version))
+			if err != nil {
+				return nil, err
+			}
+			// GB2312以外のサブセットはデコーダーと同じく無視する
+			if subset == decoder.GB2312_SUBSET {
+				text, err := decoder.DecodedBitStreamParser_decodeHanziSegment(bits, nil, count)
+				if err != nil {
+					return nil, err
+				}
+				input, err := simplifiedchinese.GBK.NewEncoder().Bytes(text)
+				if err != nil {
+					return nil, err
+				}
+				segments = append(segments, segment{text: string(text), input: input})
+			}
+		default:
+			count, err := bits.ReadBits(mode.GetCharacterCountBits(version))
+			if err != nil {
+				return nil, err
+			}
+			var text, input []byte
+			switch mode {
+			case decoder.Mode_NUMERIC:
+				text, err = decoder.DecodedBitStreamParser_decodeNumericSegment(bits, nil, count)
+				input = text
+			case decoder.Mode_ALPHANUMERIC:
+				text, err = decoder.DecodedBitStreamParser_decodeAlphanumericSegment(bits, nil, count, fnc1)
+				input = alphanumericInput(text, fnc1)
+			case decoder.Mode_KANJI:
+				text, err = decoder.DecodedBitStreamParser_decodeKanjiSegment(bits, nil, count)
+				if err == nil {
+					// 漢字モードの入力データはShift_JISの2バイト
+					input, err = japanese.ShiftJIS.NewEncoder().Bytes(text)
+				}
+			case decoder.Mode_BYTE:
+				if 8*count > bits.Available() {
+					return nil, fmt.Errorf("byte segment of %d bytes exceeds the symbol", count)
+				}
+				data := make([]byte, count)
+				for i := range data {
+					b, _ := bits.ReadBits(8)
+					data[i] = byte(b)
+				}
+				segments = append(segments, segment{byteMode: true, data: data, eci: eci})
+				continue
+			default:
+				return nil, fmt.Errorf("unknown mode %v", mode)
+			}
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, segment{text: string(text), input: input})
+		}
+	}
+	return segments, nil
+}
+
+// alphanumericInput reverses the FNC1 conversion of the decoder, which turns
+// "%%" into "%" and "%" into the group separator, to recover the encoded data
+func alphanumericInput(text []byte, fnc1 bool) []byte {
+	if !fnc1 {
+		return text
+	}
+	input := strings.ReplaceAll(string(text), "%", "%%")
+	return []byte(strings.ReplaceAll(input, "\x1d", "%"))
+}
+
+// versionForDataCodewords finds the version whose symbols hold count data
+// codewords at the given error correction level
+func versionForDataCodewords(count int, level decoder.ErrorCorrectionLevel) (*decoder.Version, error) {
+	for number := 1; number <= 40; number++ {
+		version, err := decoder.Version_GetVersionForNumber(number)
+		if err != nil {
+			return nil, err
+		}
+		if version.GetTotalCodewords()-version.GetECBlocksForLevel(level).GetTotalECCodewords() == count {
+			return version, nil
+		}
+	}
+	return nil, fmt.Errorf("no version has %d data codewords at level %v", count, level)
+}
+
+// joinSegments decodes the segments of several symbols as one stream. Adjacent
+// byte segments with the same ECI are joined before decoding, with the ECI
+// charset or else the charset of hints or a guess, as the decoder would.
+func joinSegments(parts [][]segment, hints map[gozxing.DecodeHintType]interface{}) (string, error) {
+	var text strings.Builder
+	var pending []byte
+	var pendingECI *common.CharacterSetECI
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		var charset encoding.Encoding
+		if pendingECI != nil {
+			charset = pendingECI.GetCharset()
+		} else {
+			guessed, err := common.StringUtils_guessCharset(pending, hints)
+			if err != nil {
+				return err
+			}
+			charset = guessed
+		}
+		decoded, err := charset.NewDecoder().Bytes(pending)
+		if err != nil {
+			return err
+		}
+		text.Write(decoded)
+		pending = nil
+		return nil
+	}
+
+	for _, segments := range parts {
+		for _, seg := range segments {
+			if !seg.byteMode {
+				if err := flush(); err != nil {
+					return "", err
+				}
+				text.WriteString(seg.text)
+				continue
+			}
+			if len(pending) > 0 && !sameECI(seg.eci, pendingECI) {
+				if err := flush(); err != nil {
+					return "", err
+				}
+			}
+			pending = append(pending, seg.data...)
+			pendingECI = seg.eci
+		}
+	}
+	if err := flush(); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
+
+// sameECI reports whether two ECI designators select the same charset
+func sameECI(a, b *common.CharacterSetECI) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.GetValue() == b.GetValue()
+}
@@ -0,0 +1,150 @@
+package qrcode
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Assembler buffers the symbols of structured append sequences across frames
+// and combines them into a single result once every part has been seen.
+// Parts are grouped by parity and sequence length, and a complete sequence is
+// dropped when its parity does not match the joined data, so symbols belonging
+// to different payloads are never mixed.
+type Assembler struct {
+	timeout time.Duration
+	now     func() time.Time
+
+	mu   sync.Mutex
+	sets map[sequenceKey]*partialSequence
+}
+
+type sequenceKey struct {
+	parity int
+	total  int
+}
+
+type partialSequence struct {
+	parts     []*Result
+	firstSeen time.Time
+}
+
+// NewAssembler creates an assembler that drops incomplete sequences after timeout
+func NewAssembler(timeout time.Duration) *Assembler {
+	return &Assembler{
+		timeout: timeout,
+		now:     time.Now,
+		sets:    make(map[sequenceKey]*partialSequence),
+	}
+}
+
+// Add feeds a decoded symbol to the assembler.
+// Standalone symbols are returned unchanged. For structured append symbols the
+// combined result is returned once the sequence is complete; until then ok is false.
+// The combined result carries the Points of the symbol that completed the sequence.
+func (a *Assembler) Add(result Result) (combined Result, ok bool) {
+	sa := result.Append
+	if sa == nil || sa.Total <= 1 {
+		return result, true
+	}
+	if sa.Index < 0 || sa.Index >= sa.Total {
+		return Result{}, false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expireLocked()
+
+	key := sequenceKey{parity: sa.Parity, total: sa.Total}
+	set, exists := a.sets[key]
+	if !exists {
+		set = &partialSequence{
+			parts:     make([]*Result, sa.Total),
+			firstSeen: a.now(),
+		}
+		a.sets[key] = set
+	}
+	part := result
+	set.parts[sa.Index] = &part
+
+	var raw []byte
+	for _, p := range set.parts {
+		if p == nil {
+			return Result{}, false
+		}
+		raw = append(raw, p.Raw...)
+	}
+
+	// すべてのシンボルが揃った。パリティが結合したデータと一致しない場合は
+	// 別のペイロードのシンボルが混ざっているので破棄する
+	delete(a.sets, key)
+	if parity, known := sequenceParity(set.parts); known && parity != sa.Parity {
+		return Result{}, false
+	}
+	return Result{Text: joinText(set.parts), Raw: raw, Points: result.Points}, true
+}
+
+// sequenceParity XORs the input data of every part, as the encoder does to
+// compute the parity of a sequence. known is false when the segments of a part
+// could not be parsed, since the data is then not available.
+func sequenceParity(parts []*Result) (parity int, known bool) {
+	for _, p := range parts {
+		if p.segments == nil {
+			return 0, false
+		}
+		for _, seg := range p.segments {
+			for _, b := range seg.inputData() {
+				parity ^= int(b)
+			}
+		}
+	}
+	return parity, true
+}
+
+// joinText decodes the parts of a complete sequence as one stream, so that a
+// multibyte character split between two symbols is kept. Parts whose segments
+// are unknown are joined by their decoded text.
+func joinText(parts []*Result) string {
+	segments := make([][]segment, 0, len(parts))
+	for _, p := range parts {
+		if p.segments == nil {
+			segments = nil
+			break
+		}
+		segments = append(segments, p.segments)
+	}
+	if segments != nil {
+		if text, err := joinSegments(segments, parts[0].hints); err == nil {
+			return text
+		}
+	}
+
+	var text strings.Builder
+	for _, p := range parts {
+		text.WriteString(p.Text)
+	}
+	return text.String()
+}
+
+// Pending returns the number of incomplete sequences currently buffered
+func (a *Assembler) Pending() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expireLocked()
+	return len(a.sets)
+}
+
+// expireLocked drops sequences that have been incomplete for longer than the timeout
+func (a *Assembler) expireLocked() {
+	if a.timeout <= 0 {
+		return
+	}
+	now := a.now()
+	for key, set := range a.sets {
+		if now.Sub(set.firstSeen) > a.timeout {
+			delete(a.sets, key)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package qrcode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eotel/me19/internal/qrtest"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// テスト用に時刻を制御できるアセンブラを作成する
+func newTestAssembler(timeout time.Duration) (*Assembler, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := NewAssembler(timeout)
+	a.now = func() time.Time { return now }
+	return a, &now
+}
+
+func part(text string, index, total, parity int) Result {
+	return Result{Text: text, Append: &StructuredAppend{Index: index, Total: total, Parity: parity}}
+}
+
+func TestAssembler_Standalone(t *testing.T) {
+	a, _ := newTestAssembler(time.Second)
+
+	result, ok := a.Add(Result{Text: "SINGLE"})
+	if !ok || result.Text != "SINGLE" {
+		t.Fatalf("Standalone symbol should pass through, got %q (ok=%v)", result.Text, ok)
+	}
+}
+
+func TestAssembler_OutOfOrder(t *testing.T) {
+	a, _ := newTestAssembler(time.Second)
+
+	if _, ok := a.Add(part("C", 2, 3, 0x42)); ok {
+		t.Fatal("Sequence should not complete after the first part")
+	}
+	if _, ok := a.Add(part("A", 0, 3, 0x42)); ok {
+		t.Fatal("Sequence should not complete after the second part")
+	}
+	if a.Pending() != 1 {
+		t.Fatalf("Expected 1 pending sequence, got %d", a.Pending())
+	}
+
+	result, ok := a.Add(part("B", 1, 3, 0x42))
+	if !ok {
+		t.Fatal("Sequence should complete once all parts are seen")
+	}
+	if result.Text != "ABC" {
+		t.Errorf("Expected combined text 'ABC', got %q", result.Text)
+	}
+	if result.Append != nil {
+		t.Error("Combined result should not carry structured append information")
+	}
+	if a.Pending() != 0 {
+		t.Errorf("Completed sequence should be removed, %d pending", a.Pending())
+	}
+}
+
+func TestAssembler_ParityMismatch(t *testing.T) {
+	a, _ := newTestAssembler(time.Second)
+
+	a.Add(part("A", 0, 2, 0x10))
+	if _, ok := a.Add(part("X", 1, 2, 0x20)); ok {
+		t.Fatal("Parts with different parity must not be combined")
+	}
+	if a.Pending() != 2 {
+		t.Fatalf("Expected 2 pending sequences, got %d", a.Pending())
+	}
+
+	result, ok := a.Add(part("B", 1, 2, 0x10))
+	if !ok || result.Text != "AB" {
+		t.Errorf("Expected 'AB' from matching parity, got %q (ok=%v)", result.Text, ok)
+	}
+}
+
+func TestAssembler_Duplicates(t *testing.T) {
+	a, _ := newTestAssembler(time.Second)
+
+	a.Add(part("A", 0, 2, 1))
+	if _, ok := a.Add(part("A", 0, 2, 1)); ok {
+		t.Fatal("Repeated part should not complete the sequence")
+	}
+	if result, ok := a.Add(part("B", 1, 2, 1)); !ok || result.Text != "AB" {
+		t.Errorf("Expected 'AB', got %q (ok=%v)", result.Text, ok)
+	}
+}
+
+func TestAssembler_Timeout(t *testing.T) {
+	a, now := newTestAssembler(5 * time.Second)
+
+	a.Add(part("A", 0, 2, 7))
+	*now = now.Add(6 * time.Second)
+
+	if a.Pending() != 0 {
+		t.Fatalf("Incomplete sequence should expire, %d pending", a.Pending())
+	}
+	if _, ok := a.Add(part("B", 1, 2, 7)); ok {
+		t.Error("Part arriving after the timeout should start a new sequence")
+	}
+}
+
+func TestAssembler_InvalidIndex(t *testing.T) {
+	a, _ := newTestAssembler(time.Second)
+
+	if _, ok := a.Add(part("A", 3, 2, 0)); ok {
+		t.Error("Part with an index outside the sequence should be ignored")
+	}
+	if a.Pending() != 0 {
+		t.Errorf("Invalid part should not be buffered, %d pending", a.Pending())
+	}
+}
+
+func TestStructuredAppendFromMetadata(t *testing.T) {
+	metadata := map[gozxing.ResultMetadataType]interface{}{
+		gozxing.ResultMetadataType_STRUCTURED_APPEND_SEQUENCE: 0x13, // 2番目 / 全4シンボル
+		gozxing.ResultMetadataType_STRUCTURED_APPEND_PARITY:   0x5a,
+	}
+
+	sa := structuredAppendFromMetadata(metadata)
+	if sa == nil {
+		t.Fatal("Expected structured append information")
+	}
+	if sa.Index != 1 || sa.Total != 4 || sa.Parity != 0x5a {
+		t.Errorf("Unexpected structured append information: %+v", *sa)
+	}
+
+	if structuredAppendFromMetadata(map[gozxing.ResultMetadataType]interface{}{}) != nil {
+		t.Error("Standalone symbol should not have structured append information")
+	}
+}
+
+// bitWriter はテスト用にシンボルのデータコード語を組み立てる
+type bitWriter struct {
+	bits []bool
+}
+
+func (w *bitWriter) write(value, n int) *bitWriter {
+	for i := n - 1; i >= 0; i-- {
+		w.bits = append(w.bits, value>>i&1 == 1)
+	}
+	return w
+}
+
+// bytes writes a byte mode segment (character count of 8 bits, versions 1-9)
+func (w *bitWriter) bytes(data []byte) *bitWriter {
+	w.write(0b0100, 4).write(len(data), 8)
+	for _, b := range data {
+		w.write(int(b), 8)
+	}
+	return w
+}
+
+// codewords terminates the data and pads it to the 19 data codewords of a version 1-L symbol
+func (w *bitWriter) codewords() []byte {
+	w.write(0, 4)
+	for len(w.bits)%8 != 0 {
+		w.bits = append(w.bits, false)
+	}
+	data := make([]byte, 0, 19)
+	for i := 0; i < len(w.bits); i += 8 {
+		var b byte
+		for _, bit := range w.bits[i : i+8] {
+			b <<= 1
+			if bit {
+				b |= 1
+			}
+		}
+		data = append(data, b)
+	}
+	for pad := byte(0xec); len(data) < 19; pad ^= 0xec ^ 0x11 {
+		data = append(data, pad)
+	}
+	return data
+}
+
+// kanji writes a kanji mode segment of Shift_JIS characters (character count of 8 bits, versions 1-9)
+func (w *bitWriter) kanji(sjis []byte) *bitWriter {
+	w.write(0b1000, 4).write(len(sjis)/2, 8)
+	for i := 0; i < len(sjis); i += 2 {
+		c := int(sjis[i])<<8 | int(sjis[i+1])
+		if c < 0xe040 {
+			c -= 0x8140
+		} else {
+			c -= 0xc140
+		}
+		w.write(c>>8*0xc0+c&0xff, 13)
+	}
+	return w
+}
+
+// parity はデータのバイトの排他的論理和を返す
+func parity(data ...[]byte) int {
+	p := 0
+	for _, d := range data {
+		for _, b := range d {
+			p ^= int(b)
+		}
+	}
+	return p
+}
+
+// symbolPart はデータコード語から構造的連接のシンボルを作る
+func symbolPart(t *testing.T, index, total, parity int, hints map[gozxing.DecodeHintType]interface{}, build func(*bitWriter)) Result {
+	t.Helper()
+	w := (&bitWriter{}).write(0b0011, 4).write(index<<4|(total-1), 8).write(parity, 8)
+	build(w)
+	segments, err := parseSegments(w.codewords(), "L")
+	if err != nil {
+		t.Fatalf("parseSegments failed: %v", err)
+	}
+	return Result{Append: &StructuredAppend{Index: index, Total: total, Parity: parity}, segments: segments, hints: hints}
+}
+
+func TestAssembler_SplitCharacter(t *testing.T) {
+	utf8Text := []byte("入場券A-42")
+	sjisText, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte("入場券"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shiftJIS := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_CHARACTER_SET: japanese.ShiftJIS}
+
+	tests := []struct {
+		name   string
+		hints  map[gozxing.DecodeHintType]interface{}
+		parts  []func(*bitWriter)
+		parity int
+		want   string
+	}{
+		{
+			name: "UTF-8の文字の途中で分割",
+			parts: []func(*bitWriter){
+				func(w *bitWriter) { w.bytes(utf8Text[:4]) },
+				func(w *bitWriter) { w.bytes(utf8Text[4:]) },
+			},
+			parity: parity(utf8Text),
+			want:   "入場券A-42",
+		},
+		{
+			name:  "Shift_JISの文字の途中で分割",
+			hints: shiftJIS,
+			parts: []func(*bitWriter){
+				func(w *bitWriter) { w.bytes(sjisText[:3]) },
+				func(w *bitWriter) { w.bytes(sjisText[3:]) },
+			},
+			parity: parity(sjisText),
+			want:   "入場券",
+		},
+		{
+			name: "ECIで指定したUTF-8",
+			parts: []func(*bitWriter){
+				func(w *bitWriter) { w.write(0b0111, 4).write(26, 8).bytes(utf8Text[:2]) },
+				func(w *bitWriter) { w.write(0b0111, 4).write(26, 8).bytes(utf8Text[2:]) },
+			},
+			parity: parity(utf8Text),
+			want:   "入場券A-42",
+		},
+		{
+			name: "数字モードのあとに分割されたバイト",
+			parts: []func(*bitWriter){
+				// 数字モード "42"（10ビットの文字数、2桁は7ビット）
+				func(w *bitWriter) { w.write(0b0001, 4).write(2, 10).write(42, 7).bytes(utf8Text[:5]) },
+				func(w *bitWriter) { w.bytes(utf8Text[5:]) },
+			},
+			parity: parity([]byte("42"), utf8Text),
+			want:   "42入場券A-42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestAssembler(time.Second)
+			var result Result
+			ok := false
+			for i, build := range tt.parts {
+				result, ok = a.Add(symbolPart(t, i, len(tt.parts), tt.parity, tt.hints, build))
+			}
+			if !ok {
+				t.Fatal("Sequence should complete once all parts are seen")
+			}
+			if result.Text != tt.want {
+				t.Errorf("Combined text = %q, want %q", result.Text, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssembler_DataParity(t *testing.T) {
+	sjisText, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte("入場券"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := []func(*bitWriter){
+		// 漢字モードのパリティはShift_JISのバイトで計算される
+		func(w *bitWriter) { w.kanji(sjisText) },
+		// 数字モード "42"（10ビットの文字数、2桁は7ビット）
+		func(w *bitWriter) { w.write(0b0001, 4).write(2, 10).write(42, 7) },
+		func(w *bitWriter) { w.bytes([]byte("A-42")) },
+	}
+	valid := parity(sjisText, []byte("42"), []byte("A-42"))
+
+	tests := []struct {
+		name   string
+		parity int
+		want   bool
+	}{
+		{"パリティが一致", valid, true},
+		{"パリティが不一致", valid ^ 0x01, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestAssembler(time.Second)
+			var result Result
+			ok := false
+			for i, build := range parts {
+				result, ok = a.Add(symbolPart(t, i, len(parts), tt.parity, nil, build))
+			}
+			if ok != tt.want {
+				t.Fatalf("Add() ok = %v, want %v", ok, tt.want)
+			}
+			if ok && result.Text != "入場券42A-42" {
+				t.Errorf("Combined text = %q, want %q", result.Text, "入場券42A-42")
+			}
+			// 不一致のシーケンスも残さない
+			if a.Pending() != 0 {
+				t.Errorf("Completed sequence should be removed, %d pending", a.Pending())
+			}
+		})
+	}
+}
+
+func TestParseSegments_Symbol(t *testing.T) {
+	// 実際のシンボルのデータコード語からバージョンを求めてセグメントに分割する
+	img, err := qrtest.Symbol("入場券 No.42", 300, "UTF-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := qrcode.NewQRCodeReader().Decode(bmp, nil)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	ecLevel, _ := decoded.GetResultMetadata()[gozxing.ResultMetadataType_ERROR_CORRECTION_LEVEL].(string)
+	segments, err := parseSegments(decoded.GetRawBytes(), ecLevel)
+	if err != nil {
+		t.Fatalf("parseSegments failed: %v", err)
+	}
+	text, err := joinSegments([][]segment{segments}, nil)
+	if err != nil || text != "入場券 No.42" {
+		t.Errorf("joinSegments() = %q, %v", text, err)
+	}
+}
@@ -0,0 +1,178 @@
+// Package qrtest generates QR code symbols for tests and synthetic frames.
+// Symbols are built per call from hints and the data codewords, so nothing
+// global in the encoder is changed and generation is safe to run in parallel.
+package qrtest
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/common/reedsolomon"
+	"github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/makiuchi-d/gozxing/qrcode/decoder"
+	"github.com/makiuchi-d/gozxing/qrcode/encoder"
+)
+
+// QuietZone is the margin around a symbol in modules
+const QuietZone = 4
+
+// Symbol returns a symbol of about size pixels holding content. An empty
+// charset stores UTF-8 without an ECI designator; any other charset is
+// declared with an ECI designator, so readers decode it without guessing.
+func Symbol(content string, size int, charset string) (image.Image, error) {
+	hints := map[gozxing.EncodeHintType]interface{}{
+		gozxing.EncodeHintType_MARGIN: QuietZone,
+	}
+	if charset != "" {
+		hints[gozxing.EncodeHintType_CHARACTER_SET] = charset
+	}
+
+	matrix, err := qrcode.NewQRCodeWriter().Encode(content, gozxing.BarcodeFormat_QR_CODE, size, size, hints)
+	if err != nil {
+		return nil, err
+	}
+	return toImage(matrix), nil
+}
+
+// BinarySymbol returns a symbol of about size pixels storing data as is in a
+// single byte segment without an ECI designator, as a reader sees text
+// encoded in a legacy charset such as Shift_JIS or arbitrary binary data.
+func BinarySymbol(data []byte, size int) (image.Image, error) {
+	level := decoder.ErrorCorrectionLevel_L
+	version, err := byteVersion(len(data), level)
+	if err != nil {
+		return nil, err
+	}
+	blocks := version.GetECBlocksForLevel(level)
+	dataCodewords := version.GetTotalCodewords() - blocks.GetTotalECCodewords()
+
+	// モード指示子・文字数指示子・データ・終端パターン・埋め草の順に並べる
+	bits := gozxing.NewEmptyBitArray()
+	_ = bits.AppendBits(decoder.Mode_BYTE.GetBits(), 4)
+	_ = bits.AppendBits(len(data), decoder.Mode_BYTE.GetCharacterCountBits(version))
+	for _, b := range data {
+		_ = bits.AppendBits(int(b), 8)
+	}
+	_ = bits.AppendBits(0, min(4, dataCodewords*8-bits.GetSize()))
+	if rest := bits.GetSize() % 8; rest != 0 {
+		_ = bits.AppendBits(0, 8-rest)
+	}
+	for pad := 0; bits.GetSizeInBytes() < dataCodewords; pad++ {
+		_ = bits.AppendBits([]int{0xec, 0x11}[pad%2], 8)
+	}
+	codewords := make([]byte, dataCodewords)
+	bits.ToBytes(0, codewords, 0, dataCodewords)
+
+	final, err := interleave(codewords, version, blocks)
+	if err != nil {
+		return nil, err
+	}
+	dimension := version.GetDimensionForVersion()
+	modules := encoder.NewByteMatrix(dimension, dimension)
+	if err := encoder.MatrixUtil_buildMatrix(final, level, version, 0, modules); err != nil {
+		return nil, err
+	}
+	return render(modules, size), nil
+}
+
+// byteVersion finds the smallest version holding a byte segment of n bytes
+func byteVersion(n int, level decoder.ErrorCorrectionLevel) (*decoder.Version, error) {
+	for number := 1; number <= 40; number++ {
+		version, err := decoder.Version_GetVersionForNumber(number)
+		if err != nil {
+			return nil, err
+		}
+		capacity := version.GetTotalCodewords() - version.GetECBlocksForLevel(level).GetTotalECCodewords()
+		if 4+decoder.Mode_BYTE.GetCharacterCountBits(version)+8*n <= 8*capacity {
+			return version, nil
+		}
+	}
+	return nil, fmt.Errorf("%d bytes do not fit in a QR code", n)
+}
+
+// interleave splits the data codewords into blocks, adds the error correction
+// codewords of each block and interleaves them in symbol order
+func interleave(codewords []byte, version *decoder.Version, blocks *decoder.ECBlocks) (*gozxing.BitArray, error) {
+	rs := reedsolomon.NewReedSolomonEncoder(reedsolomon.GenericGF_QR_CODE_FIELD_256)
+	ecCount := blocks.GetECCodewordsPerBlock()
+
+	var dataBlocks, ecBlocks [][]int
+	offset := 0
+	for _, ecb := range blocks.GetECBlocks() {
+		for i := 0; i < ecb.GetCount(); i++ {
+			block := make([]int, ecb.GetDataCodewords()+ecCount)
+			for j := 0; j < ecb.GetDataCodewords(); j++ {
+				block[j] = int(codewords[offset+j])
+			}
+			offset += ecb.GetDataCodewords()
+			if err := rs.Encode(block, ecCount); err != nil {
+				return nil, err
+			}
+			dataBlocks = append(dataBlocks, block[:ecb.GetDataCodewords()])
+			ecBlocks = append(ecBlocks, block[ecb.GetDataCodewords():])
+		}
+	}
+
+	bits := gozxing.NewEmptyBitArray()
+	for _, group := range [][][]int{dataBlocks, ecBlocks} {
+		longest := 0
+		for _, block := range group {
+			longest = max(longest, len(block))
+		}
+		for i := 0; i < longest; i++ {
+			for _, block := range group {
+				if i < len(block) {
+					_ = bits.AppendBits(block[i], 8)
+				}
+			}
+		}
+	}
+	if bits.GetSizeInBytes() != version.GetTotalCodewords() {
+		return nil, fmt.Errorf("interleaved %d codewords, want %d", bits.GetSizeInBytes(), version.GetTotalCodewords())
+	}
+	return bits, nil
+}
+
+// render scales the modules to about size pixels with the quiet zone around
+// them, as the writer of gozxing does
+func render(modules *encoder.ByteMatrix, size int) image.Image {
+	dimension := modules.GetWidth()
+	outer := max(size, dimension+2*QuietZone)
+	scale := outer / (dimension + 2*QuietZone)
+	padding := (outer - dimension*scale) / 2
+
+	img := image.NewGray(image.Rect(0, 0, outer, outer))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for y := 0; y < dimension; y++ {
+		for x := 0; x < dimension; x++ {
+			if modules.Get(x, y) != 1 {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetGray(padding+x*scale+dx, padding+y*scale+dy, color.Gray{Y: 0})
+				}
+			}
+		}
+	}
+	return img
+}
+
+// toImage converts a BitMatrix of gozxing to a black and white image
+func toImage(matrix *gozxing.BitMatrix) image.Image {
+	img := image.NewGray(image.Rect(0, 0, matrix.GetWidth(), matrix.GetHeight()))
+	for y := 0; y < matrix.GetHeight(); y++ {
+		for x := 0; x < matrix.GetWidth(); x++ {
+			if matrix.Get(x, y) {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
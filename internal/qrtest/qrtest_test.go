@@ -0,0 +1,68 @@
+package qrtest
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+func decode(t *testing.T, img image.Image) *gozxing.Result {
+	t.Helper()
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := qrcode.NewQRCodeReader().Decode(bmp, nil)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	return result
+}
+
+func TestSymbol(t *testing.T) {
+	for _, charset := range []string{"", "UTF-8", "Shift_JIS"} {
+		img, err := Symbol("入場券 No.42", 300, charset)
+		if err != nil {
+			t.Fatalf("Symbol(%q) failed: %v", charset, err)
+		}
+		if text := decode(t, img).GetText(); text != "入場券 No.42" {
+			t.Errorf("Symbol(%q) decoded to %q", charset, text)
+		}
+	}
+}
+
+func TestBinarySymbol(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "空", data: []byte{}},
+		{name: "制御文字と不正なUTF-8", data: []byte{0x00, 0x01, 0xfe, 0xff, 0x80, 0x1b, '[', '3', '1', 'm', 0x0a, 0xc3}},
+		{name: "複数ブロック", data: bytes.Repeat([]byte{0x93, 0xfc, 0x8f, 0xea}, 100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := BinarySymbol(tt.data, 300)
+			if err != nil {
+				t.Fatalf("BinarySymbol failed: %v", err)
+			}
+			// ECI指定子がなく、単一のバイトセグメントにそのまま格納されている
+			result := decode(t, img)
+			if mode := result.GetRawBytes()[0] >> 4; mode != 0b0100 {
+				t.Errorf("Symbol starts with mode %04b, want byte mode", mode)
+			}
+			segments, _ := result.GetResultMetadata()[gozxing.ResultMetadataType_BYTE_SEGMENTS].([][]byte)
+			var got []byte
+			for _, segment := range segments {
+				got = append(got, segment...)
+			}
+			if !bytes.Equal(got, tt.data) || len(segments) > 1 {
+				t.Errorf("Byte segments = %x, want %x", segments, tt.data)
+			}
+		})
+	}
+}
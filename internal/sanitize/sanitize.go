@@ -0,0 +1,212 @@
+// Package sanitize makes decoded payloads safe to write to files, print to the
+// terminal and draw on the preview overlay.
+package sanitize
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+// Mode selects how control and bidi characters are handled
+type Mode string
+
+const (
+	// ModeStrip removes control and bidi characters
+	ModeStrip Mode = "strip"
+	// ModeKeepSeparators is ModeStrip except that the line breaks, tabs and group
+	// separators that structure payloads such as vCards and GS1 data are kept.
+	// Only use it when every output can hold them, since a line break in a
+	// payload appended to a line-oriented file starts a forged record.
+	ModeKeepSeparators Mode = "keep_separators"
+	// ModeEscape replaces them with Go-style escapes such as \n or \u202e
+	ModeEscape Mode = "escape"
+	// ModeKeep leaves them untouched; only the size and UTF-8 checks apply
+	ModeKeep Mode = "keep"
+)
+
+// ParseMode converts a configured mode name. An empty name selects ModeStrip.
+func ParseMode(name string) (Mode, error) {
+	switch Mode(strings.ToLower(name)) {
+	case "", ModeStrip:
+		return ModeStrip, nil
+	case ModeKeepSeparators:
+		return ModeKeepSeparators, nil
+	case ModeEscape:
+		return ModeEscape, nil
+	case ModeKeep:
+		return ModeKeep, nil
+	}
+	return "", fmt.Errorf("unknown control character mode: %s", name)
+}
+
+// Policy describes how payloads are sanitized before output
+type Policy struct {
+	Mode              Mode
+	MaxLength         int  // Maximum payload size in bytes (0 means no limit)
+	RejectInvalidUTF8 bool // Reject instead of replacing invalid sequences with U+FFFD
+}
+
+// Apply sanitizes text according to the policy.
+// An error is returned when the payload must be rejected.
+func (p Policy) Apply(text string) (string, error) {
+	if p.MaxLength > 0 && len(text) > p.MaxLength {
+		return "", fmt.Errorf("payload of %d bytes exceeds the limit of %d", len(text), p.MaxLength)
+	}
+	if !utf8.ValidString(text) {
+		if p.RejectInvalidUTF8 {
+			return "", fmt.Errorf("payload is not valid UTF-8")
+		}
+		text = strings.ToValidUTF8(text, "�")
+	}
+
+	switch p.Mode {
+	case ModeKeep:
+		return text, nil
+	case ModeEscape:
+		return escape(text), nil
+	case ModeKeepSeparators:
+		return strip(text, isSeparator), nil
+	default:
+		return strip(text, nil), nil
+	}
+}
+
+// applyRaw handles the control characters of the raw bytes like Apply does for
+// the text, so outputs writing the bytes as stored in the symbol are as safe as
+// those writing the text. Only ASCII controls are touched: bytes from 0x80 are
+// part of multibyte characters in UTF-8 or Shift_JIS payloads.
+func (p Policy) applyRaw(raw []byte) []byte {
+	unsafe := func(b byte) bool {
+		return (b < 0x20 || b == 0x7f) && !(p.Mode == ModeKeepSeparators && isSeparator(rune(b)))
+	}
+	if p.Mode == ModeKeep || !bytes.ContainsFunc(raw, func(r rune) bool { return r < utf8.RuneSelf && unsafe(byte(r)) }) {
+		return raw
+	}
+
+	out := make([]byte, 0, len(raw))
+	for _, b := range raw {
+		switch {
+		case !unsafe(b):
+			out = append(out, b)
+		case p.Mode == ModeEscape:
+			out = fmt.Appendf(out, `\x%02x`, b)
+		}
+	}
+	return out
+}
+
+// Name implements pipeline.Stage
+func (p Policy) Name() string {
+	return "sanitize"
+}
+
+// Process implements pipeline.Stage. The size limit and the control
+// characters are handled for the raw bytes too.
+func (p Policy) Process(d detection.Detection) (detection.Detection, error) {
+	if p.MaxLength > 0 && len(d.Raw) > p.MaxLength {
+		return d, fmt.Errorf("payload of %d bytes exceeds the limit of %d", len(d.Raw), p.MaxLength)
+	}
+	code, err := p.Apply(d.Code)
+	if err != nil {
+		return d, err
+	}
+	d.Code = code
+	d.Raw = p.applyRaw(d.Raw)
+
+	if len(d.Fields) > 0 {
+		fields := make(map[string]string, len(d.Fields))
+		for name, value := range d.Fields {
+			// フィールドは本文の一部なので長さの上限はすでに確認済み
+			fields[name], _ = Policy{Mode: p.Mode}.Apply(value)
+		}
+		d.Fields = fields
+	}
+	return d, nil
+}
+
+// ForDisplay returns text with every control and bidi character escaped and
+// at most maxRunes characters, suitable for logs and the overlay.
+// maxRunes <= 0 means no truncation.
+func ForDisplay(text string, maxRunes int) string {
+	text = escape(strings.ToValidUTF8(text, "�"))
+	if maxRunes > 0 && utf8.RuneCountInString(text) > maxRunes {
+		runes := []rune(text)
+		text = string(runes[:maxRunes]) + "..."
+	}
+	return text
+}
+
+// IsUnsafe reports whether r is a control character or a bidi formatting character
+func IsUnsafe(r rune) bool {
+	switch {
+	case r < 0x20, r == 0x7f:
+		return true
+	case r >= 0x80 && r <= 0x9f:
+		return true
+	}
+	return isBidi(r)
+}
+
+// isBidi reports whether r changes the rendering direction of text
+func isBidi(r rune) bool {
+	switch {
+	case r == 0x061c, r == 0x200e, r == 0x200f:
+		return true
+	case r >= 0x202a && r <= 0x202e:
+		return true
+	case r >= 0x2066 && r <= 0x2069:
+		return true
+	}
+	return false
+}
+
+// isSeparator reports whether r is a control character that payload formats use
+// as a separator: line breaks and tabs in vCards and MeCards, and the GS (FNC1)
+// separator between variable-length GS1 element strings
+func isSeparator(r rune) bool {
+	return r == '\n' || r == '\r' || r == '\t' || r == 0x1d
+}
+
+// strip removes the unsafe characters of text except those keep reports (nil keeps none)
+func strip(text string, keep func(rune) bool) string {
+	return strings.Map(func(r rune) rune {
+		if IsUnsafe(r) && (keep == nil || !keep(r)) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if !IsUnsafe(r) {
+			// バックスラッシュもエスケープして元の文字列と区別できるようにする
+			if r == '\\' {
+				b.WriteString(`\\`)
+			} else {
+				b.WriteRune(r)
+			}
+			continue
+		}
+		switch r {
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x100 {
+				fmt.Fprintf(&b, `\x%02x`, r)
+			} else {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,212 @@
+package sanitize
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eotel/me19/internal/detection"
+	"github.com/eotel/me19/internal/fileio"
+)
+
+func TestPolicy_Apply(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain text unchanged", policy: Policy{Mode: ModeStrip}, text: "入場券 No.42", want: "入場券 No.42"},
+		{name: "strip line breaks and tabs", policy: Policy{Mode: ModeStrip}, text: "line1\tA\nline2\r\n", want: "line1Aline2"},
+		{name: "strip group separator", policy: Policy{Mode: ModeStrip}, text: "]d2010950110153001710\x1d10ABC", want: "]d201095011015300171010ABC"},
+		{name: "keep_separators keeps line breaks and tabs", policy: Policy{Mode: ModeKeepSeparators}, text: "line1\tA\nline2\r\n", want: "line1\tA\nline2\r\n"},
+		{name: "keep_separators keeps group separator", policy: Policy{Mode: ModeKeepSeparators}, text: "]d2010950110153001710\x1d10ABC", want: "]d2010950110153001710\x1d10ABC"},
+		{name: "keep_separators strips ansi escape", policy: Policy{Mode: ModeKeepSeparators}, text: "a\n\x1b[2Jb", want: "a\n[2Jb"},
+		{name: "strip other c0 control", policy: Policy{Mode: ModeStrip}, text: "a\x00b\x07c\x1ed", want: "abcd"},
+		{name: "strip ansi escape", policy: Policy{Mode: ModeStrip}, text: "\x1b[31mred\x1b[0m", want: "[31mred[0m"},
+		{name: "strip bidi override", policy: Policy{Mode: ModeStrip}, text: "abc\u202etxt.exe", want: "abctxt.exe"},
+		{name: "strip c1 control", policy: Policy{Mode: ModeStrip}, text: "a\u009bb", want: "ab"},
+		{name: "escape newline", policy: Policy{Mode: ModeEscape}, text: "a\nb", want: `a\nb`},
+		{name: "escape ansi", policy: Policy{Mode: ModeEscape}, text: "\x1b[2J", want: `\x1b[2J`},
+		{name: "escape bidi", policy: Policy{Mode: ModeEscape}, text: "a\u2066b", want: `a\u2066b`},
+		{name: "escape backslash", policy: Policy{Mode: ModeEscape}, text: `a\nb`, want: `a\\nb`},
+		{name: "keep", policy: Policy{Mode: ModeKeep}, text: "a\nb", want: "a\nb"},
+		{name: "invalid utf-8 replaced", policy: Policy{Mode: ModeStrip}, text: "a\xffb", want: "a�b"},
+		{name: "invalid utf-8 rejected", policy: Policy{Mode: ModeStrip, RejectInvalidUTF8: true}, text: "a\xffb", wantErr: true},
+		{name: "within limit", policy: Policy{Mode: ModeStrip, MaxLength: 5}, text: "abcde", want: "abcde"},
+		{name: "exceeds limit", policy: Policy{Mode: ModeStrip, MaxLength: 5}, text: "abcdef", wantErr: true},
+		{name: "limit counts bytes", policy: Policy{Mode: ModeStrip, MaxLength: 5}, text: "入場", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Apply(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_Process(t *testing.T) {
+	p := Policy{Mode: ModeStrip, MaxLength: 64}
+
+	d, err := p.Process(detection.New("MECARD:N:Evil\x1b[2J;;", []byte("MECARD"), time.Time{}, nil))
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if d.Code != "MECARD:N:Evil[2J;;" {
+		t.Errorf("Code = %q", d.Code)
+	}
+	if d.Fields["name"] != "Evil[2J" {
+		t.Errorf("Fields should be sanitized too, got %q", d.Fields["name"])
+	}
+
+	if _, err := p.Process(detection.Detection{Code: "ok", Raw: make([]byte, 65)}); err == nil {
+		t.Error("Process should enforce the size limit on raw bytes")
+	}
+}
+
+func TestPolicy_ProcessStructuredPayloads(t *testing.T) {
+	codes := []string{
+		"BEGIN:VCARD\r\nVERSION:3.0\r\nFN:山田 太郎\r\nTEL:+81-3-1234-5678\r\nEND:VCARD",
+		"01095011015300031725061510LOT-42\x1d21SN1",
+	}
+
+	// デフォルト（strip）では追記先の行を偽造できないよう区切り文字も除去する
+	mode, err := ParseMode("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range codes {
+		d, err := Policy{Mode: mode}.Process(detection.New(code, []byte(code), time.Time{}, nil))
+		if err != nil {
+			t.Fatalf("Process(%q) failed: %v", code, err)
+		}
+		if strings.ContainsAny(d.Code, "\r\n\x1d") || bytes.ContainsAny(d.Raw, "\r\n\x1d") {
+			t.Errorf("Process(%q) kept separators: %q, %q", code, d.Code, d.Raw)
+		}
+	}
+
+	// keep_separators を指定した場合は構造を持つペイロードが変わらない
+	for _, code := range codes {
+		d, err := Policy{Mode: ModeKeepSeparators, MaxLength: 4096}.Process(detection.New(code, []byte(code), time.Time{}, nil))
+		if err != nil {
+			t.Fatalf("Process(%q) failed: %v", code, err)
+		}
+		if d.Code != code || string(d.Raw) != code {
+			t.Errorf("Process changed %q to %q, %q", code, d.Code, d.Raw)
+		}
+	}
+}
+
+func TestPolicy_ProcessRaw(t *testing.T) {
+	// encoding: raw で書き込まれるバイト列も本文と同じように扱う
+	sjis := []byte{0x93, 0xfc, 0x8f, 0xea, 0x8c, 0x94} // 入場券（Shift_JIS）
+	raw := append(append([]byte("\x1b[2J"), sjis...), "\nFORGED\x00"...)
+
+	tests := []struct {
+		mode Mode
+		want []byte
+	}{
+		{ModeStrip, append(append([]byte("[2J"), sjis...), "FORGED"...)},
+		{ModeKeepSeparators, append(append([]byte("[2J"), sjis...), "\nFORGED"...)},
+		{ModeEscape, append(append([]byte(`\x1b[2J`), sjis...), `\x0aFORGED\x00`...)},
+		{ModeKeep, raw},
+	}
+	for _, tt := range tests {
+		d, err := Policy{Mode: tt.mode}.Process(detection.New("code", raw, time.Time{}, nil))
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if got := fileio.Encode(fileio.EncodingRaw, d.Code, d.Raw); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: raw output = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestForDisplay(t *testing.T) {
+	if got := ForDisplay("a\x1b[31m\nb\xff", 0); got != `a\x1b[31m\nb`+"�" {
+		t.Errorf("ForDisplay = %q", got)
+	}
+	if got := ForDisplay(strings.Repeat("あ", 10), 4); got != "ああああ..." {
+		t.Errorf("ForDisplay should truncate, got %q", got)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for name, want := range map[string]Mode{"": ModeStrip, "strip": ModeStrip, "Keep_Separators": ModeKeepSeparators, "ESCAPE": ModeEscape, "keep": ModeKeep} {
+		got, err := ParseMode(name)
+		if err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseMode("remove"); err == nil {
+		t.Error("ParseMode should reject unknown modes")
+	}
+}
+
+// assertSafe は出力に制御文字・双方向制御文字・不正なUTF-8が含まれないことを確認する。
+// separatorsがtrueの場合は改行・タブ・GSを許す
+func assertSafe(t *testing.T, input, output string, separators bool) {
+	t.Helper()
+	if !utf8.ValidString(output) {
+		t.Fatalf("output of %q is not valid UTF-8: %q", input, output)
+	}
+	for _, r := range output {
+		if IsUnsafe(r) && !(separators && isSeparator(r)) {
+			t.Fatalf("output of %q contains unsafe rune %U: %q", input, r, output)
+		}
+	}
+}
+
+func FuzzPolicy_Apply(f *testing.F) {
+	for _, seed := range []string{"", "plain", "a\nb", "\x1b[31mred", "\u202eabc", "\xff\xfe", "\u0085\u2069", `\x1b`} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		for _, mode := range []Mode{ModeStrip, ModeKeepSeparators, ModeEscape} {
+			p := Policy{Mode: mode, MaxLength: 256}
+			output, err := p.Apply(input)
+			if err != nil {
+				if len(input) <= p.MaxLength {
+					t.Fatalf("Apply(%q) rejected input within the limit: %v", input, err)
+				}
+				continue
+			}
+			assertSafe(t, input, output, mode == ModeKeepSeparators)
+			if mode != ModeEscape && len(output) > p.MaxLength*3 {
+				t.Fatalf("stripped output of %q grew unexpectedly: %q", input, output)
+			}
+		}
+
+		p := Policy{Mode: ModeStrip, RejectInvalidUTF8: true}
+		if _, err := p.Apply(input); (err != nil) == utf8.ValidString(input) {
+			t.Fatalf("Apply(%q) error = %v, valid UTF-8 = %v", input, err, utf8.ValidString(input))
+		}
+	})
+}
+
+func FuzzForDisplay(f *testing.F) {
+	for _, seed := range []string{"", "a\nb", "\x1b]0;title\x07", "\u202e", "\xc3"} {
+		f.Add(seed, 8)
+	}
+
+	f.Fuzz(func(t *testing.T, input string, maxRunes int) {
+		if maxRunes > 1<<16 {
+			maxRunes = 1 << 16
+		}
+		output := ForDisplay(input, maxRunes)
+		assertSafe(t, input, output, false)
+		if maxRunes > 0 && utf8.RuneCountInString(output) > maxRunes+3 {
+			t.Fatalf("ForDisplay(%q, %d) = %q is too long", input, maxRunes, output)
+		}
+	})
+}
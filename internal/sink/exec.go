@@ -0,0 +1,217 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eotel/me19/internal/command"
+	"github.com/eotel/me19/internal/detection"
+)
+
+// DefaultExecTimeout is the maximum run time of a command when none is configured
+const DefaultExecTimeout = 5 * time.Second
+
+// closeTimeout is how long Close waits for queued and running commands before
+// stopping them
+const closeTimeout = 5 * time.Second
+
+// StdinMode selects what an exec sink writes to the command's standard input
+type StdinMode string
+
+const (
+	// StdinNone leaves standard input empty
+	StdinNone StdinMode = "none"
+	// StdinText writes the decoded text
+	StdinText StdinMode = "text"
+	// StdinJSON writes the detection as a JSON object
+	StdinJSON StdinMode = "json"
+)
+
+// ParseStdinMode converts a configured stdin mode. An empty name selects StdinNone.
+func ParseStdinMode(name string) (StdinMode, error) {
+	switch StdinMode(strings.ToLower(name)) {
+	case "", StdinNone:
+		return StdinNone, nil
+	case StdinText:
+		return StdinText, nil
+	case StdinJSON:
+		return StdinJSON, nil
+	}
+	return "", fmt.Errorf("unknown stdin mode: %s", name)
+}
+
+// ExecOptions configures an exec sink
+type ExecOptions struct {
+	Name        string
+	Command     string
+	Args        []string // Passed as is; payload data is never interpolated
+	Stdin       StdinMode
+	Timeout     time.Duration // Maximum run time of one command (DefaultExecTimeout if zero)
+	Concurrency int           // Commands running at the same time (default 1)
+	QueueSize   int           // Detections waiting for a free slot (default 16)
+	InheritEnv  bool          // Pass the environment of the scanner (see command.Command)
+}
+
+// ExecResult reports the outcome of one command execution
+type ExecResult struct {
+	Sink     string
+	Code     string
+	ExitCode int // -1 if the command could not be started or was killed
+	Stderr   string
+	Err      error
+	Duration time.Duration
+}
+
+// ExecSink runs a command for each detection. The payload and its metadata are
+// passed through ME19_* environment variables and optionally standard input;
+// they never go through a shell.
+type ExecSink struct {
+	opts   ExecOptions
+	report func(ExecResult)
+	jobs   chan detection.Detection
+	wg     sync.WaitGroup
+
+	// Close がこれをキャンセルすると実行中のコマンドは終了させられ、待ち行列の残りは実行されない
+	ctx          context.Context
+	cancel       context.CancelFunc
+	closeTimeout time.Duration
+
+	runCommand func(context.Context, command.Command) command.Result // command.Run（テストで差し替える）
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewExecSink starts the workers of an exec sink. report is called with the
+// result of every command; it may be nil.
+func NewExecSink(opts ExecOptions, report func(ExecResult)) (*ExecSink, error) {
+	if opts.Command == "" {
+		return nil, errors.New("exec sink requires a command")
+	}
+	if opts.Stdin == "" {
+		opts.Stdin = StdinNone
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultExecTimeout
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 16
+	}
+	if opts.Name == "" {
+		opts.Name = "exec"
+	}
+
+	s := &ExecSink{
+		opts:         opts,
+		report:       report,
+		jobs:         make(chan detection.Detection, opts.QueueSize),
+		closeTimeout: closeTimeout,
+		runCommand:   command.Run,
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for i := 0; i < opts.Concurrency; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+	return s, nil
+}
+
+// Name implements Sink
+func (s *ExecSink) Name() string {
+	return s.opts.Name
+}
+
+// Write implements Sink. The command runs asynchronously; an error is returned
+// only when the queue is full or the sink has been closed.
+func (s *ExecSink) Write(d detection.Detection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("exec sink is closed")
+	}
+	select {
+	case s.jobs <- d:
+		return nil
+	default:
+		// 検出ループを止めないため、待ち行列があふれた場合は破棄する
+		return fmt.Errorf("%d commands already queued, detection dropped", s.opts.QueueSize)
+	}
+}
+
+// Close implements Sink. It waits a few seconds for queued commands to finish,
+// then kills those still running and drops the rest of the queue, so a hung
+// command cannot block shutdown.
+func (s *ExecSink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.jobs)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	defer s.cancel()
+
+	timer := time.NewTimer(s.closeTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+	}
+	s.cancel()
+	<-done
+	return fmt.Errorf("stopped commands still running after %v", s.closeTimeout)
+}
+
+func (s *ExecSink) worker() {
+	defer s.wg.Done()
+	for d := range s.jobs {
+		result := s.run(d)
+		if s.report != nil {
+			s.report(result)
+		}
+	}
+}
+
+// run executes the command for a single detection
+func (s *ExecSink) run(d detection.Detection) ExecResult {
+	stdin, err := s.stdin(d)
+	if err != nil {
+		return ExecResult{Sink: s.opts.Name, Code: d.Code, ExitCode: -1, Err: err}
+	}
+
+	r := s.runCommand(s.ctx, command.Command{
+		Path:       s.opts.Command,
+		Args:       s.opts.Args,
+		Env:        command.Environment(d),
+		Stdin:      stdin,
+		Timeout:    s.opts.Timeout,
+		InheritEnv: s.opts.InheritEnv,
+	})
+	return ExecResult{Sink: s.opts.Name, Code: d.Code, ExitCode: r.ExitCode, Stderr: r.Stderr, Err: r.Err, Duration: r.Duration}
+}
+
+// stdin returns the data written to the command's standard input
+func (s *ExecSink) stdin(d detection.Detection) ([]byte, error) {
+	switch s.opts.Stdin {
+	case StdinText:
+		return []byte(d.Code), nil
+	case StdinJSON:
+		return json.Marshal(d)
+	}
+	return nil, nil
+}
@@ -0,0 +1,96 @@
+// Package sink defines the outputs that accepted detections are written to.
+package sink
+
+import (
+	"text/template"
+
+	"github.com/eotel/me19/internal/detection"
+	"github.com/eotel/me19/internal/fileio"
+)
+
+// Sink receives every detection accepted by the processing stages
+type Sink interface {
+	Name() string
+	Write(d detection.Detection) error
+	Close() error
+}
+
+// FileOptions configures a file sink
+type FileOptions struct {
+	Name     string
+	Path     string
+	Encoding fileio.Encoding       // Used when Template is empty
+	Template string                // text/template rendered with a Record
+	Append   bool                  // Append each detection instead of replacing the file; without a template each is followed by a newline
+	Rotation fileio.RotationPolicy // Applied when appending
+}
+
+// FileSink writes detections to a file, either encoded as configured or formatted by a template
+type FileSink struct {
+	name     string
+	writer   *fileio.Writer
+	rotating *fileio.RotatingWriter // 追記時にローテーションする場合のみ
+	encoding fileio.Encoding
+	tmpl     *template.Template
+	append   bool
+}
+
+// NewFileSink creates a file sink. By default each detection replaces the file contents.
+func NewFileSink(opts FileOptions) (*FileSink, error) {
+	s := &FileSink{
+		name:     opts.Name,
+		writer:   fileio.New(opts.Path),
+		encoding: opts.Encoding,
+		append:   opts.Append,
+	}
+	if s.name == "" {
+		s.name = "file"
+	}
+	if opts.Append && opts.Rotation.Enabled() {
+		s.rotating = fileio.NewRotatingWriter(opts.Path, opts.Rotation)
+	}
+	if opts.Template != "" {
+		tmpl, err := ParseTemplate(s.name, opts.Template)
+		if err != nil {
+			return nil, err
+		}
+		s.tmpl = tmpl
+	}
+	return s, nil
+}
+
+// Name implements Sink
+func (s *FileSink) Name() string {
+	return s.name
+}
+
+// Write implements Sink
+func (s *FileSink) Write(d detection.Detection) error {
+	var data []byte
+	if s.tmpl != nil {
+		rendered, err := RenderTemplate(s.tmpl, d)
+		if err != nil {
+			return err
+		}
+		data = rendered
+	} else {
+		data = fileio.Encode(s.encoding, d.Code, d.Raw)
+		if s.append {
+			// テンプレートがない場合はコードごとに1行として追記する
+			data = append(data, '\n')
+		}
+	}
+
+	if s.rotating != nil {
+		return s.rotating.AppendBytes(data)
+	}
+	if s.append {
+		return s.writer.AppendBytes(data)
+	}
+	return s.writer.WriteBytes(data)
+}
+
+// Close implements Sink
+func (s *FileSink) Close() error {
+	return nil
+}
@@ -0,0 +1,355 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eotel/me19/internal/command"
+	"github.com/eotel/me19/internal/detection"
+	"github.com/eotel/me19/internal/fileio"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "code.txt")
+	s, err := NewFileSink(FileOptions{Path: path, Encoding: fileio.EncodingBase64})
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %v", err)
+	}
+
+	if err := s.Write(detection.Detection{Code: "TICKET", Raw: []byte("TICKET")}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "VElDS0VU" {
+		t.Errorf("File content = %q", data)
+	}
+}
+
+func TestFileSink_AppendWithoutTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "codes.txt")
+	s, err := NewFileSink(FileOptions{Path: path, Encoding: fileio.EncodingText, Append: true})
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %v", err)
+	}
+	for _, code := range []string{"CODE1", "CODE2"} {
+		if err := s.Write(detection.New(code, []byte(code), time.Now(), nil)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if data, _ := os.ReadFile(path); string(data) != "CODE1\nCODE2\n" {
+		t.Errorf("File content = %q, want one code per line", data)
+	}
+}
+
+func TestFileSink_Template(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "codes.jsonl")
+	s, err := NewFileSink(FileOptions{
+		Path:     path,
+		Template: `{"code":{{json .Code}},"device":{{.DeviceID}},"time":{{unix .Time}},"type":"{{.Type}}"}` + "\n",
+		Append:   true,
+	})
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	for _, code := range []string{`say "hi"`, "https://example.com/"} {
+		d := detection.New(code, nil, now, nil)
+		d.DeviceID = 2
+		if err := s.Write(d); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	data, _ := os.ReadFile(path)
+	want := `{"code":"say \"hi\"","device":2,"time":1700000000,"type":"text"}` + "\n" +
+		`{"code":"https://example.com/","device":2,"time":1700000000,"type":"url"}` + "\n"
+	if string(data) != want {
+		t.Errorf("File content =\n%s\nwant\n%s", data, want)
+	}
+
+	if _, err := NewFileSink(FileOptions{Path: path, Template: "{{.Code"}); err == nil {
+		t.Error("NewFileSink should reject invalid templates")
+	}
+}
+
+func TestFileSink_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileSink(FileOptions{
+		Path:     filepath.Join(dir, "history.log"),
+		Template: "{{.Code}}\n",
+		Append:   true,
+		Rotation: fileio.RotationPolicy{MaxSize: 8},
+	})
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %v", err)
+	}
+	for _, code := range []string{"first", "second", "third"} {
+		if err := s.Write(detection.Detection{Code: code}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("Expected the history to be split into 3 files, got %d", len(entries))
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	d := detection.New("WIFI:S:guest,net;P:pw;;", []byte("abc"), time.Date(2025, 3, 4, 5, 6, 7, 0, time.FixedZone("JST", 9*3600)), []image.Point{{10, 50}, {10, 10}, {50, 12}})
+	d.DeviceID = 1
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{`{{.Code}}`, "WIFI:S:guest,net;P:pw;;"},
+		{`{{formatTime "2006-01-02 15:04" .Time}}`, "2025-03-04 05:06"},
+		{`{{rfc3339 (utc .Time)}}`, "2025-03-03T20:06:07Z"},
+		{`{{unixMilli .Time}}`, "1741032367000"},
+		{`{{.Type}} {{.Fields.ssid}} {{.Fields.missing}}`, "wifi guest,net "},
+		{`{{csv .Fields.ssid}},{{.DeviceID}}`, `"guest,net",1`},
+		{`{{json .FinderPoints}}`, `[{"X":10,"Y":50},{"X":10,"Y":10},{"X":50,"Y":12}]`},
+		{`{{range .Corners}}{{.X}}:{{.Y}} {{end}}`, "10:10 50:12 50:52 10:50 "},
+		{`{{sha256 .Raw}}`, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{`{{sha1 "abc"}}`, "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{`{{md5 .Raw}}`, "900150983cd24fb0d6963f7d28e17f72"},
+		{`{{base64 .Raw}} {{hex .Raw}}`, "YWJj 616263"},
+		{`{{upper .Code | replace ";" "|"}}`, "WIFI:S:GUEST,NET|P:PW||"},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := ParseTemplate("test", tt.template)
+		if err != nil {
+			t.Fatalf("ParseTemplate(%q) failed: %v", tt.template, err)
+		}
+		got, err := RenderTemplate(tmpl, d)
+		if err != nil {
+			t.Fatalf("RenderTemplate(%q) failed: %v", tt.template, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("RenderTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+// resultCollector は実行結果を集める
+type resultCollector struct {
+	mu      sync.Mutex
+	results []ExecResult
+}
+
+func (c *resultCollector) report(r ExecResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = append(c.results, r)
+}
+
+func (c *resultCollector) all() []ExecResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]ExecResult(nil), c.results...)
+}
+
+// fakeRunner は実行されたコマンドを記録し、run の結果を返す。コマンドの実行自体は
+// internal/command のテストで確認する
+type fakeRunner struct {
+	mu       sync.Mutex
+	commands []command.Command
+	running  int
+	peak     int
+	run      func(ctx context.Context, c command.Command) command.Result
+}
+
+func (f *fakeRunner) Run(ctx context.Context, c command.Command) command.Result {
+	f.mu.Lock()
+	f.commands = append(f.commands, c)
+	f.running++
+	f.peak = max(f.peak, f.running)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.running--
+		f.mu.Unlock()
+	}()
+
+	if f.run == nil {
+		return command.Result{}
+	}
+	return f.run(ctx, c)
+}
+
+// newFakeExecSink creates an exec sink whose commands are handled by runner
+func newFakeExecSink(t *testing.T, opts ExecOptions, runner *fakeRunner, report func(ExecResult)) *ExecSink {
+	t.Helper()
+	if opts.Command == "" {
+		opts.Command = "/usr/local/bin/open-door"
+	}
+	s, err := NewExecSink(opts, report)
+	if err != nil {
+		t.Fatalf("NewExecSink failed: %v", err)
+	}
+	s.runCommand = runner.Run
+	return s
+}
+
+func TestExecSink_EnvironmentAndStdin(t *testing.T) {
+	const code = "$(touch pwned); `id`"
+
+	tests := []struct {
+		name  string
+		stdin StdinMode
+		check func(t *testing.T, stdin string)
+	}{
+		{name: "none", stdin: StdinNone, check: func(t *testing.T, stdin string) {
+			if stdin != "" {
+				t.Errorf("stdin = %q, want empty", stdin)
+			}
+		}},
+		{name: "text", stdin: StdinText, check: func(t *testing.T, stdin string) {
+			if stdin != code {
+				t.Errorf("stdin = %q", stdin)
+			}
+		}},
+		{name: "json", stdin: StdinJSON, check: func(t *testing.T, stdin string) {
+			var d detection.Detection
+			if err := json.Unmarshal([]byte(stdin), &d); err != nil || d.Code != code {
+				t.Errorf("stdin = %q (%v)", stdin, err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{}
+			s := newFakeExecSink(t, ExecOptions{Args: []string{"--zone", "east"}, Stdin: tt.stdin, InheritEnv: true}, runner, nil)
+			if err := s.Write(detection.Detection{Code: code}); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			s.Close()
+
+			if len(runner.commands) != 1 {
+				t.Fatalf("Ran %d commands, want 1", len(runner.commands))
+			}
+			c := runner.commands[0]
+			// ペイロードは引数に埋め込まず環境変数で渡す
+			if c.Path != "/usr/local/bin/open-door" || !slices.Equal(c.Args, []string{"--zone", "east"}) {
+				t.Errorf("Ran %s %q", c.Path, c.Args)
+			}
+			if !slices.Contains(c.Env, "ME19_CODE="+code) || !c.InheritEnv || c.Timeout != DefaultExecTimeout {
+				t.Errorf("Command = %+v", c)
+			}
+			tt.check(t, string(c.Stdin))
+		})
+	}
+}
+
+func TestExecSink_Result(t *testing.T) {
+	c := &resultCollector{}
+	runner := &fakeRunner{run: func(context.Context, command.Command) command.Result {
+		return command.Result{ExitCode: 4, Stderr: "door offline", Err: errors.New("exit status 4"), Duration: time.Second}
+	}}
+	s := newFakeExecSink(t, ExecOptions{Name: "door", Timeout: 100 * time.Millisecond}, runner, c.report)
+	s.Write(detection.Detection{Code: "A"})
+	s.Close()
+
+	want := ExecResult{Sink: "door", Code: "A", ExitCode: 4, Stderr: "door offline", Duration: time.Second}
+	results := c.all()
+	if len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("Unexpected results: %+v", results)
+	}
+	results[0].Err = nil
+	if results[0] != want {
+		t.Errorf("Result = %+v, want %+v", results[0], want)
+	}
+	if runner.commands[0].Timeout != 100*time.Millisecond {
+		t.Errorf("Timeout = %v", runner.commands[0].Timeout)
+	}
+}
+
+func TestExecSink_CloseStopsHungCommands(t *testing.T) {
+	c := &resultCollector{}
+	runner := &fakeRunner{run: func(ctx context.Context, _ command.Command) command.Result {
+		// 応答しないコマンドはコンテキストがキャンセルされるまで終わらない
+		<-ctx.Done()
+		return command.Result{ExitCode: -1, Err: ctx.Err()}
+	}}
+	s := newFakeExecSink(t, ExecOptions{Timeout: time.Minute}, runner, c.report)
+	s.closeTimeout = 100 * time.Millisecond
+	s.Write(detection.Detection{Code: "hung"})
+	s.Write(detection.Detection{Code: "queued"})
+
+	start := time.Now()
+	if err := s.Close(); err == nil {
+		t.Error("Close should report the stopped commands")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Close took %v", elapsed)
+	}
+	for _, r := range c.all() {
+		if r.Err == nil {
+			t.Errorf("%s should not succeed after Close stopped it", r.Code)
+		}
+	}
+}
+
+func TestExecSink_DefaultTimeout(t *testing.T) {
+	s, _ := NewExecSink(ExecOptions{Command: "true"}, nil)
+	defer s.Close()
+	if s.opts.Timeout != DefaultExecTimeout {
+		t.Errorf("Timeout = %v, want %v", s.opts.Timeout, DefaultExecTimeout)
+	}
+}
+
+func TestExecSink_ConcurrencyAndQueue(t *testing.T) {
+	release := make(chan struct{})
+	runner := &fakeRunner{run: func(context.Context, command.Command) command.Result {
+		<-release
+		return command.Result{}
+	}}
+	c := &resultCollector{}
+	s := newFakeExecSink(t, ExecOptions{Concurrency: 2, QueueSize: 3}, runner, c.report)
+
+	accepted := 0
+	for i := 0; i < 8; i++ {
+		if err := s.Write(detection.Detection{Code: "X"}); err == nil {
+			accepted++
+		}
+	}
+	close(release)
+	s.Close()
+
+	if accepted < 3 || accepted > 5 {
+		t.Errorf("Expected the queue to limit accepted detections, accepted %d", accepted)
+	}
+	if len(c.all()) != accepted {
+		t.Errorf("Every accepted detection should run, got %d results for %d", len(c.all()), accepted)
+	}
+	if runner.peak > 2 {
+		t.Errorf("%d commands ran at once, want at most 2", runner.peak)
+	}
+
+	if err := s.Write(detection.Detection{Code: "late"}); err == nil {
+		t.Error("Write after Close should fail")
+	}
+}
+
+func TestParseStdinMode(t *testing.T) {
+	for name, want := range map[string]StdinMode{"": StdinNone, "none": StdinNone, "text": StdinText, "JSON": StdinJSON} {
+		if got, err := ParseStdinMode(name); err != nil || got != want {
+			t.Errorf("ParseStdinMode(%q) = %q, %v", name, got, err)
+		}
+	}
+	if _, err := ParseStdinMode("args"); err == nil {
+		t.Error("ParseStdinMode should reject unknown modes")
+	}
+}
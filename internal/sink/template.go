@@ -0,0 +1,125 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+	"github.com/eotel/me19/internal/payload"
+)
+
+// Record is the data available to output templates
+type Record struct {
+	Code         string
+	Raw          []byte
+	Time         time.Time
+	DeviceID     int
+	Type         payload.Type
+	Fields       map[string]string
+	Corners      []image.Point // Corners of the code clockwise from the top left, approximated from the finder patterns
+	FinderPoints []image.Point // Centers of the finder patterns (and the alignment pattern if found)
+}
+
+// NewRecord creates the template data for a detection
+func NewRecord(d detection.Detection) Record {
+	return Record{
+		Code:         d.Code,
+		Raw:          d.Raw,
+		Time:         d.Time,
+		DeviceID:     d.DeviceID,
+		Type:         d.Type,
+		Fields:       d.Fields,
+		Corners:      corners(d.Points),
+		FinderPoints: d.Points,
+	}
+}
+
+// corners returns the parallelogram spanned by the finder pattern centers,
+// which the decoder reports as bottom left, top left and top right, clockwise
+// from the top left. The corners of the symbol lie 3.5 modules further out.
+// Other points are returned as they are.
+func corners(points []image.Point) []image.Point {
+	if len(points) < 3 {
+		return points
+	}
+	bottomLeft, topLeft, topRight := points[0], points[1], points[2]
+	return []image.Point{topLeft, topRight, topRight.Add(bottomLeft).Sub(topLeft), bottomLeft}
+}
+
+// templateFuncs are the helper functions available to output templates
+var templateFuncs = template.FuncMap{
+	// 時刻
+	"formatTime": func(layout string, t time.Time) string { return t.Format(layout) },
+	"rfc3339":    func(t time.Time) string { return t.Format(time.RFC3339Nano) },
+	"unix":       func(t time.Time) int64 { return t.Unix() },
+	"unixMilli":  func(t time.Time) int64 { return t.UnixMilli() },
+	"utc":        func(t time.Time) time.Time { return t.UTC() },
+
+	// エスケープ
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"csv": csvField,
+
+	// ハッシュとエンコード
+	"sha256": func(v any) string { sum := sha256.Sum256(toBytes(v)); return hex.EncodeToString(sum[:]) },
+	"sha1":   func(v any) string { sum := sha1.Sum(toBytes(v)); return hex.EncodeToString(sum[:]) },
+	"md5":    func(v any) string { sum := md5.Sum(toBytes(v)); return hex.EncodeToString(sum[:]) },
+	"base64": func(v any) string { return base64.StdEncoding.EncodeToString(toBytes(v)) },
+	"hex":    func(v any) string { return hex.EncodeToString(toBytes(v)) },
+
+	// 文字列
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"trim":    strings.TrimSpace,
+	"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"join":    func(sep string, values []string) string { return strings.Join(values, sep) },
+}
+
+// ParseTemplate parses an output template with the helper functions
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// RenderTemplate executes tmpl for d
+func RenderTemplate(tmpl *template.Template, d detection.Detection) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, NewRecord(d)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// toBytes converts strings and byte slices for the hashing helpers
+func toBytes(v any) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case payload.Type:
+		return []byte(v)
+	}
+	return []byte(fmt.Sprint(v))
+}
+
+// csvField quotes a value as a single CSV field when necessary
+func csvField(v any) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{fmt.Sprint(v)})
+	w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,82 @@
+// Package state persists the scanner state that must survive a restart, such as
+// the last emitted codes used for de-duplication and writes waiting to be retried.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+	"github.com/eotel/me19/internal/fileio"
+)
+
+// Version is the format version written to state files
+const Version = 1
+
+// Emission records a code that was written to the outputs
+type Emission struct {
+	Code     string    `json:"code"`
+	DeviceID int       `json:"device_id"`
+	Camera   string    `json:"camera,omitempty"` // Name of the camera when several cameras are used
+	Time     time.Time `json:"time"`
+}
+
+// Retry is a detection that could not be written to a sink and is retried later
+type Retry struct {
+	Sink      string              `json:"sink"` // Name of the sink
+	Detection detection.Detection `json:"detection"`
+	Attempts  int                 `json:"attempts"`
+}
+
+// State is the persisted scanner state
+type State struct {
+	Version  int        `json:"version"`
+	SavedAt  time.Time  `json:"saved_at"`
+	DeviceID int        `json:"device_id"` // Selected camera
+	Emitted  []Emission `json:"emitted,omitempty"`
+	Retries  []Retry    `json:"retries,omitempty"`
+}
+
+// Fresh returns the emissions that are not older than maxAge at now.
+// A zero maxAge keeps all emissions.
+func (s State) Fresh(maxAge time.Duration, now time.Time) []Emission {
+	var fresh []Emission
+	for _, e := range s.Emitted {
+		if maxAge > 0 && now.Sub(e.Time) > maxAge {
+			continue
+		}
+		fresh = append(fresh, e)
+	}
+	return fresh
+}
+
+// Load reads a state file. If the file does not exist the returned error
+// satisfies errors.Is(err, fs.ErrNotExist).
+func Load(path string) (State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return State{}, err
+	}
+
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return State{}, fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+	if s.Version > Version {
+		return State{}, fmt.Errorf("state file %s has unsupported version %d", path, s.Version)
+	}
+	return s, nil
+}
+
+// Save writes the state atomically, setting its version and save time
+func Save(path string, s State, now time.Time) error {
+	s.Version = Version
+	s.SavedAt = now
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileio.WriteFileAtomic(path, append(data, '\n'), 0600)
+}
@@ -0,0 +1,113 @@
+package state
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	want := State{
+		DeviceID: 2,
+		Emitted:  []Emission{{Code: "hello", DeviceID: 2, Time: now.Add(-time.Minute)}},
+		Retries: []Retry{{
+			Sink:      "file",
+			Detection: detection.New("WIFI:S:home;T:WPA;P:secret;;", nil, now, nil),
+			Attempts:  3,
+		}},
+	}
+	if err := Save(path, want, now); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.Version != Version || !got.SavedAt.Equal(now) {
+		t.Errorf("Load() version = %d, saved at %v", got.Version, got.SavedAt)
+	}
+	if got.DeviceID != 2 {
+		t.Errorf("DeviceID = %d, want 2", got.DeviceID)
+	}
+	if len(got.Emitted) != 1 || got.Emitted[0].Code != "hello" || !got.Emitted[0].Time.Equal(now.Add(-time.Minute)) {
+		t.Errorf("Emitted = %+v", got.Emitted)
+	}
+	if len(got.Retries) != 1 {
+		t.Fatalf("Retries = %+v", got.Retries)
+	}
+	retry := got.Retries[0]
+	if retry.Sink != "file" || retry.Attempts != 3 || retry.Detection.Code != want.Retries[0].Detection.Code {
+		t.Errorf("Retry = %+v", retry)
+	}
+	if retry.Detection.Fields["ssid"] != "home" {
+		t.Errorf("Retry fields = %v, want ssid home", retry.Detection.Fields)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	// ファイルがない場合はfs.ErrNotExistを返す
+	if _, err := Load(filepath.Join(dir, "missing.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Load(missing) error = %v, want fs.ErrNotExist", err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"壊れたJSON", `{"version":`},
+		{"新しいバージョン", `{"version": 99}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "state.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(path); err == nil {
+				t.Error("Load() should fail")
+			}
+		})
+	}
+}
+
+func TestState_Fresh(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := State{Emitted: []Emission{
+		{Code: "old", Time: now.Add(-2 * time.Hour)},
+		{Code: "recent", Time: now.Add(-time.Minute)},
+	}}
+
+	tests := []struct {
+		name   string
+		maxAge time.Duration
+		want   []string
+	}{
+		{"制限なし", 0, []string{"old", "recent"}},
+		{"10分以内", 10 * time.Minute, []string{"recent"}},
+		{"1秒以内", time.Second, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.Fresh(tt.maxAge, now)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Fresh() = %+v, want %v", got, tt.want)
+			}
+			for i, code := range tt.want {
+				if got[i].Code != code {
+					t.Errorf("Fresh()[%d] = %q, want %q", i, got[i].Code, code)
+				}
+			}
+		})
+	}
+}
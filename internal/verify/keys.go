@@ -0,0 +1,125 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// Algorithm is a signature algorithm
+type Algorithm string
+
+const (
+	// Ed25519 signatures verified with a public key
+	Ed25519 Algorithm = "ed25519"
+	// HS256 is HMAC-SHA256 with a shared secret
+	HS256 Algorithm = "hs256"
+)
+
+// ParseAlgorithm converts a configured algorithm name
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch strings.ToLower(name) {
+	case "ed25519", "eddsa":
+		return Ed25519, nil
+	case "hs256", "hmac-sha256":
+		return HS256, nil
+	}
+	return "", fmt.Errorf("unknown signature algorithm: %s", name)
+}
+
+// jwtName returns the JWT "alg" header value for the algorithm
+func (a Algorithm) jwtName() string {
+	switch a {
+	case Ed25519:
+		return "EdDSA"
+	case HS256:
+		return "HS256"
+	}
+	return ""
+}
+
+// Key is a verification key identified by a key ID
+type Key struct {
+	ID        string
+	Algorithm Algorithm
+	PublicKey ed25519.PublicKey // Ed25519
+	Secret    []byte            // HS256
+}
+
+// NewKey creates a key from its configured representation. For Ed25519 the
+// material is a base64 encoded raw public key or a PEM "PUBLIC KEY" block;
+// for HS256 it is the shared secret.
+func NewKey(id string, algorithm Algorithm, material string) (Key, error) {
+	key := Key{ID: id, Algorithm: algorithm}
+	switch algorithm {
+	case Ed25519:
+		publicKey, err := parseEd25519PublicKey(material)
+		if err != nil {
+			return Key{}, fmt.Errorf("key %s: %w", id, err)
+		}
+		key.PublicKey = publicKey
+	case HS256:
+		key.Secret = []byte(material)
+	default:
+		return Key{}, fmt.Errorf("key %s: unknown signature algorithm: %s", id, algorithm)
+	}
+	return key, key.validate()
+}
+
+// validate checks that the key material matches the algorithm
+func (k Key) validate() error {
+	if k.ID == "" {
+		return fmt.Errorf("key id must not be empty")
+	}
+	switch k.Algorithm {
+	case Ed25519:
+		if len(k.PublicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("key %s: ed25519 public key must be %d bytes", k.ID, ed25519.PublicKeySize)
+		}
+	case HS256:
+		if len(k.Secret) == 0 {
+			return fmt.Errorf("key %s: secret must not be empty", k.ID)
+		}
+	default:
+		return fmt.Errorf("key %s: unknown signature algorithm: %s", k.ID, k.Algorithm)
+	}
+	return nil
+}
+
+// verify checks sig over data with the key
+func (k Key) verify(data, sig []byte) bool {
+	switch k.Algorithm {
+	case Ed25519:
+		return verifyEd25519(k.PublicKey, data, sig)
+	case HS256:
+		return hmac.Equal(signHMAC(k.Secret, data), sig)
+	}
+	return false
+}
+
+// parseEd25519PublicKey accepts a PEM encoded PKIX key or base64 of the raw 32 bytes
+func parseEd25519PublicKey(material string) (ed25519.PublicKey, error) {
+	material = strings.TrimSpace(material)
+	if block, _ := pem.Decode([]byte(material)); block != nil {
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing public key: %w", err)
+		}
+		publicKey, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key is not ed25519")
+		}
+		return publicKey, nil
+	}
+
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if raw, err := encoding.DecodeString(material); err == nil && len(raw) == ed25519.PublicKeySize {
+			return ed25519.PublicKey(raw), nil
+		}
+	}
+	return nil, fmt.Errorf("ed25519 public key must be PEM or base64 of %d bytes", ed25519.PublicKeySize)
+}
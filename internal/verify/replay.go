@@ -0,0 +1,180 @@
+package verify
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayStore remembers which tokens have already been used
+type ReplayStore interface {
+	// Use records id as used and reports whether it was unused before.
+	// expires is when the token stops being valid (zero if never); entries may
+	// be forgotten after that.
+	Use(id string, expires time.Time) (bool, error)
+	// Used reports whether id has been recorded and not yet forgotten
+	Used(id string) (bool, error)
+}
+
+// MemoryStore is a ReplayStore that forgets everything when the process exits
+type MemoryStore struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+	now  func() time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{used: make(map[string]time.Time), now: time.Now}
+}
+
+// Use implements ReplayStore
+func (s *MemoryStore) Use(id string, expires time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.used[id]; exists {
+		return false, nil
+	}
+	s.pruneLocked()
+	s.used[id] = expires
+	return true, nil
+}
+
+// Used implements ReplayStore
+func (s *MemoryStore) Used(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.used[id]
+	return exists, nil
+}
+
+// Len returns the number of remembered tokens
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.used)
+}
+
+// pruneLocked drops tokens that have expired, as they would be rejected anyway
+func (s *MemoryStore) pruneLocked() {
+	now := s.now()
+	for id, expires := range s.used {
+		if !expires.IsZero() && now.After(expires) {
+			delete(s.used, id)
+		}
+	}
+}
+
+// FileStore is a ReplayStore persisted to a local file so used tokens are
+// remembered across restarts. Each line holds a token ID and its expiry as Unix seconds.
+type FileStore struct {
+	path   string
+	memory *MemoryStore
+	mu     sync.Mutex
+}
+
+// OpenFileStore loads the used tokens recorded in path, skipping expired entries
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, memory: NewMemoryStore()}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening replay store: %w", err)
+	}
+	defer file.Close()
+
+	now := time.Now()
+	dropped := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		quoted, expiresField, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		id, err := strconv.Unquote(quoted)
+		if err != nil || id == "" {
+			continue
+		}
+		var expires time.Time
+		if seconds, err := strconv.ParseInt(expiresField, 10, 64); err == nil && seconds > 0 {
+			expires = time.Unix(seconds, 0)
+		}
+		if !expires.IsZero() && now.After(expires) {
+			dropped++
+			continue
+		}
+		s.memory.used[id] = expires
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading replay store: %w", err)
+	}
+
+	// 期限切れのエントリがあればファイルを書き直して肥大化を防ぐ
+	if dropped > 0 {
+		if err := s.compact(); err != nil {
+			return nil, fmt.Errorf("compacting replay store: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// compact rewrites the file with the entries currently in memory
+func (s *FileStore) compact() error {
+	var b strings.Builder
+	for id, expires := range s.memory.used {
+		b.WriteString(formatEntry(id, expires))
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// formatEntry formats a line of the store file. IDs are quoted so that any
+// characters they contain cannot break the line format.
+func formatEntry(id string, expires time.Time) string {
+	var seconds int64
+	if !expires.IsZero() {
+		seconds = expires.Unix()
+	}
+	return fmt.Sprintf("%s\t%d\n", strconv.Quote(id), seconds)
+}
+
+// Use implements ReplayStore
+func (s *FileStore) Use(id string, expires time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fresh, _ := s.memory.Use(id, expires)
+	if !fresh {
+		return false, nil
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(formatEntry(id, expires))
+	return err == nil, err
+}
+
+// Used implements ReplayStore
+func (s *FileStore) Used(id string) (bool, error) {
+	return s.memory.Used(id)
+}
+
+// Len returns the number of remembered tokens
+func (s *FileStore) Len() int {
+	return s.memory.Len()
+}
@@ -0,0 +1,344 @@
+// Package verify checks cryptographically signed QR payloads such as tickets.
+//
+// Two token formats are accepted:
+//   - JWT compact serialization "header.claims.signature" with alg EdDSA or HS256
+//   - "claims.signature", where the signature covers the base64url encoded claims
+//
+// All parts are base64url encoded without padding and the claims are a JSON object.
+package verify
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+// Verification failures. Errors returned by Verify wrap one of these.
+var (
+	ErrMalformed    = errors.New("malformed token")
+	ErrUnknownKey   = errors.New("unknown key")
+	ErrBadSignature = errors.New("invalid signature")
+	ErrExpired      = errors.New("token expired")
+	ErrNotYetValid  = errors.New("token not yet valid")
+	ErrMissingExp   = errors.New("token has no expiry")
+	ErrReplayed     = errors.New("token already used")
+)
+
+// Options configures a verifier
+type Options struct {
+	Keys          []Key
+	Leeway        time.Duration // Allowed clock skew for exp and nbf
+	RequireExpiry bool          // Reject tokens without an exp claim
+	Store         ReplayStore   // Remembers used tokens; nil disables replay protection
+}
+
+// Verifier validates signed tokens
+type Verifier struct {
+	keys          map[string]Key
+	order         []string // 鍵IDの設定順（kidのないトークンで順に試す）
+	leeway        time.Duration
+	requireExpiry bool
+	store         ReplayStore
+}
+
+// Claims are the decoded claims of a verified token
+type Claims struct {
+	KeyID     string
+	ID        string // jti, or a digest of the signature when the token has none
+	Subject   string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	Values    map[string]any
+}
+
+// New creates a verifier for the given keys
+func New(opts Options) (*Verifier, error) {
+	if len(opts.Keys) == 0 {
+		return nil, fmt.Errorf("at least one key is required")
+	}
+	v := &Verifier{
+		keys:          make(map[string]Key, len(opts.Keys)),
+		leeway:        opts.Leeway,
+		requireExpiry: opts.RequireExpiry,
+		store:         opts.Store,
+	}
+	for _, key := range opts.Keys {
+		if err := key.validate(); err != nil {
+			return nil, err
+		}
+		if _, exists := v.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		v.keys[key.ID] = key
+		v.order = append(v.order, key.ID)
+	}
+	return v, nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature, validity period and replay status of token.
+// A token that passes every check is recorded as used.
+func (v *Verifier) Verify(token string, now time.Time) (Claims, error) {
+	claims, err := v.Check(token, now)
+	if err != nil {
+		return Claims{}, err
+	}
+	if err := v.record(claims); err != nil {
+		return Claims{}, err
+	}
+	return claims, nil
+}
+
+// Check verifies token like Verify without recording it as used
+func (v *Verifier) Check(token string, now time.Time) (Claims, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+
+	var hdr header
+	var signingInput, claimsPart, sigPart string
+	switch len(parts) {
+	case 3:
+		headerJSON, err := decodeSegment(parts[0])
+		if err != nil {
+			return Claims{}, fmt.Errorf("%w: header: %v", ErrMalformed, err)
+		}
+		if err := json.Unmarshal(headerJSON, &hdr); err != nil {
+			return Claims{}, fmt.Errorf("%w: header: %v", ErrMalformed, err)
+		}
+		signingInput = parts[0] + "." + parts[1]
+		claimsPart, sigPart = parts[1], parts[2]
+	case 2:
+		signingInput = parts[0]
+		claimsPart, sigPart = parts[0], parts[1]
+	default:
+		return Claims{}, fmt.Errorf("%w: expected 2 or 3 segments, got %d", ErrMalformed, len(parts))
+	}
+
+	sig, err := decodeSegment(sigPart)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: signature: %v", ErrMalformed, err)
+	}
+	claimsJSON, err := decodeSegment(claimsPart)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: claims: %v", ErrMalformed, err)
+	}
+	values := make(map[string]any)
+	decoder := json.NewDecoder(bytes.NewReader(claimsJSON))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return Claims{}, fmt.Errorf("%w: claims: %v", ErrMalformed, err)
+	}
+
+	// 2セグメント形式では鍵IDをクレームから取得する
+	kid := hdr.Kid
+	if len(parts) == 2 {
+		kid, _ = values["kid"].(string)
+	}
+
+	key, err := v.verifySignature(hdr.Alg, kid, []byte(signingInput), sig)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	claims, err := parseClaims(values, key.ID, sig)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	if !claims.ExpiresAt.IsZero() && !now.Before(claims.ExpiresAt.Add(v.leeway)) {
+		return Claims{}, fmt.Errorf("%w at %s", ErrExpired, claims.ExpiresAt.Format(time.RFC3339))
+	}
+	if claims.ExpiresAt.IsZero() && v.requireExpiry {
+		return Claims{}, ErrMissingExp
+	}
+	if !claims.NotBefore.IsZero() && now.Add(v.leeway).Before(claims.NotBefore) {
+		return Claims{}, fmt.Errorf("%w until %s", ErrNotYetValid, claims.NotBefore.Format(time.RFC3339))
+	}
+
+	if v.store != nil {
+		used, err := v.store.Used(claims.ID)
+		if err != nil {
+			return Claims{}, fmt.Errorf("looking up token %s: %w", claims.ID, err)
+		}
+		if used {
+			return Claims{}, fmt.Errorf("%w: %s", ErrReplayed, claims.ID)
+		}
+	}
+	return claims, nil
+}
+
+// record marks a checked token as used. The store keeps it until the token
+// would be rejected as expired, including the leeway.
+func (v *Verifier) record(claims Claims) error {
+	if v.store == nil {
+		return nil
+	}
+	expires := claims.ExpiresAt
+	if !expires.IsZero() {
+		expires = expires.Add(v.leeway)
+	}
+	fresh, err := v.store.Use(claims.ID, expires)
+	if err != nil {
+		return fmt.Errorf("recording token %s: %w", claims.ID, err)
+	}
+	if !fresh {
+		return fmt.Errorf("%w: %s", ErrReplayed, claims.ID)
+	}
+	return nil
+}
+
+// verifySignature finds the key for the token and checks the signature with it
+func (v *Verifier) verifySignature(alg, kid string, signingInput, sig []byte) (Key, error) {
+	var candidates []Key
+	if kid != "" {
+		key, ok := v.keys[kid]
+		if !ok {
+			return Key{}, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+		}
+		candidates = []Key{key}
+	} else {
+		for _, id := range v.order {
+			candidates = append(candidates, v.keys[id])
+		}
+	}
+
+	for _, key := range candidates {
+		// JWTではヘッダーのalgと鍵のアルゴリズムが一致しなければならない
+		if alg != "" && alg != key.Algorithm.jwtName() {
+			if kid != "" {
+				return Key{}, fmt.Errorf("%w: algorithm %s does not match key %s", ErrBadSignature, alg, key.ID)
+			}
+			continue
+		}
+		if key.verify(signingInput, sig) {
+			return key, nil
+		}
+	}
+	return Key{}, ErrBadSignature
+}
+
+// parseClaims extracts the registered claims
+func parseClaims(values map[string]any, kid string, sig []byte) (Claims, error) {
+	claims := Claims{KeyID: kid, Values: values}
+
+	var err error
+	if claims.ExpiresAt, err = numericDate(values, "exp"); err != nil {
+		return Claims{}, err
+	}
+	if claims.NotBefore, err = numericDate(values, "nbf"); err != nil {
+		return Claims{}, err
+	}
+	claims.Subject, _ = values["sub"].(string)
+	claims.ID, _ = values["jti"].(string)
+	if claims.ID == "" {
+		digest := sha256.Sum256(sig)
+		claims.ID = "sig:" + hex.EncodeToString(digest[:16])
+	}
+	return claims, nil
+}
+
+// maxNumericDate is the largest exp or nbf accepted (the end of year 9999), so that
+// the conversion to time.Time cannot overflow
+const maxNumericDate = 253402300799
+
+// numericDate reads a claim holding seconds since the Unix epoch
+func numericDate(values map[string]any, name string) (time.Time, error) {
+	value, ok := values[name]
+	if !ok {
+		return time.Time{}, nil
+	}
+	n, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: %s is not a number", ErrMalformed, name)
+	}
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s: %v", ErrMalformed, name, err)
+	}
+	if seconds < -maxNumericDate || seconds > maxNumericDate {
+		return time.Time{}, fmt.Errorf("%w: %s is out of range", ErrMalformed, name)
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), nil
+}
+
+// decodeSegment decodes a base64url segment, with or without padding
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// Name implements pipeline.Stage
+func (v *Verifier) Name() string {
+	return "verify"
+}
+
+// Process implements pipeline.Stage. Verified claims are added to the detection
+// fields. The token is not recorded as used until Commit, so a detection that
+// cannot be written does not use up the token.
+func (v *Verifier) Process(d detection.Detection) (detection.Detection, error) {
+	claims, err := v.Check(d.Code, detectionTime(d))
+	if err != nil {
+		return d, err
+	}
+
+	fields := make(map[string]string, len(d.Fields)+len(claims.Values)+1)
+	for name, value := range d.Fields {
+		fields[name] = value
+	}
+	for name, value := range claims.Values {
+		fields[name] = fmt.Sprint(value)
+	}
+	fields["kid"] = claims.KeyID
+	fields["jti"] = claims.ID
+	d.Fields = fields
+	return d, nil
+}
+
+// Commit implements pipeline.Committer by recording the token of a written
+// detection as used. A token that is already recorded is not an error, as a
+// detection is committed again when it is written to a retried output.
+func (v *Verifier) Commit(d detection.Detection) error {
+	claims, err := v.Check(d.Code, detectionTime(d))
+	if errors.Is(err, ErrReplayed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := v.record(claims); err != nil && !errors.Is(err, ErrReplayed) {
+		return err
+	}
+	return nil
+}
+
+// detectionTime returns the time a detection is verified at
+func detectionTime(d detection.Detection) time.Time {
+	if d.Time.IsZero() {
+		return time.Now()
+	}
+	return d.Time
+}
+
+// signHMAC computes the HMAC-SHA256 of data
+func signHMAC(secret, data []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// verifyEd25519 reports whether sig is a valid Ed25519 signature of data
+func verifyEd25519(publicKey ed25519.PublicKey, data, sig []byte) bool {
+	return len(sig) == ed25519.SignatureSize && ed25519.Verify(publicKey, data, sig)
+}
@@ -0,0 +1,329 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eotel/me19/internal/detection"
+)
+
+var testNow = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func encodeSegment(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signJWT はテスト用のJWTを作成する
+func signJWT(t *testing.T, alg, kid string, claims map[string]any, sign func([]byte) []byte) string {
+	t.Helper()
+	input := encodeSegment(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(input)))
+}
+
+// signCompact はテスト用の "claims.signature" 形式のトークンを作成する
+func signCompact(t *testing.T, claims map[string]any, sign func([]byte) []byte) string {
+	t.Helper()
+	body := encodeSegment(t, claims)
+	return body + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(body)))
+}
+
+type testKeys struct {
+	edPublic  ed25519.PublicKey
+	edPrivate ed25519.PrivateKey
+	secret    []byte
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	return testKeys{edPublic: public, edPrivate: private, secret: []byte("gate-secret")}
+}
+
+func (k testKeys) signEd(data []byte) []byte   { return ed25519.Sign(k.edPrivate, data) }
+func (k testKeys) signHMAC(data []byte) []byte { return signHMAC(k.secret, data) }
+
+func (k testKeys) verifier(t *testing.T, store ReplayStore) *Verifier {
+	t.Helper()
+	v, err := New(Options{
+		Keys: []Key{
+			{ID: "ed1", Algorithm: Ed25519, PublicKey: k.edPublic},
+			{ID: "hm1", Algorithm: HS256, Secret: k.secret},
+		},
+		Leeway: 30 * time.Second,
+		Store:  store,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return v
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	keys := newTestKeys(t)
+	other := newTestKeys(t)
+
+	valid := map[string]any{"jti": "T-1", "sub": "seat A1", "exp": testNow.Add(time.Hour).Unix(), "nbf": testNow.Add(-time.Hour).Unix()}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "jwt eddsa", token: signJWT(t, "EdDSA", "ed1", valid, keys.signEd)},
+		{name: "jwt hs256", token: signJWT(t, "HS256", "hm1", valid, keys.signHMAC)},
+		{name: "jwt without kid", token: signJWT(t, "HS256", "", valid, keys.signHMAC)},
+		{name: "compact with kid", token: signCompact(t, map[string]any{"kid": "ed1", "jti": "T-1"}, keys.signEd)},
+		{name: "compact without kid", token: signCompact(t, map[string]any{"jti": "T-1"}, keys.signHMAC)},
+		{name: "within leeway", token: signJWT(t, "EdDSA", "ed1", map[string]any{"exp": testNow.Add(-10 * time.Second).Unix()}, keys.signEd)},
+		{name: "wrong key", token: signJWT(t, "EdDSA", "ed1", valid, other.signEd), wantErr: ErrBadSignature},
+		{name: "unknown kid", token: signJWT(t, "EdDSA", "ed9", valid, keys.signEd), wantErr: ErrUnknownKey},
+		{name: "algorithm mismatch", token: signJWT(t, "HS256", "ed1", valid, keys.signHMAC), wantErr: ErrBadSignature},
+		{name: "none algorithm", token: signJWT(t, "none", "", valid, func([]byte) []byte { return nil }), wantErr: ErrBadSignature},
+		{name: "tampered claims", token: tamper(signJWT(t, "EdDSA", "ed1", valid, keys.signEd), encodeSegment(t, map[string]any{"jti": "T-2"})), wantErr: ErrBadSignature},
+		{name: "expired", token: signJWT(t, "EdDSA", "ed1", map[string]any{"exp": testNow.Add(-time.Minute).Unix()}, keys.signEd), wantErr: ErrExpired},
+		{name: "not yet valid", token: signJWT(t, "EdDSA", "ed1", map[string]any{"nbf": testNow.Add(time.Minute).Unix()}, keys.signEd), wantErr: ErrNotYetValid},
+		{name: "plain text", token: "TICKET-42", wantErr: ErrMalformed},
+		{name: "bad base64", token: "a.b!.c", wantErr: ErrMalformed},
+		{name: "exp out of range", token: signJWT(t, "EdDSA", "ed1", map[string]any{"exp": 1e300}, keys.signEd), wantErr: ErrMalformed},
+		{name: "non numeric exp", token: signJWT(t, "EdDSA", "ed1", map[string]any{"exp": "tomorrow"}, keys.signEd), wantErr: ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// テストごとに新しいストアを使い、リプレイ判定の影響を受けないようにする
+			_, err := keys.verifier(t, NewMemoryStore()).Verify(tt.token, testNow)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Verify failed: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// tamper はJWTのクレーム部分を差し替える
+func tamper(token, claims string) string {
+	parts := strings.Split(token, ".")
+	parts[1] = claims
+	return strings.Join(parts, ".")
+}
+
+func TestVerifier_Claims(t *testing.T) {
+	keys := newTestKeys(t)
+	exp := testNow.Add(time.Hour).Unix()
+	token := signJWT(t, "EdDSA", "ed1", map[string]any{"jti": "T-1", "sub": "seat A1", "exp": exp}, keys.signEd)
+
+	claims, err := keys.verifier(t, nil).Verify(token, testNow)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if claims.KeyID != "ed1" || claims.ID != "T-1" || claims.Subject != "seat A1" || claims.ExpiresAt.Unix() != exp {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+
+	// jtiがない場合は署名から識別子を作る
+	claims, err = keys.verifier(t, nil).Verify(signCompact(t, map[string]any{"sub": "x"}, keys.signHMAC), testNow)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !strings.HasPrefix(claims.ID, "sig:") {
+		t.Errorf("Expected a signature based ID, got %q", claims.ID)
+	}
+}
+
+func TestVerifier_RequireExpiry(t *testing.T) {
+	keys := newTestKeys(t)
+	v, err := New(Options{Keys: []Key{{ID: "hm1", Algorithm: HS256, Secret: keys.secret}}, RequireExpiry: true})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	_, err = v.Verify(signCompact(t, map[string]any{"jti": "T-1"}, keys.signHMAC), testNow)
+	if !errors.Is(err, ErrMissingExp) {
+		t.Errorf("Verify error = %v, want %v", err, ErrMissingExp)
+	}
+}
+
+func TestVerifier_Replay(t *testing.T) {
+	keys := newTestKeys(t)
+	v := keys.verifier(t, NewMemoryStore())
+
+	token := signJWT(t, "EdDSA", "ed1", map[string]any{"jti": "T-1"}, keys.signEd)
+	if _, err := v.Verify(token, testNow); err != nil {
+		t.Fatalf("First use failed: %v", err)
+	}
+	if _, err := v.Verify(token, testNow); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Second use error = %v, want %v", err, ErrReplayed)
+	}
+
+	// 同じjtiを持つ別のトークンも使用済みとして扱う
+	other := signJWT(t, "HS256", "hm1", map[string]any{"jti": "T-1"}, keys.signHMAC)
+	if _, err := v.Verify(other, testNow); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Reused jti error = %v, want %v", err, ErrReplayed)
+	}
+
+	// 署名が不正なトークンは使用済みとして記録しない
+	forged := signJWT(t, "EdDSA", "ed1", map[string]any{"jti": "T-2"}, newTestKeys(t).signEd)
+	v.Verify(forged, testNow)
+	genuine := signJWT(t, "EdDSA", "ed1", map[string]any{"jti": "T-2"}, keys.signEd)
+	if _, err := v.Verify(genuine, testNow); err != nil {
+		t.Errorf("Forged token should not consume the ID: %v", err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "used_tokens")
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+	future := time.Now().Add(time.Hour)
+	for _, id := range []string{"T-1", "line\nbreak"} {
+		if fresh, err := store.Use(id, future); err != nil || !fresh {
+			t.Fatalf("Use(%q) = %v, %v", id, fresh, err)
+		}
+	}
+	if fresh, _ := store.Use("T-1", future); fresh {
+		t.Error("T-1 should already be used")
+	}
+	if fresh, _ := store.Use("old", time.Now().Add(-time.Hour)); !fresh {
+		t.Error("old should be fresh")
+	}
+
+	// 再起動後も使用済みトークンを覚えている
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+	for _, id := range []string{"T-1", "line\nbreak"} {
+		if fresh, _ := reopened.Use(id, future); fresh {
+			t.Errorf("%q should be remembered across restarts", id)
+		}
+	}
+	// 期限切れのエントリは読み込み時に捨てられる
+	if reopened.Len() != 2 {
+		t.Errorf("Expected 2 remembered tokens, got %d", reopened.Len())
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), `"old"`) {
+		t.Errorf("Expired entry should be compacted away:\n%s", data)
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	keys := newTestKeys(t)
+	der, err := x509.MarshalPKIXPublicKey(keys.edPublic)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+	}
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	for _, material := range []string{base64.StdEncoding.EncodeToString(keys.edPublic), base64.RawURLEncoding.EncodeToString(keys.edPublic), pemKey} {
+		key, err := NewKey("ed1", Ed25519, material)
+		if err != nil {
+			t.Fatalf("NewKey failed: %v", err)
+		}
+		if !key.PublicKey.Equal(keys.edPublic) {
+			t.Errorf("Public key mismatch for %q", material)
+		}
+	}
+
+	if _, err := NewKey("ed1", Ed25519, "c2hvcnQ="); err == nil {
+		t.Error("NewKey should reject short keys")
+	}
+	if _, err := NewKey("hm1", HS256, ""); err == nil {
+		t.Error("NewKey should reject empty secrets")
+	}
+	if _, err := New(Options{Keys: []Key{{ID: "a", Algorithm: HS256, Secret: []byte("x")}, {ID: "a", Algorithm: HS256, Secret: []byte("y")}}}); err == nil {
+		t.Error("New should reject duplicate key IDs")
+	}
+}
+
+func TestVerifier_Process(t *testing.T) {
+	keys := newTestKeys(t)
+	v := keys.verifier(t, NewMemoryStore())
+	token := signJWT(t, "EdDSA", "ed1", map[string]any{"jti": "T-1", "seat": "A1"}, keys.signEd)
+
+	d, err := v.Process(detection.New(token, nil, testNow, nil))
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if d.Fields["seat"] != "A1" || d.Fields["kid"] != "ed1" || d.Fields["jti"] != "T-1" {
+		t.Errorf("Claims should be added to the fields, got %v", d.Fields)
+	}
+
+	// 出力先に書き込むまでは使用済みとして記録しない
+	if _, err := v.Process(detection.New(token, nil, testNow, nil)); err != nil {
+		t.Fatalf("Token should not be used before Commit: %v", err)
+	}
+	if err := v.Commit(d); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if _, err := v.Process(detection.New(token, nil, testNow, nil)); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Process error = %v, want %v", err, ErrReplayed)
+	}
+	// 再試行で書き込んだ場合など、同じ検出結果を再び確定してもエラーにしない
+	if err := v.Commit(d); err != nil {
+		t.Errorf("Second Commit failed: %v", err)
+	}
+}
+
+func TestVerifier_ReplayWithinLeeway(t *testing.T) {
+	keys := newTestKeys(t)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return testNow }
+	v := keys.verifier(t, store)
+
+	// 期限は過ぎているが許容範囲内のトークン
+	token := signJWT(t, "EdDSA", "ed1", map[string]any{"jti": "T-1", "exp": testNow.Add(-10 * time.Second).Unix()}, keys.signEd)
+	if _, err := v.Verify(token, testNow); err != nil {
+		t.Fatalf("First use failed: %v", err)
+	}
+	// 別のトークンの記録で期限切れのエントリが整理されても、許容範囲内は覚えている
+	if fresh, _ := store.Use("other", time.Time{}); !fresh {
+		t.Fatal("other should be fresh")
+	}
+	if _, err := v.Verify(token, testNow.Add(5*time.Second)); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Reuse within the leeway error = %v, want %v", err, ErrReplayed)
+	}
+
+	// 再起動後も許容範囲内のトークンは覚えている
+	path := filepath.Join(t.TempDir(), "used_tokens")
+	fileStore, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+	now := time.Now()
+	token = signJWT(t, "HS256", "hm1", map[string]any{"jti": "T-2", "exp": now.Add(-10 * time.Second).Unix()}, keys.signHMAC)
+	if _, err := keys.verifier(t, fileStore).Verify(token, now); err != nil {
+		t.Fatalf("First use failed: %v", err)
+	}
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+	if _, err := keys.verifier(t, reopened).Verify(token, now); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Reuse after a restart error = %v, want %v", err, ErrReplayed)
+	}
+}
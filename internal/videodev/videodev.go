@@ -0,0 +1,192 @@
+// Package videodev enumerates Linux video4linux devices and resolves stable
+// camera selectors, such as /dev/v4l/by-id paths, names and USB serial numbers,
+// to the /dev/videoN index that OpenCV opens.
+package videodev
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is matched by the error returned when no device matches a selector
+var ErrNotFound = errors.New("no matching camera")
+
+// Device is a video4linux device node
+type Device struct {
+	Index  int      `json:"index"`             // N of /dev/videoN, the ID passed to OpenCV
+	Path   string   `json:"path"`              // Device node such as /dev/video0
+	Name   string   `json:"name"`              // Name reported by the driver
+	Node   int      `json:"node"`              // Index of the node within its device (0 is usually the capture node)
+	Serial string   `json:"serial,omitempty"`  // USB serial number, if the device has one
+	ByID   []string `json:"by_id,omitempty"`   // Links in /dev/v4l/by-id
+	ByPath []string `json:"by_path,omitempty"` // Links in /dev/v4l/by-path
+}
+
+// Tree is a file system holding sysfs and /dev. Tests point Root at a fake tree.
+type Tree struct {
+	Root string
+}
+
+// System is the tree of the running system
+var System = Tree{Root: "/"}
+
+// videoNode matches device node names such as video0
+var videoNode = regexp.MustCompile(`^video(\d+)$`)
+
+// List returns the video4linux devices ordered by index. It fails where there is
+// no video4linux sysfs directory, such as on macOS and Windows.
+func (t Tree) List() ([]Device, error) {
+	classDir := t.path("/sys/class/video4linux")
+	entries, err := os.ReadDir(classDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list cameras (video4linux is only available on Linux): %w", err)
+	}
+
+	links := t.links()
+	var devices []Device
+	for _, entry := range entries {
+		m := videoNode.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(m[1])
+		dir := filepath.Join(classDir, entry.Name())
+		d := Device{
+			Index:  index,
+			Path:   "/dev/" + entry.Name(),
+			Name:   readAttribute(filepath.Join(dir, "name")),
+			Serial: t.serial(dir),
+			ByID:   links["by-id"][entry.Name()],
+			ByPath: links["by-path"][entry.Name()],
+		}
+		d.Node, _ = strconv.Atoi(readAttribute(filepath.Join(dir, "index")))
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Index < devices[j].Index })
+	return devices, nil
+}
+
+// Resolve returns the device index selected by selector:
+//
+//   - a number selects that index
+//   - a path such as /dev/v4l/by-id/usb-...-video-index0 or /dev/video2 selects the node it points to
+//   - "serial:ABC123" selects the device with that USB serial number
+//   - "name:C920" or any other text selects the device whose name contains it, ignoring case
+//
+// When a serial number or name matches several nodes of one device, its capture
+// node is used. A name that matches several devices is an error.
+func (t Tree) Resolve(selector string) (int, error) {
+	selector = strings.TrimSpace(selector)
+	if selector == "" {
+		return 0, errors.New("empty camera selector")
+	}
+	if index, err := strconv.Atoi(selector); err == nil && index >= 0 {
+		return index, nil
+	}
+	if strings.HasPrefix(selector, "/") {
+		return t.resolvePath(selector)
+	}
+
+	devices, err := t.List()
+	if err != nil {
+		return 0, err
+	}
+
+	var match func(Device) bool
+	if serial, ok := strings.CutPrefix(selector, "serial:"); ok {
+		match = func(d Device) bool { return d.Serial != "" && d.Serial == serial }
+	} else {
+		name := strings.ToLower(strings.TrimPrefix(selector, "name:"))
+		match = func(d Device) bool { return strings.Contains(strings.ToLower(d.Name), name) }
+	}
+
+	// 1台のカメラが複数のノード（キャプチャ用とメタデータ用など）を持つ場合はキャプチャ用を選ぶ
+	var matches []Device
+	for _, d := range devices {
+		if match(d) && d.Node == 0 {
+			matches = append(matches, d)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("%w for %q", ErrNotFound, selector)
+	case 1:
+		return matches[0].Index, nil
+	}
+	var found []string
+	for _, d := range matches {
+		found = append(found, fmt.Sprintf("%s (%s)", d.Path, d.Name))
+	}
+	return 0, fmt.Errorf("%q matches several cameras: %s", selector, strings.Join(found, ", "))
+}
+
+// resolvePath follows a device path to its /dev/videoN node
+func (t Tree) resolvePath(path string) (int, error) {
+	resolved, err := filepath.EvalSymlinks(t.path(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, fmt.Errorf("%w at %s", ErrNotFound, path)
+		}
+		return 0, err
+	}
+	m := videoNode.FindStringSubmatch(filepath.Base(resolved))
+	if m == nil {
+		return 0, fmt.Errorf("%s is not a video device", path)
+	}
+	index, _ := strconv.Atoi(m[1])
+	return index, nil
+}
+
+// links maps node names such as video0 to the links pointing at them in
+// /dev/v4l/by-id and /dev/v4l/by-path
+func (t Tree) links() map[string]map[string][]string {
+	links := make(map[string]map[string][]string)
+	for _, kind := range []string{"by-id", "by-path"} {
+		links[kind] = make(map[string][]string)
+		dir := "/dev/v4l/" + kind
+		entries, err := os.ReadDir(t.path(dir))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			target, err := os.Readlink(t.path(dir + "/" + entry.Name()))
+			if err != nil {
+				continue
+			}
+			node := filepath.Base(target)
+			links[kind][node] = append(links[kind][node], dir+"/"+entry.Name())
+		}
+	}
+	return links
+}
+
+// serial returns the USB serial number of the device a sysfs node belongs to.
+// The node's "device" link points at the USB interface; the serial number is an
+// attribute of the USB device above it.
+func (t Tree) serial(dir string) string {
+	device, err := filepath.EvalSymlinks(filepath.Join(dir, "device"))
+	if err != nil {
+		return ""
+	}
+	return readAttribute(filepath.Join(filepath.Dir(device), "serial"))
+}
+
+// path returns the location of an absolute system path within the tree
+func (t Tree) path(p string) string {
+	return filepath.Join(t.Root, filepath.FromSlash(p))
+}
+
+// readAttribute reads a sysfs attribute, returning an empty string if it is missing
+func readAttribute(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,179 @@
+package videodev
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// fakeCamera describes a USB camera in the fake sysfs tree
+type fakeCamera struct {
+	port   string // USB port such as 1-1
+	name   string
+	serial string
+	nodes  []int // /dev/videoN indices, the first one is the capture node
+}
+
+// newFakeTree builds a sysfs and /dev tree laid out like the one of a Linux system
+func newFakeTree(t *testing.T, cameras []fakeCamera) Tree {
+	t.Helper()
+	root := t.TempDir()
+	mkdir := func(path string) {
+		if err := os.MkdirAll(filepath.Join(root, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path, content string) {
+		if err := os.WriteFile(filepath.Join(root, path), []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, path string) {
+		if err := os.Symlink(target, filepath.Join(root, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mkdir("sys/class/video4linux")
+	mkdir("dev/v4l/by-id")
+	mkdir("dev/v4l/by-path")
+	for _, c := range cameras {
+		usbDevice := "sys/devices/pci0000:00/usb1/" + c.port
+		usbInterface := usbDevice + "/" + c.port + ":1.0"
+		mkdir(usbInterface)
+		if c.serial != "" {
+			write(usbDevice+"/serial", c.serial)
+		}
+		for i, index := range c.nodes {
+			node := "video" + strconv.Itoa(index)
+			dir := usbInterface + "/video4linux/" + node
+			mkdir(dir)
+			write(dir+"/name", c.name)
+			write(dir+"/index", strconv.Itoa(i))
+			link("../..", dir+"/device")
+			link("../../devices/pci0000:00/usb1/"+c.port+"/"+c.port+":1.0/video4linux/"+node, "sys/class/video4linux/"+node)
+			write("dev/"+node, "")
+			link("../../"+node, "dev/v4l/by-path/pci-0000:00:14.0-usb-0:"+c.port+":1.0-video-index"+strconv.Itoa(i))
+			if c.serial != "" {
+				link("../../"+node, "dev/v4l/by-id/usb-"+c.serial+"-video-index"+strconv.Itoa(i))
+			}
+		}
+	}
+	return Tree{Root: root}
+}
+
+var fakeCameras = []fakeCamera{
+	{port: "1-1", name: "HD Pro Webcam C920", serial: "ABC123", nodes: []int{0, 1}},
+	{port: "1-2", name: "USB Camera", serial: "XYZ789", nodes: []int{2, 3}},
+	{port: "1-3", name: "USB Camera", serial: "DEF456", nodes: []int{4}},
+	{port: "1-4", name: "Integrated Camera", nodes: []int{5}},
+}
+
+func TestList(t *testing.T) {
+	tree := newFakeTree(t, fakeCameras)
+
+	devices, err := tree.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(devices) != 6 {
+		t.Fatalf("List() returned %d devices, want 6", len(devices))
+	}
+
+	want := Device{
+		Index:  0,
+		Path:   "/dev/video0",
+		Name:   "HD Pro Webcam C920",
+		Node:   0,
+		Serial: "ABC123",
+		ByID:   []string{"/dev/v4l/by-id/usb-ABC123-video-index0"},
+		ByPath: []string{"/dev/v4l/by-path/pci-0000:00:14.0-usb-0:1-1:1.0-video-index0"},
+	}
+	if !reflect.DeepEqual(devices[0], want) {
+		t.Errorf("devices[0] = %+v, want %+v", devices[0], want)
+	}
+	if devices[1].Node != 1 || devices[1].Serial != "ABC123" {
+		t.Errorf("devices[1] = %+v, want the second node of the C920", devices[1])
+	}
+	// シリアル番号のないカメラ
+	if devices[5].Serial != "" || devices[5].ByID != nil || devices[5].Name != "Integrated Camera" {
+		t.Errorf("devices[5] = %+v", devices[5])
+	}
+}
+
+func TestList_NoSysfs(t *testing.T) {
+	tree := Tree{Root: t.TempDir()}
+	if _, err := tree.List(); err == nil {
+		t.Error("List() without video4linux should fail")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tree := newFakeTree(t, fakeCameras)
+
+	tests := []struct {
+		name     string
+		selector string
+		want     int
+		wantErr  bool
+		notFound bool
+	}{
+		{"番号", "3", 3, false, false},
+		{"by-idのパス", "/dev/v4l/by-id/usb-XYZ789-video-index0", 2, false, false},
+		{"by-pathのパス", "/dev/v4l/by-path/pci-0000:00:14.0-usb-0:1-3:1.0-video-index0", 4, false, false},
+		{"デバイスノード", "/dev/video5", 5, false, false},
+		{"シリアル番号", "serial:ABC123", 0, false, false},
+		{"シリアル番号（メタデータ用ノードは選ばない）", "serial:XYZ789", 2, false, false},
+		{"名前の一部（大文字小文字を区別しない）", "c920", 0, false, false},
+		{"name:接頭辞", "name:Integrated", 5, false, false},
+		{"複数のカメラに一致する名前", "USB Camera", 0, true, false},
+		{"存在しないシリアル番号", "serial:NOPE", 0, true, true},
+		{"存在しない名前", "Logitech Brio", 0, true, true},
+		{"存在しないパス", "/dev/v4l/by-id/usb-NOPE-video-index0", 0, true, true},
+		{"空のセレクター", " ", 0, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tree.Resolve(tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve(%q) error = %v, wantErr %v", tt.selector, err, tt.wantErr)
+			}
+			if tt.notFound && !errors.Is(err, ErrNotFound) {
+				t.Errorf("Resolve(%q) error = %v, want ErrNotFound", tt.selector, err)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Resolve(%q) = %d, want %d", tt.selector, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolve_AfterReboot(t *testing.T) {
+	// 再起動でインデックスが入れ替わっても同じカメラを選ぶ
+	before := newFakeTree(t, []fakeCamera{
+		{port: "1-1", name: "USB Camera", serial: "XYZ789", nodes: []int{0, 1}},
+		{port: "1-2", name: "HD Pro Webcam C920", serial: "ABC123", nodes: []int{2, 3}},
+	})
+	after := newFakeTree(t, []fakeCamera{
+		{port: "1-2", name: "HD Pro Webcam C920", serial: "ABC123", nodes: []int{0, 1}},
+		{port: "1-1", name: "USB Camera", serial: "XYZ789", nodes: []int{2, 3}},
+	})
+
+	for _, selector := range []string{"serial:ABC123", "/dev/v4l/by-id/usb-ABC123-video-index0", "C920"} {
+		first, err := before.Resolve(selector)
+		if err != nil {
+			t.Fatalf("Resolve(%q) error = %v", selector, err)
+		}
+		second, err := after.Resolve(selector)
+		if err != nil {
+			t.Fatalf("Resolve(%q) error = %v", selector, err)
+		}
+		if first != 2 || second != 0 {
+			t.Errorf("Resolve(%q) = %d before and %d after the reboot, want 2 and 0", selector, first, second)
+		}
+	}
+}